@@ -0,0 +1,310 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// With the option auto-ptr, A and AAAA records register their IP address at their node while processing the values.
+// After (re)loading data the PTR records are synthesized in the matching reverse zones (see syncAutoPtrs()), so they
+// appear as soon as the reverse zone is present and vanish with the address records. Explicit PTR records take precedence.
+// An address record updated in place (by a watch event) synchronizes only the PTR records of its node (see syncHostAutoPtrs()).
+// Auto-PTR records are not supported in lazy-load mode, because the zones are loaded independently of each other there.
+
+const autoPtrIdPrefix = "auto-ptr:" // ids of synthesized PTR records, followed by the hostname
+
+var autoPtrsUsed int32 // set to 1 when an auto-ptr was registered the first time (then the synchronization is needed)
+
+// the reverse domain name (in-addr.arpa or ip6.arpa) of an IP (4 or 16 octets)
+func reverseName(ip net.IP) string {
+	var labels []string
+	if len(ip) == net.IPv4len {
+		for i := len(ip) - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%d", ip[i]))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa."
+	}
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", ip[i]&0x0f), fmt.Sprintf("%x", ip[i]>>4))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa."
+}
+
+// the IP of a reverse domain name (in-addr.arpa with 4 labels or ip6.arpa with 32 labels), the inverse of reverseName()
+func reverseNameIP(name string) (net.IP, error) {
+	labels := splitDomainName(strings.ToLower(name), ".")
+	switch n := len(labels); {
+	case n == 6 && labels[4] == "in-addr" && labels[5] == "arpa":
+		ip := net.ParseIP(strings.Join(reversed(labels[:4]), ".")).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid in-addr.arpa name %q", name)
+		}
+		return ip, nil
+	case n == 34 && labels[32] == "ip6" && labels[33] == "arpa":
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range reversed(labels[:32]) {
+			nibble, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return nil, fmt.Errorf("invalid ip6.arpa label %q", label)
+			}
+			ip[i/2] |= byte(nibble) << (4 * (1 - i%2))
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("not a full reverse domain name: %q", name)
+}
+
+// expands a compact form of IPv6 nibble labels (below ip6.arpa) into the labels, in storage order (most significant first).
+// the compact form consists of hexadecimal groups (of up to 4 digits, zero-padded) separated by ":", where "::" fills
+// the address up with zero groups (like in IPv6 addresses), f.e. "2001:db8" (8 labels) or "2001:db8::1" (32 labels).
+// nibbles is the number of labels below ip6.arpa already given (before the compact form), it must be a multiple of 4.
+func expandIP6Labels(compact string, nibbles int) ([]string, error) {
+	if nibbles%4 != 0 {
+		return nil, fmt.Errorf("must start at a group boundary (after %d labels)", nibbles)
+	}
+	groups := func(s string) ([]string, error) {
+		if s == "" {
+			return nil, nil
+		}
+		groups := strings.Split(s, ":")
+		for i, group := range groups {
+			if len(group) == 0 || len(group) > 4 {
+				return nil, fmt.Errorf("invalid group %q", group)
+			}
+			if _, err := strconv.ParseUint(group, 16, 16); err != nil {
+				return nil, fmt.Errorf("invalid group %q", group)
+			}
+			groups[i] = strings.Repeat("0", 4-len(group)) + strings.ToLower(group)
+		}
+		return groups, nil
+	}
+	left, right, fill := strings.Cut(compact, "::")
+	leftGroups, err := groups(left)
+	if err != nil {
+		return nil, err
+	}
+	rightGroups, err := groups(right)
+	if err != nil {
+		return nil, err
+	}
+	all := leftGroups
+	if fill {
+		zeros := (32-nibbles)/4 - len(leftGroups) - len(rightGroups)
+		if zeros < 0 {
+			return nil, fmt.Errorf("too many groups")
+		}
+		for i := 0; i < zeros; i++ {
+			all = append(all, "0000")
+		}
+		all = append(all, rightGroups...)
+	}
+	if len(all) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+	if nibbles+4*len(all) > 32 {
+		return nil, fmt.Errorf("too many groups")
+	}
+	return strings.Split(strings.Join(all, ""), ""), nil
+}
+
+// registers the IP of the (just stored) A/AAAA record at its node, if the option auto-ptr is set for it.
+// the reverse zone could be not present yet, so the PTR record is synthesized after processing all values.
+func handleAutoPtr(params *rrParams, ip net.IP) {
+	autoPtr, oPath, err := findOptionValue[bool](autoPtrOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.exlog("vp", oPath, "error", err).Errorf("failed to get option %q", autoPtrOption)
+		return
+	}
+	if oPath == nil || !autoPtr {
+		return
+	}
+	if params.explanation != nil {
+		params.explain("option %s from %s: the PTR record is synthesized", autoPtrOption, oPath)
+		return
+	}
+	if *args.LazyLoad {
+		params.log().Debugf("option %q is not supported in lazy-load mode, ignoring", autoPtrOption)
+		return
+	}
+	if params.qtype == "A" {
+		ip = ip.To4()
+	}
+	if ip == nil {
+		params.log().Errorf("option %q: content is not an IP address", autoPtrOption)
+		return
+	}
+	if _, ok := params.data.autoPtrs[params.qtype]; !ok {
+		params.data.autoPtrs[params.qtype] = map[string]net.IP{}
+	}
+	params.data.autoPtrs[params.qtype][params.id] = ip
+	atomic.StoreInt32(&autoPtrsUsed, 1)
+}
+
+// the node of the reverse name of the IP, nil if there is no (loaded) zone for it
+func (dn *dataNode) autoPtrOwner(ip net.IP) *dataNode {
+	name := parseName(reverseName(ip))
+	zone := dn.getChild(name, false).findZone()
+	if zone == nil || zone.unloaded {
+		return nil
+	}
+	return dn.getChildCreate(name)
+}
+
+func (dn *dataNode) visit(f func(*dataNode)) {
+	f(dn)
+	for _, child := range dn.children {
+		child.visit(f)
+	}
+}
+
+// a PTR record to synthesize for a host (see hostAutoPtrs())
+type hostAutoPtr struct {
+	ip     net.IP
+	record recordType
+}
+
+// the PTR records to synthesize for the registered IPs of the (enabled) records of the host, by IP (string)
+func (dn *dataNode) hostAutoPtrs() map[string]hostAutoPtr {
+	autoPtrs := map[string]hostAutoPtr{}
+	for qtype, ips := range dn.autoPtrs {
+		for id, ip := range ips {
+			if record, ok := dn.records[qtype][id]; ok && !record.disabled {
+				autoPtrs[ip.String()] = hostAutoPtr{ip, recordType{content: dn.getQname(), ttl: record.ttl}}
+			}
+		}
+	}
+	return autoPtrs
+}
+
+// whether the node has an explicit (enabled) PTR record, which takes precedence over the synthesized ones
+func (dn *dataNode) hasExplicitPTR() bool {
+	for id, record := range dn.records["PTR"] {
+		if !strings.HasPrefix(id, autoPtrIdPrefix) && !record.disabled {
+			return true
+		}
+	}
+	return false
+}
+
+// synchronizes the synthesized PTR records with the registered IPs of the whole tree (must be the root node and write-locked)
+func (dn *dataNode) syncAutoPtrs() {
+	if atomic.LoadInt32(&autoPtrsUsed) == 0 || *args.LazyLoad {
+		return
+	}
+	wanted := map[*dataNode]map[string]recordType{}
+	dn.visit(func(host *dataNode) {
+		for _, autoPtr := range host.hostAutoPtrs() {
+			owner := dn.autoPtrOwner(autoPtr.ip)
+			if owner == nil {
+				host.log("ip", autoPtr.ip).Trace("no zone for the auto-ptr record, skipping")
+				continue
+			}
+			if _, ok := wanted[owner]; !ok {
+				wanted[owner] = map[string]recordType{}
+			}
+			wanted[owner][autoPtrIdPrefix+autoPtr.record.content] = autoPtr.record
+		}
+	})
+	changed := map[*dataNode]bool{} // zones
+	dn.visit(func(owner *dataNode) {
+		explicit := owner.hasExplicitPTR()
+		for id, record := range owner.records["PTR"] {
+			if wantedRecord, ok := wanted[owner][id]; strings.HasPrefix(id, autoPtrIdPrefix) && (explicit || !ok || wantedRecord != record) {
+				delete(owner.records["PTR"], id)
+				changed[owner.findZone()] = true
+			}
+		}
+		if explicit {
+			delete(wanted, owner)
+			return
+		}
+		for id, record := range wanted[owner] {
+			if _, ok := owner.records["PTR"][id]; !ok {
+				if _, ok := owner.records["PTR"]; !ok {
+					owner.records["PTR"] = map[string]recordType{}
+				}
+				owner.records["PTR"][id] = record
+				changed[owner.findZone()] = true
+				owner.log("hostname", record.content).Trace("stored auto-ptr record")
+			}
+		}
+		if len(owner.records["PTR"]) == 0 {
+			delete(owner.records, "PTR")
+		}
+	})
+	for zone := range changed {
+		lookupCache.invalidate(zone)
+	}
+}
+
+// synchronizes the synthesized PTR records of the host incrementally (after updating an entry of it in place), given its
+// PTR records to synthesize before the update (see hostAutoPtrs()). only the reverse names of the old and new IPs of
+// the host are touched, instead of synchronizing the whole tree (see syncAutoPtrs()).
+func syncHostAutoPtrs(host *dataNode, before map[string]hostAutoPtr) {
+	if atomic.LoadInt32(&autoPtrsUsed) == 0 || *args.LazyLoad {
+		return
+	}
+	root := dataRoot.Load()
+	root.mutex.Lock()
+	defer root.mutex.Unlock()
+	after := host.hostAutoPtrs()
+	id := autoPtrIdPrefix + host.getQname()
+	changed := map[*dataNode]bool{} // zones
+	for _, autoPtrs := range []map[string]hostAutoPtr{before, after} {
+		for key, autoPtr := range autoPtrs {
+			owner := root.autoPtrOwner(autoPtr.ip)
+			if owner == nil {
+				continue
+			}
+			current, exists := owner.records["PTR"][id]
+			if wanted, ok := after[key]; ok && !owner.hasExplicitPTR() {
+				if !exists || current != wanted.record {
+					if _, ok := owner.records["PTR"]; !ok {
+						owner.records["PTR"] = map[string]recordType{}
+					}
+					owner.records["PTR"][id] = wanted.record
+					changed[owner.findZone()] = true
+					owner.log("hostname", wanted.record.content).Trace("stored auto-ptr record")
+				}
+			} else if exists {
+				delete(owner.records["PTR"], id)
+				if len(owner.records["PTR"]) == 0 {
+					delete(owner.records, "PTR")
+				}
+				changed[owner.findZone()] = true
+				owner.log("hostname", current.content).Trace("removed auto-ptr record")
+			}
+		}
+	}
+	for zone := range changed {
+		lookupCache.invalidate(zone)
+	}
+}
+
+// synchronizes the auto-ptr records of the current data tree
+func syncAutoPtrs() {
+	if atomic.LoadInt32(&autoPtrsUsed) == 0 || *args.LazyLoad {
+		return
+	}
+	root := dataRoot.Load()
+	root.mutex.Lock()
+	defer root.mutex.Unlock()
+	root.syncAutoPtrs()
+}
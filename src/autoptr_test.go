@@ -0,0 +1,260 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestReverseName(t *testing.T) {
+	for ip, expected := range map[string]string{
+		"192.0.2.1":     "1.2.0.192.in-addr.arpa.",
+		"10.20.30.255":  "255.30.20.10.in-addr.arpa.",
+		"2001:db8::1":   "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"2001:db8::abc": "c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+	} {
+		parsed := net.ParseIP(ip)
+		if ip4 := parsed.To4(); ip4 != nil {
+			parsed = ip4
+		}
+		if actual := reverseName(parsed); actual != expected {
+			t.Errorf("%s: expected %q, got %q", ip, expected, actual)
+		}
+		if actual, err := reverseNameIP(expected); err != nil || !actual.Equal(parsed) {
+			t.Errorf("%s: expected IP %s, got %s (error %v)", expected, ip, actual, err)
+		}
+	}
+	for _, name := range []string{"2.0.192.in-addr.arpa.", "1.2.0.300.in-addr.arpa.", "8.b.d.0.1.0.0.2.ip6.arpa.", "example.net.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.g.ip6.arpa."} {
+		if ip, err := reverseNameIP(name); err == nil {
+			t.Errorf("%s: expected an error, got %s", name, ip)
+		}
+	}
+}
+
+func TestExpandIP6Labels(t *testing.T) {
+	for _, spec := range []struct {
+		compact  string
+		nibbles  int
+		expected string // joined by "."
+	}{
+		{"2001:db8", 0, "2.0.0.1.0.d.b.8"},
+		{"2001:DB8::1", 0, "2.0.0.1.0.d.b.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1"},
+		{"::1", 8, "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1"},
+		{"a", 28, "0.0.0.a"},
+		{"1:2::", 24, "0.0.0.1.0.0.0.2"},
+	} {
+		labels, err := expandIP6Labels(spec.compact, spec.nibbles)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", spec.compact, err)
+		} else if actual := strings.Join(labels, "."); actual != spec.expected {
+			t.Errorf("%s: expected %q, got %q", spec.compact, spec.expected, actual)
+		}
+	}
+	for _, spec := range []struct {
+		compact string
+		nibbles int
+	}{{"2001:db8", 2}, {"12345", 0}, {"2001::db8::1", 0}, {"x", 0}, {"1:2:3", 24}, {"", 0}, {"2001:", 0}} {
+		if labels, err := expandIP6Labels(spec.compact, spec.nibbles); err == nil {
+			t.Errorf("%s (after %d): expected an error, got %v", spec.compact, spec.nibbles, labels)
+		}
+	}
+}
+
+func TestCompactIP6Keys(t *testing.T) {
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":                    `{"ttl": 3600}`,
+		"dns/arpa.ip6/+2001:db8/SOA":        `{"primary": "ns1.example.net.", "mail": "hostmaster@example.net.", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/arpa.ip6/+2001:db8/+::1/PTR":   `="www.example.net."`,
+		"dns/arpa.ip6/+2001:db8/+0:0:2/PTR": `="mail.example.net."`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-kv.keys
+	<-watcher.keys
+	if contents := lookupContents(t, reverseName(net.ParseIP("2001:db8::1")), "PTR"); !reflect.DeepEqual(contents, []string{"www.example.net."}) {
+		t.Errorf("unexpected PTR records: %v", contents)
+	}
+	name := parseName("2.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.")
+	if contents := lookupContents(t, name.normal(), "PTR"); !reflect.DeepEqual(contents, []string{"mail.example.net."}) {
+		t.Errorf("unexpected PTR records: %v", contents)
+	}
+	// the key of the zone is restored for reloading it
+	zone := dataRoot.Load().getChild(parseName("8.b.d.0.1.0.0.2.ip6.arpa."), false)
+	if !zone.hasSOA() {
+		t.Fatalf("zone not found")
+	}
+	if key := zone.prefixKey(); key != "arpa.ip6/+2001:db8/" {
+		t.Errorf("expected the zone key %q, got %q", "arpa.ip6/+2001:db8/", key)
+	}
+	if _, _, _, _, _, err := parseEntryKey("dns/net.example/+2001/A"); err == nil {
+		t.Errorf("expected an error for compact IPv6 labels outside of ip6.arpa")
+	}
+}
+
+// the sorted contents of the lookup result
+func lookupContents(t *testing.T, qname, qtype string) []string {
+	result, err := lookup(objectType[any]{"qname": qname, "qtype": qtype}, newTestClient())
+	if err != nil {
+		t.Fatalf("lookup of %s %s failed: %s", qname, qtype, err)
+	}
+	var contents []string
+	if items, ok := result.([]objectType[any]); ok {
+		for _, item := range items {
+			contents = append(contents, item["content"].(string))
+		}
+	}
+	sort.Strings(contents)
+	return contents
+}
+
+func waitForContents(t *testing.T, qname, qtype string, contents ...string) {
+	for i := 0; ; i++ {
+		actual := lookupContents(t, qname, qtype)
+		if reflect.DeepEqual(actual, contents) {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("expected %s %s %q, got %q", qname, qtype, contents, actual)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAutoPtr(t *testing.T) {
+	soa := `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":                 `{"ttl": 3600}`,
+		"dns/net.example/SOA":            soa,
+		"dns/net.example/-options-/A":    `{"auto-ptr": true}`,
+		"dns/net.example/-options-/AAAA": `{"auto-ptr": true}`,
+		"dns/net.example/www/A":          `192.0.2.1`,
+		"dns/net.example/www/AAAA":       `2001:db8::1`,
+		"dns/net.example/web/A":          `192.0.2.1`,
+		"dns/net.example/mail/A":         `192.0.2.2`,
+		"dns/net.example/ftp/A":          `192.0.2.3`,
+		"dns/net.example/-options-/#3":   `{"auto-ptr": false}`,
+		"dns/net.example/ftp/A#3":        `192.0.2.4`,
+		"dns/arpa.in-addr/192.0.2/SOA":   soa,
+		"dns/arpa.in-addr/192.0.2/2/PTR": `mx.example.org.`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-watcher.keys
+	for qname, expected := range map[string][]string{
+		"1.2.0.192.in-addr.arpa.": {"web.example.net.", "www.example.net."},
+		"2.2.0.192.in-addr.arpa.": {"mx.example.org."},
+		"3.2.0.192.in-addr.arpa.": {"ftp.example.net."},
+		"4.2.0.192.in-addr.arpa.": nil,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": nil,
+	} {
+		if actual := lookupContents(t, qname, "PTR"); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected PTR %q, got %q", qname, expected, actual)
+		}
+	}
+	put := func(key, value string, rev int64) {
+		kv.entries[key] = value
+		watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+			Type: clientv3.EventTypePut,
+			Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: rev, ModRevision: rev},
+		}}}
+	}
+	// the reverse zone is added later
+	put("dns/arpa.ip6/2.0.0.1.0.d.b.8/SOA", soa, 2)
+	waitForContents(t, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "PTR", "www.example.net.")
+	// the PTR record vanishes with the address record
+	delete(kv.entries, "dns/net.example/web/A")
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypeDelete,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net.example/web/A"), ModRevision: 3},
+	}}}
+	waitForContents(t, "1.2.0.192.in-addr.arpa.", "PTR", "www.example.net.")
+	// the address changes (in place)
+	put("dns/net.example/www/A", `192.0.2.5`, 4)
+	waitForContents(t, "5.2.0.192.in-addr.arpa.", "PTR", "www.example.net.")
+	waitForContents(t, "1.2.0.192.in-addr.arpa.", "PTR")
+}
+
+func TestAutoPtrIncremental(t *testing.T) {
+	soa := `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":                 `{"ttl": 3600}`,
+		"dns/net.example/SOA":            soa,
+		"dns/net.example/-options-/A":    `{"auto-ptr": true}`,
+		"dns/net.example/www/A":          `192.0.2.1`,
+		"dns/arpa.in-addr/192.0.2/SOA":   soa,
+		"dns/arpa.in-addr/192.0.2/2/PTR": `mx.example.org.`,
+	})
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	drainKeys(kv)
+	// a stale synthesized record, which only a synchronization of the whole tree would remove
+	stale := dataRoot.Load().getChildCreate(parseName("9.2.0.192.in-addr.arpa."))
+	stale.records["PTR"] = map[string]recordType{autoPtrIdPrefix + "old.example.net.": {content: "old.example.net.", ttl: time.Hour}}
+	rev := int64(1)
+	event := func(key, value string, deleted bool) {
+		rev++
+		event := clientv3.Event{Type: clientv3.EventTypePut}
+		if deleted {
+			event.Type = clientv3.EventTypeDelete
+			delete(kv.entries, key)
+		} else {
+			kv.entries[key] = value
+			kv.revisions = map[string]int64{key: rev}
+		}
+		event.Kv = &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: rev, ModRevision: rev}
+		handleEvent("", &event)
+		if keys := drainKeys(kv); len(keys) > 0 {
+			t.Errorf("%s: expected an update in place, got a reload (%v)", key, keys)
+		}
+	}
+	for _, step := range []struct {
+		key, value string
+		deleted    bool
+		ptrs       map[string][]string // qname → PTR contents
+	}{
+		{"dns/net.example/mail/A", `192.0.2.3`, false, map[string][]string{"3.2.0.192.in-addr.arpa.": {"mail.example.net."}}},
+		{"dns/net.example/mx/A", `192.0.2.2`, false, map[string][]string{"2.2.0.192.in-addr.arpa.": {"mx.example.org."}}}, // explicit PTR
+		{"dns/net.example/web/A", `192.0.2.1`, false, map[string][]string{"1.2.0.192.in-addr.arpa.": {"web.example.net.", "www.example.net."}}},
+		{"dns/net.example/mail/A", `192.0.2.4`, false, map[string][]string{"3.2.0.192.in-addr.arpa.": nil, "4.2.0.192.in-addr.arpa.": {"mail.example.net."}}},
+		{"dns/net.example/web/A", "", true, map[string][]string{"1.2.0.192.in-addr.arpa.": {"www.example.net."}}},
+		{"dns/net.example/mail/A", "", true, map[string][]string{"4.2.0.192.in-addr.arpa.": nil}},
+	} {
+		event(step.key, step.value, step.deleted)
+		for qname, expected := range step.ptrs {
+			if actual := lookupContents(t, qname, "PTR"); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s (deleted %v): %s: expected PTR %q, got %q", step.key, step.deleted, qname, expected, actual)
+			}
+		}
+	}
+	if actual := lookupContents(t, "9.2.0.192.in-addr.arpa.", "PTR"); !reflect.DeepEqual(actual, []string{"old.example.net."}) {
+		t.Errorf("expected the other reverse names to be untouched, got %q", actual)
+	}
+}
@@ -0,0 +1,159 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	linkedlist "container/list"
+	"strings"
+	"sync"
+)
+
+// the lookup cache, nil if disabled (the methods of resultCache are no-ops on nil). it is created once before serving
+// (see setupLookupCache()) and only cleared afterwards, because the requests read it concurrently.
+var lookupCache *resultCache
+
+// creates the lookup cache by parameter lookup-cache
+func setupLookupCache() {
+	lookupCache = nil
+	if *args.LookupCache > 0 {
+		lookupCache = newResultCache(*args.LookupCache)
+	}
+}
+
+type resultCacheKey struct {
+	qname       string
+	qtype       string
+	zoneID      float64
+	pdnsVersion uint
+}
+
+type resultCacheEntry struct {
+	key    resultCacheKey
+	result interface{}
+	zone   string // the qname of the zone the result was computed in ("." if not in a zone)
+	epoch  uint64
+	rev    uint64
+}
+
+// A size-bounded (LRU) cache for computed results. An entry is valid as long as the revision of its zone (and all parent zones,
+// since reloading a zone reloads the nested zones too) is unchanged. Clearing the cache invalidates all entries (new epoch).
+type resultCache struct {
+	mutex     sync.Mutex
+	size      int
+	lru       *linkedlist.List // of *resultCacheEntry, most recently used first
+	entries   map[resultCacheKey]*linkedlist.Element
+	revisions map[string]uint64 // zone qname → revision (the counter value at the last invalidation)
+	counter   uint64
+	epoch     uint64
+	hits      uint64
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:      size,
+		lru:       linkedlist.New(),
+		entries:   map[resultCacheKey]*linkedlist.Element{},
+		revisions: map[string]uint64{},
+	}
+}
+
+// the current revision of the zone, must be called with c.mutex locked
+func (c *resultCache) zoneRevision(zone string) uint64 {
+	rev := c.revisions["."]
+	for labels := strings.SplitAfter(zone, "."); len(labels) > 1; labels = labels[1:] {
+		rev = maxOf(rev, c.revisions[strings.Join(labels, "")])
+	}
+	return rev
+}
+
+// returns the epoch to pass to put() later, must be called before accessing the data for computing the result
+func (c *resultCache) currentEpoch() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.epoch
+}
+
+func (c *resultCache) get(key resultCacheKey) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*resultCacheEntry)
+	if entry.epoch != c.epoch || entry.rev != c.zoneRevision(entry.zone) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.hits++
+	return entry.result, true
+}
+
+// stores the result computed in the zone, must be called while the data is still read-locked
+func (c *resultCache) put(key resultCacheKey, result interface{}, zone string, epoch uint64) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := &resultCacheEntry{key, result, zone, epoch, c.zoneRevision(zone)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultCacheEntry).key)
+	}
+}
+
+// invalidates all entries of the zone (and its nested zones), must be called while the zone is write-locked.
+// the root zone (or the root node) invalidates all entries.
+func (c *resultCache) invalidate(zone *dataNode) {
+	if c == nil {
+		return
+	}
+	if zone.isRoot() {
+		c.clear()
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counter++
+	c.revisions[zone.getQname()] = c.counter
+}
+
+func (c *resultCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru.Init()
+	clearMap(c.entries)
+	clearMap(c.revisions)
+	c.epoch++
+}
@@ -0,0 +1,115 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestResultCacheLRU(t *testing.T) {
+	cache := newResultCache(2)
+	key := func(qname string) resultCacheKey {
+		return resultCacheKey{qname, "A", -1, 4}
+	}
+	for _, qname := range []string{"a.", "b."} {
+		cache.put(key(qname), qname, ".", cache.currentEpoch())
+	}
+	if result, ok := cache.get(key("a.")); !ok || result != "a." {
+		t.Errorf("expected a cache hit for a., got %v (%v)", result, ok)
+	}
+	cache.put(key("c."), "c.", ".", cache.currentEpoch())
+	if _, ok := cache.get(key("b.")); ok {
+		t.Errorf("expected b. to be evicted (least recently used)")
+	}
+	for _, qname := range []string{"a.", "c."} {
+		if _, ok := cache.get(key(qname)); !ok {
+			t.Errorf("expected a cache hit for %s", qname)
+		}
+	}
+	// a result computed before clearing is not stored
+	epoch := cache.currentEpoch()
+	cache.clear()
+	cache.put(key("a."), "a.", ".", epoch)
+	if _, ok := cache.get(key("a.")); ok {
+		t.Errorf("expected no cache hit for a result of an old epoch")
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":          `{"ttl": 3600}`,
+		"dns/net.example/SOA":     `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A":   `192.0.2.1`,
+		"dns/net.example/sub/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/sub/A":   `192.0.2.2`,
+		"dns/org.example/SOA":     `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/org.example/A":       `192.0.2.3`,
+	})
+	*args.LookupCache = 10
+	setupLookupCache()
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer func() {
+		cancel()
+		lookupCache = nil
+	}()
+	<-watcher.keys
+	lookupResult := func(qname string) interface{} {
+		result, err := lookup(objectType[any]{"qname": qname, "qtype": "A"}, newTestClient())
+		if err != nil {
+			t.Fatalf("lookup of %s failed: %s", qname, err)
+		}
+		return result
+	}
+	hit := func(qname string) bool {
+		hits := lookupCache.hits
+		lookupResult(qname)
+		return lookupCache.hits > hits
+	}
+	first := lookupResult("www.example.net.")
+	if second := lookupResult("www.example.net."); fmt.Sprintf("%p", first) != fmt.Sprintf("%p", second) || lookupCache.hits != 1 {
+		t.Errorf("expected the identical cached result, got %v and %v (hits %d)", first, second, lookupCache.hits)
+	}
+	if hit("WWW.Example.NET.") != true {
+		t.Errorf("expected a cache hit for a differently cased query name")
+	}
+	for _, qname := range []string{"sub.example.net.", "example.org.", "missing.example.org."} {
+		lookupResult(qname)
+		if !hit(qname) {
+			t.Errorf("expected a cache hit for %s", qname)
+		}
+	}
+	// changing example.net. invalidates its entries and those of its nested zones, but not those of other zones
+	kv.entries["dns/net.example/www/A"] = `192.0.2.11`
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net.example/www/A"), Value: []byte(`192.0.2.11`), CreateRevision: 1, ModRevision: 2},
+	}}}
+	waitForContent(t, "www.example.net.", "A", "192.0.2.11")
+	if hit("sub.example.net.") {
+		t.Errorf("expected no cache hit for the nested zone after the change")
+	}
+	for _, qname := range []string{"example.org.", "missing.example.org."} {
+		if !hit(qname) {
+			t.Errorf("expected a cache hit for %s after the change of another zone", qname)
+		}
+	}
+}
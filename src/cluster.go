@@ -0,0 +1,217 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The data may be sharded across multiple ETCD clusters (see parameter endpoints). The first cluster is the primary cluster,
+// the option cluster (in an options entry without QTYPE and id in the primary cluster) routes a domain and its subdomains
+// to another cluster. The entries of a domain are taken only from the cluster it is routed to.
+
+type clusterEndpoints struct {
+	name      string
+	endpoints []string
+}
+
+// parses the value of the parameter endpoints, either a single unnamed cluster (<endpoint>[|<endpoint>|...])
+// or named clusters (<name>=<endpoint>[|<endpoint>|...][;<name>=...]).
+func parseClusters(value string) ([]clusterEndpoints, error) {
+	if !strings.Contains(value, "=") {
+		return []clusterEndpoints{{"", strings.Split(value, `|`)}}, nil
+	}
+	var clusters []clusterEndpoints
+	names := map[string]bool{}
+	for _, cluster := range strings.Split(value, ";") {
+		name, endpoints, ok := strings.Cut(cluster, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("missing cluster name in %q", cluster)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate cluster name %q", name)
+		}
+		if endpoints == "" {
+			return nil, fmt.Errorf("missing endpoints of cluster %q", name)
+		}
+		names[name] = true
+		clusters = append(clusters, clusterEndpoints{name, strings.Split(endpoints, `|`)})
+	}
+	return clusters, nil
+}
+
+// the routes of domain names (lowercase, normal form) to clusters, read from the primary cluster on (re)loading the data
+var clusterRoutes = struct {
+	sync.RWMutex
+	routes map[string]string
+}{routes: map[string]string{}}
+
+func setClusterRoutes(routes map[string]string) {
+	clusterRoutes.Lock()
+	defer clusterRoutes.Unlock()
+	clusterRoutes.routes = routes
+}
+
+// the cluster the name is routed to (by the route of the name or of its nearest ancestor), the primary cluster by default
+func clusterOf(name nameType) string {
+	clusterRoutes.RLock()
+	defer clusterRoutes.RUnlock()
+	for depth := name.len(); depth >= 0; depth-- {
+		ancestor := name.toDepth(depth)
+		if cluster, ok := clusterRoutes.routes[strings.ToLower(ancestor.normal())]; ok {
+			return cluster
+		}
+	}
+	return clusters[0]
+}
+
+// the cluster the entry key (with prefix) is routed to, the primary cluster for unparsable keys
+func keyCluster(key string) string {
+	if len(clusters) == 1 {
+		return clusters[0]
+	}
+	name, _, _, _, _, err := parseEntryKey(key)
+	if err != nil {
+		return clusters[0]
+	}
+	return clusterOf(name)
+}
+
+// whether the entry key is an options entry which may hold the option cluster (without QTYPE and id)
+func isRouteEntry(key string) (nameType, bool) {
+	name, entryType, qtype, id, version, err := parseEntryKey(key)
+	if err != nil || (version != nil && !dataVersion.isCompatibleTo(version)) {
+		return nil, false
+	}
+	return name, entryType == optionsEntry && qtype == "" && id == ""
+}
+
+// whether the entry (of the primary cluster) changes the routes: it is a route entry setting the option cluster or of a routed name
+func changesRoutes(key string, value []byte) bool {
+	name, ok := isRouteEntry(key)
+	if !ok {
+		return false
+	}
+	clusterRoutes.RLock()
+	_, routed := clusterRoutes.routes[strings.ToLower(name.normal())]
+	clusterRoutes.RUnlock()
+	if routed {
+		return true
+	}
+	content, _, err := parseEntryContent(value, false)
+	if err != nil {
+		return false
+	}
+	options, _ := content.(objectType[any])
+	_, ok = options[clusterOption]
+	return ok
+}
+
+// reads the routes from the entry items of the primary cluster
+func readClusterRoutes(items []etcdItem) map[string]string {
+	routes := map[string]string{}
+	for _, item := range items {
+		name, ok := isRouteEntry(item.Key)
+		if !ok {
+			continue
+		}
+		value, _, err := parseEntryContent(item.Value, false)
+		if err != nil {
+			continue // logged on reload
+		}
+		options, _ := value.(objectType[any])
+		value, ok = options[clusterOption]
+		if !ok {
+			continue
+		}
+		logger := logFrom(log.data(), "option", clusterOption, "entry", item.Key)
+		cluster, ok := value.(string)
+		if _, exists := cli[cluster]; !ok || !exists {
+			logger.Errorf("invalid cluster %v, ignoring the route", value)
+			continue
+		}
+		qname := strings.ToLower(name.normal())
+		logger.Debugf("routing %q to cluster %q", qname, cluster)
+		routes[qname] = cluster
+	}
+	return routes
+}
+
+// reads the routes from the primary cluster (without loading the data), for writing entries to their clusters (see put())
+func loadClusterRoutes() error {
+	if len(clusters) == 1 {
+		return nil
+	}
+	response, err := get(*args.Prefix, true, nil)
+	if err != nil {
+		return err
+	}
+	var items []etcdItem
+	for item := range response.DataChan {
+		items = append(items, item)
+	}
+	setClusterRoutes(readClusterRoutes(items))
+	return nil
+}
+
+// gets the entries with the key prefix from all clusters, each entry only from the cluster its domain name is routed to.
+// the revision (if not nil) applies to the given cluster, the other clusters are read at their current revision.
+// with updateRoutes, the routes are (re)read from the primary cluster first. returns the revisions of the clusters.
+func getRouted(key, revCluster string, revision *int64, updateRoutes bool, timeout time.Duration) (map[string]int64, <-chan etcdItem, error) {
+	if len(clusters) == 1 {
+		response, err := getFromWithin(clusters[0], key, true, revision, timeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]int64{clusters[0]: response.Revision}, response.DataChan, nil
+	}
+	revisions := map[string]int64{}
+	items := map[string][]etcdItem{}
+	for _, cluster := range clusters {
+		var rev *int64
+		if cluster == revCluster {
+			rev = revision
+		}
+		response, err := getFromWithin(cluster, key, true, rev, timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %q: %s", cluster, err)
+		}
+		revisions[cluster] = response.Revision
+		for item := range response.DataChan {
+			items[cluster] = append(items[cluster], item)
+		}
+	}
+	if updateRoutes {
+		setClusterRoutes(readClusterRoutes(items[clusters[0]]))
+	}
+	ch := make(chan etcdItem)
+	go func() {
+		defer close(ch)
+		for _, cluster := range clusters {
+			for _, item := range items[cluster] {
+				if keyCluster(item.Key) == cluster {
+					ch <- item
+				} else {
+					log.data().WithField("cluster", cluster).Tracef("ignoring entry %q, it is routed to another cluster", item.Key)
+				}
+			}
+		}
+	}()
+	return revisions, ch, nil
+}
@@ -0,0 +1,132 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestParseClusters(t *testing.T) {
+	for _, spec := range []struct {
+		value    string
+		expected []clusterEndpoints
+		err      bool
+	}{
+		{"[::1]:2379|127.0.0.1:2379", []clusterEndpoints{{"", []string{"[::1]:2379", "127.0.0.1:2379"}}}, false},
+		{"main=10.0.0.1:2379|10.0.0.2:2379;edge=10.0.1.1:2379", []clusterEndpoints{{"main", []string{"10.0.0.1:2379", "10.0.0.2:2379"}}, {"edge", []string{"10.0.1.1:2379"}}}, false},
+		{"main=10.0.0.1:2379;main=10.0.1.1:2379", nil, true},
+		{"main=10.0.0.1:2379;10.0.1.1:2379", nil, true},
+		{"main=10.0.0.1:2379;edge=", nil, true},
+		{"=10.0.0.1:2379", nil, true},
+	} {
+		actual, err := parseClusters(spec.value)
+		if spec.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", spec.value, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", spec.value, err)
+			continue
+		}
+		if len(actual) != len(spec.expected) {
+			t.Errorf("%q: expected %v, got %v", spec.value, spec.expected, actual)
+			continue
+		}
+		for i := range actual {
+			if actual[i].name != spec.expected[i].name || !equal(actual[i].endpoints, spec.expected[i].endpoints) {
+				t.Errorf("%q: expected %v, got %v", spec.value, spec.expected, actual)
+			}
+		}
+	}
+}
+
+func TestClusterSharding(t *testing.T) {
+	soa := `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`
+	mainKV, mainWatcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":                `{"ttl": 3600}`,
+		"dns/net.example/SOA":           soa,
+		"dns/net.example/A":             `192.0.2.1`,
+		"dns/net.example.sub/-options-": `{"cluster": "edge"}`,
+		"dns/net.example.sub/A":         `192.0.2.99`, // stale, the domain is routed to edge
+	})
+	edgeKV := &testKV{entries: map[string]string{
+		"dns/net.example.sub/SOA": soa,
+		"dns/net.example.sub/A":   `192.0.2.20`,
+		"dns/net.example/A":       `192.0.2.98`, // not routed to edge
+	}, revision: 1, keys: make(chan string, 100)}
+	edgeWatcher := &testWatcher{keys: make(chan string, 10), responses: make(chan clientv3.WatchResponse)}
+	cli["main"], cli["edge"] = cli[""], &clientv3.Client{KV: edgeKV, Watcher: edgeWatcher}
+	delete(cli, "")
+	clusters = []string{"main", "edge"}
+	defer setClusterRoutes(map[string]string{})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-mainKV.keys
+	<-edgeKV.keys
+	<-mainWatcher.keys
+	<-edgeWatcher.keys
+	if contents := lookupContents(t, "example.net.", "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected the A record of the primary cluster, got %q", contents)
+	}
+	if contents := lookupContents(t, "sub.example.net.", "A"); !equal(contents, []string{"192.0.2.20"}) {
+		t.Errorf("expected the A record of the edge cluster, got %q", contents)
+	}
+	if contents := lookupContents(t, "sub.example.net.", "SOA"); len(contents) != 1 {
+		t.Errorf("expected the SOA record of the edge cluster, got %q", contents)
+	}
+	for key, cluster := range map[string]string{
+		"dns/net.example/www/A":     "main",
+		"dns/net.example.sub/A":     "edge",
+		"dns/net/example/sub/www/A": "edge",
+		"dns/net.example.subx/A":    "main",
+	} {
+		if actual := keyCluster(key); actual != cluster {
+			t.Errorf("%s: expected cluster %q, got %q", key, cluster, actual)
+		}
+	}
+	// events are only handled from the cluster the entry is routed to
+	event := func(key, value string, rev int64) *clientv3.Event {
+		return &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: 1, ModRevision: rev}}
+	}
+	updateMutex.Lock()
+	handleEvent("main", event("dns/net.example.sub/A", `192.0.2.66`, 2))
+	handleEvent("edge", event("dns/net.example/A", `192.0.2.67`, 2))
+	updateMutex.Unlock()
+	if contents := lookupContents(t, "sub.example.net.", "A"); !equal(contents, []string{"192.0.2.20"}) {
+		t.Errorf("expected the event of the primary cluster to be ignored, got %q", contents)
+	}
+	if contents := lookupContents(t, "example.net.", "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected the event of the edge cluster to be ignored, got %q", contents)
+	}
+	edgeKV.entries["dns/net.example.sub/A"] = `192.0.2.21`
+	edgeWatcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{event("dns/net.example.sub/A", `192.0.2.21`, 3)}}
+	waitForContent(t, "sub.example.net.", "A", "192.0.2.21")
+	// removing the route reloads the data, the domain is served from the primary cluster then
+	delete(mainKV.entries, "dns/net.example.sub/-options-")
+	updateMutex.Lock()
+	handleEvent("main", &clientv3.Event{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte("dns/net.example.sub/-options-"), ModRevision: 4}})
+	updateMutex.Unlock()
+	if contents := lookupContents(t, "sub.example.net.", "A"); !equal(contents, []string{"192.0.2.99"}) {
+		t.Errorf("expected the A record of the primary cluster after removing the route, got %q", contents)
+	}
+}
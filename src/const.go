@@ -26,24 +26,91 @@ const (
 	defaultEndpointIPv6 = "[::1]:2379"
 	defaultDialTimeout  = 2 * time.Second
 	minimumDialTimeout  = 10 * time.Millisecond
+	minimumReqTimeout   = 10 * time.Millisecond
+	defaultStartTimeout = 10 * time.Second // at least, see startupTimeout()
+	minimumDefaultTTL   = time.Second
+	minimumWatchBackoff = 100 * time.Millisecond
+	maximumWatchBackoff = 30 * time.Second
+	shutdownTimeout     = 10 * time.Second
+	zoneSnapshotsCount  = 10        // per changed zone, for getZoneDiff
+	minimalAnyTTL       = time.Hour // of the synthesized HINFO record (option minimal-any)
+	// the validity period of the synthesized signatures (option dnssec), the inception lies before the signing time for clock skews
+	signatureInceptionOffset = time.Hour
+	signatureValidity        = 14 * 24 * time.Hour
 )
 
 const (
-	pdnsVersionParam = "pdns-version"
-	prefixParam      = "prefix"
-	logParamPrefix   = "log-"
-	configFileParam  = "config-file"
-	endpointsParam   = "endpoints"
-	dialTimeoutParam = "timeout"
+	pdnsVersionParam   = "pdns-version"
+	prefixParam        = "prefix"
+	logParamPrefix     = "log-"
+	logSyslogParam     = "log-syslog"
+	logParam           = "log"
+	configFileParam    = "config-file"
+	endpointsParam     = "endpoints"
+	dialTimeoutParam   = "timeout"
+	reqTimeoutParam    = "request-timeout"
+	certFileParam      = "cert-file"
+	keyFileParam       = "key-file"
+	caFileParam        = "ca-file"
+	usernameParam      = "etcd-username"
+	passwordParam      = "etcd-password"
+	namespaceParam     = "etcd-namespace"
+	lazyLoadParam      = "lazy-load"
+	lookupCacheParam   = "lookup-cache"
+	snapshotFileParam  = "snapshot-file"
+	serialFileParam    = "serial-file"
+	nameCaseParam      = "name-case"
+	strictJSONParam    = "strict-json"
+	explainParam       = "explain"
+	statusParam        = "status"
+	reloadWorkersParam = "reload-workers"
+	defaultTTLParam    = "default-ttl"
+	defaultTTLsParam   = "default-ttls"
+	zonesParam         = "zones"
+	concurrencyParam   = "max-concurrency"
+	startTimeoutParam  = "startup-timeout"
+	keySepParam        = "key-sep"
+	idSepParam         = "id-sep"
+	versionSepParam    = "version-sep"
+	maxAnswersParam    = "max-answers"
+	lookupBatchParam   = "lookup-batch"
+	orderSeedParam     = "order-seed"
+	rateParam          = "rate"
+	burstParam         = "burst"
 )
 
+// values of parameter name-case
 const (
-	defaultsKey      = "-defaults-"
-	optionsKey       = "-options-"
-	keySeparator     = "/"
-	labelPrefix      = "+"
-	idSeparator      = "#"
-	versionSeparator = "@"
+	nameCaseFold           = "fold"            // names are matched case-insensitively (all names are lowercased)
+	nameCasePreserveStrict = "preserve-strict" // names are matched case-sensitively (as given)
+)
+
+const (
+	defaultsKey = "-defaults-"
+	optionsKey  = "-options-"
+	metadataKey = "-metadata-"
+	keysKey     = "-keys-"
+	labelPrefix = "+"
+)
+
+// the default separators in the entry keys (see setSeparators())
+const (
+	defaultKeySeparator     = "/"
+	defaultIDSeparator      = "#"
+	defaultVersionSeparator = "@"
+)
+
+// the separators in the entry keys, set by the parameters key-sep, id-sep and version-sep
+var (
+	keySeparator     = defaultKeySeparator
+	idSeparator      = defaultIDSeparator
+	versionSeparator = defaultVersionSeparator
+)
+
+// the markers of string entry values (see parseEntryContent())
+const (
+	rawStringMarker     = '!'
+	literalStringMarker = '`'
 )
 
 type ipMetaT map[int]struct {
@@ -55,17 +122,99 @@ type ipMetaT map[int]struct {
 var (
 	pid        = os.Getpid()
 	qtypeRegex = regexp.MustCompile("^[A-Z][A-Z0-9]*$")
-	ipMeta     = ipMetaT{
+	kindRegex  = regexp.MustCompile("^[A-Z][A-Z0-9-]*$")
+	// the generic type names of RFC 3597, for types unknown to the program (and possibly to PowerDNS)
+	genericTypeRegex = regexp.MustCompile("^TYPE[0-9]+$")
+	ipMeta           = ipMetaT{
 		4: {4, 1, `.`},
 		6: {16, 2, `:`},
 	}
 	ipHexRE    = regexp.MustCompile("^(0[xX])?([0-9a-fA-F]+)$")
 	ip4OctetRE = regexp.MustCompile("^[0-9]{1,3}$")
 	priorityRE = regexp.MustCompile("{priority:(.*?)}")
+	// digest type → length of the hex digest (DS)
+	dsDigestLengths = map[uint8]int{
+		1: 40, // SHA-1
+		2: 64, // SHA-256
+		3: 64, // GOST R 34.11-94
+		4: 96, // SHA-384
+	}
+	// mnemonic → certificate type (CERT)
+	certTypes = map[string]uint16{
+		"PKIX":    1,
+		"SPKI":    2,
+		"PGP":     3,
+		"IPKIX":   4,
+		"ISPKI":   5,
+		"IPGP":    6,
+		"ACPKIX":  7,
+		"IACPKIX": 8,
+		"URI":     253,
+		"OID":     254,
+	}
+	// the types whose domain names are always absolute (regardless of option trailing-dot)
+	absoluteNameTypes = map[string]bool{
+		"SOA": true,
+		"NS":  true,
+		"MX":  true,
+	}
+	// the DNSSEC types, which may coexist with a CNAME record
+	dnssecTypes = map[string]bool{
+		"DNSKEY":     true,
+		"DS":         true,
+		"RRSIG":      true,
+		"NSEC":       true,
+		"NSEC3":      true,
+		"NSEC3PARAM": true,
+	}
 )
 
 const (
 	autoPtrOption          = "auto-ptr"
+	notAuthoritativeOption = "not-authoritative"
+	notAAOption            = "not-aa" // alias of notAuthoritativeOption
 	ipPrefixOption         = "ip-prefix"
+	ipNetworkOption        = "ip-network"
+	eui64Option            = "eui64"
 	zoneAppendDomainOption = "zone-append-domain"
+	ptrForwardZoneOption   = "ptr-forward-zone"
+	txtChunkOption         = "txt-chunk"
+	contentTemplatesOption = "content-templates"
+	orderOption            = "order"
+	minTTLOption           = "min-ttl"
+	ttlInheritOption       = "ttl-inherit-across-zones"
+	maxTTLOption           = "max-ttl"
+	soaSerialOption        = "soa-serial"
+	clampNegTTLOption      = "clamp-neg-ttl"
+	cnameExclusiveOption   = "cname-exclusive"
+	strictTargetsOption    = "strict-targets"
+	minimalAnyOption       = "minimal-any"
+	anyTypesOption         = "any-types"
+	clusterOption          = "cluster"
+	trailingDotOption      = "trailing-dot"
+	dnssecOption           = "dnssec"
+	viewOption             = "view"
+	ptrTemplateOption      = "ptr-template"
+)
+
+const (
+	txtChunkSize = 255
+)
+
+const (
+	orderAsIs       = "as-is"
+	orderRandom     = "random"
+	orderRoundRobin = "round-robin"
+	orderWeighted   = "weighted"
+)
+
+const (
+	trailingDotAlways    = "always"
+	trailingDotAsWritten = "as-written"
+)
+
+const (
+	soaSerialRevision = "revision"
+	soaSerialUnixtime = "unixtime"
+	soaSerialDate     = "date"
 )
@@ -15,8 +15,11 @@ limitations under the License. */
 package src
 
 import (
-	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -31,9 +34,21 @@ var (
 
 type recordType struct {
 	content  string
-	priority *uint16       // only used when pdnsVersion == 3
-	ttl      time.Duration // TODO make TTL an option, not a value
+	priority *uint16 // only used when pdnsVersion == 3
+	ttl      time.Duration
 	version  *VersionType
+	notAuth  bool      // option not-authoritative
+	disabled bool      // value (or default) disabled, the record is not served by lookup
+	view     *viewType // the networks of the clients the record is served to (see view.go), nil = default view
+	weight   *uint16   // field weight, used by the option value order=weighted, nil = 1
+}
+
+// the weight of the record, 1 if not set
+func (record *recordType) getWeight() uint16 {
+	if record.weight == nil {
+		return 1
+	}
+	return *record.weight
 }
 
 type valuesType struct {
@@ -41,6 +56,7 @@ type valuesType struct {
 	value            interface{}
 	isLastFieldValue bool
 	version          *VersionType
+	equivalents      []string // the ignored keys of unversioned entries equivalent to key (see reload())
 }
 
 type defoptType struct {
@@ -51,14 +67,21 @@ type defoptType struct {
 type dataNode struct {
 	mutex     sync.RWMutex
 	parent    *dataNode
-	lname     string // local name
+	lname     string // local name, folded by parameter name-case (see foldCase())
+	label     string // local name as given, for the output
 	keyPrefix string
 	defaults  map[string]map[string]defoptType // <QTYPE> or "" → (<id> → values)
 	options   map[string]map[string]defoptType // <QTYPE> or "" → (<id> → values)
 	values    map[string]map[string]valuesType // <QTYPE> or "" → (<id> → values) // unprocessed, key "" means lastFieldValue
 	records   map[string]map[string]recordType // <QTYPE> → (<id> → record) // processed
+	metadata  map[string][]string              // <KIND> → values
+	keys      map[string]dnssecKey             // <id> → key (only for zones, see getDomainKeys())
+	autoPtrs  map[string]map[string]net.IP     // <QTYPE> → (<id> → IP) // A/AAAA records with option auto-ptr, see syncAutoPtrs()
 	children  map[string]*dataNode             // key = <lname of subdomain>
 	maxRev    int64                            // the maximum of Rev of all ETCD items
+	zoneID    int64                            // only set for zones (when the SOA record is stored)
+	unloaded  bool                             // only set for zones in lazy-load mode, until they are loaded
+	keyRanges []keyRange                       // only set for zones in lazy-load mode, the ranges of their entry keys (see reloadLazily())
 }
 
 func newDataNode(parent *dataNode, lname, keyPrefix string) *dataNode {
@@ -66,13 +89,18 @@ func newDataNode(parent *dataNode, lname, keyPrefix string) *dataNode {
 		mutex:     sync.RWMutex{},
 		parent:    parent,
 		lname:     lname,
+		label:     lname,
 		keyPrefix: keyPrefix,
 		defaults:  map[string]map[string]defoptType{},
 		options:   map[string]map[string]defoptType{},
 		values:    map[string]map[string]valuesType{},
 		records:   map[string]map[string]recordType{},
+		metadata:  map[string][]string{},
+		keys:      map[string]dnssecKey{},
+		autoPtrs:  map[string]map[string]net.IP{},
 		children:  map[string]*dataNode{},
 		maxRev:    0,
+		zoneID:    0,
 	}
 }
 
@@ -81,9 +109,9 @@ func (dn *dataNode) String() string {
 }
 
 func (dn *dataNode) getQname() string {
-	qname := dn.lname + "."
+	qname := escapeLabel(dn.label, ".") + "."
 	for dn := dn.parent; dn != nil && len(dn.lname) > 0; dn = dn.parent {
-		qname += dn.lname + "."
+		qname += escapeLabel(dn.label, ".") + "."
 	}
 	return qname
 }
@@ -127,6 +155,46 @@ func (dn *dataNode) findZone() *dataNode {
 	})
 }
 
+// finds the closest delegation point (a node with NS records, but without SOA) at or above the node, not crossing the zone apex
+func (dn *dataNode) findDelegation() *dataNode {
+	for dn := dn; dn != nil && !dn.hasSOA(); dn = dn.parent {
+		for _, record := range dn.records["NS"] {
+			if !record.disabled {
+				return dn
+			}
+		}
+	}
+	return nil
+}
+
+// finds the closest DNAME record of a node above the given depth (the DNAME owner itself is not redirected), not crossing the zone apex
+func (dn *dataNode) findDNAME(depth int) (*dataNode, *recordType) {
+	for dn := dn; dn != nil; dn = dn.parent {
+		if dn.depth() < depth {
+			for _, record := range dn.records["DNAME"] {
+				if !record.disabled {
+					return dn, &record
+				}
+			}
+		}
+		if dn.hasSOA() {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// the first enabled ALIAS record of this node, nil if none
+func (dn *dataNode) findAlias() *recordType {
+	records := dn.records["ALIAS"]
+	for _, id := range sortedKeys(records) {
+		if record := records[id]; !record.disabled {
+			return &record
+		}
+	}
+	return nil
+}
+
 func (dn *dataNode) log(args ...any) *logrus.Entry {
 	return logFrom(log.data(), append([]any{"dn", dn.getQname()}, args...)...)
 }
@@ -134,7 +202,7 @@ func (dn *dataNode) log(args ...any) *logrus.Entry {
 func (dn *dataNode) getName() *nameType {
 	var parts []namePart
 	for dn := dn; dn.lname != ""; dn = dn.parent {
-		parts = append(parts, namePart{dn.lname, dn.keyPrefix})
+		parts = append(parts, namePart{dn.lname, dn.keyPrefix, dn.label})
 	}
 	name := nameType(reversed(parts))
 	return &name
@@ -149,6 +217,7 @@ func (dn *dataNode) getChildCreate(name nameType) *dataNode {
 	lChild, ok := dn.children[childLName]
 	if !ok || lChild == nil {
 		lChild = newDataNode(dn, childLName, name.keyPrefix(1))
+		lChild.label = name.label(1) // of the first entry creating the node, if spelled differently
 		dn.children[childLName] = lChild
 	}
 	return lChild.getChildCreate(name.fromDepth(2))
@@ -169,6 +238,46 @@ func (dn *dataNode) getChild(name nameType, rLock bool) *dataNode {
 	return lChild.getChild(name.fromDepth(2), rLock)
 }
 
+// like getChild(name, true), but the name is relative to the node, which is read-locked already (by getChild()).
+// only the descendants are read-locked, so the result is to be unlocked by rUnlockUpwards(dn).
+func (dn *dataNode) getDescendant(name nameType) *dataNode {
+	if name.len() == 0 {
+		return dn
+	}
+	lChild, ok := dn.children[name.lname(1)]
+	if !ok || lChild == nil {
+		return dn
+	}
+	return lChild.getChild(name.fromDepth(2), true)
+}
+
+// whether the name is the name of the node or of one of its descendants
+func (dn *dataNode) isAncestorOf(name nameType) bool {
+	depth := dn.depth()
+	if name.len() < depth {
+		return false
+	}
+	for ancestor := dn; depth > 0; ancestor, depth = ancestor.parent, depth-1 {
+		if ancestor.lname != name.lname(depth) {
+			return false
+		}
+	}
+	return true
+}
+
+// calls f for this node and all of its descendants (depth-first), the descendants of a node are skipped when f returns false for it.
+// each node is read-locked while visiting it and its descendants.
+func (dn *dataNode) walk(f func(*dataNode) bool) {
+	dn.mutex.RLock()
+	defer dn.mutex.RUnlock()
+	if !f(dn) {
+		return
+	}
+	for _, child := range dn.children {
+		child.walk(f)
+	}
+}
+
 func (dn *dataNode) rUnlockUpwards(stopAt *dataNode) {
 	for dn := dn; dn != stopAt; dn = dn.parent {
 		dn.mutex.RUnlock()
@@ -189,6 +298,95 @@ func (dn *dataNode) zoneRev() int64 {
 	return rev
 }
 
+// the zone id is derived from the (folded) zone name, so it is stable across reloads and program instances. the hashes
+// can collide, so the ids are assigned by zoneIDs.
+func makeZoneID(qname string) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(qname))
+	return int64(hash.Sum32() & 0x7fffffff)
+}
+
+// the assigned zone ids, unique among the zones (by their folded name). on a collision the next free id is taken, in
+// the order of the zone names on loading the data (see assignZoneIDs()), or in the order of appearance afterwards.
+var zoneIDs = zoneIDsType{ids: map[string]int64{}, zones: map[int64]string{}}
+
+type zoneIDsType struct {
+	sync.Mutex
+	ids   map[string]int64 // <folded qname> → id
+	zones map[int64]string // id → <folded qname>
+}
+
+// the id of the zone, a new one is assigned if the zone has none
+func (zoneIDs *zoneIDsType) of(qname string) int64 {
+	zoneIDs.Lock()
+	defer zoneIDs.Unlock()
+	return zoneIDs.assign(qname)
+}
+
+// the zones must be locked
+func (zoneIDs *zoneIDsType) assign(qname string) int64 {
+	if id, ok := zoneIDs.ids[qname]; ok {
+		return id
+	}
+	id := makeZoneID(qname)
+	for {
+		if _, ok := zoneIDs.zones[id]; !ok {
+			break
+		}
+		id = (id + 1) & 0x7fffffff
+	}
+	zoneIDs.ids[qname] = id
+	zoneIDs.zones[id] = qname
+	return id
+}
+
+// the folded name of the zone, for its id
+func (dn *dataNode) zoneIDName() string {
+	return dn.getName().normal()
+}
+
+// assigns the ids of the zones of the tree (must be the root node, write-locked) anew, in the order of their names,
+// so the ids do not depend on the order of loading. the ids of the zones of the previous tree are dropped.
+func (dn *dataNode) assignZoneIDs() {
+	zones := map[string]*dataNode{}
+	dn.visit(func(dn *dataNode) {
+		if dn.unloaded || dn.hasSOA() {
+			zones[dn.zoneIDName()] = dn
+		}
+	})
+	qnames := make([]string, 0, len(zones))
+	for qname := range zones {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	zoneIDs.Lock()
+	defer zoneIDs.Unlock()
+	zoneIDs.ids, zoneIDs.zones = map[string]int64{}, map[int64]string{}
+	for _, qname := range qnames {
+		zones[qname].zoneID = zoneIDs.assign(qname)
+	}
+}
+
+// whether neither the node nor a descendant has records (f.e. a node holding only defaults or options, or whose
+// records were deleted), such a domain name does not exist (unlike an empty non-terminal, which has records below).
+// the node must be read-locked, the descendants are read-locked while checking them.
+func (dn *dataNode) isEmpty() bool {
+	if len(dn.records) > 0 {
+		return false
+	}
+	empty := true
+	for _, child := range dn.children {
+		child.walk(func(dn *dataNode) bool {
+			empty = empty && len(dn.records) == 0
+			return empty
+		})
+		if !empty {
+			return false
+		}
+	}
+	return true
+}
+
 func (dn *dataNode) recordsCount() int {
 	count := len(dn.records)
 	for _, child := range dn.children {
@@ -211,7 +409,14 @@ func (dn *dataNode) zonesCount() int {
 }
 
 func cutKey(key, separator string) (string, string) {
-	idx := strings.LastIndex(key, separator)
+	idx := -1
+	for i := 0; i < len(key); i++ {
+		if key[i] == '\\' {
+			i++ // an escaped separator is part of a label
+		} else if strings.HasPrefix(key[i:], separator) {
+			idx = i
+		}
+	}
 	if idx < 0 {
 		return key, ""
 	}
@@ -229,6 +434,33 @@ func cutParts(parts []string, predicate func(string) bool) ([]string, string) {
 	return parts, ""
 }
 
+// whether the key part is a QTYPE: uppercase (also the unsupported ones), or a supported one (including the generic type
+// names) in any case, f.e. for hand-entered data
+func isQtypePart(part string) bool {
+	return qtypeRegex.MatchString(part) || rrFuncOf(strings.ToUpper(part)) != nil
+}
+
+// appends the nibble labels of a compact ip6.arpa key part ("+<IPv6 address or groups>", see expandIP6Labels()) to the
+// name parts. the key prefix of the last label holds the whole key part (and the others only the labelPrefix), so the
+// key can be restored (see nameType.asKey()).
+func appendIP6Labels(nameParts []namePart, part string) ([]namePart, error) {
+	if len(nameParts) < 2 || nameParts[0].name != "arpa" || nameParts[1].name != "ip6" {
+		return nil, fmt.Errorf("compact IPv6 labels (%q) are only allowed below ip6.arpa", part)
+	}
+	labels, err := expandIP6Labels(strings.TrimPrefix(part, labelPrefix), len(nameParts)-2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compact IPv6 labels %q: %s", part, err)
+	}
+	for i, label := range labels {
+		keyPrefix := labelPrefix
+		if i == len(labels)-1 {
+			keyPrefix = keySeparator + part
+		}
+		nameParts = append(nameParts, namePart{label, keyPrefix, label})
+	}
+	return nameParts, nil
+}
+
 func parseEntryKey(key string) (name nameType, entryType entryType, qtype, id string, version *VersionType, err error) {
 	key = strings.TrimPrefix(key, *args.Prefix)
 	// note: qtype is also used as temp variable until it is set itself
@@ -245,8 +477,13 @@ func parseEntryKey(key string) (name nameType, entryType entryType, qtype, id st
 	key, id = cutKey(key, idSeparator)
 	// name+entryType+qtype
 	parts := splitDomainName(key, keySeparator)
-	// qtype
-	parts, qtype = cutParts(parts, qtypeRegex.MatchString)
+	// qtype (or metadata kind)
+	if n := len(parts); n >= 2 && parts[n-2] == metadataKey && kindRegex.MatchString(parts[n-1]) {
+		parts, qtype = parts[:n-1], parts[n-1]
+	} else {
+		parts, qtype = cutParts(parts, isQtypePart)
+		qtype = strings.ToUpper(qtype)
+	}
 	// entryType
 	{
 		idx := len(parts) - 1
@@ -262,6 +499,13 @@ func parseEntryKey(key string) (name nameType, entryType entryType, qtype, id st
 	// name
 	var nameParts []namePart
 	for _, part := range parts {
+		if strings.HasPrefix(part, labelPrefix) {
+			nameParts, err = appendIP6Labels(nameParts, part)
+			if err != nil {
+				return
+			}
+			continue
+		}
 		subParts := splitDomainName(part, ".")
 		for i := 0; i < len(subParts); i++ {
 			var keyPrefix string
@@ -272,7 +516,8 @@ func parseEntryKey(key string) (name nameType, entryType entryType, qtype, id st
 			} else { // other sub-parts were separated by a dot
 				keyPrefix = "."
 			}
-			nameParts = append(nameParts, namePart{subParts[i], keyPrefix})
+			label := unescapeLabel(subParts[i])
+			nameParts = append(nameParts, namePart{foldCase(label), keyPrefix, label})
 		}
 	}
 	name = nameType(nameParts)
@@ -285,9 +530,26 @@ func parseEntryKey(key string) (name nameType, entryType entryType, qtype, id st
 		err = fmt.Errorf("SOA entry cannot have an id (%q)", id)
 		return
 	}
+	if entryType == metadataEntry && id != "" {
+		err = fmt.Errorf("metadata entry cannot have an id (%q)", id)
+		return
+	}
+	if entryType == keysEntry && (qtype != "" || !isDigits(id) || id == "") {
+		err = fmt.Errorf("keys entry must have a numeric id and no qtype (%q, %q)", id, qtype)
+		return
+	}
 	return
 }
 
+// a plain string value given with the raw marker ('!'), which is stored as record content without any processing
+type stringValueType struct {
+	value     string
+	noParsing bool
+}
+
+// parses the value of an entry by its first character (the marker): '!' (raw string, optionally followed by '`', which is
+// dropped too), '`' (literal string), '=' (last-field-value), '{' (JSON object) or "---" (YAML object). the string
+// markers take precedence, everything after them is the string. values without a marker are plain strings.
 func parseEntryContent(value []byte, allowString bool) (interface{}, bool, error) {
 	if len(value) == 0 {
 		if allowString {
@@ -296,20 +558,36 @@ func parseEntryContent(value []byte, allowString bool) (interface{}, bool, error
 		return nil, false, fmt.Errorf("empty")
 	}
 	switch value[0] {
+	case rawStringMarker:
+		if allowString {
+			return stringValueType{strings.TrimPrefix(string(value[1:]), string(literalStringMarker)), true}, false, nil
+		}
+	case literalStringMarker:
+		if allowString {
+			return string(value[1:]), false, nil
+		}
 	case '=': // last-field-value syntax
 		var content interface{}
-		err := json.Unmarshal(value[1:], &content)
+		err := unmarshalJSON(value[1:], &content)
 		if err != nil {
 			return nil, true, fmt.Errorf("failed to parse as JSON value: %s", err)
 		}
 		return content, true, nil
 	case '{':
 		values := objectType[any](nil)
-		err := json.Unmarshal(value, &values)
+		err := unmarshalJSON(value, &values)
 		if err != nil {
 			return nil, false, fmt.Errorf("failed to parse as JSON object: %s", err)
 		}
 		return values, false, nil
+	case '-':
+		if document, ok := cutYAMLDocument(value); ok {
+			values, err := parseYAMLObject(document)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to parse as YAML object: %s", err)
+			}
+			return values, false, nil
+		}
 	}
 	if allowString {
 		return string(value), false, nil
@@ -317,15 +595,22 @@ func parseEntryContent(value []byte, allowString bool) (interface{}, bool, error
 	return nil, false, fmt.Errorf("invalid")
 }
 
-func (dn *dataNode) reload(dataChan <-chan etcdItem) {
+// processes the entry items into the (cleared) node. returns the numbers of entries ignored due to version
+// incompatibility, by version.
+func (dn *dataNode) reload(dataChan <-chan etcdItem) map[string]int {
 	since := time.Now()
 	clearMap(dn.defaults)
 	clearMap(dn.options)
 	clearMap(dn.values)
 	clearMap(dn.records)
+	clearMap(dn.metadata)
+	clearMap(dn.keys)
 	clearMap(dn.children)
 	dn.log().Debug("processing entry items from ETCD")
 	depth := dn.depth()
+	metadataKinds := map[*dataNode]map[string]bool{} // the kinds set by kind-specific metadata entries (they override the object entries)
+	collisions := 0                                  // unversioned record entries with equivalent keys
+	incompatible := map[string]int{}                 // the entries ignored due to version incompatibility, by version
 ITEMS:
 	for item := range dataChan {
 		name, entryType, qtype, id, version, err := parseEntryKey(item.Key)
@@ -333,6 +618,7 @@ ITEMS:
 		// check version first, because a higher version (than our current dataVersion) could change the key syntax (but not prefix and version suffix)
 		if version != nil && !dataVersion.isCompatibleTo(version) {
 			dn.log("my", dataVersion, "their", *version).Tracef("ignoring entry %q due to version incompatibility", item.Key)
+			incompatible[version.String()]++
 			continue ITEMS
 		}
 		if err != nil {
@@ -395,22 +681,32 @@ ITEMS:
 		switch entryType {
 		case normalEntry:
 			// if entry already present, only overwrite it if version dictates it, otherwise ignore
+			var equivalents []string
 			if curr, ok := itemData.values[qtype]; ok {
 				if curr, ok := curr[id]; ok {
 					if version == nil && curr.version == nil {
-						dn.log().Errorf("ignoring entry %q due to duplication", item.Key)
-						continue ITEMS
-					}
-					if version != nil && curr.version != nil && version.Minor <= curr.version.Minor {
-						dn.log("old", curr.version, "new", version).Tracef("ignoring entry %q due to version constraints", item.Key)
-						continue ITEMS
+						// equivalent keys (f.e. "a.b/A" and "a/b/A"), the first one in key order wins (independent of the order of the items)
+						collisions++
+						if item.Key > curr.key {
+							dn.log("equivalent", curr.key).Errorf("ignoring entry %q due to duplication", item.Key)
+							curr.equivalents = append(curr.equivalents, item.Key)
+							itemData.values[qtype][id] = curr
+							continue ITEMS
+						}
+						dn.log("equivalent", item.Key).Errorf("ignoring entry %q due to duplication", curr.key)
+						equivalents = append(curr.equivalents, curr.key)
+					} else {
+						if version != nil && curr.version != nil && version.Minor <= curr.version.Minor {
+							dn.log("old", curr.version, "new", version).Tracef("ignoring entry %q due to version constraints", item.Key)
+							continue ITEMS
+						}
+						dn.log("target", rrParams.Target(), "entry", item.Key, "old-version", curr.version).Trace("overriding existing entry due to version constraints")
 					}
-					dn.log("target", rrParams.Target(), "entry", item.Key, "old-version", curr.version).Trace("overriding existing entry due to version constraints")
 				}
 			} else {
 				itemData.values[qtype] = map[string]valuesType{}
 			}
-			itemData.values[qtype][id] = valuesType{item.Key, value, isLastFieldValue, version}
+			itemData.values[qtype][id] = valuesType{item.Key, value, isLastFieldValue, version, equivalents}
 		case defaultsEntry:
 			fallthrough
 		case optionsEntry:
@@ -431,6 +727,54 @@ ITEMS:
 			}
 			vals[qtype][id] = defoptType{value.(objectType[any]), version}
 			dn.log().Tracef("stored %s for %s: %v", entryType2key[entryType], rrParams.Target(), value)
+		case metadataEntry:
+			if _, ok := metadataKinds[itemData]; !ok {
+				metadataKinds[itemData] = map[string]bool{}
+			}
+			if qtype != "" {
+				values, err := parseMetadataValues(value)
+				if err != nil {
+					dn.log().Errorf("failed to parse metadata values of %q: %s", item.Key, err)
+					continue ITEMS
+				}
+				itemData.metadata[qtype] = values
+				metadataKinds[itemData][qtype] = true
+				dn.log().Tracef("stored metadata %s for %s: %v", qtype, itemData.getQname(), values)
+				break
+			}
+			object, ok := value.(objectType[any])
+			if !ok {
+				dn.log().Errorf("failed to parse metadata of %q: not an object (%T)", item.Key, value)
+				continue ITEMS
+			}
+			for kind, value := range object {
+				if !kindRegex.MatchString(kind) {
+					dn.log().Warnf("ignoring metadata kind %q in %q: invalid syntax", kind, item.Key)
+					continue
+				}
+				if metadataKinds[itemData][kind] {
+					dn.log().Tracef("ignoring metadata kind %s in %q, already set by a kind-specific entry", kind, item.Key)
+					continue
+				}
+				values, err := parseMetadataValues(value)
+				if err != nil {
+					dn.log().Errorf("failed to parse metadata values of kind %s in %q: %s", kind, item.Key, err)
+					continue
+				}
+				itemData.metadata[kind] = values
+				dn.log().Tracef("stored metadata %s for %s: %v", kind, itemData.getQname(), values)
+			}
+		case keysEntry:
+			key, err := parseDNSSECKey(item.Key, id, value)
+			if err != nil {
+				dn.log().Errorf("failed to parse the key %q: %s", item.Key, err)
+				continue ITEMS
+			}
+			if key.signer == nil {
+				dn.log().Warnf("the key %q cannot be used for signing: %s", item.Key, key.signerErr)
+			}
+			itemData.keys[id] = key
+			dn.log().Tracef("stored key %s for %s", id, itemData.getQname())
 		default:
 			dn.log().Warnf("unsupported entry type %q, ignoring entry %q", entryType, item.Key)
 		}
@@ -439,25 +783,45 @@ ITEMS:
 	}
 	dn.processValues()
 	dur := time.Since(since)
-	dn.log("duration", dur).Trace("reload() finished")
+	dn.log("duration", dur, "collisions", collisions).Debug("reload() finished")
+	return incompatible
+}
+
+// the number of goroutines processing the values of a tree in parallel, defaults to the number of CPUs
+func reloadWorkers() int {
+	if args.ReloadWorkers == nil || *args.ReloadWorkers == 0 {
+		return runtime.NumCPU()
+	}
+	return *args.ReloadWorkers
 }
 
 func (dn *dataNode) processValues() {
+	// the current goroutine is a worker too
+	dn.processValuesWith(newWorkerPool(reloadWorkers() - 1))
+}
+
+// sibling subtrees are independent (every node stores only its own records and reads only from its ancestors,
+// which are processed before), so the children are processed in parallel as far as the pool allows
+func (dn *dataNode) processValuesWith(pool workerPool) {
 	dn.log().Trace("processing values to records")
 	dn.records = map[string]map[string]recordType{}
+	dn.autoPtrs = map[string]map[string]net.IP{}
+	dn.zoneID = 0
 	// process SOA first, to have proper zone appending for other entries
-	if values, ok := dn.values["SOA"]; ok {
-		for id, values := range values {
-			rrParams := rrParams{
-				qtype:   "SOA",
-				id:      id,
-				version: values.version,
-				data:    dn,
-				//logger:  log.data(), // TODO remove?
-			}
-			processValuesEntry(&rrParams, &values)
-		}
+	dn.processSOA()
+	dn.processEntries()
+	dn.checkDNAME()
+	dn.checkCNAME()
+	wg := sync.WaitGroup{}
+	for _, child := range dn.children {
+		child := child
+		pool.run(&wg, func() { child.processValuesWith(pool) })
 	}
+	wg.Wait()
+}
+
+// processes the values into records, except SOA (see processSOA())
+func (dn *dataNode) processEntries() {
 	for qtype, values := range dn.values {
 		if qtype == "SOA" {
 			continue
@@ -473,26 +837,230 @@ func (dn *dataNode) processValues() {
 			processValuesEntry(&rrParams, &values)
 		}
 	}
-	for _, child := range dn.children {
-		child.processValues()
+}
+
+// (re-)processes the SOA values into records, the zone revision (serial) is taken at this time
+func (dn *dataNode) processSOA() {
+	for id, values := range dn.values["SOA"] {
+		rrParams := rrParams{
+			qtype:   "SOA",
+			id:      id,
+			version: values.version,
+			data:    dn,
+		}
+		processValuesEntry(&rrParams, &values)
+	}
+}
+
+func (dn *dataNode) checkDNAME() {
+	if _, ok := dn.records["DNAME"]; ok {
+		for _, qtype := range []string{"A", "AAAA"} {
+			if _, ok := dn.records[qtype]; ok {
+				dn.log("qtype", qtype).Warn("DNAME record coexists with other data at the same name")
+			}
+		}
 	}
 }
 
+// drops the records coexisting with a CNAME record at the same name (RFC 1034, section 3.6.2), except the DNSSEC types.
+// enabled by default, can be disabled by the option cname-exclusive.
+func (dn *dataNode) checkCNAME() {
+	if _, ok := dn.records["CNAME"]; !ok {
+		return
+	}
+	exclusive, oPath, err := findOptionValue[bool](cnameExclusiveOption, "CNAME", "", dn, false)
+	if err != nil {
+		dn.log("option", cnameExclusiveOption, "error", err).Error("failed to get option, assuming true")
+	} else if oPath != nil && !exclusive {
+		return
+	}
+	for qtype := range dn.records {
+		if qtype == "CNAME" || dnssecTypes[qtype] {
+			continue
+		}
+		dn.log("qtype", qtype).Error("CNAME record coexists with other data at the same name, dropping the other records")
+		delete(dn.records, qtype)
+		delete(dn.autoPtrs, qtype)
+	}
+}
+
+// checks the targets of the NS, MX and SRV records of the node and its descendants, which must not be the owner of a
+// CNAME record in the tree of root (RFC 2181, section 10.3). a violation is logged as a warning, or as an error when
+// the option strict-targets is set. the records are served anyway. the caller must hold the writer lock of the node (f.e.
+// of root on loading or of a zone on an update) and the reader locks of its ancestors, the other nodes are read-locked
+// while checking a target in them.
+func (dn *dataNode) checkTargets(root *dataNode) {
+	locked := dn
+	// the target node, read-locked if outside of the locked node (see getDescendant()), to be released by the returned function
+	targetNode := func(name nameType) (*dataNode, func()) {
+		ancestor := locked
+		for ancestor.parent != nil && !ancestor.isAncestorOf(name) {
+			ancestor = ancestor.parent
+		}
+		if ancestor == locked {
+			return root.getChild(name, false), func() {}
+		}
+		node := ancestor.getDescendant(name.fromDepth(ancestor.depth() + 1))
+		return node, func() { node.rUnlockUpwards(ancestor) }
+	}
+	dn.visit(func(dn *dataNode) {
+		for _, qtype := range []string{"NS", "MX", "SRV"} {
+			for id, record := range dn.records[qtype] {
+				fields := strings.Fields(recordContent(&record, defaultPdnsVersion))
+				if len(fields) == 0 {
+					continue
+				}
+				target := fields[len(fields)-1]
+				name := parseName(target)
+				node, release := targetNode(name)
+				isCNAME := node.depth() == name.len() && len(node.records["CNAME"]) > 0
+				release()
+				if !isCNAME {
+					continue
+				}
+				strict, _, err := findOptionValue[bool](strictTargetsOption, qtype, id, dn, false)
+				if err != nil {
+					dn.log("option", strictTargetsOption, "error", err).Warn("failed to get option, assuming false")
+				}
+				entry := dn.log("qtype", qtype, "id", id)
+				if strict {
+					entry.Errorf("the target %q is the owner of a CNAME record", target)
+				} else {
+					entry.Warnf("the target %q is the owner of a CNAME record", target)
+				}
+			}
+		}
+	})
+}
+
+// updates (or deletes) a single unversioned normal entry of this node in place, instead of reloading the whole zone.
+// the caller must hold the writer lock of the zone (or of this node) and update the SOA record afterwards (see processSOA()).
+func (dn *dataNode) updateEntry(key, qtype, id string, value []byte, rev int64, deleted bool) {
+	if records, ok := dn.records[qtype]; ok {
+		delete(records, id)
+		if len(records) == 0 {
+			delete(dn.records, qtype)
+		}
+	}
+	if values, ok := dn.values[qtype]; ok {
+		delete(values, id)
+		if len(values) == 0 {
+			delete(dn.values, qtype)
+		}
+	}
+	if ips, ok := dn.autoPtrs[qtype]; ok {
+		delete(ips, id)
+		if len(ips) == 0 {
+			delete(dn.autoPtrs, qtype)
+		}
+	}
+	dn.maxRev = maxOf(dn.maxRev, rev)
+	if deleted {
+		dn.log().Tracef("deleted entry %q", key)
+		if qtype == "CNAME" {
+			// restore the records which were dropped in favor of the CNAME
+			dn.processEntries()
+			dn.checkDNAME()
+			dn.checkCNAME()
+		}
+		return
+	}
+	content, isLastFieldValue, err := parseEntryContent(value, true)
+	if err != nil {
+		dn.log().Errorf("failed to parse content of %q: %s", key, err)
+		return
+	}
+	values := valuesType{key, content, isLastFieldValue, nil, nil}
+	if _, ok := dn.values[qtype]; !ok {
+		dn.values[qtype] = map[string]valuesType{}
+	}
+	dn.values[qtype][id] = values
+	rrParams := rrParams{
+		qtype: qtype,
+		id:    id,
+		data:  dn,
+	}
+	processValuesEntry(&rrParams, &values)
+	dn.checkDNAME()
+	dn.checkCNAME()
+}
+
 func processValuesEntry(rrParams *rrParams, values *valuesType) {
-	ttl, vPath, err := getDuration("ttl", rrParams)
-	if vPath == nil || err != nil {
+	var object objectType[any]
+	if !values.isLastFieldValue {
+		object, _ = values.value.(objectType[any])
+	}
+	ttl, vPath, err := getTTL(rrParams, object)
+	if paramTTL, param := qtypeDefaultTTL(rrParams.qtype); vPath == nil && err == nil && paramTTL > 0 {
+		ttl = paramTTL
+		logFrom(log.data(), "ttl", ttl).Tracef("no TTL found for entry %q, using parameter %s", values.key, param)
+		rrParams.explain("ttl %s from the parameter %s", ttl, param)
+	} else if vPath == nil || err != nil {
 		logFrom(log.data(), "vp", vPath, "error", err).Errorf("failed to get TTL for entry %q, ignoring", values.key)
+		rrParams.explain("no ttl found (error: %s), the entry is ignored", err2str(err))
 		return
+	} else {
+		if vPath.data != rrParams.data {
+			logFrom(log.data(), "ttl", ttl).Debugf("TTL of entry %q inherited from %s", values.key, vPath)
+		}
+		rrParams.explain("ttl %s from %s", ttl, vPath)
+	}
+	clamped, err := clampTTL(ttl, rrParams)
+	if err != nil {
+		logFrom(log.data(), "error", err).Errorf("failed to clamp TTL for entry %q, ignoring", values.key)
+		return
+	}
+	if clamped != ttl {
+		rrParams.explain("ttl clamped to %s (options %s/%s)", clamped, minTTLOption, maxTTLOption)
+	}
+	rrParams.ttl = clamped
+	notAuth, err := notAuthoritative(rrParams)
+	if err != nil {
+		logFrom(log.data(), "error", err).Errorf("failed to get option for entry %q, ignoring", values.key)
+		return
+	}
+	rrParams.notAuth = notAuth
+	disabled, err := isDisabled(rrParams, object)
+	if err != nil {
+		logFrom(log.data(), "error", err).Errorf("failed to get value for entry %q, ignoring", values.key)
+		return
+	}
+	rrParams.disabled = disabled
+	view, err := recordView(rrParams, object)
+	if err != nil {
+		logFrom(log.data(), "error", err).Errorf("failed to get the view for entry %q, ignoring", values.key)
+		return
+	}
+	rrParams.view = view
+	weight, err := recordWeight(rrParams, object)
+	if err != nil {
+		logFrom(log.data(), "error", err).Errorf("failed to get the weight for entry %q, ignoring", values.key)
+		return
+	}
+	rrParams.weight = weight
+	if view != nil {
+		rrParams.explain("view: %s", strings.Join(view.strings(), ", "))
+	}
+	if notAuth || disabled {
+		rrParams.explain("not-authoritative: %v, disabled: %v", notAuth, disabled)
 	}
-	rrParams.ttl = ttl
 	if values.isLastFieldValue {
-		rrFunc := rr2func[rrParams.qtype]
+		rrFunc := rrFuncOf(rrParams.qtype)
 		if rrFunc == nil {
 			log.data().WithField("entry", values.key).Errorf("record type %q is not object-supported (tried to use last-field-value syntax)", rrParams.qtype)
 			return
 		}
 		rrParams.values = objectType[any]{}
-		rrParams.lastFieldValue = &values.value
+		lastFieldValue := values.value
+		if str, ok := lastFieldValue.(string); ok {
+			expanded, err := expandTemplate(str, rrParams)
+			if err != nil {
+				log.data().WithField("entry", values.key).Errorf("failed to expand the content template, ignoring entry: %s", err)
+				return
+			}
+			lastFieldValue = expanded
+		}
+		rrParams.lastFieldValue = &lastFieldValue
 		rrFunc(rrParams)
 	} else {
 		switch value := values.value.(type) {
@@ -501,10 +1069,31 @@ func processValuesEntry(rrParams *rrParams, values *valuesType) {
 				log.data().Errorf("ignoring plain string entry %q, because it is a SOA record, which must be of object type", values.key)
 				return
 			}
+			value, err := expandTemplate(value, rrParams)
+			if err != nil {
+				log.data().WithField("entry", values.key).Errorf("failed to expand the content template, ignoring entry: %s", err)
+				return
+			}
+			if genericTypeRegex.MatchString(rrParams.qtype) {
+				if err := checkGenericRdata(value); err != nil {
+					log.data().WithField("entry", values.key).Errorf("ignoring invalid generic record data (RFC 3597) of %q: %s", rrParams.qtype, err)
+					return
+				}
+			}
 			logFrom(log.data(), "value", value).Tracef("found plain string value for %s", rrParams.Target())
 			rrParams.SetContent(value, nil)
+			if rrParams.qtype == "A" || rrParams.qtype == "AAAA" {
+				handleAutoPtr(rrParams, net.ParseIP(value))
+			}
+		case stringValueType:
+			logFrom(log.data(), "value", value.value).Tracef("found raw string value for %s", rrParams.Target())
+			rrParams.SetContent(value.value, nil)
 		case objectType[any]:
-			rrFunc := rr2func[rrParams.qtype]
+			rrFunc := rrFuncOf(rrParams.qtype)
+			if _, ok := value["rdata"]; ok && rrParams.qtype != "SOA" {
+				// raw record data in the generic form, for any QTYPE (f.e. for types not supported yet)
+				rrFunc = genericRdata("rdata")
+			}
 			if rrFunc == nil {
 				log.data().WithField("entry", values.key).Errorf("record type %q is not object-supported", rrParams.qtype)
 				return
@@ -0,0 +1,571 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var testUpdateEntries = map[string]string{
+	"dns/-defaults-":          `{"ttl": 3600}`,
+	"dns/net.example/SOA":     `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"dns/net.example/MX":      `{"priority": 10, "target": "mail"}`,
+	"dns/net.example/www/A":   `192.0.2.1`,
+	"dns/net.example/sub/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"dns/net.example/sub/A":   `192.0.2.2`,
+}
+
+// all records of the data tree by qname (SOA records are left out if withSOA is false)
+func collectRecords(root *dataNode, withSOA bool) map[string]map[string]map[string]recordType {
+	result := map[string]map[string]map[string]recordType{}
+	root.walk(func(dn *dataNode) bool {
+		records := map[string]map[string]recordType{}
+		for qtype, recs := range dn.records {
+			if qtype != "SOA" || withSOA {
+				records[qtype] = recs
+			}
+		}
+		if len(records) > 0 {
+			result[dn.getQname()] = records
+		}
+		return true
+	})
+	return result
+}
+
+func TestUpdateEntry(t *testing.T) {
+	for _, spec := range []struct {
+		key, value  string
+		deleted     bool
+		incremental bool
+	}{
+		{"dns/net.example/www/A", `192.0.2.11`, false, true},
+		{"dns/net.example/www/TXT", `text`, false, true},
+		{"dns/net.example/www/A", ``, true, true},
+		{"dns/net.example/MX", ``, true, true},
+		{"dns/net.example/MX", `{"priority": "invalid"}`, false, true},
+		{"dns/net.example/sub/A", `192.0.2.22`, false, true},
+		{"dns/net.example/www/A#2", `192.0.2.12`, false, true},
+		{"dns/net.example/new/A", `192.0.2.3`, false, true},
+		{"dns/net.example/new/sub/A", `192.0.2.4`, false, true},
+		{"dns/net.example/gone/A", ``, true, false},
+		{"dns/net.example/www/TXT@0.1.1", `versioned`, false, false},
+		{"dns/net.example/-defaults-", `{"ttl": 60}`, false, false},
+		{"dns/net.example/SOA", `{"primary": "ns2", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`, false, false},
+	} {
+		entries := map[string]string{}
+		for key, value := range testUpdateEntries {
+			entries[key] = value
+		}
+		kv, _ := newTestETCD("dns/", entries)
+		if _, err := loadData("test"); err != nil {
+			t.Fatalf("loadData() failed: %s", err)
+		}
+		drainKeys(kv)
+		event := clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(spec.key), Value: []byte(spec.value), CreateRevision: 2, ModRevision: 2}}
+		if spec.deleted {
+			event.Type = clientv3.EventTypeDelete
+			delete(kv.entries, spec.key)
+		} else {
+			kv.entries[spec.key] = spec.value
+			kv.revisions = map[string]int64{spec.key: 2}
+		}
+		handleEvent("", &event)
+		if reloaded := len(drainKeys(kv)) > 0; reloaded == spec.incremental {
+			t.Errorf("%+v: expected incremental update %v, but zone reloaded: %v", spec, spec.incremental, reloaded)
+		}
+		// the serials may differ: on a full reload the serial of a zone goes backwards after a deletion and includes the nested zones (see zoneRev())
+		updated := collectRecords(dataRoot.Load(), false)
+		if spec.incremental {
+			name, _, _, _, _, _ := parseEntryKey(spec.key)
+			zone := dataRoot.Load().getChild(name, false).findZone()
+			if serial := strings.Fields(zone.records["SOA"][""].content)[2]; serial != "2" {
+				t.Errorf("%+v: expected the serial of zone %q to be updated to 2, got %s", spec, zone.getQname(), serial)
+			}
+		}
+		if _, err := loadData("test"); err != nil {
+			t.Fatalf("loadData() failed: %s", err)
+		}
+		drainKeys(kv)
+		reloaded := collectRecords(dataRoot.Load(), false)
+		if !reflect.DeepEqual(updated, reloaded) {
+			t.Errorf("%+v: records differ:\n updated: %v\nreloaded: %v", spec, updated, reloaded)
+		}
+	}
+}
+
+func TestUpdateEquivalentEntry(t *testing.T) {
+	const served, ignored = "dns/net.example.www/A", "dns/net/example/www/A"
+	for _, spec := range []struct {
+		key     string
+		value   string
+		deleted bool
+		served  string
+		content string
+	}{
+		{ignored, "", true, served, "192.0.2.1"},
+		{ignored, "192.0.2.9", false, served, "192.0.2.1"},
+		{served, "", true, ignored, "192.0.2.2"},
+	} {
+		entries := map[string]string{}
+		for key, value := range testUpdateEntries {
+			entries[key] = value
+		}
+		delete(entries, "dns/net.example/www/A")
+		entries[served] = "192.0.2.1"
+		entries[ignored] = "192.0.2.2"
+		kv, _ := newTestETCD("dns/", entries)
+		if _, err := loadData("test"); err != nil {
+			t.Fatalf("loadData() failed: %s", err)
+		}
+		drainKeys(kv)
+		event := clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(spec.key), Value: []byte(spec.value), CreateRevision: 2, ModRevision: 2}}
+		if spec.deleted {
+			event.Type = clientv3.EventTypeDelete
+			delete(kv.entries, spec.key)
+		} else {
+			kv.entries[spec.key] = spec.value
+			kv.revisions = map[string]int64{spec.key: 2}
+		}
+		handleEvent("", &event)
+		if len(drainKeys(kv)) == 0 {
+			t.Errorf("%+v: expected the data to be reloaded", spec)
+		}
+		www := dataRoot.Load().getChild(parseName("www.example.net."), false)
+		if values := www.values["A"][""]; values.key != spec.served {
+			t.Errorf("%+v: expected the entry %q to be served, got %q", spec, spec.served, values.key)
+		}
+		if records := www.records["A"]; len(records) != 1 || records[""].content != spec.content {
+			t.Errorf("%+v: unexpected records %v", spec, records)
+		}
+	}
+}
+
+// a tree of <zones> zones with <hosts> hosts each (with an A and a TXT entry), the values are not processed yet
+func newSyntheticTree(zones, hosts int) *dataNode {
+	value := func(content string) valuesType {
+		value, isLastFieldValue, err := parseEntryContent([]byte(content), true)
+		if err != nil {
+			panic(err)
+		}
+		return valuesType{"", value, isLastFieldValue, nil, nil}
+	}
+	root := newDataNode(nil, "", "")
+	root.defaults[""] = map[string]defoptType{"": {objectType[any]{"ttl": float64(3600)}, nil}}
+	for z := 0; z < zones; z++ {
+		zone := root.getChildCreate(testName(fmt.Sprintf("zone%d.example.", z)))
+		zone.values["SOA"] = map[string]valuesType{"": value(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`)}
+		zone.values["NS"] = map[string]valuesType{"": value(`ns1`)}
+		for h := 0; h < hosts; h++ {
+			host := zone.getChildCreate(testName(fmt.Sprintf("host%d", h)))
+			host.values["A"] = map[string]valuesType{"": value(fmt.Sprintf("192.0.%d.%d", z%256, h%256))}
+			host.values["TXT"] = map[string]valuesType{"": value(fmt.Sprintf(`="host %d in zone %d"`, h, z))}
+		}
+	}
+	return root
+}
+
+func withReloadWorkers(workers int, f func()) {
+	defer func(workers *int) { args.ReloadWorkers = workers }(args.ReloadWorkers)
+	args.ReloadWorkers = &workers
+	f()
+}
+
+func TestProcessValuesParallel(t *testing.T) {
+	serial := newSyntheticTree(20, 50)
+	withReloadWorkers(1, serial.processValues)
+	expected := collectRecords(serial, true)
+	if len(expected) != 20*51 {
+		t.Fatalf("expected records for %d names, got %d", 20*51, len(expected))
+	}
+	for _, workers := range []int{2, 8, 0} {
+		parallel := newSyntheticTree(20, 50)
+		withReloadWorkers(workers, parallel.processValues)
+		if actual := collectRecords(parallel, true); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%d workers: records differ from the serial processing", workers)
+		}
+		if parallel.zonesCount() != serial.zonesCount() {
+			t.Errorf("%d workers: expected %d zones, got %d", workers, serial.zonesCount(), parallel.zonesCount())
+		}
+	}
+}
+
+func BenchmarkProcessValues(b *testing.B) {
+	// 100 zones with 100 hosts each => 10k nodes
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			withReloadWorkers(workers, func() {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					root := newSyntheticTree(100, 100)
+					b.StartTimer()
+					root.processValues()
+				}
+			})
+		})
+	}
+}
+
+func TestIncompatibleVersions(t *testing.T) {
+	newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":              `{"ttl": 3600}`,
+		"dns/net.example/SOA":         `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A":       `192.0.2.1`,
+		"dns/net.example/www/A#2@0.1": `192.0.2.2`,
+		"dns/net.example/www/A#3@0.2": `192.0.2.3`,
+		"dns/net.example/www/TXT@0.2": `text`,
+		"dns/net.example/new/A@0.9":   `192.0.2.4`,
+		"dns/net.example/old/A@1.0":   `192.0.2.5`,
+	})
+	hook := logtest.NewLocal(log.main())
+	defer hook.Reset()
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	if records := dataRoot.Load().getChild(parseName("www.example.net."), false).records; len(records["A"]) != 2 || len(records["TXT"]) != 0 {
+		t.Errorf("expected only the compatible records, got %v", records)
+	}
+	warned := map[string]any{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "incompatible") {
+			warned[entry.Data["version"].(string)] = entry.Data["#"]
+		}
+	}
+	if expected := map[string]any{"0.2.0": 2, "0.9.0": 1, "1.0": 1}; !reflect.DeepEqual(warned, expected) {
+		t.Errorf("expected the warnings %v, got %v", expected, warned)
+	}
+}
+
+func TestEquivalentKeys(t *testing.T) {
+	newTestETCD("dns/", nil)
+	hook := logtest.NewLocal(log.data())
+	defer hook.Reset()
+	keys := []string{"dns/net/example/www/A", "dns/net.example/www/A", "dns/net.example.www/A"}
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 0, 2}} {
+		hook.Reset()
+		items := make(chan etcdItem, len(keys)+1)
+		items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+		for _, i := range order {
+			items <- etcdItem{keys[i], []byte(fmt.Sprintf("192.0.2.%d", i+1)), 1}
+		}
+		close(items)
+		root := newDataNode(nil, "", "")
+		root.reload(items)
+		www := root.getChild(parseName("www.example.net."), false)
+		if values := www.values["A"][""]; values.key != "dns/net.example.www/A" {
+			t.Errorf("%v: expected the entry of the first key in order, got %q", order, values.key)
+		}
+		if records := www.records["A"]; len(records) != 1 || records[""].content != "192.0.2.3" {
+			t.Errorf("%v: unexpected records %v", order, records)
+		}
+		errors := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.ErrorLevel && strings.Contains(entry.Message, "due to duplication") {
+				errors++
+			}
+		}
+		if errors != 2 {
+			t.Errorf("%v: expected 2 duplication errors, got %d", order, errors)
+		}
+	}
+}
+
+func TestLowercaseQtype(t *testing.T) {
+	newTestETCD("dns/", nil)
+	for _, spec := range []struct {
+		key, qname, qtype string
+		entryType         entryType
+	}{
+		{"dns/net.example/www/a", "www.example.net.", "A", normalEntry},
+		{"dns/net.example/www/Aaaa#1", "www.example.net.", "AAAA", normalEntry},
+		{"dns/net.example/type123", "example.net.", "TYPE123", normalEntry},
+		{"dns/net.example/-defaults-/mx", "example.net.", "MX", defaultsEntry},
+		{"dns/net.example/www/FOO", "www.example.net.", "FOO", normalEntry}, // unsupported, but uppercase
+	} {
+		name, entryType, qtype, _, _, err := parseEntryKey(spec.key)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", spec.key, err)
+			continue
+		}
+		if name.normal() != spec.qname || entryType != spec.entryType || qtype != spec.qtype {
+			t.Errorf("%s: unexpected name %q, entry type %v, qtype %q", spec.key, name.normal(), entryType, qtype)
+		}
+	}
+	if _, _, qtype, _, _, _ := parseEntryKey("dns/net.example/www/a"); reflect.ValueOf(rrFuncOf(qtype)).Pointer() != reflect.ValueOf(a).Pointer() {
+		t.Errorf("expected the A handler for the lowercase qtype")
+	}
+	// unsupported lowercase types are domain name parts
+	if _, _, _, _, _, err := parseEntryKey("dns/net.example/www/foo"); err == nil {
+		t.Errorf("expected an error (empty qtype) for an unsupported lowercase qtype")
+	}
+	items := make(chan etcdItem, 3)
+	items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+	items <- etcdItem{"dns/net.example/SOA", []byte(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`), 1}
+	items <- etcdItem{"dns/net.example/www/a", []byte(`192.0.2.1`), 1}
+	close(items)
+	dataRoot.Store(newDataNode(nil, "", ""))
+	dataRoot.Load().reload(items)
+	if contents := lookupContents(t, "www.example.net.", "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected the A record of the lowercase key, got %v", contents)
+	}
+}
+
+func TestCNAMEExclusive(t *testing.T) {
+	newTestETCD("dns/", nil)
+	hook := logtest.NewLocal(log.data())
+	defer hook.Reset()
+	for _, exclusive := range []string{"", "true", "false"} {
+		hook.Reset()
+		items := make(chan etcdItem, 6)
+		items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+		if exclusive != "" {
+			items <- etcdItem{"dns/-options-/CNAME", []byte(`{"cname-exclusive": ` + exclusive + `}`), 1}
+		}
+		items <- etcdItem{"dns/net.example/SOA", []byte(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`), 1}
+		items <- etcdItem{"dns/net.example/www/A", []byte(`192.0.2.1`), 1}
+		items <- etcdItem{"dns/net.example/www/CNAME", []byte(`web.example.net.`), 1}
+		close(items)
+		root := newDataNode(nil, "", "")
+		root.reload(items)
+		www := root.getChild(parseName("www.example.net."), false)
+		if _, ok := www.records["CNAME"]; !ok {
+			t.Errorf("%q: expected the CNAME record to be kept", exclusive)
+		}
+		_, hasA := www.records["A"]
+		errors := 0
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.ErrorLevel && strings.Contains(entry.Message, "CNAME record coexists") {
+				errors++
+			}
+		}
+		if exclusive == "false" {
+			if !hasA || errors != 0 {
+				t.Errorf("%q: expected the A record to be kept without an error, got %v and %d errors", exclusive, www.records["A"], errors)
+			}
+		} else if hasA || errors != 1 {
+			t.Errorf("%q: expected the A record to be dropped with an error, got %v and %d errors", exclusive, www.records["A"], errors)
+		}
+	}
+}
+
+func TestParseEntryContentRelaxed(t *testing.T) {
+	defer func() { args.StrictJSON = nil }()
+	for _, spec := range []struct {
+		value    string
+		expected interface{}
+		strict   bool // also valid in strict mode
+	}{
+		{`{a: 1}`, objectType[any]{"a": float64(1)}, false},
+		{`{/*comment*/"a": 1}`, objectType[any]{"a": float64(1)}, false},
+		{"{\n  // comment\n  a: 1, // another\n  b_2: \"x/*y*/\",\n}", objectType[any]{"a": float64(1), "b_2": "x/*y*/"}, false},
+		{`{a: [1, 2,], $b : {c: true,}, d: -1.5e2}`, objectType[any]{"a": []any{float64(1), float64(2)}, "$b": objectType[any]{"c": true}, "d": float64(-150)}, false},
+		{`{"a": "b: c, }"}`, objectType[any]{"a": "b: c, }"}, true},
+		{`="1.2.3.4" // the IP`, "1.2.3.4", false},
+		{`=/* the IP */ 7`, float64(7), false},
+	} {
+		args.StrictJSON = new(bool)
+		value, _, err := parseEntryContent([]byte(spec.value), true)
+		if err != nil {
+			t.Errorf("%q: failed to parse: %s", spec.value, err)
+		} else if fmt.Sprint(value) != fmt.Sprint(spec.expected) {
+			t.Errorf("%q: expected %v, got %v", spec.value, spec.expected, value)
+		}
+		*args.StrictJSON = true
+		if _, _, err := parseEntryContent([]byte(spec.value), true); (err == nil) != spec.strict {
+			t.Errorf("%q: unexpected result in strict mode: %v", spec.value, err)
+		}
+	}
+	args.StrictJSON = nil
+	for _, value := range []string{`{a: 1`, `{"a": "b}`, `{/* a: 1}`, `{a b: 1}`} {
+		if _, _, err := parseEntryContent([]byte(value), true); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestParseEntryContentYAML(t *testing.T) {
+	for _, spec := range []struct {
+		value    string
+		expected interface{}
+	}{
+		{"---\na: 1", objectType[any]{"a": float64(1)}},
+		{"---\r\na: 1\r\nb: text\r\n", objectType[any]{"a": float64(1), "b": "text"}},
+		{"---\nprimary: ns1\nrefresh: 1h\nlist:\n  - 1\n  - x\nsub:\n  c: true\n", objectType[any]{"primary": "ns1", "refresh": "1h", "list": []any{float64(1), "x"}, "sub": objectType[any]{"c": true}}},
+		{"---\n", objectType[any]{}},
+		{"---", objectType[any]{}},
+		{"---text", "---text"},
+		{"--- a", "--- a"},
+	} {
+		value, _, err := parseEntryContent([]byte(spec.value), true)
+		if err != nil {
+			t.Errorf("%q: failed to parse: %s", spec.value, err)
+		} else if fmt.Sprintf("%#v", value) != fmt.Sprintf("%#v", spec.expected) {
+			t.Errorf("%q: expected %#v, got %#v", spec.value, spec.expected, value)
+		}
+	}
+	// the same object in JSON and YAML
+	jsonValue, _, _ := parseEntryContent([]byte(`{"ttl": 3600, "ip": [192, 0, 2, 1]}`), false)
+	yamlValue, _, _ := parseEntryContent([]byte("---\nttl: 3600\nip: [192, 0, 2, 1]\n"), false)
+	if !reflect.DeepEqual(jsonValue, yamlValue) {
+		t.Errorf("expected equal values from JSON and YAML, got %#v and %#v", jsonValue, yamlValue)
+	}
+	for _, value := range []string{"---\n- 1\n- 2", "---\n1: a", "---\na: [1"} {
+		if _, _, err := parseEntryContent([]byte(value), true); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestParseEntryContentStringMarkers(t *testing.T) {
+	for _, spec := range []struct {
+		value    string
+		expected interface{}
+	}{
+		{"`{}", "{}"},
+		{"`=1", "=1"},
+		{"`---\na: 1", "---\na: 1"},
+		{"``x", "`x"},
+		{"`", ""},
+		{"!{}", stringValueType{"{}", true}},
+		{"!`{}", stringValueType{"{}", true}},
+		{"!=1", stringValueType{"=1", true}},
+		{"!!x", stringValueType{"!x", true}},
+		{"`!x", "!x"},
+		{"x!`", "x!`"},
+	} {
+		value, isLastFieldValue, err := parseEntryContent([]byte(spec.value), true)
+		if err != nil || isLastFieldValue || value != spec.expected {
+			t.Errorf("%q: expected %#v, got %#v (%v, %v)", spec.value, spec.expected, value, isLastFieldValue, err)
+		}
+		if value, _, err := parseEntryContent([]byte(spec.value), false); err == nil {
+			t.Errorf("%q: expected an error when strings are not allowed, got %#v", spec.value, value)
+		}
+	}
+}
+
+func TestRawStringValues(t *testing.T) {
+	zone := newTestZone("example.net.")
+	for _, spec := range []struct {
+		qtype, value, content string
+	}{
+		{"TXT", "`{\"a\": 1}", `{"a": 1}`},
+		{"TXT", `!"text"`, `"text"`},
+		{"SOA", "!ns1.example.net. hostmaster.example.net. 7 3600 600 86400 300", "ns1.example.net. hostmaster.example.net. 7 3600 600 86400 300"},
+	} {
+		delete(zone.records, spec.qtype)
+		record, err := storeTestEntry(zone, spec.qtype, "", spec.value)
+		if err != nil {
+			t.Errorf("%s %q: %s", spec.qtype, spec.value, err)
+		} else if record.content != spec.content {
+			t.Errorf("%s %q: expected content %q, got %q", spec.qtype, spec.value, spec.content, record.content)
+		}
+	}
+	delete(zone.records, "SOA")
+	if _, err := storeTestEntry(zone, "SOA", "", "ns1.example.net. hostmaster.example.net. 7 3600 600 86400 300"); err == nil {
+		t.Errorf("expected a plain string SOA entry to be ignored")
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	zone := newTestZone("example.net.")
+	root := zone.parent.parent
+	for _, entry := range []struct{ name, qtype, content string }{
+		{"", "MX", `{"priority": 10, "target": "alias"}`},
+		{"", "NS", `="ns1"`},
+		{"_sip._udp", "SRV", `{"priority": 0, "weight": 0, "port": 5060, "target": "sip"}`},
+		{"alias", "CNAME", `="mail"`},
+		{"mail", "A", `="192.0.2.1"`},
+		{"ns1", "A", `="192.0.2.2"`},
+		{"sip", "CNAME", `="mail"`},
+	} {
+		if _, err := storeTestEntry(zone.getChildCreate(testName(entry.name)), entry.qtype, "", entry.content); err != nil {
+			t.Fatalf("failed to store %s %s: %s", entry.name, entry.qtype, err)
+		}
+	}
+	hook := logtest.NewLocal(log.data())
+	flagged := func() map[string]logrus.Level {
+		hook.Reset()
+		root.checkTargets(root)
+		levels := map[string]logrus.Level{}
+		for _, entry := range hook.AllEntries() {
+			if strings.Contains(entry.Message, "CNAME") {
+				levels[entry.Data["qtype"].(string)] = entry.Level
+			}
+		}
+		return levels
+	}
+	levels := flagged()
+	if len(levels) != 2 || levels["MX"] != logrus.WarnLevel || levels["SRV"] != logrus.WarnLevel {
+		t.Errorf("expected warnings for MX and SRV, got %v", levels)
+	}
+	zone.options["MX"] = map[string]defoptType{"": {objectType[any]{strictTargetsOption: true}, nil}}
+	levels = flagged()
+	if len(levels) != 2 || levels["MX"] != logrus.ErrorLevel || levels["SRV"] != logrus.WarnLevel {
+		t.Errorf("expected an error for MX and a warning for SRV, got %v", levels)
+	}
+}
+
+func TestCheckTargetsOnEvents(t *testing.T) {
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/NS":  `ns1.example.org.`,
+		"dns/net.example/MX":  `{"priority": 10, "target": "mail"}`,
+		"dns/org.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	})
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	hook := logtest.NewLocal(log.data())
+	rev := int64(1)
+	// the levels of the flagged targets (by QTYPE) after the event
+	event := func(key, value string) map[string]logrus.Level {
+		hook.Reset()
+		rev++
+		kv.entries[key] = value
+		kv.revision = rev
+		handleEvent("", &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: rev, ModRevision: rev}})
+		levels := map[string]logrus.Level{}
+		for _, entry := range hook.AllEntries() {
+			if strings.Contains(entry.Message, "owner of a CNAME") {
+				levels[entry.Data["qtype"].(string)] = entry.Level
+			}
+		}
+		return levels
+	}
+	// updated in place, the owner is spelled differently than the target
+	if levels := event("dns/net.example/Mail/CNAME", `other.example.org.`); len(levels) != 1 || levels["MX"] != logrus.WarnLevel {
+		t.Errorf("in place: expected a warning for MX, got %v", levels)
+	}
+	// reloaded zone
+	if levels := event("dns/net.example/-options-/MX", `{"strict-targets": true}`); len(levels) != 1 || levels["MX"] != logrus.ErrorLevel {
+		t.Errorf("reload: expected an error for MX, got %v", levels)
+	}
+	// a target in another zone
+	if levels := event("dns/org.example/ns1/CNAME", `other.example.org.`); len(levels) != 0 {
+		t.Errorf("other zone: expected only the changed zone to be checked, got %v", levels)
+	}
+	if levels := event("dns/net.example/-options-/NS", `{}`); len(levels) != 2 || levels["NS"] != logrus.WarnLevel {
+		t.Errorf("other zone: expected a warning for NS, got %v", levels)
+	}
+}
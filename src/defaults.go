@@ -0,0 +1,107 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// connects to ETCD, loads the data and writes the defaults and options tree (see writeDefaults()) to w
+func showDefaults(w io.Writer) error {
+	if err := loadDataOnce("show-defaults"); err != nil {
+		return err
+	}
+	defer closeClient()
+	return writeDefaults(w, dataRoot.Load())
+}
+
+// writes the defaults and options entries of each node and the effective values for the QTYPEs of its records
+// (with the node where they are found), for debugging the search order. nodes without any of them are left out.
+func writeDefaults(w io.Writer, dn *dataNode) error {
+	var lines []string
+	for _, area := range []struct {
+		name   string
+		values map[string]map[string]defoptType
+	}{{defaultsKey, dn.defaults}, {optionsKey, dn.options}} {
+		for _, qtype := range sortedKeys(area.values) {
+			for _, id := range sortedKeys(area.values[qtype]) {
+				value, err := json.Marshal(area.values[qtype][id].values)
+				if err != nil {
+					return fmt.Errorf("failed to marshal %s of %s: %s", area.name, dn.getQname(), err)
+				}
+				lines = append(lines, fmt.Sprintf("  %s/%s%s%s: %s", area.name, qtype, idSeparator, id, value))
+			}
+		}
+	}
+	for _, qtype := range sortedKeys(dn.records) {
+		var effective []string
+		for _, area := range []struct {
+			name   string
+			values func(*dataNode) map[string]map[string]defoptType
+		}{
+			{"default", func(dn *dataNode) map[string]map[string]defoptType { return dn.defaults }},
+			{"option", func(dn *dataNode) map[string]map[string]defoptType { return dn.options }},
+		} {
+			for _, key := range effectiveKeys(dn, qtype, area.values) {
+				var value any
+				var vPath *valuePath
+				var err error
+				if area.name == "default" {
+					value, vPath, err = findValueOrDefault[any](key, nil, qtype, "", dn, false)
+				} else {
+					value, vPath, err = findOptionValue[any](key, qtype, "", dn, false)
+				}
+				if err != nil || vPath == nil {
+					continue
+				}
+				valueJSON, err := json.Marshal(value)
+				if err != nil {
+					return fmt.Errorf("failed to marshal %s %s of %s: %s", area.name, key, dn.getQname(), err)
+				}
+				effective = append(effective, fmt.Sprintf("%s %s=%s (%s)", area.name, key, valueJSON, vPath))
+			}
+		}
+		if len(effective) > 0 {
+			lines = append(lines, fmt.Sprintf("  effective %s: %s", qtype, strings.Join(effective, ", ")))
+		}
+	}
+	if len(lines) > 0 {
+		if _, err := fmt.Fprintf(w, "%s\n%s\n", dn.getQname(), strings.Join(lines, "\n")); err != nil {
+			return err
+		}
+	}
+	for _, lname := range sortedKeys(dn.children) {
+		if err := writeDefaults(w, dn.children[lname]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the (sorted) keys of all values applicable to records of the QTYPE (without id) of the node
+func effectiveKeys(dn *dataNode, qtype string, values func(*dataNode) map[string]map[string]defoptType) []string {
+	keys := map[string]bool{}
+	for dn := dn; dn != nil; dn = dn.parent {
+		for _, soe := range searchOrder(qtype, "") {
+			for key := range values(dn)[soe.qtype][soe.id].values {
+				keys[key] = true
+			}
+		}
+	}
+	return sortedKeys(keys)
+}
@@ -0,0 +1,53 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDefaults(t *testing.T) {
+	zone := newTestZone("example.net.")
+	zone.defaults["A"] = map[string]defoptType{"": {objectType[any]{"ttl": "5m"}, nil}}
+	zone.options[""] = map[string]defoptType{"1": {objectType[any]{notAuthoritativeOption: true}, nil}}
+	zone.options["A"] = map[string]defoptType{"": {objectType[any]{autoPtrOption: true}, nil}}
+	www := zone.getChildCreate(testName("www"))
+	if _, err := storeTestEntry(www, "A", "", `192.0.2.1`); err != nil {
+		t.Fatalf("failed to store record: %s", err)
+	}
+	if _, err := storeTestEntry(www, "TXT", "", `="text"`); err != nil {
+		t.Fatalf("failed to store record: %s", err)
+	}
+	zone.getChildCreate(testName("empty.non.terminal"))
+	buffer := strings.Builder{}
+	if err := writeDefaults(&buffer, zone.parent.parent); err != nil {
+		t.Fatalf("writeDefaults() failed: %s", err)
+	}
+	expected := `.
+  -defaults-/#: {"ttl":3600}
+example.net.
+  -defaults-/A#: {"ttl":"5m"}
+  -options-/#1: {"not-authoritative":true}
+  -options-/A#: {"auto-ptr":true}
+  effective SOA: default ttl=3600 (./#)
+www.example.net.
+  effective A: default ttl="5m" (example.net./A#), option auto-ptr=true (example.net./A#)
+  effective TXT: default ttl=3600 (./#)
+`
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
@@ -0,0 +1,169 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// a record of a zone as compared by getZoneDiff()
+type diffRecord struct {
+	qname, qtype, content string
+	ttl                   int64
+}
+
+// the records of a zone at a revision, with the serial reported for it (see zoneSerial())
+type zoneSnapshot struct {
+	rev     int64
+	serial  int64
+	records map[diffRecord]struct{}
+}
+
+// the latest snapshots of the changed zones (by qname, oldest first), see saveZoneSnapshot()
+var zoneSnapshots = struct {
+	sync.Mutex
+	byZone map[string][]zoneSnapshot
+}{byZone: map[string][]zoneSnapshot{}}
+
+// collects the (enabled) records of the zone, without those of nested zones. the zone must be locked.
+func (dn *dataNode) zoneRecords() map[diffRecord]struct{} {
+	records := map[diffRecord]struct{}{}
+	addRecords := func(dn *dataNode) {
+		for qtype, rrs := range dn.records {
+			for _, record := range rrs {
+				if !record.disabled {
+					records[diffRecord{dn.getQname(), qtype, recordContent(&record, defaultPdnsVersion), seconds(record.ttl)}] = struct{}{}
+				}
+			}
+		}
+	}
+	addRecords(dn)
+	for _, child := range dn.children {
+		child.walk(func(dn *dataNode) bool {
+			if dn.hasSOA() {
+				return false // another zone
+			}
+			addRecords(dn)
+			return true
+		})
+	}
+	return records
+}
+
+// saves the snapshot of the zone at its current revision (unless saved already), keeping the latest zoneSnapshotsCount
+// ones. it is called before and after each change of the zone, so only the changed zones are kept. the zone must be locked.
+func saveZoneSnapshot(zone *dataNode) {
+	if !zone.hasSOA() {
+		return
+	}
+	qname, rev := zone.getQname(), zone.zoneRev()
+	zoneSnapshots.Lock()
+	snapshots := zoneSnapshots.byZone[qname]
+	saved := len(snapshots) > 0 && snapshots[len(snapshots)-1].rev == rev
+	zoneSnapshots.Unlock()
+	if saved {
+		return
+	}
+	snapshot := zoneSnapshot{rev, zone.zoneSerial(), zone.zoneRecords()}
+	zoneSnapshots.Lock()
+	defer zoneSnapshots.Unlock()
+	snapshots = append(zoneSnapshots.byZone[qname], snapshot)
+	if len(snapshots) > zoneSnapshotsCount {
+		snapshots = snapshots[len(snapshots)-zoneSnapshotsCount:]
+	}
+	zoneSnapshots.byZone[qname] = snapshots
+	log.data().WithField("#", len(snapshot.records)).Tracef("saved snapshot of zone %q at revision %d (serial %d)", qname, rev, snapshot.serial)
+}
+
+// the saved snapshot of the zone with the serial, nil if not available
+func findZoneSnapshot(qname string, serial int64) *zoneSnapshot {
+	zoneSnapshots.Lock()
+	defer zoneSnapshots.Unlock()
+	snapshots := zoneSnapshots.byZone[qname]
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].serial == serial {
+			return &snapshots[i]
+		}
+	}
+	return nil
+}
+
+// the records in a but not in b, as result items (sorted by name, QTYPE and content)
+func recordsMissing(a, b map[diffRecord]struct{}) []objectType[any] {
+	var records []diffRecord
+	for record := range a {
+		if _, ok := b[record]; !ok {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		ri, rj := records[i], records[j]
+		if ri.qname != rj.qname {
+			return ri.qname < rj.qname
+		}
+		if ri.qtype != rj.qtype {
+			return ri.qtype < rj.qtype
+		}
+		return ri.content < rj.content
+	})
+	items := []objectType[any]{}
+	for _, record := range records {
+		items = append(items, objectType[any]{"qname": record.qname, "qtype": record.qtype, "content": record.content, "ttl": record.ttl})
+	}
+	return items
+}
+
+// returns the records added to and removed from the zone since the serial reported by getUpdatedMasters (or in the SOA
+// record), for incremental zone transfers. the snapshots are looked up by that serial (not by the revision), because
+// it differs from the revision with the option soa-serial. returns false if the zone is unknown or the serial is not
+// available (anymore), then a full zone transfer is needed.
+func getZoneDiff(params objectType[any], client *pdnsClient) (interface{}, error) {
+	zonename, ok := params["zonename"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'zonename'")
+	}
+	since, ok := params["since"].(float64)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'since'")
+	}
+	name := parseName(zonename)
+	ensureLoaded(name)
+	data := dataRoot.Load().getChild(name, true)
+	defer data.rUnlockUpwards(nil)
+	if data.depth() < name.len() || !data.hasSOA() || !isServedZone(data) {
+		client.log.data().Debugf("no such zone: %q", name.normal())
+		return false, nil
+	}
+	serial := data.zoneSerial()
+	current := data.zoneRecords()
+	previous := current
+	if int64(since) != serial {
+		snapshot := findZoneSnapshot(data.getQname(), int64(since))
+		if snapshot == nil {
+			client.log.data().Debugf("no snapshot of zone %q with serial %d", data.getQname(), int64(since))
+			return false, nil
+		}
+		previous = snapshot.records
+	}
+	result := objectType[any]{
+		"serial":  serial,
+		"added":   recordsMissing(current, previous),
+		"removed": recordsMissing(previous, current),
+	}
+	client.log.pdns().WithField("result", result).Trace("zone diff")
+	return result, nil
+}
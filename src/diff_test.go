@@ -0,0 +1,120 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestGetZoneDiff(t *testing.T) {
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/A":   `192.0.2.1`,
+		"dns/org.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	})
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	drainKeys(kv)
+	zoneSnapshots.byZone = map[string][]zoneSnapshot{} // of other tests
+	rev := int64(1)
+	event := func(key, value string, deleted bool) {
+		rev++
+		event := clientv3.Event{Type: clientv3.EventTypePut}
+		if deleted {
+			event.Type = clientv3.EventTypeDelete
+			delete(kv.entries, key)
+		} else {
+			kv.entries[key] = value
+			kv.revisions = map[string]int64{key: rev}
+		}
+		event.Kv = &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: rev, ModRevision: rev}
+		handleEvent("", &event)
+		drainKeys(kv)
+	}
+	// the records (without SOA) as "<qname> <qtype> <content>", and the number of SOA records
+	diff := func(zonename string, since int64) (added, removed []string, soas int) {
+		result, err := getZoneDiff(objectType[any]{"zonename": zonename, "since": float64(since)}, newTestClient())
+		if err != nil {
+			t.Fatalf("getZoneDiff failed: %s", err)
+		}
+		if result == false {
+			return nil, nil, -1
+		}
+		for key, list := range map[string]*[]string{"added": &added, "removed": &removed} {
+			for _, item := range result.(objectType[any])[key].([]objectType[any]) {
+				if item["qtype"] == "SOA" {
+					soas++
+				} else {
+					*list = append(*list, fmt.Sprintf("%s %s %s", item["qname"], item["qtype"], item["content"]))
+				}
+			}
+		}
+		return
+	}
+	event("dns/net.example/www/A", `192.0.2.2`, false)
+	event("dns/net.example/A", `192.0.2.3`, false)
+	for _, spec := range []struct {
+		since          int64
+		added, removed []string
+		soas           int
+	}{
+		{1, []string{"example.net. A 192.0.2.3", "www.example.net. A 192.0.2.2"}, []string{"example.net. A 192.0.2.1"}, 2},
+		{2, []string{"example.net. A 192.0.2.3"}, []string{"example.net. A 192.0.2.1"}, 2},
+		{3, nil, nil, 0},   // the current revision
+		{99, nil, nil, -1}, // unknown revision
+	} {
+		if added, removed, soas := diff("example.net.", spec.since); !equal(added, spec.added) || !equal(removed, spec.removed) || soas != spec.soas {
+			t.Errorf("since %d: expected %v, %v (%d SOA records), got %v, %v (%d)", spec.since, spec.added, spec.removed, spec.soas, added, removed, soas)
+		}
+	}
+	if _, _, soas := diff("example.com.", 1); soas != -1 {
+		t.Errorf("expected no diff for an unknown zone")
+	}
+	// the diff over a zone reload and a deletion
+	event("dns/net.example/-defaults-", `{"ttl": 3600, "comment": "reload"}`, false)
+	event("dns/net.example/www/A", "", true)
+	if added, removed, _ := diff("example.net.", 3); len(added) != 0 || !equal(removed, []string{"www.example.net. A 192.0.2.2"}) {
+		t.Errorf("since 3: expected the removal of www.example.net. A, got %v, %v", added, removed)
+	}
+	// only the latest snapshots are kept
+	for i := 0; i < zoneSnapshotsCount; i++ {
+		event("dns/net.example/A", fmt.Sprintf("192.0.2.%d", 10+i), false)
+	}
+	if _, _, soas := diff("example.net.", 3); soas != -1 {
+		t.Errorf("expected the snapshot of revision 3 to be dropped")
+	}
+	if added, removed, _ := diff("example.net.", rev-1); !equal(added, []string{fmt.Sprintf("example.net. A 192.0.2.%d", 9+zoneSnapshotsCount)}) || len(removed) != 1 {
+		t.Errorf("since %d: expected the last update, got %v, %v", rev-1, added, removed)
+	}
+	// with option soa-serial the diff is since the serial, not the revision
+	delete(zoneSerials.states, "example.org.") // of other tests
+	defer func(f func() time.Time) { currentTime = f }(currentTime)
+	currentTime = func() time.Time { return time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC) }
+	event("dns/org.example/-options-/SOA", `{"soa-serial": "date"}`, false)
+	event("dns/org.example/A", `192.0.2.4`, false)
+	if added, removed, _ := diff("example.org.", 2024010200); !equal(added, []string{"example.org. A 192.0.2.4"}) || len(removed) != 0 {
+		t.Errorf("since serial 2024010200: expected the addition of example.org. A, got %v, %v", added, removed)
+	}
+	if _, _, soas := diff("example.org.", rev-1); soas != -1 {
+		t.Errorf("expected no diff since the revision %d", rev-1)
+	}
+}
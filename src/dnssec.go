@@ -0,0 +1,807 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compares two names (labels in reversed form) in DNSSEC canonical order (RFC 4034, section 6.1)
+func canonicalLess(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		la, lb := strings.ToLower(a[i]), strings.ToLower(b[i])
+		if la != lb {
+			return la < lb
+		}
+	}
+	return len(a) < len(b)
+}
+
+// the labels (in reversed form) of dn relative to the (ancestor) zone node
+func (dn *dataNode) relativeLabels(zone *dataNode) []string {
+	var labels []string
+	for dn := dn; dn != zone && dn != nil; dn = dn.parent {
+		labels = append(labels, dn.lname)
+	}
+	return reversed(labels)
+}
+
+// collects the owner names of the zone (relative to it), including the delegation points, but not the names beneath them.
+// must be called on a zone node, which is read-locked.
+func (dn *dataNode) ownerNames() [][]string {
+	var names [][]string
+	if len(dn.records) > 0 {
+		names = append(names, []string(nil))
+	}
+	for _, child := range dn.children {
+		child.walk(func(node *dataNode) bool {
+			if len(node.records) > 0 {
+				names = append(names, node.relativeLabels(dn))
+			}
+			return !node.hasSOA()
+		})
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return canonicalLess(names[i], names[j])
+	})
+	return names
+}
+
+// finds the names before (or equal) and after the given name (in reversed form) in the (sorted) names, wrapping around at the ends
+func beforeAndAfter(names [][]string, name []string) ([]string, []string) {
+	n := len(names)
+	idx := sort.Search(n, func(i int) bool {
+		return canonicalLess(name, names[i])
+	}) // the first name after the given name (or n)
+	before := names[(idx+n-1)%n]
+	after := names[idx%n]
+	return before, after
+}
+
+// the names (in reversed form) closely before and after the (non-existent) name, as epsilon functions (RFC 4471, section 3)
+// for minimally covering NSEC records (RFC 4470, "white lies"): the predecessor decrements the last octet of the leftmost
+// label (or drops it, if it is \000), the successor is the name with the prepended label \000
+func epsilonNames(name []string) ([]string, []string) {
+	n := len(name)
+	successor := append(append([]string(nil), name...), "\000")
+	predecessor := append([]string(nil), name[:n-1]...)
+	label := []byte(name[n-1])
+	last := label[len(label)-1]
+	label = label[:len(label)-1]
+	if last != 0 {
+		last--
+		if last >= 'A' && last <= 'Z' {
+			last = 'A' - 1 // the canonical order compares lowercased, so skip the uppercase letters
+		}
+		label = append(label, last)
+	}
+	if len(label) > 0 {
+		predecessor = append(predecessor, string(label))
+	}
+	return predecessor, successor
+}
+
+// narrows the names before and after the (non-existent) name to the minimally covering ones, without passing over the
+// existing names before and after (which may be wrapped around)
+func minimallyCovering(name, before, after []string) ([]string, []string) {
+	predecessor, successor := epsilonNames(name)
+	if canonicalLess(name, before) || canonicalLess(before, predecessor) {
+		before = predecessor
+	}
+	if !canonicalLess(name, after) || canonicalLess(successor, after) {
+		after = successor
+	}
+	return before, after
+}
+
+// whether the name (in reversed form, relative to the zone) is beneath a delegation point or a nested zone of the zone
+func (dn *dataNode) isDelegated(name []string) bool {
+	node := dn
+	for _, label := range name[:maxOf(len(name)-1, 0)] {
+		if node = node.children[label]; node == nil {
+			return false
+		}
+		if node.hasSOA() || len(node.records["NS"]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the (sorted) names contain the name
+func containsName(names [][]string, name []string) bool {
+	for _, n := range names {
+		if !canonicalLess(n, name) && !canonicalLess(name, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func relativeName(labels []string) string {
+	return strings.Join(Map(reversed(labels), func(label string, _ int) string { return escapeLabel(label, ".") }), ".")
+}
+
+func getBeforeAndAfterNamesAbsolute(params objectType[any], client *pdnsClient) (interface{}, error) {
+	id, ok := params["id"].(float64)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'id'")
+	}
+	qname, ok := params["qname"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'qname'")
+	}
+	var result interface{} = false
+	found := false
+	ensureZoneLoaded(int64(id))
+	dataRoot.Load().walk(func(dn *dataNode) bool {
+		if found {
+			return false
+		}
+		if !dn.hasSOA() || float64(dn.zoneID) != id {
+			return true
+		}
+		found = true
+		names := dn.ownerNames()
+		if len(names) == 0 {
+			return false
+		}
+		// the qname is relative to the zone (PowerDNS appends the zone name to the results), but accept an absolute name too
+		qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+		if zoneName := strings.TrimSuffix(dn.getQname(), "."); qname == zoneName {
+			qname = ""
+		} else {
+			qname = strings.TrimSuffix(qname, "."+zoneName)
+		}
+		labels := Map(reversed(splitDomainName(qname, ".")), func(label string, _ int) string { return unescapeLabel(label) })
+		before, after := beforeAndAfter(names, labels)
+		if len(labels) > 0 && dnssecEnabled(dn, client) && !containsName(names, labels) && !dn.isDelegated(labels) {
+			// the NSEC record (synthesized by PowerDNS) covers only the non-existent name
+			before, after = minimallyCovering(labels, before, after)
+		}
+		result = objectType[any]{
+			"before":   relativeName(before),
+			"after":    relativeName(after),
+			"unhashed": relativeName(labels),
+		}
+		client.log.data().WithField("result", result).Tracef("found names before and after %q in zone %q", qname, dn.getQname())
+		return false
+	})
+	if !found {
+		client.log.data().Debugf("no zone with id %v", id)
+	}
+	return result, nil
+}
+
+// the only signing algorithm supported (yet)
+const ecdsaP256SHA256 = 13
+
+// the type codes of the record types which can be signed (their RDATA can be encoded, see rdata()), additionally to the
+// generic types (TYPE<n>). ALIAS records are not signed, they are expanded by PowerDNS.
+var signableTypes = map[string]uint16{
+	"A":          1,
+	"NS":         2,
+	"CNAME":      5,
+	"SOA":        6,
+	"PTR":        12,
+	"HINFO":      13,
+	"MX":         15,
+	"TXT":        16,
+	"AAAA":       28,
+	"SRV":        33,
+	"CERT":       37,
+	"DNAME":      39,
+	"APL":        42,
+	"DS":         43,
+	"DNSKEY":     48,
+	"NSEC3PARAM": 51,
+	"SPF":        99,
+}
+
+// the type code of the QTYPE for signing, false if it cannot be signed
+func signableType(qtype string) (uint16, bool) {
+	if code, ok := signableTypes[qtype]; ok {
+		return code, true
+	}
+	if genericTypeRegex.MatchString(qtype) {
+		code, err := strconv.ParseUint(qtype[len("TYPE"):], 10, 16)
+		return uint16(code), err == nil
+	}
+	return 0, false
+}
+
+// a zone key (entry <zone>/-keys-#<id>), in the form of the PowerDNS DNSSEC backend calls
+type dnssecKey struct {
+	entryKey  string
+	id        int64
+	flags     uint16 // 256 (ZSK) or 257 (KSK)
+	active    bool
+	published bool
+	content   string            // the private key in the ISC format ("Private-key-format: v1.2\nAlgorithm: 13 (ECDSAP256SHA256)\n...")
+	algorithm uint8             // from the content
+	signer    *ecdsa.PrivateKey // nil if the key cannot be used for signing (see signerErr)
+	signerErr error
+}
+
+// parses the value of a keys entry, including the private key (for signing, if supported)
+func parseDNSSECKey(entryKey, id string, value any) (dnssecKey, error) {
+	key := dnssecKey{entryKey: entryKey, published: true}
+	var err error
+	if key.id, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return key, fmt.Errorf("invalid id %q: %s", id, err)
+	}
+	object, ok := value.(objectType[any])
+	if !ok {
+		return key, fmt.Errorf("not an object (%T)", value)
+	}
+	flags, ok := object["flags"].(float64)
+	if !ok || (flags != 256 && flags != 257) {
+		return key, fmt.Errorf("missing or invalid 'flags' (must be 256 or 257): %v", object["flags"])
+	}
+	key.flags = uint16(flags)
+	for field, ptr := range map[string]*bool{"active": &key.active, "published": &key.published} {
+		if value, ok := object[field]; ok {
+			if *ptr, ok = value.(bool); !ok {
+				return key, fmt.Errorf("invalid '%s' (not a boolean): %v", field, value)
+			}
+		}
+	}
+	if key.content, ok = object["content"].(string); !ok || key.content == "" {
+		return key, fmt.Errorf("missing or invalid 'content'")
+	}
+	if key.algorithm, key.signer, err = parsePrivateKey(key.content); err != nil {
+		if key.algorithm == 0 {
+			return key, fmt.Errorf("invalid 'content': %s", err)
+		}
+		key.signerErr = err
+	}
+	return key, nil
+}
+
+// parses the private key in the ISC format, returns the algorithm and (only for ECDSAP256SHA256) the signer.
+// an error with a non-zero algorithm means that the key is valid, but cannot be used for signing.
+func parsePrivateKey(content string) (uint8, *ecdsa.PrivateKey, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	algorithmFields := strings.Fields(fields["Algorithm"])
+	if len(algorithmFields) == 0 {
+		return 0, nil, fmt.Errorf("missing 'Algorithm'")
+	}
+	algorithm, err := strconv.ParseUint(algorithmFields[0], 10, 8)
+	if err != nil || algorithm == 0 {
+		return 0, nil, fmt.Errorf("invalid 'Algorithm' %q", fields["Algorithm"])
+	}
+	if algorithm != ecdsaP256SHA256 {
+		return uint8(algorithm), nil, fmt.Errorf("unsupported algorithm %d (only %d, ECDSAP256SHA256)", algorithm, ecdsaP256SHA256)
+	}
+	private, err := base64.StdEncoding.DecodeString(fields["PrivateKey"])
+	if err != nil || len(private) != 32 {
+		return 0, nil, fmt.Errorf("invalid 'PrivateKey' (must be 32 bytes, base64 encoded)")
+	}
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(private)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return 0, nil, fmt.Errorf("invalid 'PrivateKey' (out of range)")
+	}
+	signer := &ecdsa.PrivateKey{D: d}
+	signer.Curve = curve
+	signer.X, signer.Y = curve.ScalarBaseMult(private)
+	return ecdsaP256SHA256, signer, nil
+}
+
+// the RDATA of the DNSKEY record of the key (only for keys with a signer)
+func (key *dnssecKey) dnskeyRdata() []byte {
+	rdata := []byte{byte(key.flags >> 8), byte(key.flags), 3, key.algorithm}
+	rdata = append(rdata, key.signer.X.FillBytes(make([]byte, 32))...)
+	return append(rdata, key.signer.Y.FillBytes(make([]byte, 32))...)
+}
+
+// the key tag of the DNSKEY RDATA (RFC 4034, appendix B)
+func keyTag(rdata []byte) uint16 {
+	var sum uint32
+	for i, b := range rdata {
+		if i%2 == 0 {
+			sum += uint32(b) << 8
+		} else {
+			sum += uint32(b)
+		}
+	}
+	sum += sum >> 16
+	return uint16(sum)
+}
+
+// the value of option dnssec of the zone, false if not set. the zone must be read-locked.
+func dnssecEnabled(zone *dataNode, client *pdnsClient) bool {
+	enabled, _, err := findOptionValue[bool](dnssecOption, "", "", zone, false)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get option %q, using false", dnssecOption)
+		return false
+	}
+	return enabled
+}
+
+// the active signing keys of the zone if its option dnssec is enabled, nil otherwise. the zone must be read-locked.
+func zoneSigningKeys(zone *dataNode, client *pdnsClient) []dnssecKey {
+	if zone == nil || len(zone.keys) == 0 || !dnssecEnabled(zone, client) {
+		return nil
+	}
+	var keys []dnssecKey
+	for _, id := range sortedKeys(zone.keys) {
+		if key := zone.keys[id]; key.active && key.signer != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// the keys signing the RRset of the QTYPE: the KSKs sign the DNSKEY RRset, the ZSKs all others. if there are no keys
+// of a kind, the others sign (as combined signing keys).
+func signingKeysFor(qtype string, keys []dnssecKey) []dnssecKey {
+	ksks := Filter(keys, func(key dnssecKey) bool { return key.flags&1 == 1 })
+	zsks := Filter(keys, func(key dnssecKey) bool { return key.flags&1 == 0 })
+	if (qtype == "DNSKEY" && len(ksks) > 0) || len(zsks) == 0 {
+		return ksks
+	}
+	return zsks
+}
+
+// the DNSKEY records of the published keys (with a signer) of the zone, with the TTL of its SOA record
+func dnskeyRecords(zone *dataNode) []recordType {
+	soa, ok := zone.records["SOA"][""]
+	if !ok {
+		return nil
+	}
+	var records []recordType
+	for _, id := range sortedKeys(zone.keys) {
+		if key := zone.keys[id]; key.published && key.signer != nil {
+			content := fmt.Sprintf("%d 3 %d %s", key.flags, key.algorithm, base64.StdEncoding.EncodeToString(key.dnskeyRdata()[4:]))
+			records = append(records, recordType{content: content, ttl: soa.ttl})
+		}
+	}
+	return records
+}
+
+// the canonical wire form (RFC 4034, section 6.2) of the domain name, a name without trailing dot is relative to origin
+func nameWire(name, origin string) []byte {
+	labels := splitDomainName(name, ".")
+	if !isAbsoluteName(name) {
+		labels = append(labels, splitDomainName(origin, ".")...)
+	}
+	var wire []byte
+	for _, label := range labels {
+		label = strings.ToLower(unescapeLabel(label))
+		wire = append(append(wire, byte(len(label))), label...)
+	}
+	return append(wire, 0)
+}
+
+// whether the name (in presentation form) ends with an unescaped dot
+func isAbsoluteName(name string) bool {
+	if !strings.HasSuffix(name, ".") {
+		return false
+	}
+	backslashes := len(name) - 1 - len(strings.TrimRight(name[:len(name)-1], `\`))
+	return backslashes%2 == 0
+}
+
+// writes the values in network byte order
+func writeWire(buf *bytes.Buffer, values ...any) {
+	for _, value := range values {
+		_ = binary.Write(buf, binary.BigEndian, value)
+	}
+}
+
+// the RDATA (in canonical form) of the record content of the QTYPE
+func rdata(qtype, content, origin string) ([]byte, error) {
+	fields := strings.Fields(content)
+	if len(fields) > 0 && fields[0] == `\#` { // the generic form (see genericRdata()), for any QTYPE
+		if err := checkGenericRdata(content); err != nil {
+			return nil, fmt.Errorf("invalid generic content %q: %s", content, err)
+		}
+		return hex.DecodeString(strings.Join(fields[2:], ""))
+	}
+	// the fields (starting at index from) as unsigned integers of the bit sizes
+	numbers := func(from int, bitSizes ...int) ([]byte, error) {
+		var buf bytes.Buffer
+		for i, bitSize := range bitSizes {
+			n, err := strconv.ParseUint(fields[from+i], 10, bitSize)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s content %q: %s", qtype, content, err)
+			}
+			buf.Write(binary.BigEndian.AppendUint64(nil, n)[8-bitSize/8:])
+		}
+		return buf.Bytes(), nil
+	}
+	switch qtype {
+	case "A", "AAAA":
+		ip := net.ParseIP(content)
+		if qtype == "A" {
+			ip = ip.To4()
+		}
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", content)
+		}
+		return ip, nil
+	case "NS", "CNAME", "PTR", "DNAME":
+		return nameWire(content, origin), nil
+	case "MX":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid MX content %q", content)
+		}
+		priority, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MX priority %q", fields[0])
+		}
+		return append([]byte{byte(priority >> 8), byte(priority)}, nameWire(fields[1], origin)...), nil
+	case "DNSKEY":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid DNSKEY content %q", content)
+		}
+		flags, err1 := strconv.ParseUint(fields[0], 10, 16)
+		protocol, err2 := strconv.ParseUint(fields[1], 10, 8)
+		algorithm, err3 := strconv.ParseUint(fields[2], 10, 8)
+		publicKey, err4 := base64.StdEncoding.DecodeString(fields[3])
+		for _, err := range []error{err1, err2, err3, err4} {
+			if err != nil {
+				return nil, fmt.Errorf("invalid DNSKEY content %q: %s", content, err)
+			}
+		}
+		return append([]byte{byte(flags >> 8), byte(flags), byte(protocol), byte(algorithm)}, publicKey...), nil
+	case "SOA":
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid SOA content %q", content)
+		}
+		numbers, err := numbers(2, 32, 32, 32, 32, 32)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(nameWire(fields[0], origin), nameWire(fields[1], origin)...), numbers...), nil
+	case "SRV":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid SRV content %q", content)
+		}
+		numbers, err := numbers(0, 16, 16, 16)
+		if err != nil {
+			return nil, err
+		}
+		return append(numbers, nameWire(fields[3], origin)...), nil
+	case "DS":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid DS content %q", content)
+		}
+		numbers, err := numbers(0, 16, 8, 8)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := hex.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid DS digest %q: %s", fields[3], err)
+		}
+		return append(numbers, digest...), nil
+	case "CERT":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid CERT content %q", content)
+		}
+		numbers, err := numbers(0, 16, 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CERT certificate: %s", err)
+		}
+		return append(numbers, certificate...), nil
+	case "NSEC3PARAM":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid NSEC3PARAM content %q", content)
+		}
+		numbers, err := numbers(0, 8, 8, 16)
+		if err != nil {
+			return nil, err
+		}
+		var salt []byte
+		if fields[3] != "-" {
+			if salt, err = hex.DecodeString(fields[3]); err != nil {
+				return nil, fmt.Errorf("invalid NSEC3PARAM salt %q: %s", fields[3], err)
+			}
+		}
+		return append(append(numbers, byte(len(salt))), salt...), nil
+	case "TXT", "SPF", "HINFO":
+		strs, err := characterStrings(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s content %q: %s", qtype, content, err)
+		}
+		if qtype == "HINFO" && len(strs) != 2 {
+			return nil, fmt.Errorf("invalid HINFO content %q", content)
+		}
+		var wire []byte
+		for _, str := range strs {
+			wire = append(append(wire, byte(len(str))), str...)
+		}
+		return wire, nil
+	case "APL":
+		var wire []byte
+		for _, item := range fields {
+			negate := strings.HasPrefix(item, "!")
+			family, prefix, _ := strings.Cut(strings.TrimPrefix(item, "!"), ":")
+			ip, ipNet, err := net.ParseCIDR(prefix)
+			if err != nil || (family != "1" && family != "2") {
+				return nil, fmt.Errorf("invalid APL item %q", item)
+			}
+			address := []byte(ip.To16())
+			if family == "1" {
+				address = ip.To4()
+			}
+			address = bytes.TrimRight(address, "\x00")
+			ones, _ := ipNet.Mask.Size()
+			afdLength := byte(len(address))
+			if negate {
+				afdLength |= 0x80
+			}
+			wire = append(append(wire, 0, family[0]-'0', byte(ones), afdLength), address...)
+		}
+		return wire, nil
+	default:
+		return nil, fmt.Errorf("record type %s is not supported for signing", qtype)
+	}
+}
+
+// parses the character-strings (RFC 1035, section 5.1) of the content: quoted or unquoted (separated by whitespace),
+// with the escapes \X and \DDD
+func characterStrings(content string) ([]string, error) {
+	var strs []string
+	for i := 0; i < len(content); {
+		if content[i] == ' ' || content[i] == '\t' {
+			i++
+			continue
+		}
+		quoted := content[i] == '"'
+		if quoted {
+			i++
+		}
+		var str []byte
+		for ; i < len(content); i++ {
+			c := content[i]
+			if quoted && c == '"' {
+				quoted = false
+				i++
+				break
+			}
+			if !quoted && (c == ' ' || c == '\t') {
+				break
+			}
+			if c == '\\' {
+				if i+3 < len(content) && isDigits(content[i+1:i+4]) {
+					n, _ := strconv.ParseUint(content[i+1:i+4], 10, 16)
+					if n > 255 {
+						return nil, fmt.Errorf("invalid escape \\%s", content[i+1:i+4])
+					}
+					str = append(str, byte(n))
+					i += 3
+					continue
+				}
+				if i+1 == len(content) {
+					return nil, fmt.Errorf("incomplete escape at the end")
+				}
+				i++
+				c = content[i]
+			}
+			str = append(str, c)
+		}
+		if quoted {
+			return nil, fmt.Errorf("missing closing quote")
+		}
+		if len(str) > 255 {
+			return nil, fmt.Errorf("character-string too long (%d > 255 bytes)", len(str))
+		}
+		strs = append(strs, string(str))
+	}
+	return strs, nil
+}
+
+// synthesizes the RRSIG records (one per key) of the RRset of the QTYPE at owner (given by the contents in presentation
+// format, with the TTL), owner and zone must be read-locked
+func signRRset(zone, owner *dataNode, qtype string, ttl time.Duration, contents []string, keys []dnssecKey) ([]recordType, error) {
+	typeCode, ok := signableType(qtype)
+	if !ok {
+		return nil, fmt.Errorf("record type %s is not supported for signing", qtype)
+	}
+	origin := zone.getQname()
+	var rdatas [][]byte
+	for _, content := range contents {
+		rd, err := rdata(qtype, content, origin)
+		if err != nil {
+			return nil, err
+		}
+		rdatas = append(rdatas, rd)
+	}
+	// the RRs in canonical order (RFC 4034, section 6.3), without duplicates
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+	ownerWire := nameWire(owner.getQname(), "")
+	var rrs bytes.Buffer
+	for i, rd := range rdatas {
+		if i > 0 && bytes.Equal(rd, rdatas[i-1]) {
+			continue
+		}
+		rrs.Write(ownerWire)
+		writeWire(&rrs, typeCode, uint16(1), uint32(seconds(ttl)), uint16(len(rd)))
+		rrs.Write(rd)
+	}
+	labels := uint8(owner.depth())
+	if owner.lname == "*" {
+		labels--
+	}
+	inception := currentTime().Add(-signatureInceptionOffset).Truncate(time.Hour)
+	expiration := inception.Add(signatureValidity)
+	var records []recordType
+	for _, key := range keys {
+		tag := keyTag(key.dnskeyRdata())
+		var data bytes.Buffer
+		writeWire(&data, typeCode, key.algorithm, labels, uint32(seconds(ttl)), uint32(expiration.Unix()), uint32(inception.Unix()), tag)
+		data.Write(nameWire(origin, ""))
+		data.Write(rrs.Bytes())
+		hash := sha256.Sum256(data.Bytes())
+		r, s, err := ecdsa.Sign(rand.Reader, key.signer, hash[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign with key %d: %s", key.id, err)
+		}
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		content := fmt.Sprintf("%s %d %d %d %s %s %d %s %s", qtype, key.algorithm, labels, seconds(ttl),
+			expiration.UTC().Format(rrsigTimeFormat), inception.UTC().Format(rrsigTimeFormat), tag, origin,
+			base64.StdEncoding.EncodeToString(signature))
+		records = append(records, recordType{content: content, ttl: ttl})
+	}
+	return records, nil
+}
+
+// the time format of the signature expiration and inception in the presentation format of RRSIG records
+const rrsigTimeFormat = "20060102150405"
+
+// finds the zone node of the name, which is read-locked upwards (the caller must unlock it), nil if there is no such zone
+func findKeysZone(name string, client *pdnsClient) *dataNode {
+	qname := parseName(name)
+	ensureLoaded(qname)
+	data := dataRoot.Load().getChild(qname, true)
+	if data.depth() < qname.len() || !data.hasSOA() || !isServedZone(data) {
+		data.rUnlockUpwards(nil)
+		client.log.data().Debugf("no such zone: %q", qname.normal())
+		return nil
+	}
+	return data
+}
+
+func getDomainKeys(params objectType[any], client *pdnsClient) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	zone := findKeysZone(name, client)
+	if zone == nil {
+		return false, nil
+	}
+	defer zone.rUnlockUpwards(nil)
+	result := []objectType[any]{}
+	for _, id := range sortedKeys(zone.keys) {
+		key := zone.keys[id]
+		result = append(result, objectType[any]{
+			"id":        key.id,
+			"flags":     key.flags,
+			"active":    key.active,
+			"published": key.published,
+			"content":   key.content,
+		})
+	}
+	client.log.pdns().WithField("#", len(result)).Debug("keys count")
+	return result, nil
+}
+
+// writes the key into its entry (the data is updated by the watcher)
+func putDNSSECKey(key dnssecKey) error {
+	value, err := json.Marshal(objectType[any]{
+		"flags":     key.flags,
+		"active":    key.active,
+		"published": key.published,
+		"content":   key.content,
+	})
+	if err != nil {
+		return err
+	}
+	return put(key.entryKey, string(value))
+}
+
+func addDomainKey(params objectType[any], client *pdnsClient) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	value, ok := params["key"].(map[string]any)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'key'")
+	}
+	zone := findKeysZone(name, client)
+	if zone == nil {
+		return false, nil
+	}
+	var id int64 = 1
+	for _, key := range zone.keys {
+		id = maxOf(id, key.id+1)
+	}
+	entryKey := *args.Prefix + zone.prefixKey() + keysKey + idSeparator + strconv.FormatInt(id, 10)
+	zone.rUnlockUpwards(nil)
+	key, err := parseDNSSECKey(entryKey, strconv.FormatInt(id, 10), objectType[any](value))
+	if err != nil {
+		return false, fmt.Errorf("invalid key: %s", err)
+	}
+	if err := putDNSSECKey(key); err != nil {
+		return false, fmt.Errorf("failed to put %q: %s", entryKey, err)
+	}
+	client.log.data().Infof("added key %d to zone %q", id, name)
+	return id, nil
+}
+
+// sets the flag active of the key (parameters name and id) in its entry
+func setDomainKeyActive(params objectType[any], client *pdnsClient, active bool) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	id, ok := params["id"].(float64)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'id'")
+	}
+	zone := findKeysZone(name, client)
+	if zone == nil {
+		return false, nil
+	}
+	key, ok := zone.keys[strconv.FormatInt(int64(id), 10)]
+	zone.rUnlockUpwards(nil)
+	if !ok {
+		client.log.data().Debugf("no key %v in zone %q", id, name)
+		return false, nil
+	}
+	key.active = active
+	if err := putDNSSECKey(key); err != nil {
+		return false, fmt.Errorf("failed to put %q: %s", key.entryKey, err)
+	}
+	client.log.data().Infof("set key %d of zone %q active: %v", key.id, name, active)
+	return true, nil
+}
+
+func activateDomainKey(params objectType[any], client *pdnsClient) (interface{}, error) {
+	return setDomainKeyActive(params, client, true)
+}
+
+func deactivateDomainKey(params objectType[any], client *pdnsClient) (interface{}, error) {
+	return setDomainKeyActive(params, client, false)
+}
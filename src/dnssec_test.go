@@ -0,0 +1,354 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalOrder(t *testing.T) {
+	// example from RFC 4034, section 6.1 (relative to "example.")
+	sorted := []string{"", "a", "yljkjljk.a", "Z.a", "zABC.a", "z", "\001.z", "*.z", "\200.z"}
+	for i := 0; i+1 < len(sorted); i++ {
+		a, b := reversed(splitDomainName(sorted[i], ".")), reversed(splitDomainName(sorted[i+1], "."))
+		if !canonicalLess(a, b) || canonicalLess(b, a) {
+			t.Errorf("expected %q < %q", sorted[i], sorted[i+1])
+		}
+	}
+}
+
+func TestGetBeforeAndAfterNamesAbsolute(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	for _, name := range []string{"b", "d", "x.d", "f"} {
+		zone.getChildCreate(testName(name)).records["A"] = map[string]recordType{"": {content: "192.0.2.1"}}
+	}
+	zone.getChildCreate(testName("empty.non.terminal")).records["A"] = map[string]recordType{"": {content: "192.0.2.2"}}
+	sub := zone.getChildCreate(testName("sub"))
+	setTestSOA(sub)
+	sub.getChildCreate(testName("a")).records["A"] = map[string]recordType{"": {content: "192.0.2.3"}}
+	// sorted: "" b d x.d f sub empty.non.terminal
+	for _, spec := range []struct{ qname, before, after string }{
+		{"", "", "b"},
+		{"a", "", "b"},
+		{"b", "b", "d"},
+		{"c", "b", "d"},
+		{"e.d", "d", "x.d"},
+		{"non", "f", "sub"},
+		{"sub", "sub", "empty.non.terminal"},
+		{"zzz", "empty.non.terminal", ""},                // wrap-around
+		{"empty.non.terminal", "empty.non.terminal", ""}, // wrap-around
+		{"a.sub", "sub", "empty.non.terminal"},           // beneath the delegation
+		{"C.example.net.", "b", "d"},                     // absolute
+	} {
+		result, err := getBeforeAndAfterNamesAbsolute(objectType[any]{"id": float64(zone.zoneID), "qname": spec.qname}, newTestClient())
+		if err != nil {
+			t.Fatalf("%q: failed: %s", spec.qname, err)
+		}
+		names, ok := result.(objectType[any])
+		if !ok {
+			t.Fatalf("%q: unexpected result: %v", spec.qname, result)
+		}
+		if names["before"] != spec.before || names["after"] != spec.after {
+			t.Errorf("%q: expected (%q, %q), got (%q, %q)", spec.qname, spec.before, spec.after, names["before"], names["after"])
+		}
+	}
+	if result, err := getBeforeAndAfterNamesAbsolute(objectType[any]{"id": float64(-1), "qname": "b"}, newTestClient()); err != nil || result != false {
+		t.Errorf("unknown zone: expected false, got %v (%v)", result, err)
+	}
+}
+
+func TestMinimallyCoveringNames(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	for _, name := range []string{"b", "d", "x.d", "f"} {
+		zone.getChildCreate(testName(name)).records["A"] = map[string]recordType{"": {content: "192.0.2.1"}}
+	}
+	zone.getChildCreate(testName("del")).records["NS"] = map[string]recordType{"": {content: "ns.example.org."}}
+	setTestSOA(zone.getChildCreate(testName("sub")))
+	zone.options[""] = map[string]defoptType{"": {objectType[any]{dnssecOption: true}, nil}}
+	// sorted: "" b d x.d del f sub
+	for _, spec := range []struct{ qname, before, after string }{
+		{"", "", "b"},
+		{"a", "`", "\\000.a"},
+		{"b", "b", "d"},
+		{"c", "b", "\\000.c"},
+		{"ca", "c`", "\\000.ca"},
+		{"e", "del", "\\000.e"},
+		{"e.d", "d.d", "\\000.e.d"},
+		{"[", "@", "\\000.["},
+		{"a\\000", "a", "\\000.a\\000"},
+		{"zzz", "zzy", "\\000.zzz"}, // wrap-around
+		{"a.del", "del", "f"},       // beneath the delegation
+		{"a.sub", "sub", ""},        // beneath the nested zone
+	} {
+		result, err := getBeforeAndAfterNamesAbsolute(objectType[any]{"id": float64(zone.zoneID), "qname": spec.qname}, newTestClient())
+		if err != nil {
+			t.Fatalf("%q: failed: %s", spec.qname, err)
+		}
+		names, ok := result.(objectType[any])
+		if !ok {
+			t.Fatalf("%q: unexpected result: %v", spec.qname, result)
+		}
+		if names["before"] != spec.before || names["after"] != spec.after {
+			t.Errorf("%q: expected (%q, %q), got (%q, %q)", spec.qname, spec.before, spec.after, names["before"], names["after"])
+		}
+	}
+}
+
+// a new ECDSAP256SHA256 private key in the ISC format
+func newTestPrivateKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("Private-key-format: v1.2\nAlgorithm: 13 (ECDSAP256SHA256)\nPrivateKey: %s\n", base64.StdEncoding.EncodeToString(private.D.FillBytes(make([]byte, 32))))
+	return private, content
+}
+
+func TestParseDNSSECKey(t *testing.T) {
+	private, content := newTestPrivateKey(t)
+	key, err := parseDNSSECKey("dns/net.example/-keys-#3", "3", objectType[any]{"flags": float64(257), "active": true, "content": content})
+	if err != nil {
+		t.Fatalf("failed to parse the key: %s", err)
+	}
+	if key.id != 3 || key.flags != 257 || !key.active || !key.published || key.algorithm != ecdsaP256SHA256 || key.signer == nil {
+		t.Fatalf("unexpected key: %+v", key)
+	}
+	if key.signer.X.Cmp(private.X) != 0 || key.signer.Y.Cmp(private.Y) != 0 {
+		t.Errorf("the public key does not match")
+	}
+	// an algorithm unsupported for signing is still a valid key
+	key, err = parseDNSSECKey("", "1", objectType[any]{"flags": float64(256), "content": "Private-key-format: v1.2\nAlgorithm: 8 (RSASHA256)\nModulus: AQAB\n"})
+	if err != nil || key.algorithm != 8 || key.signer != nil || key.signerErr == nil || key.active {
+		t.Errorf("unsupported algorithm: unexpected key %+v (%v)", key, err)
+	}
+	for i, value := range []any{
+		"plain string",
+		objectType[any]{"flags": float64(255), "content": content},
+		objectType[any]{"flags": float64(256)},
+		objectType[any]{"flags": float64(256), "content": content, "active": "yes"},
+		objectType[any]{"flags": float64(256), "content": "Algorithm: 13\nPrivateKey: AAAA\n"},
+		objectType[any]{"flags": float64(256), "content": "PrivateKey: AAAA\n"},
+	} {
+		if _, err := parseDNSSECKey("", "1", value); err == nil {
+			t.Errorf("#%d: expected an error for %v", i+1, value)
+		}
+	}
+	newTestETCD("dns/", nil)
+	for _, key := range []string{"dns/net.example/-keys-#1", "dns/net.example/-keys-", "dns/net.example/-keys-#x", "dns/net.example/-keys-/A#1"} {
+		_, entryType, _, id, _, err := parseEntryKey(key)
+		if valid := key == "dns/net.example/-keys-#1"; (err == nil) != valid || (valid && (entryType != keysEntry || id != "1")) {
+			t.Errorf("%s: unexpected result %q %q (%v)", key, entryType, id, err)
+		}
+	}
+}
+
+func TestKeyTag(t *testing.T) {
+	// the DNSKEY of the root zone (KSK-2017, key tag 20326)
+	rdata, err := rdata("DNSKEY", "257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag := keyTag(rdata); tag != 20326 {
+		t.Errorf("expected key tag 20326, got %d", tag)
+	}
+}
+
+func TestRdata(t *testing.T) {
+	for _, spec := range []struct {
+		qtype, content string
+		expected       string // hex, "" = error
+	}{
+		{"SOA", `ns1.example.net. host\.master.example.net. 5 60 30 600 10`, "036e7331076578616d706c65036e6574000b686f73742e6d6173746572076578616d706c65036e657400000000050000003c0000001e000002580000000a"},
+		{"SOA", "ns1 hostmaster 5", ""},
+		{"TXT", `"v=spf1 -all" two\032words \"`, "0b763d73706631202d616c6c0974776f20776f7264730122"},
+		{"SPF", `"unterminated`, ""},
+		{"HINFO", `"PC" "Linux"`, "025043054c696e7578"},
+		{"HINFO", `"PC"`, ""},
+		{"SRV", "10 5 443 www", "000a000501bb03777777076578616d706c65036e657400"},
+		{"DS", "12345 13 2 ABCD", "30390d02abcd"},
+		{"CERT", "1 12345 13 AQI=", "000130390d0102"},
+		{"NSEC3PARAM", "1 0 10 -", "0100000a00"},
+		{"NSEC3PARAM", "1 0 10 ab", "0100000a01ab"},
+		{"APL", "1:192.0.2.0/24 !2:2001:db8::/32", "00011803c000020002208420010db8"},
+		{"TYPE65280", `\# 2 abcd`, "abcd"},
+		{"TYPE65280", `\# 3 abcd`, ""},
+		{"ALIAS", "www", ""},
+	} {
+		rd, err := rdata(spec.qtype, spec.content, "example.net.")
+		if actual := hex.EncodeToString(rd); err != nil && spec.expected != "" || err == nil && actual != spec.expected {
+			t.Errorf("%s %q: expected %q, got %q (%v)", spec.qtype, spec.content, spec.expected, actual, err)
+		}
+	}
+}
+
+func TestSignedLookup(t *testing.T) {
+	defer func(f func() time.Time) { currentTime = f }(currentTime)
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for id, ip := range map[string]string{"1": "192.0.2.2", "2": "192.0.2.1"} {
+		if _, err := storeTestEntry(www, "A", id, `{"ip": "`+ip+`", "ttl": 300}`); err != nil {
+			t.Fatalf("failed to store A: %s", err)
+		}
+	}
+	private, content := newTestPrivateKey(t)
+	key, err := parseDNSSECKey("", "1", objectType[any]{"flags": float64(256), "active": true, "content": content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone.keys["1"] = key
+	lookupItems := func(qname, qtype string) []objectType[any] {
+		result, err := lookup(objectType[any]{"qname": qname, "qtype": qtype}, newTestClient())
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		items, _ := result.([]objectType[any])
+		return items
+	}
+	if items := lookupItems("www.example.net.", "A"); len(items) != 2 {
+		t.Errorf("expected no signature without option %s, got %v", dnssecOption, items)
+	}
+	zone.options[""] = map[string]defoptType{"": {objectType[any]{dnssecOption: true}, nil}}
+	items := lookupItems("www.example.net.", "A")
+	if len(items) != 3 || items[2]["qtype"] != "RRSIG" || items[2]["ttl"] != int64(300) {
+		t.Fatalf("expected 2 A records and their RRSIG, got %v", items)
+	}
+	tag := keyTag(key.dnskeyRdata())
+	fields := strings.Fields(items[2]["content"].(string))
+	expected := []string{"A", "13", "3", "300", "20240116090000", "20240102090000", fmt.Sprint(tag), "example.net."}
+	if len(fields) != 9 || strings.Join(fields[:8], " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected RRSIG %v <signature>, got %v", expected, fields)
+	}
+	// the signed data: the RRSIG RDATA (without the signature) and the RRs in canonical order
+	var data bytes.Buffer
+	data.Write([]byte{0, 1, 13, 3, 0, 0, 1, 44})
+	data.Write(big.NewInt(now.Add(-time.Hour).Truncate(time.Hour).Add(14 * 24 * time.Hour).Unix()).FillBytes(make([]byte, 4)))
+	data.Write(big.NewInt(now.Add(-time.Hour).Truncate(time.Hour).Unix()).FillBytes(make([]byte, 4)))
+	data.Write([]byte{byte(tag >> 8), byte(tag)})
+	data.WriteString("\x07example\x03net\x00")
+	for _, ip := range []byte{1, 2} {
+		data.WriteString("\x03www\x07example\x03net\x00")
+		data.Write([]byte{0, 1, 0, 1, 0, 0, 1, 44, 0, 4, 192, 0, 2, ip})
+	}
+	signature, err := base64.StdEncoding.DecodeString(fields[8])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("invalid signature %q (%v)", fields[8], err)
+	}
+	hash := sha256.Sum256(data.Bytes())
+	if !ecdsa.Verify(&private.PublicKey, hash[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		t.Errorf("the signature does not verify")
+	}
+	// the DNSKEY RRset is synthesized at the apex and signed too
+	items = lookupItems("example.net.", "DNSKEY")
+	if len(items) != 2 || items[0]["qtype"] != "DNSKEY" || !strings.HasPrefix(items[0]["content"].(string), "256 3 13 ") || items[1]["qtype"] != "RRSIG" {
+		t.Errorf("expected the DNSKEY record and its RRSIG, got %v", items)
+	}
+	// all served types are signed, SOA in particular (for the negative answers)
+	if _, err := storeTestEntry(zone, "SOA", "", `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`); err != nil {
+		t.Fatalf("failed to store SOA: %s", err)
+	}
+	if _, err := storeTestEntry(www, "TXT", "", `"v=spf1 -all"`); err != nil {
+		t.Fatalf("failed to store TXT: %s", err)
+	}
+	for _, spec := range []struct{ qname, qtype string }{{"example.net.", "SOA"}, {"www.example.net.", "TXT"}} {
+		if items := lookupItems(spec.qname, spec.qtype); len(items) != 2 || items[1]["qtype"] != "RRSIG" || !strings.HasPrefix(items[1]["content"].(string), spec.qtype+" ") {
+			t.Errorf("%s: expected the record and its RRSIG, got %v", spec.qtype, items)
+		}
+	}
+	// a signed answer is truncated by whole RRsets, keeping at least the first one
+	defer func() { args.MaxAnswers = nil }()
+	for _, spec := range []struct {
+		limit  int
+		qtypes []string
+	}{
+		{4, []string{"A", "A", "RRSIG"}},
+		{2, []string{"A", "A", "RRSIG"}},
+		{5, []string{"A", "A", "RRSIG", "TXT", "RRSIG"}},
+	} {
+		args.MaxAnswers = &spec.limit
+		var qtypes []string
+		for _, item := range lookupItems("www.example.net.", "ANY") {
+			qtypes = append(qtypes, item["qtype"].(string))
+		}
+		if !equal(qtypes, spec.qtypes) {
+			t.Errorf("max-answers %d: expected the items %v, got %v", spec.limit, spec.qtypes, qtypes)
+		}
+	}
+	args.MaxAnswers = nil
+	// inactive keys do not sign
+	key.active = false
+	zone.keys["1"] = key
+	if items := lookupItems("www.example.net.", "A"); len(items) != 2 {
+		t.Errorf("expected no signature without active key, got %v", items)
+	}
+}
+
+func TestDomainKeys(t *testing.T) {
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	})
+	_, content := newTestPrivateKey(t)
+	contentJSON := strings.ReplaceAll(content, "\n", `\n`)
+	kv.entries["dns/net.example/-keys-#2"] = `{"flags": 257, "active": true, "content": "` + contentJSON + `"}`
+	kv.entries["dns/net.example/-keys-#5"] = `{"flags": 256, "content": "invalid"}`
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	drainKeys(kv)
+	result, err := getDomainKeys(objectType[any]{"name": "example.net."}, newTestClient())
+	keys, ok := result.([]objectType[any])
+	if err != nil || !ok || len(keys) != 1 || keys[0]["id"] != int64(2) || keys[0]["flags"] != uint16(257) || keys[0]["active"] != true || keys[0]["content"] != content {
+		t.Fatalf("expected the key 2, got %v (%v)", result, err)
+	}
+	if result, err := getDomainKeys(objectType[any]{"name": "example.org."}, newTestClient()); result != false || err != nil {
+		t.Errorf("expected no keys for an unknown zone, got %v (%v)", result, err)
+	}
+	// new keys get the next id
+	result, err = addDomainKey(objectType[any]{"name": "example.net.", "key": map[string]any{"flags": float64(256), "active": false, "published": true, "content": content}}, newTestClient())
+	if err != nil || result != int64(3) {
+		t.Fatalf("expected the new key id 3, got %v (%v)", result, err)
+	}
+	if value := kv.entries["dns/net.example/-keys-#3"]; !strings.Contains(value, `"flags":256`) || !strings.Contains(value, `"active":false`) {
+		t.Errorf("unexpected entry of the new key: %s", value)
+	}
+	if _, err := addDomainKey(objectType[any]{"name": "example.net.", "key": map[string]any{"flags": float64(1)}}, newTestClient()); err == nil {
+		t.Errorf("expected an invalid key to be rejected")
+	}
+	if result, err := activateDomainKey(objectType[any]{"name": "example.net.", "id": float64(4)}, newTestClient()); result != false || err != nil {
+		t.Errorf("expected no activation of an unknown key, got %v (%v)", result, err)
+	}
+	if result, err := deactivateDomainKey(objectType[any]{"name": "example.net.", "id": float64(2)}, newTestClient()); result != true || err != nil {
+		t.Fatalf("deactivation failed: %v (%v)", result, err)
+	}
+	if value := kv.entries["dns/net.example/-keys-#2"]; !strings.Contains(value, `"active":false`) || !strings.Contains(value, `"flags":257`) {
+		t.Errorf("unexpected entry of the deactivated key: %s", value)
+	}
+}
@@ -0,0 +1,174 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the zones with a changed serial since the last getUpdatedMasters request, with their serials reported before
+var updatedZones = struct {
+	sync.Mutex
+	serials map[string]int64
+}{serials: map[string]int64{}}
+
+// the zones to serve (parameter zones), nil for all zones
+func servedZones() []nameType {
+	if args.Zones == nil || *args.Zones == "" {
+		return nil
+	}
+	var zones []nameType
+	for _, zone := range strings.Split(*args.Zones, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, parseName(zone))
+		}
+	}
+	return zones
+}
+
+// whether the zone (nil for the data outside of any zone) is served, according to the parameter zones
+func isServedZone(zone *dataNode) bool {
+	zones := servedZones()
+	if zones == nil {
+		return true
+	}
+	if zone == nil {
+		return false
+	}
+	qname := zone.getQname()
+	for _, served := range zones {
+		if served.normal() == qname {
+			return true
+		}
+	}
+	return false
+}
+
+// whether the name is neither within nor above any of the served zones (parameter zones), so its entries do not affect them
+func isOutsideServedZones(name nameType) bool {
+	zones := servedZones()
+	if zones == nil {
+		return false
+	}
+ZONES:
+	for _, zone := range zones {
+		for depth := 1; depth <= minOf(zone.len(), name.len()); depth++ {
+			if zone.lname(depth) != name.lname(depth) {
+				continue ZONES
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func makeDomainInfo(zone *dataNode) objectType[any] {
+	serial := zone.zoneSerial()
+	return objectType[any]{
+		"id":              zone.zoneID,
+		"zone":            zone.getQname(),
+		"serial":          serial,
+		"notified_serial": serial, // notifications are not tracked, so it's always up-to-date
+		"kind":            "NATIVE",
+	}
+}
+
+func getAllDomains(params objectType[any], client *pdnsClient) (interface{}, error) {
+	includeDisabled, _ := params["include_disabled"].(bool)
+	client.log.data().WithField("include_disabled", includeDisabled).Trace("collecting all zones")
+	// there are no disabled zones (yet), so include_disabled does not change the result
+	ensureAllLoaded()
+	// the infos are made while the zone is read-locked (by walk())
+	result := []objectType[any]{}
+	dataRoot.Load().walk(func(dn *dataNode) bool {
+		if dn.hasSOA() && isServedZone(dn) {
+			result = append(result, makeDomainInfo(dn))
+		}
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i]["zone"].(string) < result[j]["zone"].(string)
+	})
+	client.log.pdns().WithField("#", len(result)).Debug("zones count")
+	return result, nil
+}
+
+func getDomainInfo(params objectType[any], client *pdnsClient) (interface{}, error) {
+	qname, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	name := parseName(qname)
+	ensureLoaded(name)
+	data := dataRoot.Load().getChild(name, true)
+	defer data.rUnlockUpwards(nil)
+	if data.depth() < name.len() || !data.hasSOA() || !isServedZone(data) {
+		client.log.data().Debugf("no such zone: %q", name.normal())
+		return false, nil
+	}
+	return makeDomainInfo(data), nil
+}
+
+// marks the zone as updated if its serial differs from the previous serial (the caller must hold a lock of the zone)
+func markUpdatedZone(zone *dataNode, prevSerial int64) {
+	if !zone.hasSOA() || zone.zoneSerial() == prevSerial {
+		return
+	}
+	qname := zone.getQname()
+	updatedZones.Lock()
+	defer updatedZones.Unlock()
+	if _, ok := updatedZones.serials[qname]; !ok {
+		updatedZones.serials[qname] = prevSerial
+	}
+	log.data().WithField("serial", zone.zoneSerial()).Tracef("zone %q updated", qname)
+}
+
+// returns the zones updated since the last call (with notified_serial being the serial reported before) and clears them
+func getUpdatedMasters(_ objectType[any], client *pdnsClient) (interface{}, error) {
+	updatedZones.Lock()
+	serials := updatedZones.serials
+	updatedZones.serials = map[string]int64{}
+	updatedZones.Unlock()
+	qnames := make([]string, 0, len(serials))
+	for qname := range serials {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	result := []objectType[any]{}
+	for _, qname := range qnames {
+		name := parseName(qname)
+		data := dataRoot.Load().getChild(name, true)
+		if data.depth() == name.len() && data.hasSOA() && isServedZone(data) {
+			info := makeDomainInfo(data)
+			info["notified_serial"] = serials[qname]
+			result = append(result, info)
+		}
+		data.rUnlockUpwards(nil)
+	}
+	client.log.pdns().WithField("#", len(result)).Debug("updated zones count")
+	return result, nil
+}
+
+// acknowledges the notification of a zone reported by getUpdatedMasters(). the notified serials are not tracked (see
+// makeDomainInfo()), so there is nothing to store.
+func setNotified(params objectType[any], client *pdnsClient) (interface{}, error) {
+	client.log.pdns().WithFields(logrus.Fields{"id": params["id"], "serial": params["serial"]}).Debug("zone notified")
+	return true, nil
+}
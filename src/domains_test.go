@@ -0,0 +1,195 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+func TestGetAllDomains(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	zone.maxRev = 12
+	zone.getChildCreate(testName("www")).maxRev = 15
+	sub := zone.getChildCreate(testName("sub"))
+	setTestSOA(sub)
+	sub.maxRev = 20
+	dataRoot.Load().getChildCreate(testName("example.org.")).records["A"] = map[string]recordType{"": {content: "192.0.2.1"}}
+	result, err := getAllDomains(objectType[any]{"include_disabled": false}, newTestClient())
+	if err != nil {
+		t.Fatalf("getAllDomains failed: %s", err)
+	}
+	domains, ok := result.([]objectType[any])
+	if !ok || len(domains) != 2 {
+		t.Fatalf("expected 2 zones, got %v", result)
+	}
+	for i, expected := range []struct {
+		zone   string
+		serial int64
+		id     int64
+	}{
+		{"example.net.", 15, zone.zoneID},
+		{"sub.example.net.", 20, sub.zoneID},
+	} {
+		domain := domains[i]
+		if domain["zone"] != expected.zone || domain["serial"] != expected.serial || domain["kind"] != "NATIVE" || domain["id"] != expected.id {
+			t.Errorf("zone #%d: expected %+v, got %v", i+1, expected, domain)
+		}
+	}
+	if zone.zoneID == sub.zoneID {
+		t.Errorf("zone ids are not distinct")
+	}
+	if id := newTestZone("example.net.").zoneID; id != zone.zoneID {
+		t.Errorf("zone id is not stable: %d != %d", id, zone.zoneID)
+	}
+}
+
+func TestZoneIDCollision(t *testing.T) {
+	zone := newTestZone("zone229618.example.")
+	root := zone.parent.parent
+	dataRoot.Store(root)
+	other := zone.parent.getChildCreate(testName("zone801496"))
+	setTestSOA(other)
+	if makeZoneID(zone.zoneIDName()) != makeZoneID(other.zoneIDName()) {
+		t.Fatalf("expected the hashes of the zone names to collide")
+	}
+	root.assignZoneIDs()
+	if zone.zoneID == other.zoneID {
+		t.Fatalf("expected distinct zone ids, got %d twice", zone.zoneID)
+	}
+	if zone.zoneID != makeZoneID(zone.zoneIDName()) || other.zoneID != zone.zoneID+1 {
+		t.Errorf("expected the ids by the order of the names, got %d and %d", zone.zoneID, other.zoneID)
+	}
+	for _, dn := range []*dataNode{zone, other} {
+		dn.records["A"] = map[string]recordType{"": {content: "192.0.2.1", ttl: time.Hour}}
+	}
+	for _, spec := range []struct {
+		zone, other *dataNode
+	}{{zone, other}, {other, zone}} {
+		qname := spec.zone.getQname()
+		if result, _ := lookup(objectType[any]{"qname": qname, "qtype": "A", "zone-id": float64(spec.zone.zoneID)}, newTestClient()); result == false {
+			t.Errorf("%s: expected the record by its zone id %d", qname, spec.zone.zoneID)
+		}
+		if result, _ := lookup(objectType[any]{"qname": qname, "qtype": "A", "zone-id": float64(spec.other.zoneID)}, newTestClient()); result != false {
+			t.Errorf("%s: expected no record by the zone id %d of the other zone, got %v", qname, spec.other.zoneID, result)
+		}
+	}
+}
+
+func TestGetDomainInfo(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	zone.maxRev = 42
+	if _, err := storeTestEntry(zone, "SOA", "", `{"primary":"ns1","mail":"hostmaster","refresh":1,"retry":1,"expire":1,"neg-ttl":1}`); err != nil {
+		t.Fatalf("failed to store SOA: %s", err)
+	}
+	zone.getChildCreate(testName("www")).records["A"] = map[string]recordType{"": {content: "192.0.2.1"}}
+	result, err := getDomainInfo(objectType[any]{"name": "example.net."}, newTestClient())
+	if err != nil {
+		t.Fatalf("getDomainInfo failed: %s", err)
+	}
+	info, ok := result.(objectType[any])
+	if !ok {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	if info["zone"] != "example.net." || info["serial"] != int64(42) || info["kind"] != "NATIVE" || info["id"] != zone.zoneID {
+		t.Errorf("unexpected domain info: %v", info)
+	}
+	if soa := zone.records["SOA"][""].content; !strings.Contains(soa, fmt.Sprintf(" %d ", info["serial"])) {
+		t.Errorf("serial %v does not match SOA record %q", info["serial"], soa)
+	}
+	for _, name := range []string{"www.example.net.", "net.", "example.org."} {
+		if result, err := getDomainInfo(objectType[any]{"name": name}, newTestClient()); err != nil || result != false {
+			t.Errorf("%s: expected false, got %v (%v)", name, result, err)
+		}
+	}
+}
+
+func TestDomainInfoSerial(t *testing.T) {
+	defer func(f func() time.Time) { currentTime = f }(currentTime)
+	currentTime = func() time.Time { return time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC) }
+	zone := newTestZone("serial.example.")
+	dataRoot.Store(zone.parent.parent)
+	zone.maxRev = 7
+	zone.options["SOA"] = map[string]defoptType{"": {objectType[any]{soaSerialOption: soaSerialDate}, nil}}
+	if _, err := storeTestEntry(zone, "SOA", "", `{"primary":"ns1","mail":"hostmaster","refresh":1,"retry":1,"expire":1,"neg-ttl":1}`); err != nil {
+		t.Fatalf("failed to store SOA: %s", err)
+	}
+	serial := strings.Fields(zone.records["SOA"][""].content)[2]
+	if serial != "2024010200" {
+		t.Fatalf("unexpected SOA serial %s", serial)
+	}
+	info, _ := getDomainInfo(objectType[any]{"name": "serial.example."}, newTestClient())
+	domains, _ := getAllDomains(objectType[any]{}, newTestClient())
+	for _, info := range []any{info, domains.([]objectType[any])[0]} {
+		info := info.(objectType[any])
+		if fmt.Sprint(info["serial"]) != serial || fmt.Sprint(info["notified_serial"]) != serial {
+			t.Errorf("expected the serial %s of the SOA record, got %v", serial, info)
+		}
+	}
+}
+
+func TestGetUpdatedMasters(t *testing.T) {
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/A":   `192.0.2.1`,
+		"dns/org.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	})
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	drainKeys(kv)
+	updated := func() []objectType[any] {
+		result, err := getUpdatedMasters(objectType[any]{}, newTestClient())
+		if err != nil {
+			t.Fatalf("getUpdatedMasters failed: %s", err)
+		}
+		return result.([]objectType[any])
+	}
+	updated() // clear the zones updated by other tests
+	for rev, entry := range []struct{ key, value string }{
+		{"dns/net.example/A", `192.0.2.2`},            // updated in place
+		{"dns/net.example/-defaults-", `{"ttl": 60}`}, // reloads the zone
+	} {
+		rev := int64(rev + 2)
+		kv.entries[entry.key] = entry.value
+		kv.revisions = map[string]int64{entry.key: rev}
+		handleEvent("", &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(entry.key), Value: []byte(entry.value), CreateRevision: rev, ModRevision: rev}})
+		drainKeys(kv)
+	}
+	zones := updated()
+	if len(zones) != 1 || zones[0]["zone"] != "example.net." || zones[0]["serial"] != int64(3) || zones[0]["notified_serial"] != int64(1) {
+		t.Errorf("expected the zone example.net. with serial 3 (notified 1), got %v", zones)
+	}
+	if zones := updated(); len(zones) != 0 {
+		t.Errorf("expected the updated zones to be cleared, got %v", zones)
+	}
+}
+
+func TestSetNotified(t *testing.T) {
+	out := &strings.Builder{}
+	client := newPdnsClient(0, strings.NewReader(""), out)
+	handleRequest(&pdnsRequest{"setNotified", objectType[any]{"id": float64(1), "serial": float64(2)}}, client)
+	if response := strings.TrimSpace(out.String()); response != `{"result":true}` {
+		t.Errorf("expected the response {\"result\":true}, got %s", response)
+	}
+}
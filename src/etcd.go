@@ -15,8 +15,12 @@ limitations under the License. */
 package src
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"strings"
+	"math/rand"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -25,38 +29,163 @@ import (
 )
 
 var (
-	cli *clientv3.Client
+	cli      map[string]*clientv3.Client // cluster name → client
+	clusters []string                    // the cluster names in the given order, the first one is the primary cluster ("" for a single unnamed cluster)
 )
 
 func setupClient() (logMessages []string, err error) {
+	cli = map[string]*clientv3.Client{}
+	clusters = nil
 	if len(*args.ConfigFile) > 0 {
-		cli, err = clientv3.NewFromConfigFile(*args.ConfigFile)
+		var client *clientv3.Client
+		client, err = clientv3.NewFromConfigFile(*args.ConfigFile)
 		if err != nil {
 			err = fmt.Errorf("failed to create client instance: %s", err)
 			return
 		}
+		cli[""], clusters = client, []string{""}
 		logMessages = append(logMessages, fmt.Sprintf("%s: %s", configFileParam, *args.ConfigFile))
+		logMessages = append(logMessages, fmt.Sprintf("%s: %s", reqTimeoutParam, requestTimeout()))
+		if *args.Username != "" || *args.Password != "" {
+			logMessages = append(logMessages, fmt.Sprintf("%s and %s are ignored, because %s is given", usernameParam, passwordParam, configFileParam))
+		}
+		logMessages = append(logMessages, applyNamespace(client)...)
+		return
+	}
+	endpoints, err := parseClusters(*args.Endpoints)
+	if err != nil {
+		err = fmt.Errorf("invalid %s: %s", endpointsParam, err)
+		return
+	}
+	if len(endpoints) > 1 && *args.LazyLoad {
+		err = fmt.Errorf("%s is not supported with multiple clusters", lazyLoadParam)
+		return
+	}
+	var cfg clientv3.Config
+	cfg, logMessages, err = clientConfig()
+	if err != nil {
+		return
+	}
+	for _, cluster := range endpoints {
+		cfg.Endpoints = cluster.endpoints
+		var client *clientv3.Client
+		client, err = clientv3.New(cfg)
+		if err != nil {
+			closeClient()
+			err = fmt.Errorf("failed to create ETCD client instance (cluster %q): %s", cluster.name, err)
+			return
+		}
+		cli[cluster.name] = client
+		clusters = append(clusters, cluster.name)
+		if cluster.name == "" {
+			logMessages = append(logMessages, fmt.Sprintf("%s: %v", endpointsParam, cfg.Endpoints))
+		} else {
+			logMessages = append(logMessages, fmt.Sprintf("%s (cluster %s): %v", endpointsParam, cluster.name, cfg.Endpoints))
+		}
+	}
+	logMessages = append(logMessages, applyNamespace(Map(clusters, func(cluster string, _ int) *clientv3.Client { return cli[cluster] })...)...)
+	return
+}
+
+// wraps the KV and Watcher of the clients with the namespace (if given). Lease needs no wrapping, it has no keys.
+func applyNamespace(clients ...*clientv3.Client) (logMessages []string) {
+	if *args.Namespace == "" {
 		return
 	}
-	cfg := clientv3.Config{
+	for _, client := range clients {
+		client.KV = newNamespaceKV(client.KV, *args.Namespace)
+		client.Watcher = newNamespaceWatcher(client.Watcher, *args.Namespace)
+	}
+	return []string{fmt.Sprintf("%s: %s", namespaceParam, *args.Namespace)}
+}
+
+// builds the client configuration from the parameters (when not using a configuration file)
+func clientConfig() (cfg clientv3.Config, logMessages []string, err error) {
+	cfg = clientv3.Config{
 		DialTimeout: *args.DialTimeout,
-		Endpoints:   strings.Split(*args.Endpoints, `|`),
 	}
 	logMessages = append(logMessages,
 		fmt.Sprintf("%s: %s", dialTimeoutParam, *args.DialTimeout),
+		fmt.Sprintf("%s: %s", reqTimeoutParam, requestTimeout()),
 		fmt.Sprintf("%s: %s", endpointsParam, *args.Endpoints),
 	)
-	cli, err = clientv3.New(cfg)
+	cfg.TLS, err = tlsConfig()
 	if err != nil {
-		err = fmt.Errorf("failed to create ETCD client instance: %s", err)
+		err = fmt.Errorf("failed to create TLS configuration: %s", err)
+		return
+	}
+	if cfg.TLS != nil {
+		logMessages = append(logMessages, fmt.Sprintf("TLS: %s=%q, %s=%q, %s=%q", certFileParam, *args.CertFile, keyFileParam, *args.KeyFile, caFileParam, *args.CAFile))
+	}
+	if *args.Username == "" && *args.Password != "" {
+		err = fmt.Errorf("%s is given, but %s is missing", passwordParam, usernameParam)
+		return
+	}
+	if *args.Username != "" && *args.Password == "" {
+		err = fmt.Errorf("%s is given, but %s is missing", usernameParam, passwordParam)
 		return
 	}
-	logMessages = append(logMessages, fmt.Sprintf("%s: %v", endpointsParam, cfg.Endpoints))
+	if *args.Username != "" {
+		cfg.Username = *args.Username
+		cfg.Password = *args.Password
+		logMessages = append(logMessages, fmt.Sprintf("%s: %s", usernameParam, *args.Username))
+	}
 	return
 }
 
+// returns nil (and no error) when no TLS parameter is given
+func tlsConfig() (*tls.Config, error) {
+	if *args.CertFile == "" && *args.KeyFile == "" && *args.CAFile == "" {
+		return nil, nil
+	}
+	if *args.CertFile == "" && *args.KeyFile != "" {
+		return nil, fmt.Errorf("%s is given, but %s is missing", keyFileParam, certFileParam)
+	}
+	if *args.CertFile != "" && *args.KeyFile == "" {
+		return nil, fmt.Errorf("%s is given, but %s is missing", certFileParam, keyFileParam)
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *args.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(*args.CertFile, *args.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if *args.CAFile != "" {
+		pem, err := os.ReadFile(*args.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the CA file %s", *args.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// the request timeout defaults to the dial timeout (for backward compatibility)
+func requestTimeout() time.Duration {
+	if args.ReqTimeout == nil || *args.ReqTimeout == 0 {
+		return *args.DialTimeout
+	}
+	return *args.ReqTimeout
+}
+
+// the timeout for loading the whole data at startup defaults to the dial timeout, but at least defaultStartTimeout
+func startupTimeout() time.Duration {
+	if args.StartTimeout == nil || *args.StartTimeout == 0 {
+		return maxOf(defaultStartTimeout, *args.DialTimeout)
+	}
+	return *args.StartTimeout
+}
+
 func closeClient() {
-	cli.Close()
+	for _, client := range cli {
+		client.Close()
+	}
 }
 
 type etcdItem struct {
@@ -81,55 +210,128 @@ func getResponse(response *clientv3.GetResponse) *getResponseType {
 	return &getResponseType{response.Header.Revision, ch}
 }
 
-func get(key string, multi bool, revision *int64) (*getResponseType, error) {
-	log.etcd().WithFields(logrus.Fields{"multi": multi, "rev": revision}).Tracef("get %q", key)
-	opts := []clientv3.OpOption(nil)
+// gets from the primary cluster (see getFrom())
+func get(key string, multi bool, revision *int64, extraOpts ...clientv3.OpOption) (*getResponseType, error) {
+	return getFrom(clusters[0], key, multi, revision, extraOpts...)
+}
+
+func getFrom(cluster, key string, multi bool, revision *int64, extraOpts ...clientv3.OpOption) (*getResponseType, error) {
+	return getFromWithin(cluster, key, multi, revision, requestTimeout(), extraOpts...)
+}
+
+func getFromWithin(cluster, key string, multi bool, revision *int64, timeout time.Duration, extraOpts ...clientv3.OpOption) (*getResponseType, error) {
+	log.etcd().WithFields(logrus.Fields{"cluster": cluster, "multi": multi, "rev": revision}).Tracef("get %q", key)
+	opts := extraOpts
 	if multi {
 		opts = append(opts, clientv3.WithPrefix())
 	}
 	if revision != nil {
 		opts = append(opts, clientv3.WithRev(*revision))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), *args.DialTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	since := time.Now()
-	response, err := cli.Get(ctx, key, opts...)
+	response, err := cli[cluster].Get(ctx, key, opts...)
 	dur := time.Since(since)
 	if err != nil {
 		return nil, fmt.Errorf("[dur %s] %s", dur, err)
 	}
-	log.etcd().WithFields(logrus.Fields{"multi": multi, "dur": dur, "rev": revision, "#": response.Count, "more": response.More}).Tracef("got %q", key)
+	log.etcd().WithFields(logrus.Fields{"cluster": cluster, "multi": multi, "dur": dur, "rev": revision, "#": response.Count, "more": response.More}).Tracef("got %q", key)
 	return getResponse(response), nil
 }
 
-func watchData(doneCtx context.Context, revision int64) {
-	watcher := clientv3.NewWatcher(cli)
-	defer watcher.Close()
+// puts into the cluster the key is routed to (see keyCluster())
+func put(key, value string) error {
+	cluster := keyCluster(key)
+	log.etcd().WithField("cluster", cluster).Tracef("put %q", key)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout())
+	defer cancel()
+	since := time.Now()
+	_, err := cli[cluster].Put(ctx, key, value)
+	dur := time.Since(since)
+	if err != nil {
+		return fmt.Errorf("[dur %s] %s", dur, err)
+	}
+	log.etcd().WithFields(logrus.Fields{"cluster": cluster, "dur": dur}).Tracef("put %q done", key)
+	return nil
+}
+
+// serializes the data updates of the watchers (one per cluster)
+var updateMutex sync.Mutex
+
+// watches the data of the cluster (starting at the given revision) and handles the events until doneCtx is done.
+// the revision is advanced with every event, so a restarted watch continues after the last handled event.
+func watchData(doneCtx context.Context, cluster string, revision int64) {
+	watcher := cli[cluster].Watcher
+	retry := backoff{min: minimumWatchBackoff, max: maximumWatchBackoff}
 WATCH:
 	for {
-		watchCtx := clientv3.WithRequireLeader(doneCtx)
-		watchChan := watcher.Watch(watchCtx, *args.Prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
+		watchCtx, cancelWatch := context.WithCancel(doneCtx)
+		watchChan := watcher.Watch(clientv3.WithRequireLeader(watchCtx), *args.Prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
 	SELECT:
 		for {
 			select {
 			case <-doneCtx.Done():
+				cancelWatch()
 				break WATCH
 			case watchResponse, ok := <-watchChan:
-				if ok {
-					if watchResponse.Canceled {
-						log.etcd().WithError(watchResponse.Err()).Error("watch canceled")
-						break
-					} else {
-						log.etcd().WithFields(logrus.Fields{"compact-rev": watchResponse.CompactRevision, "#events": len(watchResponse.Events), "rev": watchResponse.Header.Revision}).Debug("watch event")
-						for _, ev := range watchResponse.Events {
-							handleEvent(ev)
-						}
+				if !ok {
+					log.etcd().Errorf("watch failed")
+					break SELECT
+				}
+				if watchResponse.CompactRevision != 0 {
+					// the events since the revision are lost, so the data must be loaded completely
+					log.etcd().WithFields(logrus.Fields{"cluster": cluster, "compact-rev": watchResponse.CompactRevision, "rev": revision}).Warn("watch revision compacted, reloading data")
+					updateMutex.Lock()
+					revisions, err := loadData("watch")
+					updateMutex.Unlock()
+					if err != nil {
+						log.etcd().WithError(err).Error("failed to reload data")
+						break SELECT
 					}
-				} else {
-					log.etcd().WithError(watchResponse.Err()).Errorf("watch failed")
+					revision = revisions[cluster] + 1
+					break SELECT
+				}
+				if watchResponse.Canceled {
+					log.etcd().WithError(watchResponse.Err()).Error("watch canceled")
 					break SELECT
 				}
+				log.etcd().WithFields(logrus.Fields{"cluster": cluster, "#events": len(watchResponse.Events), "rev": watchResponse.Header.Revision}).Debug("watch event")
+				updateMutex.Lock()
+				for _, ev := range watchResponse.Events {
+					handleEvent(cluster, ev)
+					revision = maxOf(revision, ev.Kv.ModRevision+1)
+				}
+				advanceDataRevision(cluster, watchResponse.Header.Revision)
+				updateMutex.Unlock()
+				retry.reset()
 			}
 		}
+		cancelWatch()
+		delay := retry.next()
+		log.etcd().Debugf("restarting watch in %s", delay)
+		select {
+		case <-doneCtx.Done():
+			break WATCH
+		case <-time.After(delay):
+		}
 	}
 }
+
+// exponential backoff with jitter: the delay doubles from min up to max, a random part of up to half of it is subtracted
+type backoff struct {
+	min, max, current time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = minOf(2*b.current, b.max)
+	}
+	return b.current - time.Duration(rand.Int63n(int64(b.current/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
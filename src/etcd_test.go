@@ -0,0 +1,442 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// writes a self-signed certificate and its key into dir, returns the file paths
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pdns-etcd3 test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir())
+	setArgs := func(certFile, keyFile, caFile string) {
+		args.CertFile, args.KeyFile, args.CAFile = strPtr(certFile), strPtr(keyFile), strPtr(caFile)
+	}
+	setArgs("", "", "")
+	if cfg, err := tlsConfig(); cfg != nil || err != nil {
+		t.Errorf("no TLS parameters: expected no configuration, got %v (%v)", cfg, err)
+	}
+	setArgs(certFile, keyFile, certFile)
+	cfg, err := tlsConfig()
+	if err != nil {
+		t.Fatalf("failed to create TLS configuration: %s", err)
+	}
+	if len(cfg.Certificates) != 1 || cfg.RootCAs == nil {
+		t.Errorf("unexpected TLS configuration: %#v", cfg)
+	}
+	setArgs("", "", certFile)
+	if cfg, err := tlsConfig(); err != nil || len(cfg.Certificates) != 0 || cfg.RootCAs == nil {
+		t.Errorf("CA only: unexpected TLS configuration %#v (%v)", cfg, err)
+	}
+	for _, spec := range []struct{ certFile, keyFile, caFile, err string }{
+		{certFile, "", "", "key-file is missing"},
+		{"", keyFile, certFile, "cert-file is missing"},
+		{certFile, keyFile, keyFile, ""},
+		{keyFile, certFile, "", ""},
+	} {
+		setArgs(spec.certFile, spec.keyFile, spec.caFile)
+		if _, err := tlsConfig(); err == nil || !strings.Contains(err.Error(), spec.err) {
+			t.Errorf("%+v: expected error with %q, got %v", spec, spec.err, err)
+		}
+	}
+}
+
+func TestClientConfigAuth(t *testing.T) {
+	args = programArgs{
+		ConfigFile:  strPtr(""),
+		Endpoints:   strPtr("[::1]:2379"),
+		DialTimeout: new(time.Duration),
+		Prefix:      strPtr(""),
+		CertFile:    strPtr(""),
+		KeyFile:     strPtr(""),
+		CAFile:      strPtr(""),
+		Username:    strPtr(""),
+		Password:    strPtr(""),
+		LazyLoad:    new(bool),
+	}
+	client := newTestClient()
+	if err := readParameters(objectType[string]{usernameParam: "pdns", passwordParam: "secret"}, client); err != nil {
+		t.Fatalf("failed to read parameters: %s", err)
+	}
+	cfg, logMessages, err := clientConfig()
+	if err != nil {
+		t.Fatalf("failed to create client configuration: %s", err)
+	}
+	if cfg.Username != "pdns" || cfg.Password != "secret" {
+		t.Errorf("unexpected credentials: %q / %q", cfg.Username, cfg.Password)
+	}
+	for _, msg := range logMessages {
+		if strings.Contains(msg, "secret") {
+			t.Errorf("password leaked into log message: %q", msg)
+		}
+	}
+	for _, spec := range []struct{ username, password, err string }{
+		{"", "secret", "etcd-username is missing"},
+		{"pdns", "", "etcd-password is missing"},
+	} {
+		*args.Username, *args.Password = spec.username, spec.password
+		if _, _, err := clientConfig(); err == nil || !strings.Contains(err.Error(), spec.err) {
+			t.Errorf("%+v: expected error with %q, got %v", spec, spec.err, err)
+		}
+	}
+	*args.Username, *args.Password = "", ""
+	if cfg, _, err := clientConfig(); err != nil || cfg.Username != "" || cfg.Password != "" {
+		t.Errorf("no credentials: unexpected configuration %+v (%v)", cfg, err)
+	}
+}
+
+// a KV serving the entries of a range (see clientv3.WithRange()), or else the entry for an existing key or else the
+// entries (full keys) with key as prefix
+type testKV struct {
+	clientv3.KV
+	entries   map[string]string
+	revision  int64
+	revisions map[string]int64 // the entry revisions, if differing from revision
+	keys      chan string
+	deadline  time.Time
+}
+
+func (kv *testKV) Put(_ context.Context, key, value string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	kv.entries[key] = value
+	return &clientv3.PutResponse{Header: &pb.ResponseHeader{Revision: kv.revision}}, nil
+}
+
+func (kv *testKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.keys <- key
+	kv.deadline, _ = ctx.Deadline()
+	response := &clientv3.GetResponse{Header: &pb.ResponseHeader{Revision: kv.revision}}
+	_, exact := kv.entries[key]
+	end := rangeEnd(key, opts...)
+	isRange := end != "" && end != rangeEnd(key, clientv3.WithPrefix())
+	for k, v := range kv.entries {
+		if (isRange && key <= k && k < end) || (!isRange && (k == key || (!exact && strings.HasPrefix(k, key)))) {
+			rev, ok := kv.revisions[k]
+			if !ok {
+				rev = kv.revision
+			}
+			response.Kvs = append(response.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v), CreateRevision: 1, ModRevision: rev})
+		}
+	}
+	response.Count = int64(len(response.Kvs))
+	return response, nil
+}
+
+type testWatcher struct {
+	clientv3.Watcher
+	keys      chan string
+	responses chan clientv3.WatchResponse
+}
+
+func (w *testWatcher) Watch(ctx context.Context, key string, _ ...clientv3.OpOption) clientv3.WatchChan {
+	w.keys <- key
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case response := <-w.responses:
+				ch <- response
+				if response.Canceled {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// sets up the global client with a test KV and watcher (without namespace)
+func newTestETCD(prefix string, entries map[string]string) (*testKV, *testWatcher) {
+	namespace := ""
+	args.Namespace, args.Prefix, args.DialTimeout, args.ReqTimeout, args.LazyLoad = &namespace, &prefix, new(time.Duration), new(time.Duration), new(bool)
+	args.LookupCache, args.StartTimeout = new(int), new(time.Duration)
+	*args.DialTimeout = time.Second
+	kv := &testKV{entries: entries, revision: 1, keys: make(chan string, 100)}
+	watcher := &testWatcher{keys: make(chan string, 10), responses: make(chan clientv3.WatchResponse)}
+	cli, clusters = map[string]*clientv3.Client{"": {KV: kv, Watcher: watcher}}, []string{""}
+	return kv, watcher
+}
+
+// waits (a limited time) until the lookup of qname/qtype returns exactly one record with the given content
+func waitForContent(t *testing.T, qname, qtype, content string) {
+	for i := 0; ; i++ {
+		result, err := lookup(objectType[any]{"qname": qname, "qtype": qtype}, newTestClient())
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		if items, ok := result.([]objectType[any]); ok && len(items) == 1 && items[0]["content"] == content {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("expected %s %s %q, got %v", qname, qtype, content, result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	namespace, prefix := "cluster-a/", "dns/"
+	kv, watcher := newTestETCD(prefix, map[string]string{
+		"cluster-a/dns/-defaults-":      `{"ttl": 3600}`,
+		"cluster-a/dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"cluster-b/dns/net.example/A":   `192.0.2.99`,
+	})
+	args.Namespace = &namespace
+	logMessages := applyNamespace(cli[""])
+	if _, ok := cli[""].KV.(*namespaceKV); !ok {
+		t.Errorf("KV is not wrapped: %T", cli[""].KV)
+	}
+	if _, ok := cli[""].Watcher.(*namespaceWatcher); !ok {
+		t.Errorf("Watcher is not wrapped: %T", cli[""].Watcher)
+	}
+	if len(logMessages) != 1 || !strings.Contains(logMessages[0], namespace) {
+		t.Errorf("unexpected log messages: %v", logMessages)
+	}
+	response, err := get(prefix, true, nil)
+	if err != nil {
+		t.Fatalf("get() failed: %s", err)
+	}
+	if key := <-kv.keys; key != namespace+prefix {
+		t.Errorf("get: expected key %q, got %q", namespace+prefix, key)
+	}
+	for item := range response.DataChan {
+		if !strings.HasPrefix(item.Key, prefix) {
+			t.Errorf("get: namespace not stripped from key %q", item.Key)
+		}
+	}
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-kv.keys
+	if key := <-watcher.keys; key != namespace+prefix {
+		t.Errorf("watch: expected key %q, got %q", namespace+prefix, key)
+	}
+	kv.entries["cluster-a/dns/net.example/A"] = `192.0.2.1`
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("cluster-a/dns/net.example/A"), Value: []byte(`192.0.2.1`), CreateRevision: 2, ModRevision: 2},
+	}}}
+	waitForContent(t, "example.net.", "A", "192.0.2.1")
+}
+
+func TestWatchCompaction(t *testing.T) {
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/A":   `192.0.2.1`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-kv.keys
+	<-watcher.keys
+	waitForContent(t, "example.net.", "A", "192.0.2.1")
+	// the data changed while the events were compacted away
+	kv.entries["dns/net.example/A"] = `192.0.2.2`
+	kv.revision = 10
+	watcher.responses <- clientv3.WatchResponse{CompactRevision: 5, Canceled: true}
+	if key := <-kv.keys; key != "dns/" {
+		t.Errorf("expected a full reload (key %q), got key %q", "dns/", key)
+	}
+	if key := <-watcher.keys; key != "dns/" {
+		t.Errorf("expected the watch to be restarted (key %q), got key %q", "dns/", key)
+	}
+	waitForContent(t, "example.net.", "A", "192.0.2.2")
+	// the restarted watch handles events again
+	kv.entries["dns/net.example/A"] = `192.0.2.3`
+	kv.revision = 11
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net.example/A"), Value: []byte(`192.0.2.3`), CreateRevision: 1, ModRevision: 11},
+	}}}
+	waitForContent(t, "example.net.", "A", "192.0.2.3")
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: 100 * time.Millisecond, max: time.Second}
+	for i, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		expected *= time.Millisecond
+		delay := b.next()
+		if delay < expected/2 || delay > expected {
+			t.Errorf("step %d: expected delay in [%s, %s], got %s", i, expected/2, expected, delay)
+		}
+	}
+	b.reset()
+	if delay := b.next(); delay < 50*time.Millisecond || delay > 100*time.Millisecond {
+		t.Errorf("after reset: expected delay in [50ms, 100ms], got %s", delay)
+	}
+}
+
+func TestWatchRestart(t *testing.T) {
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":      `{"ttl": 3600}`,
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/A":   `192.0.2.1`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-kv.keys
+	<-watcher.keys
+	// the watch fails (f.e. the cluster is down), it must be restarted after a delay
+	since := time.Now()
+	watcher.responses <- clientv3.WatchResponse{Canceled: true}
+	<-watcher.keys
+	if dur := time.Since(since); dur < minimumWatchBackoff/2 {
+		t.Errorf("watch restarted too early (after %s)", dur)
+	}
+	kv.entries["dns/net.example/A"] = `192.0.2.2`
+	kv.revision = 2
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net.example/A"), Value: []byte(`192.0.2.2`), CreateRevision: 1, ModRevision: 2},
+	}}}
+	waitForContent(t, "example.net.", "A", "192.0.2.2")
+	// cancellation is responsive while waiting for the restart
+	watcher.responses <- clientv3.WatchResponse{Canceled: true}
+	cancel()
+	select {
+	case key := <-watcher.keys:
+		t.Errorf("watch restarted after cancellation (key %q)", key)
+	case <-time.After(2 * minimumWatchBackoff):
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	kv, _ := newTestETCD("", map[string]string{})
+	get := func() time.Duration {
+		since := time.Now()
+		if _, err := get("", true, nil); err != nil {
+			t.Fatalf("get() failed: %s", err)
+		}
+		<-kv.keys
+		return kv.deadline.Sub(since)
+	}
+	if timeout := get(); timeout < time.Second || timeout > time.Second+100*time.Millisecond {
+		t.Errorf("unset request timeout: expected the dial timeout (1s), got %s", timeout)
+	}
+	if err := readParameters(objectType[string]{reqTimeoutParam: "1ms"}, newTestClient()); err == nil {
+		t.Errorf("expected an error for a request timeout below the minimum")
+	}
+	if err := readParameters(objectType[string]{reqTimeoutParam: "5m"}, newTestClient()); err != nil {
+		t.Fatalf("failed to read parameters: %s", err)
+	}
+	if timeout := get(); timeout < 5*time.Minute || timeout > 5*time.Minute+100*time.Millisecond {
+		t.Errorf("expected the request timeout (5m), got %s", timeout)
+	}
+}
+
+func TestStartupTimeout(t *testing.T) {
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/net.example/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1, "ttl": 3600}`,
+	})
+	load := func() time.Duration {
+		since := time.Now()
+		if _, err := loadData("test"); err != nil {
+			t.Fatalf("loadData() failed: %s", err)
+		}
+		drainKeys(kv)
+		return kv.deadline.Sub(since)
+	}
+	if timeout := load(); timeout < defaultStartTimeout || timeout > defaultStartTimeout+100*time.Millisecond {
+		t.Errorf("unset startup timeout: expected the minimum (%s), got %s", defaultStartTimeout, timeout)
+	}
+	if err := readParameters(objectType[string]{startTimeoutParam: "1ms"}, newTestClient()); err == nil {
+		t.Errorf("expected an error for a startup timeout below the minimum")
+	}
+	if err := readParameters(objectType[string]{dialTimeoutParam: "30s"}, newTestClient()); err != nil {
+		t.Fatalf("failed to read parameters: %s", err)
+	}
+	if timeout := load(); timeout < 30*time.Second || timeout > 30*time.Second+100*time.Millisecond {
+		t.Errorf("unset startup timeout: expected the dial timeout (30s), got %s", timeout)
+	}
+	if err := readParameters(objectType[string]{startTimeoutParam: "5m"}, newTestClient()); err != nil {
+		t.Fatalf("failed to read parameters: %s", err)
+	}
+	if timeout := load(); timeout < 5*time.Minute || timeout > 5*time.Minute+100*time.Millisecond {
+		t.Errorf("expected the startup timeout (5m), got %s", timeout)
+	}
+	// the other requests still use the request timeout (the dial timeout)
+	since := time.Now()
+	if _, err := get("dns/", true, nil); err != nil {
+		t.Fatalf("get() failed: %s", err)
+	}
+	<-kv.keys
+	if timeout := kv.deadline.Sub(since); timeout < 30*time.Second || timeout > 30*time.Second+100*time.Millisecond {
+		t.Errorf("expected the request timeout (30s), got %s", timeout)
+	}
+}
@@ -0,0 +1,83 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import "fmt"
+
+// the resolution trace of a record entry, collected while processing it by explain()
+type explanation struct {
+	steps  []string
+	record *recordType // the resulting record, nil if the entry was ignored
+}
+
+func (p *rrParams) explain(format string, args ...any) {
+	if p.explanation != nil {
+		p.explanation.steps = append(p.explanation.steps, fmt.Sprintf(format, args...))
+	}
+}
+
+// the non-standard request method "explain" (enabled by parameter explain): processes the record entries of qname and
+// qtype (or all QTYPEs for ANY) again without storing them, returns the resulting record (like lookup, without ordering)
+// and the resolution trace of each entry (where the fields and the TTL came from, zone appending)
+func explain(params objectType[any], client *pdnsClient) (interface{}, error) {
+	if args.Explain == nil || !*args.Explain {
+		return false, fmt.Errorf("request method explain is not enabled (parameter %s)", explainParam)
+	}
+	qname, ok := params["qname"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing qname")
+	}
+	qtype, ok := params["qtype"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing qtype")
+	}
+	name := parseName(qname)
+	ensureLoaded(name) // lazy mode: ETCD calls
+	data := dataRoot.Load().getChild(name, true)
+	defer data.rUnlockUpwards(nil)
+	if data.depth() < name.len() {
+		client.log.data().Debugf("no such domain: %q", name.normal())
+		return false, nil
+	}
+	qtypes := []string{qtype}
+	if qtype == "ANY" {
+		qtypes = sortedKeys(data.values)
+	}
+	result := []objectType[any]{}
+	for _, qtype := range qtypes {
+		for _, id := range sortedKeys(data.values[qtype]) {
+			values := data.values[qtype][id]
+			exp := &explanation{}
+			rrParams := rrParams{
+				qtype:       qtype,
+				id:          id,
+				version:     values.version,
+				data:        data,
+				explanation: exp,
+			}
+			processValuesEntry(&rrParams, &values)
+			item := objectType[any]{"qname": data.getQname(), "qtype": qtype}
+			if exp.record != nil {
+				item = makeResultItem(qtype, data, exp.record, client)
+				item["disabled"] = exp.record.disabled
+			}
+			item["id"] = id
+			item["entry"] = values.key
+			item["trace"] = exp.steps
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,68 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	newTestETCD("dns/", nil)
+	args.Explain = new(bool)
+	defer func() { args.Explain = nil }()
+	items := make(chan etcdItem, 4)
+	items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+	items <- etcdItem{"dns/net.example/SOA", []byte(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`), 1}
+	items <- etcdItem{"dns/net.example/-defaults-/MX", []byte(`{"priority": 10}`), 1}
+	items <- etcdItem{"dns/net.example/MX", []byte(`{"target": "mail"}`), 1}
+	close(items)
+	dataRoot.Store(newDataNode(nil, "", ""))
+	dataRoot.Load().reload(items)
+	query := objectType[any]{"qname": "example.net.", "qtype": "MX"}
+	if _, err := explain(query, newTestClient()); err == nil {
+		t.Errorf("expected an error when explain is not enabled")
+	}
+	*args.Explain = true
+	result, err := explain(query, newTestClient())
+	if err != nil {
+		t.Fatalf("explain failed: %s", err)
+	}
+	explained, ok := result.([]objectType[any])
+	if !ok || len(explained) != 1 {
+		t.Fatalf("expected one explained entry, got %v", result)
+	}
+	item := explained[0]
+	if item["content"] != "10 mail.example.net." || item["entry"] != "dns/net.example/MX" {
+		t.Errorf("unexpected explained entry: %v", item)
+	}
+	trace := strings.Join(item["trace"].([]string), "\n")
+	for _, step := range []string{
+		"ttl 1h0m0s from ./#",
+		"field priority = 10 from the defaults of example.net./MX#",
+		"field target = mail from the entry",
+		`appended zone "example.net.": "mail.example.net."`,
+	} {
+		if !strings.Contains(trace, step) {
+			t.Errorf("expected the step %q in the trace:\n%s", step, trace)
+		}
+	}
+	if records := dataRoot.Load().getChild(parseName("example.net."), false).records["MX"]; len(records) != 1 {
+		t.Errorf("expected the stored record to be unchanged, got %v", records)
+	}
+	if result, err := explain(objectType[any]{"qname": "nx.example.net.", "qtype": "A"}, newTestClient()); err != nil || result != false {
+		t.Errorf("expected false for a missing name, got %v (%v)", result, err)
+	}
+}
@@ -0,0 +1,96 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// connects to ETCD, loads the data and writes the zone as zonefile (see writeZonefile()) to w
+func exportZone(w io.Writer, zonename string) error {
+	if err := loadDataOnce("export"); err != nil {
+		return err
+	}
+	defer closeClient()
+	name := parseName(zonename)
+	zone := dataRoot.Load().getChild(name, false)
+	if zone.depth() < name.len() || !zone.hasSOA() {
+		return fmt.Errorf("no such zone: %q", name.normal())
+	}
+	return writeZonefile(w, zone)
+}
+
+// the owner name relative to the origin ("@" for the origin itself), absolute if not below the origin
+func ownerName(qname, origin string) string {
+	if qname == origin {
+		return "@"
+	}
+	if origin == "." {
+		return strings.TrimSuffix(qname, ".")
+	}
+	if relative, ok := strings.CutSuffix(qname, "."+origin); ok {
+		return relative
+	}
+	return qname
+}
+
+// writes the records of the zone (without nested zones) as zonefile (RFC 1035), with the zone as $ORIGIN
+// and the TTL of the SOA record as $TTL. the SOA record comes first, disabled records are commented out.
+func writeZonefile(w io.Writer, zone *dataNode) error {
+	origin := zone.getQname()
+	soa, ok := zone.records["SOA"][""]
+	if !ok {
+		return fmt.Errorf("no SOA record in %q", origin)
+	}
+	if _, err := fmt.Fprintf(w, "$ORIGIN %s\n$TTL %d\n", origin, seconds(soa.ttl)); err != nil {
+		return err
+	}
+	var writeNode func(dn *dataNode) error
+	writeNode = func(dn *dataNode) error {
+		owner := ownerName(dn.getQname(), origin)
+		qtypes := sortedKeys(dn.records)
+		if dn == zone {
+			qtypes = append([]string{"SOA"}, Filter(qtypes, func(qtype string) bool { return qtype != "SOA" })...)
+		}
+		for _, qtype := range qtypes {
+			records := dn.records[qtype]
+			for _, id := range sortedKeys(records) {
+				record := records[id]
+				content := recordContent(&record, defaultPdnsVersion)
+				if (qtype == "TXT" || qtype == "SPF") && !strings.HasPrefix(content, `"`) {
+					content = quote(content)
+				}
+				line := fmt.Sprintf("%s\t%d\tIN\t%s\t%s\n", owner, seconds(record.ttl), qtype, content)
+				if record.disabled {
+					line = ";" + line
+				}
+				if _, err := io.WriteString(w, line); err != nil {
+					return err
+				}
+			}
+		}
+		for _, lname := range sortedKeys(dn.children) {
+			if child := dn.children[lname]; !child.hasSOA() { // nested zones are separate zones
+				if err := writeNode(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return writeNode(zone)
+}
@@ -0,0 +1,70 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteZonefile(t *testing.T) {
+	zone := newTestZone("example.net.")
+	for _, entry := range []struct{ name, qtype, id, content string }{
+		{"", "SOA", "", `{"primary": "ns1", "mail": "hostmaster", "refresh": "1h", "retry": "30m", "expire": 604800, "neg-ttl": "10m", "ttl": "2h"}`},
+		{"", "NS", "1", `="ns1"`},
+		{"", "NS", "2", `="ns2.example.org."`},
+		{"", "MX", "", `{"priority": 10, "target": "mail"}`},
+		{"www", "A", "", `192.0.2.1`},
+		{"www", "TXT", "", `{"text": "hello \"world\"", "ttl": 60}`},
+		{"_sip._tcp", "SRV", "", `{"priority": 10, "weight": 20, "port": 5060, "target": "sip"}`},
+		{"staging", "A", "", `{"ip": "192.0.2.9", "disabled": true}`},
+		{"sub", "SOA", "", `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`},
+		{"www.sub", "A", "", `192.0.2.2`},
+	} {
+		if _, err := storeTestEntry(zone.getChildCreate(testName(entry.name)), entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s/%s#%s: %s", entry.name, entry.qtype, entry.id, err)
+		}
+	}
+	buffer := strings.Builder{}
+	if err := writeZonefile(&buffer, zone); err != nil {
+		t.Fatalf("writeZonefile() failed: %s", err)
+	}
+	expected := `$ORIGIN example.net.
+$TTL 7200
+@	7200	IN	SOA	ns1.example.net. hostmaster.example.net. 0 3600 1800 604800 600
+@	3600	IN	MX	10 mail.example.net.
+@	3600	IN	NS	ns1.example.net.
+@	3600	IN	NS	ns2.example.org.
+_sip._tcp	3600	IN	SRV	10 20 5060 sip.example.net.
+;staging	3600	IN	A	192.0.2.9
+www	3600	IN	A	192.0.2.1
+www	60	IN	TXT	"hello \"world\""
+`
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+	for qname, expected := range map[string]string{
+		"example.net.":     "@",
+		"www.example.net.": "www",
+		"www.example.org.": "www.example.org.",
+	} {
+		if actual := ownerName(qname, "example.net."); actual != expected {
+			t.Errorf("%s: expected %q, got %q", qname, expected, actual)
+		}
+	}
+	if actual := ownerName("www.example.net.", "."); actual != "www.example.net" {
+		t.Errorf("expected a relative name below the root, got %q", actual)
+	}
+}
@@ -0,0 +1,456 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// a record of a zonefile, with absolute (lowercase) name
+type zoneRecord struct {
+	name   string
+	ttl    int64 // seconds
+	qtype  string
+	rdata  []string // the fields, quoted strings are unquoted and unescaped, the others are kept as given (with escapes)
+	quoted []bool   // whether the field was quoted
+}
+
+var zoneClasses = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// the names of the domain name field of the object-supported QTYPEs having only that field
+var domainNameFields = map[string]string{
+	"NS":    "hostname",
+	"CNAME": "target",
+	"DNAME": "name",
+	"PTR":   "hostname",
+}
+
+// parses a TTL of a zonefile, in seconds or with units (f.e. 1h30m, 1w)
+func parseZoneTTL(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	units := map[rune]int64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var ttl, n int64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + int64(c-'0')
+			digits = true
+		} else if unit, ok := units[c]; ok && digits {
+			ttl += n * unit
+			n, digits = 0, false
+		} else {
+			return 0, fmt.Errorf("invalid TTL: %q", s)
+		}
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid TTL (missing unit): %q", s)
+	}
+	return ttl, nil
+}
+
+// splits a zonefile line into fields, handling quoted strings (unquoted and unescaped, with quoted=true) and comments.
+// the escapes of the other fields are kept, because their meaning depends on the field (f.e. the dot of a SOA mailbox).
+func splitZoneLine(line string) (fields []string, quoted []bool, openParens int, err error) {
+	var field strings.Builder
+	inField, inQuotes, escaped := false, false, false
+	flush := func(wasQuoted bool) {
+		if inField {
+			if wasQuoted {
+				fields = append(fields, unescapeLabel(field.String()))
+			} else {
+				fields = append(fields, field.String())
+			}
+			quoted = append(quoted, wasQuoted)
+			field.Reset()
+			inField = false
+		}
+	}
+	for _, c := range line {
+		switch {
+		case escaped:
+			field.WriteRune('\\')
+			field.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+			inField = true
+		case inQuotes:
+			if c == '"' {
+				inQuotes = false
+				flush(true)
+			} else {
+				field.WriteRune(c)
+			}
+		case c == '"':
+			flush(false)
+			inQuotes = true
+			inField = true
+		case c == ';':
+			flush(false)
+			return
+		case c == '(' || c == ')':
+			flush(false)
+			if c == '(' {
+				openParens++
+			} else {
+				openParens--
+			}
+		case unicode.IsSpace(c):
+			flush(false)
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if inQuotes {
+		return nil, nil, 0, fmt.Errorf("unterminated quoted string")
+	}
+	flush(false)
+	return
+}
+
+// whether the name (in presentation form) ends with an unescaped dot
+func isAbsoluteName(name string) bool {
+	backslashes := 0
+	for i := len(name) - 2; i >= 0 && name[i] == '\\'; i-- {
+		backslashes++
+	}
+	return strings.HasSuffix(name, ".") && backslashes%2 == 0
+}
+
+// parses the zonefile (RFC 1035, with the directives $ORIGIN and $TTL). origin is used until a $ORIGIN directive, it may be empty.
+func parseZonefile(r io.Reader, origin string) ([]zoneRecord, error) {
+	var records []zoneRecord
+	var defaultTTL *int64
+	var lastName string
+	absolute := func(name string) (string, error) {
+		name = foldCase(name)
+		switch {
+		case name == "@":
+			name = origin
+		case isAbsoluteName(name):
+			return name, nil
+		case origin == ".":
+			name += "."
+		case origin != "":
+			name += "." + origin
+		}
+		if name == "" {
+			return "", fmt.Errorf("relative name without origin")
+		}
+		return name, nil
+	}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		fields, quoted, parens, err := splitZoneLine(line)
+		// continue lines in parentheses
+		for err == nil && parens > 0 && scanner.Scan() {
+			lineNo++
+			moreFields, moreQuoted, moreParens, moreErr := splitZoneLine(scanner.Text())
+			fields, quoted, parens, err = append(fields, moreFields...), append(quoted, moreQuoted...), parens+moreParens, moreErr
+		}
+		if err == nil && parens != 0 {
+			err = fmt.Errorf("unbalanced parentheses")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "$") {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: missing value for %s", lineNo, fields[0])
+			}
+			switch strings.ToUpper(fields[0]) {
+			case "$ORIGIN":
+				if origin, err = absolute(fields[1]); err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNo, err)
+				}
+			case "$TTL":
+				ttl, err := parseZoneTTL(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNo, err)
+				}
+				defaultTTL = &ttl
+			default:
+				return nil, fmt.Errorf("line %d: unsupported directive %s", lineNo, fields[0])
+			}
+			continue
+		}
+		record := zoneRecord{}
+		if unicode.IsSpace(rune(line[0])) { // the name of the previous record
+			if lastName == "" {
+				return nil, fmt.Errorf("line %d: missing name", lineNo)
+			}
+			record.name = lastName
+		} else {
+			if record.name, err = absolute(fields[0]); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			fields, quoted = fields[1:], quoted[1:]
+		}
+		lastName = record.name
+		var ttl *int64
+		for len(fields) > 0 && record.qtype == "" {
+			field := fields[0]
+			if zoneClasses[strings.ToUpper(field)] {
+				if strings.ToUpper(field) != "IN" {
+					return nil, fmt.Errorf("line %d: unsupported class %s", lineNo, field)
+				}
+			} else if value, err := parseZoneTTL(field); err == nil && ttl == nil {
+				ttl = &value
+			} else {
+				record.qtype = strings.ToUpper(field)
+			}
+			fields, quoted = fields[1:], quoted[1:]
+		}
+		if record.qtype == "" || !qtypeRegex.MatchString(record.qtype) {
+			return nil, fmt.Errorf("line %d: missing or invalid type", lineNo)
+		}
+		switch {
+		case ttl != nil:
+			record.ttl = *ttl
+		case defaultTTL != nil:
+			record.ttl = *defaultTTL
+		case record.qtype == "SOA" && len(fields) == 7:
+			// RFC 2308: the negative TTL is the default (if $TTL is missing)
+			if record.ttl, err = parseZoneTTL(fields[6]); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+			defaultTTL = &record.ttl
+		default:
+			return nil, fmt.Errorf("line %d: missing TTL", lineNo)
+		}
+		// domain names in the fields of the converted records must be absolute
+		domainFields := map[string][]int{"SOA": {0, 1}, "MX": {1}, "SRV": {3}, "NS": {0}, "CNAME": {0}, "DNAME": {0}, "PTR": {0}}[record.qtype]
+		for _, i := range domainFields {
+			if i < len(fields) && !quoted[i] {
+				if fields[i], err = absolute(fields[i]); err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNo, err)
+				}
+			}
+		}
+		record.rdata, record.quoted = fields, quoted
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// the e-mail address of the mailbox domain name of a SOA record (the first unescaped dot is the @)
+func soaMail(mailbox string) string {
+	for i := 0; i < len(mailbox); i++ {
+		switch mailbox[i] {
+		case '\\':
+			i++
+		case '.':
+			return strings.ReplaceAll(mailbox[:i], `\.`, ".") + "@" + mailbox[i+1:]
+		}
+	}
+	return mailbox
+}
+
+// converts the record to the entry value (the object form, if supported, or the plain content otherwise)
+func zoneRecordValue(record *zoneRecord) (interface{}, error) {
+	rdata := record.rdata
+	// the character string of the field, the escapes of unquoted fields are resolved
+	text := func(i int) string {
+		if record.quoted[i] {
+			return rdata[i]
+		}
+		return unescapeLabel(rdata[i])
+	}
+	wantFields := func(n int) error {
+		if len(rdata) != n {
+			return fmt.Errorf("%s %s: expected %d fields, got %d", record.name, record.qtype, n, len(rdata))
+		}
+		return nil
+	}
+	uints := func(fields ...int) ([]uint64, error) {
+		var values []uint64
+		for _, i := range fields {
+			value, err := strconv.ParseUint(rdata[i], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: invalid number %q", record.name, record.qtype, rdata[i])
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+	object := objectType[any]{"ttl": record.ttl}
+	switch record.qtype {
+	case "A", "AAAA":
+		if err := wantFields(1); err != nil {
+			return nil, err
+		}
+		object["ip"] = rdata[0]
+	case "SOA":
+		if err := wantFields(7); err != nil {
+			return nil, err
+		}
+		timers := []string{"refresh", "retry", "expire", "neg-ttl"}
+		for i, timer := range timers {
+			value, err := parseZoneTTL(rdata[3+i])
+			if err != nil {
+				return nil, fmt.Errorf("%s SOA: %s: %s", record.name, timer, err)
+			}
+			object[timer] = value
+		}
+		// the serial is not stored, it is generated from the revision of the data
+		object["primary"] = rdata[0]
+		object["mail"] = soaMail(rdata[1])
+	case "MX":
+		if err := wantFields(2); err != nil {
+			return nil, err
+		}
+		values, err := uints(0)
+		if err != nil {
+			return nil, err
+		}
+		object["priority"], object["target"] = values[0], rdata[1]
+	case "SRV":
+		if err := wantFields(4); err != nil {
+			return nil, err
+		}
+		values, err := uints(0, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		object["priority"], object["weight"], object["port"], object["target"] = values[0], values[1], values[2], rdata[3]
+	case "TXT", "SPF":
+		texts := make([]string, len(rdata))
+		for i := range rdata {
+			texts[i] = text(i)
+		}
+		object["text"] = strings.Join(texts, "")
+	case "HINFO":
+		if err := wantFields(2); err != nil {
+			return nil, err
+		}
+		object["cpu"], object["os"] = text(0), text(1)
+	default:
+		if field, ok := domainNameFields[record.qtype]; ok {
+			if err := wantFields(1); err != nil {
+				return nil, err
+			}
+			object[field] = rdata[0]
+		} else {
+			fields := make([]string, len(rdata))
+			for i, field := range rdata {
+				if record.quoted[i] {
+					field = quote(field)
+				}
+				fields[i] = field
+			}
+			return strings.Join(fields, " "), nil
+		}
+	}
+	return object, nil
+}
+
+// converts the records to ETCD entries (key without the prefix → value). records of the same name and QTYPE get the
+// ids 1, 2, …; the TTL of plain content records (without object support) is stored as a default of the QTYPE.
+func zoneRecordEntries(records []zoneRecord) (map[string]string, error) {
+	counts := map[string]int{}
+	for _, record := range records {
+		counts[record.name+"/"+record.qtype]++
+	}
+	entries := map[string]string{}
+	ids := map[string]int{}
+	for _, record := range records {
+		// the labels are escaped for the keys instead of the presentation form
+		labels := Map(splitDomainName(record.name, "."), func(label string, _ int) string {
+			return escapeLabel(unescapeLabel(label), keySpecialChars())
+		})
+		nameKey := strings.Join(reversed(labels), keySeparator)
+		if nameKey != "" {
+			nameKey += keySeparator
+		}
+		key := nameKey + record.qtype
+		if counts[record.name+"/"+record.qtype] > 1 {
+			ids[key]++
+			key += idSeparator + strconv.Itoa(ids[key])
+		}
+		value, err := zoneRecordValue(&record)
+		if err != nil {
+			return nil, err
+		}
+		switch value := value.(type) {
+		case string:
+			entries[key] = value
+			ttl, _ := json.Marshal(objectType[any]{"ttl": record.ttl})
+			entries[nameKey+defaultsKey+keySeparator+record.qtype] = string(ttl)
+		default:
+			valueJSON, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = string(valueJSON)
+		}
+	}
+	return entries, nil
+}
+
+// reads the zonefile and writes its records to ETCD (below the prefix), or prints the entries to w (dryRun)
+func importZonefile(path string, dryRun bool, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	records, err := parseZonefile(file, "")
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	entries, err := zoneRecordEntries(records)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		if _, err := setupClient(); err != nil {
+			return fmt.Errorf("setupClient() failed: %s", err)
+		}
+		defer closeClient()
+		if err := loadClusterRoutes(); err != nil {
+			return fmt.Errorf("failed to read the cluster routes: %s", err)
+		}
+	}
+	for _, key := range sortedKeys(entries) {
+		if dryRun {
+			if _, err := fmt.Fprintf(w, "%s%s → %s\n", *args.Prefix, key, entries[key]); err != nil {
+				return err
+			}
+		} else if err := put(*args.Prefix+key, entries[key]); err != nil {
+			return fmt.Errorf("failed to put %q: %s", *args.Prefix+key, err)
+		}
+	}
+	log.main().Infof("imported %d records from %s (%d entries)", len(records), path, len(entries))
+	return nil
+}
@@ -0,0 +1,158 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testZonefile = `$ORIGIN example.net.
+$TTL 1h
+@	IN	SOA	ns1 hostmaster.example.net. (
+		2024010101 ; serial
+		1h 30m 1w 600 )
+	86400	NS	ns1
+	IN 86400 NS	ns2.example.org.
+@		MX	10 mail
+		MX	20 mail.example.org.
+www	60	A	192.0.2.1 ; comment
+www.example.net.	TXT	"hello \"world\"" " and more; no comment"
+foo		CAA	0 issue "ca.example.org"
+`
+
+func TestImportZonefile(t *testing.T) {
+	records, err := parseZonefile(strings.NewReader(testZonefile), "")
+	if err != nil {
+		t.Fatalf("parseZonefile() failed: %s", err)
+	}
+	entries, err := zoneRecordEntries(records)
+	if err != nil {
+		t.Fatalf("zoneRecordEntries() failed: %s", err)
+	}
+	expected := map[string]string{
+		"net/example/SOA":                `{"expire":604800,"mail":"hostmaster@example.net.","neg-ttl":600,"primary":"ns1.example.net.","refresh":3600,"retry":1800,"ttl":3600}`,
+		"net/example/NS#1":               `{"hostname":"ns1.example.net.","ttl":86400}`,
+		"net/example/NS#2":               `{"hostname":"ns2.example.org.","ttl":86400}`,
+		"net/example/MX#1":               `{"priority":10,"target":"mail.example.net.","ttl":3600}`,
+		"net/example/MX#2":               `{"priority":20,"target":"mail.example.org.","ttl":3600}`,
+		"net/example/www/A":              `{"ip":"192.0.2.1","ttl":60}`,
+		"net/example/www/TXT":            `{"text":"hello \"world\" and more; no comment","ttl":3600}`,
+		"net/example/foo/CAA":            `0 issue "ca.example.org"`,
+		"net/example/foo/-defaults-/CAA": `{"ttl":3600}`,
+	}
+	if !equal(sortedKeys(entries), sortedKeys(expected)) {
+		t.Errorf("expected keys %v, got %v", sortedKeys(expected), sortedKeys(entries))
+	}
+	for key, value := range expected {
+		if entries[key] != value {
+			t.Errorf("%s: expected %s, got %s", key, value, entries[key])
+		}
+	}
+	// the entries must be valid
+	zone := newTestZone("example.net.")
+	if rec, err := storeTestEntry(zone, "SOA", "", entries["net/example/SOA"]); err != nil {
+		t.Errorf("failed to store SOA: %s", err)
+	} else if rec.content != "ns1.example.net. hostmaster.example.net. 0 3600 1800 604800 600" {
+		t.Errorf("unexpected SOA content: %q", rec.content)
+	}
+	for _, spec := range []struct{ key, qtype, content string }{
+		{"net/example/MX#1", "MX", "10 mail.example.net."},
+		{"net/example/www/TXT", "TXT", `hello "world" and more; no comment`},
+	} {
+		if rec, err := storeTestEntry(zone, spec.qtype, "", entries[spec.key]); err != nil {
+			t.Errorf("%s: failed to store: %s", spec.key, err)
+		} else if content := recordContent(rec, defaultPdnsVersion); content != spec.content {
+			t.Errorf("%s: expected %q, got %q", spec.key, spec.content, content)
+		}
+	}
+	for _, zonefile := range []string{
+		"www A 192.0.2.1\n",                          // relative name without origin
+		"www.example.net. A 192.0.2.1\n",             // missing TTL
+		"www.example.net. 60 CH A 192.0.2.1\n",       // unsupported class
+		"www.example.net. 60 TXT \"unterminated\n",   // unterminated quoted string
+		"example.net. 60 SOA ns1. hm. ( 1 2 3 4 5\n", // unbalanced parentheses
+		"example.net. 60 MX ten mail.example.net.\n", // invalid number
+	} {
+		records, err := parseZonefile(strings.NewReader(zonefile), "")
+		if err == nil {
+			_, err = zoneRecordEntries(records)
+		}
+		if err == nil {
+			t.Errorf("%q: expected an error", zonefile)
+		}
+	}
+}
+
+func TestImportZonefileEscapes(t *testing.T) {
+	zonefile := `$ORIGIN example.com.
+$TTL 1h
+@	SOA	ns1 hostmaster\.ops.example.com. 1 2 3 4 5
+a\.b	A	192.0.2.1
+txt	TXT	a\032b "c\"d"
+host	HINFO	"Intel x86" Linux
+naptr	NAPTR	100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .
+`
+	records, err := parseZonefile(strings.NewReader(zonefile), "")
+	if err != nil {
+		t.Fatalf("parseZonefile() failed: %s", err)
+	}
+	entries, err := zoneRecordEntries(records)
+	if err != nil {
+		t.Fatalf("zoneRecordEntries() failed: %s", err)
+	}
+	expected := map[string]string{
+		"com/example/SOA":                    `{"expire":4,"mail":"hostmaster.ops@example.com.","neg-ttl":5,"primary":"ns1.example.com.","refresh":2,"retry":3,"ttl":3600}`,
+		`com/example/a\.b/A`:                 `{"ip":"192.0.2.1","ttl":3600}`,
+		"com/example/txt/TXT":                `{"text":"a bc\"d","ttl":3600}`,
+		"com/example/host/HINFO":             `{"cpu":"Intel x86","os":"Linux","ttl":3600}`,
+		"com/example/naptr/NAPTR":            `100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`,
+		"com/example/naptr/-defaults-/NAPTR": `{"ttl":3600}`,
+	}
+	if !equal(sortedKeys(entries), sortedKeys(expected)) {
+		t.Errorf("expected keys %v, got %v", sortedKeys(expected), sortedKeys(entries))
+	}
+	for key, value := range expected {
+		if entries[key] != value {
+			t.Errorf("%s: expected %s, got %s", key, value, entries[key])
+		}
+	}
+	// the escaped dot of the mailbox is restored in the SOA record
+	zone := newTestZone("example.com.")
+	if rec, err := storeTestEntry(zone, "SOA", "", entries["com/example/SOA"]); err != nil {
+		t.Errorf("failed to store SOA: %s", err)
+	} else if !strings.HasPrefix(rec.content, `ns1.example.com. hostmaster\.ops.example.com. `) {
+		t.Errorf("unexpected SOA content: %q", rec.content)
+	}
+}
+
+func TestImportZonefileDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zonefile")
+	if err := os.WriteFile(path, []byte("$ORIGIN example.net.\nwww 60 A 192.0.2.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(prefix *string) { args.Prefix = prefix }(args.Prefix)
+	prefix := "/DNS/"
+	args.Prefix = &prefix
+	buffer := strings.Builder{}
+	if err := importZonefile(path, true, &buffer); err != nil {
+		t.Fatalf("importZonefile() failed: %s", err)
+	}
+	if expected := "/DNS/net/example/www/A → {\"ip\":\"192.0.2.1\",\"ttl\":60}\n"; buffer.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buffer.String())
+	}
+}
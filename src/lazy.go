@@ -0,0 +1,285 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+// In lazy-load mode only the entries outside of zones are loaded at startup, the zones are just discovered (by their SOA entries)
+// and represented by empty (unloaded) nodes. A zone is loaded on the first access and unloaded again on any change in it.
+// Nested zones are loaded together with their (outermost) parent zone.
+
+// reloads the node (must be the root node and write-locked) lazily, returns the revision of the data
+func (dn *dataNode) reloadLazily(revision *int64) (int64, error) {
+	keysResponse, err := get(*args.Prefix, true, revision, clientv3.WithKeysOnly())
+	if err != nil {
+		return 0, err
+	}
+	rev := keysResponse.Revision
+	var keys []string
+	apexes := map[string]nameType{}
+	for item := range keysResponse.DataChan {
+		keys = append(keys, item.Key)
+		name, entryType, qtype, _, version, err := parseEntryKey(item.Key)
+		if err == nil && (version == nil || dataVersion.isCompatibleTo(version)) && entryType == normalEntry && qtype == "SOA" {
+			apexes[name.normal()] = name
+		}
+	}
+	if _, ok := apexes["."]; ok {
+		dn.log().Debug("root zone present, loading all data")
+		getResponse, err := get(*args.Prefix, true, &rev)
+		if err != nil {
+			return 0, err
+		}
+		dn.reload(getResponse.DataChan)
+		return rev, nil
+	}
+	// the outermost zone containing the name, nil if the name is not in a zone
+	outermostZone := func(name nameType) *nameType {
+		for depth := 1; depth <= name.len(); depth++ {
+			ancestor := name.toDepth(depth)
+			if apex, ok := apexes[ancestor.normal()]; ok {
+				return &apex
+			}
+		}
+		return nil
+	}
+	// the keys of a zone (or outside of zones) need not be consecutive, they are fetched by runs of consecutive keys.
+	// entries outside of zones (and unparsable ones, for logging them) are loaded directly.
+	sort.Strings(keys)
+	zones := map[string]nameType{}
+	zoneKeys := map[string][]keyRange{} // <normal name of the outermost zone> or "" (outside of zones) → key ranges
+	prev := "."                         // the zone of the previous key (none yet, the root zone is handled above)
+	for _, key := range keys {
+		zone := ""
+		if name, _, _, _, _, err := parseEntryKey(key); err == nil {
+			if apex := outermostZone(name); apex != nil {
+				zone = apex.normal()
+				zones[zone] = *apex
+			}
+		}
+		if ranges := zoneKeys[zone]; zone == prev {
+			ranges[len(ranges)-1].to = key
+		} else {
+			zoneKeys[zone] = append(ranges, keyRange{key, key})
+		}
+		prev = zone
+	}
+	items, err := getRanges(zoneKeys[""], &rev)
+	if err != nil {
+		return 0, err
+	}
+	dataChan := make(chan etcdItem)
+	go func() {
+		defer close(dataChan)
+		for _, item := range items {
+			dataChan <- item
+		}
+	}()
+	dn.reload(dataChan)
+	qnames := make([]string, 0, len(zones))
+	for qname := range zones {
+		qnames = append(qnames, qname)
+	}
+	sort.Strings(qnames)
+	for _, qname := range qnames {
+		zone := dn.getChildCreate(zones[qname])
+		zone.unload()
+		zone.keyRanges = zoneKeys[qname]
+	}
+	dn.log("#zones", len(zones), "#entries", len(items), "revision", rev).Debug("loaded data lazily")
+	return rev, nil
+}
+
+// a range of ETCD keys (from and to inclusive), fetched by a single request
+type keyRange struct {
+	from, to string
+}
+
+// gets the entries of the key ranges (at the revision, if not nil)
+func getRanges(ranges []keyRange, revision *int64) ([]etcdItem, error) {
+	var items []etcdItem
+	for _, r := range ranges {
+		var opts []clientv3.OpOption
+		if r.to != r.from {
+			opts = append(opts, clientv3.WithRange(r.to+"\x00"))
+		}
+		getResponse, err := get(r.from, false, revision, opts...)
+		if err != nil {
+			return nil, err
+		}
+		for item := range getResponse.DataChan {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// clears the node and marks it as unloaded (must be write-locked)
+func (dn *dataNode) unload() {
+	clearMap(dn.defaults)
+	clearMap(dn.options)
+	clearMap(dn.values)
+	clearMap(dn.records)
+	clearMap(dn.metadata)
+	clearMap(dn.keys)
+	clearMap(dn.autoPtrs)
+	clearMap(dn.children)
+	dn.unloaded = true
+	dn.zoneID = zoneIDs.of(dn.zoneIDName())
+}
+
+// loads the (unloaded) zone node (must be write-locked). its entry keys can be spelled differently (f.e. "a.b/A" and
+// "a/b/A"), so they are fetched by the key ranges instead of the key prefix of the node. the ranges can also contain
+// other entries (created after the discovery), which are ignored by reload().
+func (dn *dataNode) load() error {
+	releaseSerials := zoneSerials.hold()
+	defer releaseSerials()
+	items, err := getRanges(dn.keyRanges, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get data: %s", err)
+	}
+	dataChan := make(chan etcdItem)
+	go func() {
+		defer close(dataChan)
+		for _, item := range items {
+			dataChan <- item
+		}
+	}()
+	dn.reload(dataChan)
+	dn.unloaded = false
+	lookupCache.invalidate(dn) // results computed while unloaded
+	dn.log("#records", dn.recordsCount(), "#zones", dn.zonesCount(), "#ranges", len(dn.keyRanges)).Debug("loaded zone")
+	return nil
+}
+
+// adds the key to the key ranges of the zone (must be write-locked), if not contained yet
+func (dn *dataNode) addKey(key string) {
+	for _, r := range dn.keyRanges {
+		if r.from <= key && key <= r.to {
+			return
+		}
+	}
+	dn.keyRanges = append(dn.keyRanges, keyRange{key, key})
+}
+
+// in lazy-load mode, loads the zone containing the name, if not loaded yet
+func ensureLoaded(name nameType) {
+	if !*args.LazyLoad {
+		return
+	}
+	data := dataRoot.Load().getChild(name, true)
+	zone := data.findUpwards(func(dn *dataNode) bool {
+		return dn.unloaded
+	})
+	if zone == nil {
+		data.rUnlockUpwards(nil)
+		return
+	}
+	data.rUnlockUpwards(zone)
+	zone.mutex.RUnlock()
+	if zone.parent != nil {
+		defer zone.parent.rUnlockUpwards(nil)
+	}
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+	if !zone.unloaded {
+		return // loaded concurrently
+	}
+	if err := zone.load(); err != nil {
+		zone.log().WithError(err).Error("failed to load zone")
+	}
+}
+
+// in lazy-load mode, loads all zones which are not loaded yet (for requests operating on all data)
+func ensureAllLoaded() {
+	if !*args.LazyLoad {
+		return
+	}
+	var names []nameType
+	dataRoot.Load().walk(func(dn *dataNode) bool {
+		if dn.unloaded {
+			names = append(names, *dn.getName())
+		}
+		return true
+	})
+	for _, name := range names {
+		ensureLoaded(name)
+	}
+}
+
+// in lazy-load mode, loads the zone with the given id, if not loaded yet.
+// nested zones are unknown until their parent zone is loaded, so all zones are loaded if the id is not found.
+func ensureZoneLoaded(id int64) {
+	if !*args.LazyLoad {
+		return
+	}
+	var zone *dataNode
+	var name nameType
+	dataRoot.Load().walk(func(dn *dataNode) bool {
+		if zone == nil && (dn.unloaded || dn.hasSOA()) && dn.zoneID == id {
+			zone, name = dn, *dn.getName()
+		}
+		return zone == nil
+	})
+	switch {
+	case zone == nil:
+		ensureAllLoaded()
+	case zone.unloaded:
+		ensureLoaded(name)
+	}
+}
+
+// handles an event in lazy-load mode: the outermost zone of the entry is unloaded, entries outside of zones cause a lazy reload of all data
+func handleEventLazily(event *clientv3.Event, name nameType, entryType entryType, qtype, id string) {
+	root := dataRoot.Load()
+	itemData := root.getChild(name, true)
+	var zone *dataNode
+	for dn := itemData; dn != nil; dn = dn.parent {
+		if dn.unloaded || dn.hasSOA() {
+			zone = dn
+		}
+	}
+	deletesZone := zone == itemData && event.Type == clientv3.EventTypeDelete && qtype == "SOA" && id == "" && entryType == normalEntry
+	if zone == nil || zone.isRoot() || deletesZone {
+		itemData.rUnlockUpwards(nil)
+		root.mutex.Lock()
+		defer root.mutex.Unlock()
+		if _, err := root.reloadLazily(nil); err != nil {
+			log.data().WithError(err).Error("failed to reload data lazily")
+		}
+		lookupCache.clear()
+		return
+	}
+	defer zone.parent.rUnlockUpwards(nil)
+	itemData.rUnlockUpwards(zone)
+	zone.mutex.RUnlock()
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+	if event.Type == clientv3.EventTypePut {
+		zone.addKey(string(event.Kv.Key))
+	}
+	if zone.unloaded {
+		log.data().Tracef("zone %q is not loaded, ignoring event", zone.getQname())
+		return
+	}
+	zone.unload()
+	lookupCache.invalidate(zone)
+	log.data().Debugf("unloaded zone %q", zone.getQname())
+}
@@ -0,0 +1,199 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+var testLazyEntries = map[string]string{
+	"dns/-defaults-":          `{"ttl": 3600}`,
+	"dns/net/-defaults-/A":    `{"ttl": 300}`,
+	"dns/com.nozone/A":        `192.0.2.100`,
+	"dns/net.example/SOA":     `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"dns/net.example/NS":      `ns1`,
+	"dns/net.example/A":       `192.0.2.1`,
+	"dns/net.example/www/A":   `192.0.2.2`,
+	"dns/net.example/sub/SOA": `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"dns/net.example/sub/A":   `192.0.2.3`,
+	"dns/net/example/mixed/A": `192.0.2.5`,
+	"dns/org.example/SOA":     `{"primary": "ns1.example.net.", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"dns/org.example/MX":      `{"priority": 10, "target": "mail"}`,
+	"dns/org.example/TXT":     `some text`,
+}
+
+var testLazyQueries = [][2]string{
+	{"example.net.", "SOA"},
+	{"example.net.", "ANY"},
+	{"www.example.net.", "A"},
+	{"sub.example.net.", "SOA"},
+	{"sub.example.net.", "A"},
+	{"mixed.example.net.", "A"},
+	{"missing.example.net.", "A"},
+	{"example.org.", "ANY"},
+	{"nozone.com.", "A"},
+	{"example.com.", "A"},
+}
+
+// the lookup results of all testLazyQueries (the items sorted by their string representation)
+func lookupTestLazyQueries(t *testing.T) []string {
+	var results []string
+	for _, query := range testLazyQueries {
+		result, err := lookup(objectType[any]{"qname": query[0], "qtype": query[1]}, newTestClient())
+		if err != nil {
+			t.Fatalf("%v: lookup failed: %s", query, err)
+		}
+		var items []string
+		if resultItems, ok := result.([]objectType[any]); ok {
+			for _, item := range resultItems {
+				items = append(items, fmt.Sprint(item))
+			}
+		} else {
+			items = append(items, fmt.Sprint(result))
+		}
+		sort.Strings(items)
+		results = append(results, fmt.Sprint(query, items))
+	}
+	return results
+}
+
+// returns the keys requested since the last call
+func drainKeys(kv *testKV) []string {
+	var keys []string
+	for {
+		select {
+		case key := <-kv.keys:
+			keys = append(keys, key)
+		default:
+			return keys
+		}
+	}
+}
+
+func TestLazyLoad(t *testing.T) {
+	entries := func() map[string]string {
+		entries := map[string]string{}
+		for key, value := range testLazyEntries {
+			entries[key] = value
+		}
+		return entries
+	}
+	_, watcher := newTestETCD("dns/", entries())
+	cancel, err := populateData("eager")
+	if err != nil {
+		t.Fatalf("eager populateData() failed: %s", err)
+	}
+	<-watcher.keys
+	cancel()
+	eager := lookupTestLazyQueries(t)
+	kv, watcher := newTestETCD("dns/", entries())
+	*args.LazyLoad = true
+	cancel, err = populateData("lazy")
+	if err != nil {
+		t.Fatalf("lazy populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-watcher.keys
+	// the keys and then the entries outside of zones, by the runs of consecutive keys
+	if keys := drainKeys(kv); fmt.Sprint(keys) != fmt.Sprint([]string{"dns/", "dns/-defaults-", "dns/net/-defaults-/A"}) {
+		t.Errorf("unexpected requests at startup: %v", keys)
+	}
+	if count := dataRoot.Load().zonesCount(); count != 0 {
+		t.Errorf("expected no loaded zones after startup, got %d", count)
+	}
+	lazy := lookupTestLazyQueries(t)
+	for i := range eager {
+		if eager[i] != lazy[i] {
+			t.Errorf("lazy result differs:\n eager: %s\n  lazy: %s", eager[i], lazy[i])
+		}
+	}
+	keys := drainKeys(kv)
+	sort.Strings(keys)
+	// the entries of example.net. are spelled differently, interrupted by the defaults of net.
+	if fmt.Sprint(keys) != fmt.Sprint([]string{"dns/net.example/A", "dns/net/example/mixed/A", "dns/org.example/MX"}) {
+		t.Errorf("expected each zone to be loaded once, got requests for %v", keys)
+	}
+	// an event unloads the zone, the next access loads it with the new data
+	kv.entries["dns/net.example/www/A"] = `192.0.2.22`
+	kv.revision = 2
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net.example/www/A"), Value: []byte(`192.0.2.22`), CreateRevision: 1, ModRevision: 2},
+	}}}
+	waitForContent(t, "www.example.net.", "A", "192.0.2.22")
+	// a new entry in the zone, spelled differently again
+	kv.entries["dns/net/example.new/A"] = `192.0.2.6`
+	kv.revision = 3
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/net/example.new/A"), Value: []byte(`192.0.2.6`), CreateRevision: 3, ModRevision: 3},
+	}}}
+	waitForContent(t, "new.example.net.", "A", "192.0.2.6")
+	// a new zone (outside of zones) is discovered
+	kv.entries["dns/com.example/SOA"] = `{"primary": "ns1.example.net.", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`
+	kv.entries["dns/com.example/A"] = `192.0.2.4`
+	kv.revision = 4
+	watcher.responses <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("dns/com.example/SOA"), CreateRevision: 4, ModRevision: 4},
+	}}}
+	waitForContent(t, "example.com.", "A", "192.0.2.4")
+}
+
+func TestLazyLoadNamespace(t *testing.T) {
+	_, watcher := newTestETCD("dns/", testLazyEntries)
+	cancel, err := populateData("eager")
+	if err != nil {
+		t.Fatalf("eager populateData() failed: %s", err)
+	}
+	<-watcher.keys
+	cancel()
+	eager := lookupTestLazyQueries(t)
+	// the entries of another namespace sort before and after the ranges (with and without the namespace)
+	entries := map[string]string{}
+	for key, value := range testLazyEntries {
+		entries["cluster-a/"+key] = value
+		entries["cluster-b/"+key] = `{"invalid`
+		entries["a/"+key] = `{"invalid`
+	}
+	kv, watcher := newTestETCD("dns/", entries)
+	namespace := "cluster-a/"
+	args.Namespace = &namespace
+	*args.LazyLoad = true
+	applyNamespace(cli[""])
+	cancel, err = populateData("lazy")
+	if err != nil {
+		t.Fatalf("lazy populateData() failed: %s", err)
+	}
+	defer cancel()
+	<-watcher.keys
+	lazy := lookupTestLazyQueries(t)
+	for i := range eager {
+		if eager[i] != lazy[i] {
+			t.Errorf("lazy result differs:\n eager: %s\n  lazy: %s", eager[i], lazy[i])
+		}
+	}
+	for _, key := range drainKeys(kv) {
+		if !strings.HasPrefix(key, namespace) {
+			t.Errorf("requested key %q outside of the namespace", key)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import "fmt"
+
+func list(params objectType[any], client *pdnsClient) (interface{}, error) {
+	zonename, ok := params["zonename"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'zonename'")
+	}
+	name := parseName(zonename)
+	ensureLoaded(name)
+	data := dataRoot.Load().getChild(name, true)
+	defer data.rUnlockUpwards(nil)
+	if data.depth() < name.len() || !data.hasSOA() || !isServedZone(data) {
+		client.log.data().Debugf("no such zone: %q", name.normal())
+		return false, nil
+	}
+	var result []objectType[any]
+	addRecords := func(dn *dataNode) {
+		for qtype, records := range dn.records {
+			for _, record := range records {
+				item := makeResultItem(qtype, dn, &record, client)
+				if record.disabled {
+					item["disabled"] = true
+				}
+				client.log.pdns().WithField("item", item).Trace("adding result item")
+				result = append(result, item)
+			}
+		}
+	}
+	// the zone node is already read-locked
+	addRecords(data)
+	for _, child := range data.children {
+		child.walk(func(dn *dataNode) bool {
+			if dn.hasSOA() {
+				return false // another zone
+			}
+			addRecords(dn)
+			return true
+		})
+	}
+	client.log.pdns().WithField("#", len(result)).Debug("request result items count")
+	if len(result) == 0 {
+		return false, nil
+	}
+	return result, nil
+}
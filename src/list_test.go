@@ -0,0 +1,116 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	for _, entry := range []struct{ name, qtype, content string }{
+		{"", "NS", "ns1.example.net."},
+		{"www", "A", "192.0.2.1"},
+		{"a.b", "TXT", "two levels"},
+		{"sub", "SOA", `{"primary":"ns1","mail":"hostmaster","refresh":1,"retry":1,"expire":1,"neg-ttl":1}`},
+		{"sub", "NS", "ns1.sub.example.net."},
+		{"www.sub", "A", "192.0.2.2"},
+	} {
+		if _, err := storeTestEntry(zone.getChildCreate(testName(entry.name)), entry.qtype, "", entry.content); err != nil {
+			t.Fatalf("failed to store %s/%s: %s", entry.name, entry.qtype, err)
+		}
+	}
+	for _, spec := range []struct {
+		zonename string
+		expected []string
+	}{
+		{"example.net.", []string{"a.b.example.net./TXT", "example.net./NS", "example.net./SOA", "www.example.net./A"}},
+		{"Sub.Example.Net.", []string{"sub.example.net./NS", "sub.example.net./SOA", "www.sub.example.net./A"}},
+	} {
+		result, err := list(objectType[any]{"zonename": spec.zonename, "domain_id": float64(-1)}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s: list failed: %s", spec.zonename, err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok {
+			t.Fatalf("%s: unexpected result: %v", spec.zonename, result)
+		}
+		got := Map(items, func(item objectType[any], _ int) string { return item["qname"].(string) + "/" + item["qtype"].(string) })
+		sort.Strings(got)
+		if !equal(got, spec.expected) {
+			t.Errorf("%s: expected %v, got %v", spec.zonename, spec.expected, got)
+		}
+	}
+	for _, zonename := range []string{"www.example.net.", "example.org."} {
+		if result, err := list(objectType[any]{"zonename": zonename}, newTestClient()); err != nil || result != false {
+			t.Errorf("%s: expected false, got %v (%v)", zonename, result, err)
+		}
+	}
+}
+
+func TestListDisabled(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	staging := zone.getChildCreate(testName("staging"))
+	staging.defaults["AAAA"] = map[string]defoptType{"": {objectType[any]{"disabled": true}, nil}}
+	for _, entry := range []struct{ name, qtype, id, content string }{
+		{"www", "A", "1", `192.0.2.1`},
+		{"www", "A", "2", `{"ip": "192.0.2.2", "disabled": true}`},
+		{"staging", "A", "", `192.0.2.3`},
+		{"staging", "AAAA", "", `2001:db8::3`},
+		{"staging", "AAAA", "enabled", `{"ip": "2001:db8::4", "disabled": false}`},
+	} {
+		if _, err := storeTestEntry(zone.getChildCreate(testName(entry.name)), entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s/%s#%s: %s", entry.name, entry.qtype, entry.id, err)
+		}
+	}
+	// hidden from lookup
+	for qname, expected := range map[string][]string{
+		"www.example.net.":     {"192.0.2.1"},
+		"staging.example.net.": {"192.0.2.3", "2001:db8::4"},
+	} {
+		result, err := lookup(objectType[any]{"qname": qname, "qtype": "ANY"}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", qname, err)
+		}
+		items, _ := result.([]objectType[any])
+		got := Map(items, func(item objectType[any], _ int) string { return item["content"].(string) })
+		sort.Strings(got)
+		if !equal(got, expected) {
+			t.Errorf("%s: expected %v, got %v", qname, expected, got)
+		}
+	}
+	// visible to list, with a marker
+	result, err := list(objectType[any]{"zonename": "example.net."}, newTestClient())
+	if err != nil {
+		t.Fatalf("list failed: %s", err)
+	}
+	items, ok := result.([]objectType[any])
+	if !ok {
+		t.Fatalf("unexpected result: %v", result)
+	}
+	var disabled []string
+	for _, item := range items {
+		if item["disabled"] == true {
+			disabled = append(disabled, item["content"].(string))
+		}
+	}
+	sort.Strings(disabled)
+	if expected := []string{"192.0.2.2", "2001:db8::3"}; len(items) != 6 || !equal(disabled, expected) {
+		t.Errorf("expected 6 items with disabled %v, got %v", expected, items)
+	}
+}
@@ -16,6 +16,7 @@ package src
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"time"
@@ -69,6 +70,50 @@ func (f *logFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 type logType map[string]*logrus.Logger
 
+// the components of the program log and the client logs
+var logComponents = map[string]bool{"main": true, "pdns": true, "etcd": true, "data": true}
+
+// the methods of *syslog.Writer used by syslogHook
+type syslogWriter interface {
+	Crit(m string) error
+	Err(m string) error
+	Warning(m string) error
+	Info(m string) error
+	Debug(m string) error
+}
+
+// writes the log entries to syslog, with the severity of the log level
+type syslogHook struct {
+	writer syslogWriter
+}
+
+// the syslog writer for the loggers created by newLog(), set by the program argument
+var defaultSyslog syslogWriter
+
+func (h syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h syslogHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	msg := string(line)
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.writer.Crit(msg)
+	case logrus.ErrorLevel:
+		return h.writer.Err(msg)
+	case logrus.WarnLevel:
+		return h.writer.Warning(msg)
+	case logrus.InfoLevel:
+		return h.writer.Info(msg)
+	default:
+		return h.writer.Debug(msg)
+	}
+}
+
 func newLog(msgPrefix string, components ...string) logType {
 	newLogger := func(component string) *logrus.Logger {
 		logger := logrus.New()
@@ -79,9 +124,21 @@ func newLog(msgPrefix string, components ...string) logType {
 	for _, comp := range components {
 		log[comp] = newLogger(comp)
 	}
+	if defaultSyslog != nil {
+		log.useSyslog(defaultSyslog)
+	}
 	return log
 }
 
+// logs to the writer instead of stderr
+func (log *logType) useSyslog(writer syslogWriter) {
+	for _, logger := range *log {
+		logger.ReplaceHooks(logrus.LevelHooks{})
+		logger.AddHook(syslogHook{writer})
+		logger.SetOutput(io.Discard)
+	}
+}
+
 func (log *logType) main() *logrus.Logger {
 	return (*log)["main"]
 }
@@ -109,6 +166,81 @@ func (log *logType) setLoggingLevel(components string, level logrus.Level) {
 	}
 }
 
+// parses a comma (or semicolon) separated list of <component>=<level> settings, f.e. "main=debug,data=trace".
+// the components are checked against all known components, the levels are the logrus level names.
+func parseLoggingLevels(spec string) (map[string]logrus.Level, error) {
+	levels := map[string]logrus.Level{}
+	for _, setting := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ';' }) {
+		component, levelName, ok := strings.Cut(strings.TrimSpace(setting), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid setting %q (expected <component>=<level>)", setting)
+		}
+		if !logComponents[component] {
+			return nil, fmt.Errorf("invalid component %q", component)
+		}
+		level, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for component %q: %s", component, err)
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// sets the logging levels given by the spec (see parseLoggingLevels()), components which are not used by this log are ignored
+func (log *logType) setLoggingLevels(spec string) error {
+	levels, err := parseLoggingLevels(spec)
+	if err != nil {
+		return err
+	}
+	for _, component := range sortedKeys(levels) {
+		if _, ok := (*log)[component]; ok {
+			log.setLoggingLevel(component, levels[component])
+		}
+	}
+	return nil
+}
+
+// accumulates the durations of the phases of a request (in order of their first occurrence), for the trace log.
+// a nil *timings (trace level disabled) ignores all calls, so the overhead is near zero.
+type timings struct {
+	last   time.Time
+	phases []string
+	durs   map[string]time.Duration
+}
+
+// returns nil if the trace level of the logger is disabled
+func newTimings(logger *logrus.Logger) *timings {
+	if !logger.IsLevelEnabled(logrus.TraceLevel) {
+		return nil
+	}
+	return &timings{last: time.Now(), durs: map[string]time.Duration{}}
+}
+
+// adds the time since the previous call (or the creation) to the duration of the phase
+func (t *timings) mark(phase string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	if _, ok := t.durs[phase]; !ok {
+		t.phases = append(t.phases, phase)
+	}
+	t.durs[phase] += now.Sub(t.last)
+	t.last = now
+}
+
+// the durations as log fields, named "t-<phase>"
+func (t *timings) fields() logrus.Fields {
+	fields := logrus.Fields{}
+	if t != nil {
+		for _, phase := range t.phases {
+			fields["t-"+phase] = t.durs[phase]
+		}
+	}
+	return fields
+}
+
 func logFrom(logger *logrus.Logger, fieldsArgs ...any) *logrus.Entry {
 	fields := logrus.Fields{}
 	var name string
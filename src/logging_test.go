@@ -0,0 +1,54 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoggingLevels(t *testing.T) {
+	// readParameters() sets the levels of the program log too (not in standalone mode)
+	for _, logger := range log {
+		defer logger.SetLevel(logger.GetLevel())
+	}
+	client := newTestClient()
+	if err := readParameters(objectType[string]{logParam: "main=debug, data=trace;etcd=warning,pdns=error"}, client); err != nil {
+		t.Fatalf("readParameters() failed: %s", err)
+	}
+	for component, expected := range map[string]logrus.Level{
+		"main": logrus.DebugLevel,
+		"data": logrus.TraceLevel,
+		"pdns": logrus.ErrorLevel,
+	} {
+		if level := client.log[component].GetLevel(); level != expected {
+			t.Errorf("%s: expected level %s, got %s", component, expected, level)
+		}
+	}
+	for spec, expected := range map[string]string{
+		"main=debug,foo=info": `invalid component "foo"`,
+		"main=loud":           `invalid level for component "main"`,
+		"main":                `invalid setting "main"`,
+	} {
+		if _, err := parseLoggingLevels(spec); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %v", spec, expected, err)
+		}
+	}
+	if levels, err := parseLoggingLevels(""); err != nil || len(levels) != 0 {
+		t.Errorf("expected no levels for an empty spec, got %v (error %v)", levels, err)
+	}
+}
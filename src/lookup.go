@@ -16,11 +16,19 @@ package src
 
 import (
 	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type queryType struct {
-	name  nameType
-	qtype string
+	name   nameType
+	qtype  string
+	remote net.IP // the address of the client (see clientAddress()), nil if unknown
 }
 
 func (query *queryType) String() string {
@@ -35,69 +43,686 @@ const (
 	normalEntry   entryType = "normal"
 	defaultsEntry entryType = "defaults"
 	optionsEntry  entryType = "options"
+	metadataEntry entryType = "metadata"
+	keysEntry     entryType = "keys"
 )
 
 var (
 	key2entryType = map[string]entryType{
 		defaultsKey: defaultsEntry,
 		optionsKey:  optionsEntry,
+		metadataKey: metadataEntry,
+		keysKey:     keysEntry,
 	}
 	entryType2key = map[entryType]string{
 		defaultsEntry: defaultsKey,
 		optionsEntry:  optionsKey,
+		metadataEntry: metadataKey,
+		keysEntry:     keysKey,
 	}
 )
 
-func lookup(params objectType[any], client *pdnsClient) (interface{}, error) {
+// a parsed lookup request (see lookup() and lookupBatch())
+type lookupQuery struct {
+	queryType
+	zoneID   float64 // the parameter zone-id, -1 if not given
+	cacheKey resultCacheKey
+}
+
+func parseLookupQuery(params objectType[any], client *pdnsClient) lookupQuery {
 	query := queryType{
-		name:  nameType(Map(reversed(splitDomainName(params["qname"].(string), ".")), func(name string, _ int) namePart { return namePart{name, ""} })), // the keyPrefix from query.name will not be used, so it could be anything
-		qtype: params["qtype"].(string),
+		name:   parseName(params["qname"].(string)),
+		qtype:  params["qtype"].(string),
+		remote: clientAddress(params),
 	}
-	data := dataRoot.getChild(query.name, true)
-	defer data.rUnlockUpwards(nil)
-	if data.depth() < query.name.len() {
+	zoneID, ok := params["zone-id"].(float64)
+	if !ok {
+		zoneID = -1
+	}
+	client.log.pdns().WithFields(remoteFields(params)).Tracef("lookup of %q", query.String())
+	return lookupQuery{query, zoneID, resultCacheKey{query.name.normal(), query.qtype, zoneID, client.PdnsVersion}}
+}
+
+func lookup(params objectType[any], client *pdnsClient) (interface{}, error) {
+	query := parseLookupQuery(params, client)
+	if result, ok := lookupCache.get(query.cacheKey); ok {
+		client.log.data().Tracef("cache hit for %q", query.String())
+		client.timings.mark("cache")
+		return result.(lookupResult).ordered(&query.queryType, client), nil
+	}
+	client.timings.mark("cache")
+	epoch := lookupCache.currentEpoch()
+	ensureLoaded(query.name) // lazy mode: ETCD calls
+	client.timings.mark("load")
+	result := func() lookupResult {
+		data := dataRoot.Load().getChild(query.name, true)
+		defer data.rUnlockUpwards(nil)
+		client.timings.mark("walk")
+		return lookupLocked(&query, data, epoch, client)
+	}()
+	return completeLookup(result, &query.queryType, client), nil
+}
+
+// looks up the query in the data node of the query name (read-locked upwards) and caches the result, if possible
+func lookupLocked(query *lookupQuery, data *dataNode, epoch uint64, client *pdnsClient) lookupResult {
+	result := lookupData(&query.queryType, data, query.zoneID, client)
+	client.timings.mark("assemble")
+	if result.alias != nil {
+		return result // not cached, it depends on the data of the ALIAS target
+	}
+	if data.hasViews() {
+		return result // not cached, it depends on the client
+	}
+	zoneData := data.findZone()
+	if zoneSigningKeys(zoneData, client) != nil {
+		return result // not cached, the signatures depend on the time
+	}
+	zone := "."
+	if zoneData != nil {
+		zone = zoneData.getQname()
+	}
+	lookupCache.put(query.cacheKey, result, zone, epoch)
+	return result
+}
+
+// resolves the ALIAS of the result (if any, the data must not be locked) and orders and limits the items
+func completeLookup(result lookupResult, query *queryType, client *pdnsClient) interface{} {
+	if result.alias != nil {
+		// resolved after unlocking the queried data, the target may be anywhere in the tree
+		result = resolveAlias(result, client)
+	}
+	defer client.timings.mark("order")
+	return limitAnswers(result.ordered(query, client), query, client)
+}
+
+// the non-standard request method "lookupBatch" (enabled by parameter lookup-batch): answers the queries of the
+// parameter queries (an array of objects with qname, qtype and optionally zone-id, like lookup) in one request,
+// returns an array with the result of each query (in the order of the queries).
+// the queries are looked up in the order of their names, the queries of a zone share one read-locked walk to its apex.
+func lookupBatch(params objectType[any], client *pdnsClient) (interface{}, error) {
+	if args.LookupBatch == nil || !*args.LookupBatch {
+		return false, fmt.Errorf("request method lookupBatch is not enabled (parameter %s)", lookupBatchParam)
+	}
+	queryParams, ok := params["queries"].([]any)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'queries'")
+	}
+	queries := make([]lookupQuery, len(queryParams))
+	for i, query := range queryParams {
+		query, ok := query.(map[string]any)
+		if !ok {
+			return false, fmt.Errorf("invalid query #%d: not an object", i+1)
+		}
+		if _, ok := query["qname"].(string); !ok {
+			return false, fmt.Errorf("invalid query #%d: missing qname", i+1)
+		}
+		if _, ok := query["qtype"].(string); !ok {
+			return false, fmt.Errorf("invalid query #%d: missing qtype", i+1)
+		}
+		queries[i] = parseLookupQuery(query, client)
+	}
+	results := make([]interface{}, len(queries))
+	lookupResults := make([]lookupResult, len(queries))
+	var pending []int // the indexes of the queries not answered from the cache
+	for i := range queries {
+		if result, ok := lookupCache.get(queries[i].cacheKey); ok {
+			client.log.data().Tracef("cache hit for %q", queries[i].String())
+			results[i] = result.(lookupResult).ordered(&queries[i].queryType, client)
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	client.timings.mark("cache")
+	epoch := lookupCache.currentEpoch()
+	for _, i := range pending {
+		ensureLoaded(queries[i].name) // lazy mode: ETCD calls
+	}
+	client.timings.mark("load")
+	sort.SliceStable(pending, func(i, j int) bool { return nameLess(queries[pending[i]].name, queries[pending[j]].name) })
+	var zone *dataNode // the zone apex of the previous query, read-locked upwards
+	for _, i := range pending {
+		query := &queries[i]
+		var data *dataNode
+		if zone != nil && zone.isAncestorOf(query.name) {
+			data = zone.getDescendant(query.name.fromDepth(zone.depth() + 1))
+		} else {
+			if zone != nil {
+				zone.rUnlockUpwards(nil)
+			}
+			data = dataRoot.Load().getChild(query.name, true)
+			zone = data.findZone()
+		}
+		client.timings.mark("walk")
+		lookupResults[i] = lookupLocked(query, data, epoch, client)
+		data.rUnlockUpwards(zone)
+	}
+	if zone != nil {
+		zone.rUnlockUpwards(nil)
+	}
+	for _, i := range pending {
+		results[i] = completeLookup(lookupResults[i], &queries[i].queryType, client)
+	}
+	return results, nil
+}
+
+// compares the names by their labels from the top, so that the names of a zone are adjacent when sorted
+func nameLess(a, b nameType) bool {
+	for depth := 1; depth <= a.len() && depth <= b.len(); depth++ {
+		if la, lb := a.lname(depth), b.lname(depth); la != lb {
+			return la < lb
+		}
+	}
+	return a.len() < b.len()
+}
+
+func maxAnswers() int {
+	if args.MaxAnswers == nil {
+		return 0
+	}
+	return *args.MaxAnswers
+}
+
+// truncates the result items to the parameter max-answers (a safety valve against huge answers, f.e. of ANY queries).
+// a signed answer is truncated by whole RRsets with their RRSIG records (keeping at least the first one), because a
+// partial RRset or a missing signature would make the answer bogus for validating resolvers.
+func limitAnswers(result interface{}, query *queryType, client *pdnsClient) interface{} {
+	items, ok := result.([]objectType[any])
+	limit := maxAnswers()
+	if !ok || limit <= 0 || len(items) <= limit {
+		return result
+	}
+	client.log.data().WithField("#", len(items)).Warnf("answer of %q exceeds %s=%d, truncating it", query.String(), maxAnswersParam, limit)
+	signed := false
+	for _, item := range items {
+		signed = signed || item["qtype"] == "RRSIG"
+	}
+	if !signed {
+		return items[:limit]
+	}
+	var rrsets []string
+	sizes := map[string]int{}
+	for _, item := range items {
+		rrset := rrsetOf(item)
+		if sizes[rrset] == 0 {
+			rrsets = append(rrsets, rrset)
+		}
+		sizes[rrset]++
+	}
+	kept := map[string]bool{}
+	for i, count := 0, 0; i < len(rrsets) && (i == 0 || count+sizes[rrsets[i]] <= limit); i++ {
+		kept[rrsets[i]] = true
+		count += sizes[rrsets[i]]
+	}
+	truncated := []objectType[any]{}
+	for _, item := range items {
+		if kept[rrsetOf(item)] {
+			truncated = append(truncated, item)
+		}
+	}
+	return truncated
+}
+
+// the RRset of the result item as "<qname> <QTYPE>", for RRSIG records the one of the covered QTYPE
+func rrsetOf(item objectType[any]) string {
+	qtype := item["qtype"].(string)
+	if qtype == "RRSIG" {
+		qtype, _, _ = strings.Cut(item["content"].(string), " ")
+	}
+	return fmt.Sprintf("%s %s", item["qname"], qtype)
+}
+
+// the result of lookupData(), which is cached as is. the order of the items is applied afterward (see ordered()).
+type lookupResult struct {
+	items   interface{}                  // the result items (sorted by QTYPE and id) or false
+	orders  map[string]string            // QTYPE → value of option order, only for values other than as-is
+	weights map[string]map[string]uint16 // QTYPE → content → weight of the items, only for the order value weighted
+	alias   *aliasLookup                 // the ALIAS record to resolve (see resolveAlias()), nil if none
+}
+
+// an ALIAS record found by lookupData(), to be flattened into the address records of its target
+type aliasLookup struct {
+	item   objectType[any] // the result item of the ALIAS record
+	qtypes []string        // the QTYPEs to resolve at the target (A and/or AAAA)
+}
+
+// computes the lookup result, data must be read-locked
+func lookupData(query *queryType, data *dataNode, zoneID float64, client *pdnsClient) lookupResult {
+	if !isServedZone(data.findZone()) {
+		client.log.data().Debugf("the zone of %q is not served (parameter %s)", query.name.normal(), zonesParam)
+		return lookupResult{false, nil, nil, nil}
+	}
+	if zoneID != -1 {
+		if zone := data.findZone(); zone == nil || float64(zone.zoneID) != zoneID {
+			client.log.data().Debugf("zone id %v does not match the zone of %q", zoneID, query.name.normal())
+			return lookupResult{false, nil, nil, nil}
+		}
+	}
+	if owner, dname := data.findDNAME(query.name.len()); dname != nil {
+		client.log.data().Debugf("found DNAME at %q for %q", owner.getQname(), query.name.normal())
+		return lookupResult{synthesizeDNAME(query, owner, dname, client), nil, nil, nil}
+	}
+	if data.depth() < query.name.len() || data.isEmpty() {
+		if record := templatePTR(query, data, client); record != nil {
+			item := makeResultItem("PTR", data, record, client)
+			item["qname"] = query.name.normal()
+			client.log.pdns().WithField("item", item).Trace("adding synthesized result item")
+			return lookupResult{[]objectType[any]{item}, nil, nil, nil}
+		}
 		client.log.data().Tracef("search for %q returned %q", query.name.normal(), data.getQname())
 		client.log.data().Debugf("no such domain: %q", query.name.normal())
-		return false, nil // need to return false to cause NXDOMAIN, returning an empty array causes PDNS error: "Backend reported condition which prevented lookup (Exception caught when receiving: No 'result' field in response from remote process) sending out servfail"
+		return lookupResult{false, nil, nil, nil} // need to return false to cause NXDOMAIN
+	}
+	if query.qtype == "ANY" && len(data.records) > 0 && minimalAny(data, client) {
+		item := minimalAnyItem(data, client)
+		client.log.pdns().WithField("item", item).Trace("adding synthesized result item")
+		return lookupResult{[]objectType[any]{item}, nil, nil, nil}
 	}
 	var result []objectType[any]
-	records := map[string]map[string]recordType{}
+	orders := map[string]string{}
+	weights := map[string]map[string]uint16{}
+	zone := data.findZone()
+	keys := zoneSigningKeys(zone, client)
+	recordsOf := func(qtype string) map[string]recordType { return data.records[qtype] }
+	if keys != nil && data == zone {
+		// the DNSKEY records of the zone keys are synthesized at the apex
+		dnskeys := map[string]recordType{}
+		for i, record := range dnskeyRecords(zone) {
+			dnskeys[fmt.Sprint(i+1)] = record
+		}
+		recordsOf = func(qtype string) map[string]recordType {
+			if qtype == "DNSKEY" && len(dnskeys) > 0 {
+				return dnskeys
+			}
+			return data.records[qtype]
+		}
+	}
+	if record := templatePTR(query, data, client); record != nil {
+		dataRecordsOf := recordsOf
+		recordsOf = func(qtype string) map[string]recordType {
+			if qtype == "PTR" {
+				return map[string]recordType{"": *record}
+			}
+			return dataRecordsOf(qtype)
+		}
+	}
+	qtypes := []string{query.qtype}
 	if query.qtype == "ANY" {
-		records = data.records
-	} else {
-		records[query.qtype] = data.records[query.qtype]
+		qtypes = sortedKeys(data.records)
+		for _, qtype := range []string{"DNSKEY", "PTR"} {
+			if _, ok := data.records[qtype]; !ok && len(recordsOf(qtype)) > 0 {
+				qtypes = append(qtypes, qtype)
+			}
+		}
+		sort.Strings(qtypes)
+		if included := anyTypes(data, client); included != nil {
+			var filtered []string
+			for _, qtype := range qtypes {
+				if included[qtype] {
+					filtered = append(filtered, qtype)
+				}
+			}
+			qtypes = filtered
+		}
+	}
+	var alias *aliasLookup
+	if record := data.findAlias(); record != nil {
+		switch query.qtype {
+		case "A", "AAAA":
+			alias = &aliasLookup{makeResultItem("ALIAS", data, record, client), qtypes}
+			qtypes = nil
+		case "ANY":
+			alias = &aliasLookup{makeResultItem("ALIAS", data, record, client), []string{"A", "AAAA"}}
+		}
 	}
-	for qtype, records := range records {
-		for _, record := range records {
+	for _, qtype := range qtypes {
+		if alias != nil && (qtype == "ALIAS" || qtype == "A" || qtype == "AAAA") {
+			continue // the address records come from the ALIAS target
+		}
+		count := 0
+		records := viewRecords(recordsOf(qtype), query.remote)
+		var contents []string
+		itemWeights := map[string]uint16{}
+		var ttl time.Duration
+		authoritative := true
+		for _, id := range sortedKeys(records) {
+			record := records[id]
+			if record.disabled {
+				continue
+			}
 			item := makeResultItem(qtype, data, &record, client)
 			client.log.pdns().WithField("item", item).Trace("adding result item")
 			result = append(result, item)
+			count++
+			contents = append(contents, recordContent(&record, defaultPdnsVersion))
+			itemWeights[item["content"].(string)] = record.getWeight()
+			if count == 1 || record.ttl < ttl {
+				ttl = record.ttl
+			}
+			authoritative = authoritative && item["auth"] == true
+		}
+		if count > 1 {
+			if order := recordsOrder(qtype, data, client); order != orderAsIs {
+				orders[qtype] = order
+				if order == orderWeighted {
+					weights[qtype] = itemWeights
+				}
+			}
 		}
+		if keys != nil && count > 0 && authoritative {
+			rrsigs, err := signRRset(zone, data, qtype, ttl, contents, signingKeysFor(qtype, keys))
+			if err != nil {
+				client.log.data().WithError(err).Errorf("not signing the %s records of %q, the answer will be bogus for validating resolvers", qtype, data.getQname())
+			}
+			for _, rrsig := range rrsigs {
+				item := makeResultItem("RRSIG", data, &rrsig, client)
+				client.log.pdns().WithField("item", item).Trace("adding synthesized result item")
+				result = append(result, item)
+			}
+		}
+	}
+	if alias != nil {
+		client.log.data().Debugf("found ALIAS at %q to %q", data.getQname(), alias.item["content"])
+		return lookupResult{result, orders, weights, alias}
 	}
 	client.log.pdns().WithField("#", len(result)).Debug("request result items count")
 	if len(result) == 0 {
-		return false, nil // see above for reasoning
+		// NODATA: the name exists, but has no records of the QTYPE. the slice must not be nil, because it would be
+		// encoded as null, which causes the PDNS error: "Backend reported condition which prevented lookup (Exception caught
+		// when receiving: No 'result' field in response from remote process) sending out servfail"
+		result = []objectType[any]{}
 	}
-	return result, nil
+	return lookupResult{result, orders, weights, nil}
 }
 
-func makeResultItem(qtype string, data *dataNode, record *recordType, client *pdnsClient) objectType[any] {
+// adds the address records of the ALIAS target to the result under the name of the ALIAS record, if the target is in a local zone.
+// otherwise the ALIAS record itself is added, for PowerDNS to expand it.
+func resolveAlias(result lookupResult, client *pdnsClient) lookupResult {
+	alias := result.alias
+	items, _ := result.items.([]objectType[any])
+	orders := result.orders
+	weights := result.weights
+	target := parseName(alias.item["content"].(string))
+	ensureLoaded(target) // lazy mode: ETCD calls
+	data := dataRoot.Load().getChild(target, true)
+	defer data.rUnlockUpwards(nil)
+	if data.findZone() == nil {
+		client.log.data().Debugf("ALIAS target %q is not local, passing it through", target.normal())
+		client.log.pdns().WithField("item", alias.item).Trace("adding result item")
+		items = append(items, alias.item)
+	} else if data.depth() == target.len() {
+		for _, qtype := range alias.qtypes {
+			count := 0
+			itemWeights := map[string]uint16{}
+			records := data.records[qtype]
+			for _, id := range sortedKeys(records) {
+				record := records[id]
+				if record.disabled {
+					continue
+				}
+				item := makeResultItem(qtype, data, &record, client)
+				item["qname"] = alias.item["qname"]
+				item["auth"] = alias.item["auth"]
+				client.log.pdns().WithField("item", item).Trace("adding flattened result item")
+				items = append(items, item)
+				itemWeights[item["content"].(string)] = record.getWeight()
+				count++
+			}
+			if count > 1 {
+				if order := recordsOrder(qtype, data, client); order != orderAsIs {
+					orders[qtype] = order
+					if order == orderWeighted {
+						if weights[qtype] == nil {
+							weights[qtype] = map[string]uint16{}
+						}
+						for content, weight := range itemWeights {
+							weights[qtype][content] = weight
+						}
+					}
+				}
+			}
+		}
+	} else {
+		client.log.data().Debugf("ALIAS target %q does not exist", target.normal())
+	}
+	client.log.pdns().WithField("#", len(items)).Debug("request result items count")
+	if len(items) == 0 {
+		items = []objectType[any]{} // NODATA, see lookupData() for reasoning
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i]["qtype"].(string) < items[j]["qtype"].(string) })
+	return lookupResult{items, orders, weights, nil}
+}
+
+// the value of option minimal-any, false if not set, data must be read-locked
+func minimalAny(data *dataNode, client *pdnsClient) bool {
+	minimal, _, err := findOptionValue[bool](minimalAnyOption, "ANY", "", data, false)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get option %q, using false", minimalAnyOption)
+		return false
+	}
+	return minimal
+}
+
+// the QTYPEs to answer an ANY query with by option any-types (an array of QTYPEs or a comma-separated string),
+// nil for all QTYPEs, data must be read-locked
+func anyTypes(data *dataNode, client *pdnsClient) map[string]bool {
+	value, oPath, err := findOptionValue[any](anyTypesOption, "ANY", "", data, false)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get option %q, using all QTYPEs", anyTypesOption)
+		return nil
+	}
+	if oPath == nil {
+		return nil
+	}
+	var qtypes []string
+	switch value := value.(type) {
+	case string:
+		qtypes = strings.Split(value, ",")
+	case []any:
+		for _, qtype := range value {
+			if qtype, ok := qtype.(string); ok {
+				qtypes = append(qtypes, qtype)
+			}
+		}
+	default:
+		client.log.data().Warnf("invalid value %v of option %q (in %s), using all QTYPEs", value, anyTypesOption, oPath)
+		return nil
+	}
+	included := map[string]bool{}
+	for _, qtype := range qtypes {
+		if qtype = strings.ToUpper(strings.TrimSpace(qtype)); qtype != "" {
+			included[qtype] = true
+		}
+	}
+	return included
+}
+
+// the synthesized HINFO record answering an ANY query minimally (RFC 8482, section 4.2)
+func minimalAnyItem(data *dataNode, client *pdnsClient) objectType[any] {
+	record := recordType{content: fmt.Sprintf("%s %s", quote("RFC8482"), quote("")), ttl: minimalAnyTTL}
+	return makeResultItem("HINFO", data, &record, client)
+}
+
+// the PTR record synthesized by the option ptr-template for a full in-addr.arpa query name without an (enabled) PTR record,
+// nil if none, data must be read-locked
+func templatePTR(query *queryType, data *dataNode, client *pdnsClient) *recordType {
+	if query.qtype != "PTR" && query.qtype != "ANY" {
+		return nil
+	}
+	if data.findZone() == nil {
+		return nil
+	}
+	if data.depth() == query.name.len() {
+		for _, record := range data.records["PTR"] {
+			if !record.disabled {
+				return nil
+			}
+		}
+	}
+	ip, err := reverseNameIP(query.name.normal())
+	if err != nil || len(ip) != net.IPv4len {
+		return nil
+	}
+	template, oPath, err := findOptionValue[string](ptrTemplateOption, "PTR", "", data, false)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get option %q", ptrTemplateOption)
+		return nil
+	}
+	if oPath == nil {
+		return nil
+	}
+	if !strings.Contains(template, "%d") {
+		client.log.data().Warnf("invalid value %q of option %q (in %s): missing %q", template, ptrTemplateOption, oPath, "%d")
+		return nil
+	}
+	params := &rrParams{qtype: "PTR", data: data}
+	hostname, err := fqdn(strings.ReplaceAll(strings.TrimSpace(template), "%d", strconv.Itoa(int(ip[3]))), params)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to complete the hostname of option %q (in %s)", ptrTemplateOption, oPath)
+		return nil
+	}
+	ttl, vPath, err := getTTL(params, nil)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get the TTL for option %q (in %s)", ptrTemplateOption, oPath)
+		return nil
+	}
+	if vPath == nil {
+		ttl, _ = qtypeDefaultTTL("PTR")
+	}
+	if ttl, err = clampTTL(ttl, params); err != nil {
+		client.log.data().WithError(err).Warnf("failed to clamp the TTL for option %q (in %s)", ptrTemplateOption, oPath)
+		return nil
+	}
+	if ttl == 0 {
+		client.log.data().Warnf("no TTL for option %q (in %s), not synthesizing a PTR record", ptrTemplateOption, oPath)
+		return nil
+	}
+	return &recordType{content: hostname, ttl: ttl}
+}
+
+// the value of option order for the records of the given QTYPE, data must be read-locked
+func recordsOrder(qtype string, data *dataNode, client *pdnsClient) string {
+	order, oPath, err := findOptionValue[string](orderOption, qtype, "", data, false)
+	if err != nil {
+		client.log.data().WithError(err).Warnf("failed to get option %q, using %q", orderOption, orderAsIs)
+		return orderAsIs
+	}
+	if oPath == nil {
+		return orderAsIs
+	}
+	switch order {
+	case orderAsIs, orderRandom, orderRoundRobin, orderWeighted:
+		return order
+	}
+	client.log.data().Warnf("invalid value %q of option %q (in %s), using %q", order, orderOption, oPath, orderAsIs)
+	return orderAsIs
+}
+
+// rotation counters for the option value round-robin, by <qname>/<QTYPE>
+var roundRobinCounters = struct {
+	sync.Mutex
+	counters map[string]int
+}{counters: map[string]int{}}
+
+func nextRoundRobin(key string) int {
+	roundRobinCounters.Lock()
+	defer roundRobinCounters.Unlock()
+	counter := roundRobinCounters.counters[key]
+	roundRobinCounters.counters[key] = counter + 1
+	return counter
+}
+
+// returns the result items ordered by the order options, the items are copied before reordering (the result could be cached)
+func (result lookupResult) ordered(query *queryType, client *pdnsClient) interface{} {
+	items, ok := result.items.([]objectType[any])
+	if !ok || len(result.orders) == 0 {
+		return result.items
+	}
+	items = append([]objectType[any](nil), items...)
+	for start, end := 0, 0; start < len(items); start = end {
+		qtype := items[start]["qtype"].(string)
+		for end = start + 1; end < len(items) && items[end]["qtype"] == qtype; end++ {
+		}
+		group := items[start:end]
+		switch result.orders[qtype] {
+		case orderRandom:
+			client.rand().Shuffle(len(group), func(i, j int) {
+				group[i], group[j] = group[j], group[i]
+			})
+		case orderRoundRobin:
+			n := nextRoundRobin(query.name.normal()+keySeparator+qtype) % len(group)
+			copy(group, append(append([]objectType[any](nil), group[n:]...), group[:n]...))
+		case orderWeighted:
+			weights := Map(group, func(item objectType[any], _ int) uint16 {
+				weight, ok := result.weights[qtype][item["content"].(string)]
+				if !ok {
+					client.log.data().WithField("item", item).Warn("no weight for the result item, using 1")
+					return 1
+				}
+				return weight
+			})
+			copy(group, Map(weightedOrder(weights, client.rand()), func(i int, _ int) objectType[any] { return group[i] }))
+		}
+	}
+	return items
+}
+
+// returns the indexes of the weights in a random order, where each next index is picked with a probability proportional
+// to its weight among the remaining ones. indexes with weight 0 come last, in their given order.
+func weightedOrder(weights []uint16, random *rand.Rand) []int {
+	var order, remaining, zero []int
+	total := 0
+	for i, weight := range weights {
+		if weight == 0 {
+			zero = append(zero, i)
+		} else {
+			remaining = append(remaining, i)
+			total += int(weight)
+		}
+	}
+	for len(remaining) > 0 {
+		r := random.Intn(total)
+		pick := len(remaining) - 1 // in case of rounding issues
+		for j, i := range remaining {
+			if r < int(weights[i]) {
+				pick = j
+				break
+			}
+			r -= int(weights[i])
+		}
+		order = append(order, remaining[pick])
+		total -= int(weights[remaining[pick]])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+	return append(order, zero...)
+}
+
+// the content of the record, the priority is part of it except for PowerDNS version 3
+func recordContent(record *recordType, pdnsVersion uint) string {
 	content := record.content
 	if record.priority != nil {
 		content = priorityRE.ReplaceAllStringFunc(content, func(placeholder string) string {
-			if client.PdnsVersion == 3 {
+			if pdnsVersion == 3 {
 				return ""
 			}
 			return fmt.Sprintf(priorityRE.FindStringSubmatch(placeholder)[1], *record.priority)
 		})
 	}
+	return content
+}
+
+// whether the address records of the node are glue (at or beneath a delegation point), which are not authoritative
+func isGlue(qtype string, data *dataNode) bool {
+	return (qtype == "A" || qtype == "AAAA") && data.findDelegation() != nil
+}
+
+func makeResultItem(qtype string, data *dataNode, record *recordType, client *pdnsClient) objectType[any] {
 	zoneNode := data.findZone()
 	result := objectType[any]{
 		"qname":   data.getQname(),
 		"qtype":   qtype,
-		"content": content,
+		"content": recordContent(record, client.PdnsVersion),
 		"ttl":     seconds(record.ttl),
-		"auth":    zoneNode != nil,
+		"auth":    zoneNode != nil && !record.notAuth && !isGlue(qtype, data),
 	}
 	if record.priority != nil && client.PdnsVersion == 3 {
 		result["priority"] = *record.priority
@@ -105,6 +730,25 @@ func makeResultItem(qtype string, data *dataNode, record *recordType, client *pd
 	return result
 }
 
+// synthesizes the redirection of the query name by the DNAME record of owner (RFC 6672), returning the DNAME record itself and the synthesized CNAME record
+func synthesizeDNAME(query *queryType, owner *dataNode, dname *recordType, client *pdnsClient) []objectType[any] {
+	dnameItem := makeResultItem("DNAME", owner, dname, client)
+	var labels []string
+	for depth := query.name.len(); depth > owner.depth(); depth-- {
+		labels = append(labels, escapeLabel(query.name.label(depth), "."))
+	}
+	target := strings.Join(labels, ".") + "."
+	if dname.content != "." {
+		target += dname.content
+	}
+	cname := recordType{content: target, ttl: dname.ttl, version: dname.version, notAuth: dname.notAuth}
+	cnameItem := makeResultItem("CNAME", owner, &cname, client)
+	cnameItem["qname"] = query.name.normal()
+	client.log.pdns().WithField("item", dnameItem).Trace("adding result item")
+	client.log.pdns().WithField("item", cnameItem).Trace("adding synthesized result item")
+	return []objectType[any]{dnameItem, cnameItem}
+}
+
 type searchOrderElement struct {
 	qtype, id string
 }
@@ -161,7 +805,7 @@ func findValue[T any](key, qtype, id string, data *dataNode, values func(*dataNo
 	return zeroValue, nil, nil // not found (and no error)
 }
 
-func findValueOrDefault[V any](key string, values objectType[any], qtype, id string, data *dataNode) (V, *valuePath, error) {
+func findValueOrDefault[V any](key string, values objectType[any], qtype, id string, data *dataNode, notUpwards bool) (V, *valuePath, error) {
 	if value, ok := values[key]; ok {
 		queryPath := valuePath{data, &searchOrderElement{qtype, id}}
 		if value, ok := value.(V); ok {
@@ -172,7 +816,7 @@ func findValueOrDefault[V any](key string, values objectType[any], qtype, id str
 		var zeroValue V
 		return zeroValue, &queryPath, fmt.Errorf("invalid type: %T", value)
 	}
-	return findValue[V](key, qtype, id, data, func(dn *dataNode) map[string]map[string]defoptType { return dn.defaults }, "defaults", false)
+	return findValue[V](key, qtype, id, data, func(dn *dataNode) map[string]map[string]defoptType { return dn.defaults }, "defaults", notUpwards)
 }
 
 func findOptionValue[V any](key, qtype, id string, data *dataNode, notUpwards bool) (V, *valuePath, error) {
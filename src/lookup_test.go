@@ -0,0 +1,841 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDNAMELookup(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	if _, err := storeTestEntry(zone.getChildCreate(testName("old")), "DNAME", "", `="new.example.org."`); err != nil {
+		t.Fatalf("failed to store DNAME: %s", err)
+	}
+	for _, qname := range []string{"www.old.example.net.", "a.b.old.example.net."} {
+		result, err := lookup(objectType[any]{"qname": qname, "qtype": "A"}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", qname, err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok || len(items) != 2 {
+			t.Fatalf("%s: expected 2 result items, got %v", qname, result)
+		}
+		if items[0]["qname"] != "old.example.net." || items[0]["qtype"] != "DNAME" || items[0]["content"] != "new.example.org." {
+			t.Errorf("%s: unexpected DNAME item: %v", qname, items[0])
+		}
+		expectedTarget := qname[:len(qname)-len("old.example.net.")] + "new.example.org."
+		if items[1]["qname"] != qname || items[1]["qtype"] != "CNAME" || items[1]["content"] != expectedTarget {
+			t.Errorf("%s: unexpected CNAME item: %v", qname, items[1])
+		}
+	}
+	// the owner itself is not redirected
+	result, err := lookup(objectType[any]{"qname": "old.example.net.", "qtype": "ANY"}, newTestClient())
+	if err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+	if items, ok := result.([]objectType[any]); !ok || len(items) != 1 || items[0]["qtype"] != "DNAME" {
+		t.Errorf("expected only the DNAME record at its owner, got %v", result)
+	}
+}
+
+func TestDNAMEConflictWarning(t *testing.T) {
+	hook := logtest.NewLocal(log.data())
+	defer hook.Reset()
+	node := newTestZone("example.net.").getChildCreate(testName("old"))
+	node.values["DNAME"] = map[string]valuesType{"": {"old/DNAME", "new.example.org.", false, nil, nil}}
+	node.values["A"] = map[string]valuesType{"": {"old/A", "192.0.2.1", false, nil, nil}}
+	node.processValues()
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && entry.Data["qtype"] == "A" {
+			return
+		}
+	}
+	t.Errorf("expected a warning about DNAME coexisting with A")
+}
+
+func TestLookupZoneID(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	sub := zone.getChildCreate(testName("sub"))
+	setTestSOA(sub)
+	sub.getChildCreate(testName("www")).records["A"] = map[string]recordType{"": {content: "192.0.2.1", ttl: time.Hour}}
+	if zone.zoneID == sub.zoneID {
+		t.Fatalf("zone ids are not distinct")
+	}
+	for _, spec := range []struct {
+		zoneID  int64
+		results int
+	}{
+		{-1, 1},
+		{sub.zoneID, 1},
+		{zone.zoneID, 0},
+	} {
+		result, err := lookup(objectType[any]{"qname": "www.sub.example.net.", "qtype": "A", "zone-id": float64(spec.zoneID)}, newTestClient())
+		if err != nil {
+			t.Fatalf("zone-id %d: lookup failed: %s", spec.zoneID, err)
+		}
+		if spec.results == 0 {
+			if result != false {
+				t.Errorf("zone-id %d: expected false, got %v", spec.zoneID, result)
+			}
+		} else if items, ok := result.([]objectType[any]); !ok || len(items) != spec.results {
+			t.Errorf("zone-id %d: expected %d items, got %v", spec.zoneID, spec.results, result)
+		}
+	}
+}
+
+func TestNotAuthoritative(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	notAuth := func(key string) defoptType {
+		return defoptType{objectType[any]{key: true}, nil}
+	}
+	zone.options["NS"] = map[string]defoptType{"": notAuth(notAAOption)}
+	glue := zone.getChildCreate(testName("glue"))
+	glue.options[""] = map[string]defoptType{"": notAuth(notAuthoritativeOption)}
+	www := zone.getChildCreate(testName("www"))
+	www.options["A"] = map[string]defoptType{"2": notAuth(notAuthoritativeOption)}
+	for _, entry := range []struct {
+		dn                 *dataNode
+		qtype, id, content string
+	}{
+		{zone, "NS", "", `="ns1.example.org."`},
+		{zone, "MX", "", `{"priority": 10, "target": "mail"}`},
+		{glue, "A", "", `192.0.2.53`},
+		{www, "A", "1", `192.0.2.1`},
+		{www, "A", "2", `192.0.2.2`},
+	} {
+		if _, err := storeTestEntry(entry.dn, entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s %s#%s: %s", entry.dn.getQname(), entry.qtype, entry.id, err)
+		}
+	}
+	for _, spec := range []struct {
+		qname, qtype string
+		auth         map[string]bool // content → auth
+	}{
+		{"example.net.", "NS", map[string]bool{"ns1.example.org.": false}},
+		{"example.net.", "MX", map[string]bool{"10 mail.example.net.": true}},
+		{"glue.example.net.", "A", map[string]bool{"192.0.2.53": false}},
+		{"www.example.net.", "A", map[string]bool{"192.0.2.1": true, "192.0.2.2": false}},
+	} {
+		result, err := lookup(objectType[any]{"qname": spec.qname, "qtype": spec.qtype}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s %s: lookup failed: %s", spec.qname, spec.qtype, err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok || len(items) != len(spec.auth) {
+			t.Fatalf("%s %s: expected %d result items, got %v", spec.qname, spec.qtype, len(spec.auth), result)
+		}
+		for _, item := range items {
+			if auth, ok := spec.auth[item["content"].(string)]; !ok || item["auth"] != auth {
+				t.Errorf("%s %s: unexpected result item %v", spec.qname, spec.qtype, item)
+			}
+		}
+	}
+}
+
+func TestGlueNotAuthoritative(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	sub := zone.getChildCreate(testName("sub"))
+	nested := zone.getChildCreate(testName("nested"))
+	setTestSOA(nested)
+	for _, entry := range []struct {
+		dn                 *dataNode
+		qtype, id, content string
+	}{
+		{zone, "NS", "", `="ns1.example.net."`},
+		{zone.getChildCreate(testName("ns1")), "A", "", `192.0.2.1`},
+		{sub, "NS", "", `="ns.sub.example.net."`},
+		{sub, "A", "", `192.0.2.2`},
+		{sub, "TXT", "", `glue?`},
+		{sub.getChildCreate(testName("ns")), "A", "", `192.0.2.3`},
+		{sub.getChildCreate(testName("ns")), "AAAA", "", `2001:db8::3`},
+		{nested, "NS", "", `="ns.nested.example.net."`},
+		{nested.getChildCreate(testName("ns")), "A", "", `192.0.2.4`},
+	} {
+		if _, err := storeTestEntry(entry.dn, entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s %s#%s: %s", entry.dn.getQname(), entry.qtype, entry.id, err)
+		}
+	}
+	for _, spec := range []struct {
+		qname, qtype string
+		auth         bool
+	}{
+		{"example.net.", "NS", true},
+		{"ns1.example.net.", "A", true},
+		{"sub.example.net.", "A", false},
+		{"sub.example.net.", "TXT", true},
+		{"ns.sub.example.net.", "A", false},
+		{"ns.sub.example.net.", "AAAA", false},
+		{"ns.nested.example.net.", "A", true}, // in the nested zone
+	} {
+		result, err := lookup(objectType[any]{"qname": spec.qname, "qtype": spec.qtype}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s %s: lookup failed: %s", spec.qname, spec.qtype, err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok || len(items) != 1 || items[0]["auth"] != spec.auth {
+			t.Errorf("%s %s: expected one result item with auth %v, got %v", spec.qname, spec.qtype, spec.auth, result)
+		}
+	}
+}
+
+func TestMaxAnswers(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for i := 1; i <= 5; i++ {
+		if _, err := storeTestEntry(www, "A", strconv.Itoa(i), fmt.Sprintf("192.0.2.%d", i)); err != nil {
+			t.Fatalf("failed to store A: %s", err)
+		}
+	}
+	if _, err := storeTestEntry(www, "TXT", "", `"text"`); err != nil {
+		t.Fatalf("failed to store TXT: %s", err)
+	}
+	limit := 4
+	args.MaxAnswers = &limit
+	defer func() { args.MaxAnswers = nil }()
+	client := newTestClient()
+	hook := logtest.NewLocal(client.log.data())
+	for _, spec := range []struct {
+		qtype     string
+		count     int
+		truncated bool
+	}{
+		{"ANY", 4, true},
+		{"A", 4, true},
+		{"TXT", 1, false},
+	} {
+		hook.Reset()
+		result, err := lookup(objectType[any]{"qname": "www.example.net.", "qtype": spec.qtype}, client)
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", spec.qtype, err)
+		}
+		if items, ok := result.([]objectType[any]); !ok || len(items) != spec.count {
+			t.Errorf("%s: expected %d result items, got %v", spec.qtype, spec.count, result)
+		}
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || (entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, maxAnswersParam))
+		}
+		if warned != spec.truncated {
+			t.Errorf("%s: expected a warning %v, got %v", spec.qtype, spec.truncated, warned)
+		}
+	}
+}
+
+func TestLookupOrder(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	base := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.5"}
+	for _, order := range []string{orderAsIs, orderRandom, orderRoundRobin, "invalid"} {
+		dn := zone.getChildCreate(testName(order))
+		dn.options["A"] = map[string]defoptType{"": {objectType[any]{orderOption: order}, nil}}
+		for i, ip := range base {
+			if _, err := storeTestEntry(dn, "A", fmt.Sprint(i), ip); err != nil {
+				t.Fatalf("failed to store %s: %s", ip, err)
+			}
+		}
+	}
+	lookupIPs := func(name string) []string {
+		result, err := lookup(objectType[any]{"qname": name + ".example.net.", "qtype": "A"}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s: lookup failed: %s", name, err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok {
+			t.Fatalf("%s: unexpected result %v", name, result)
+		}
+		return Map(items, func(item objectType[any], _ int) string { return item["content"].(string) })
+	}
+	for _, order := range []string{orderAsIs, "invalid"} {
+		for i := 0; i < 3; i++ {
+			if ips := lookupIPs(order); !equal(ips, base) {
+				t.Errorf("%s: expected %v, got %v", order, base, ips)
+			}
+		}
+	}
+	permuted := false
+	for i := 0; i < 50 && !permuted; i++ {
+		ips := lookupIPs(orderRandom)
+		permuted = !equal(ips, base)
+		sort.Strings(ips)
+		if !equal(ips, base) {
+			t.Fatalf("%s: expected a permutation of %v, got %v", orderRandom, base, ips)
+		}
+	}
+	if !permuted {
+		t.Errorf("%s: expected a permuted order in 50 lookups", orderRandom)
+	}
+	// the rotation advances also for cached results
+	lookupCache = newResultCache(10)
+	defer func() { lookupCache = nil }()
+	previous := lookupIPs(orderRoundRobin)
+	for i := 0; i < 2*len(base); i++ {
+		ips := lookupIPs(orderRoundRobin)
+		if expected := append(previous[1:], previous[0]); !equal(ips, expected) {
+			t.Fatalf("%s: expected %v, got %v", orderRoundRobin, expected, ips)
+		}
+		previous = ips
+	}
+}
+
+func TestLookupOrderWeighted(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	defer func(seed *int) { args.OrderSeed = seed }(args.OrderSeed)
+	seed := 1
+	args.OrderSeed = &seed
+	dn := zone.getChildCreate(testName("weighted"))
+	dn.options["A"] = map[string]defoptType{"": {objectType[any]{orderOption: orderWeighted}, nil}}
+	weights := map[string]float64{"192.0.2.1": 1, "192.0.2.2": 3, "192.0.2.3": 0, "192.0.2.4": 6}
+	i := 0
+	for _, ip := range sortedKeys(weights) {
+		if _, err := storeTestEntry(dn, "A", fmt.Sprint(i), fmt.Sprintf(`{"ip": %q, "weight": %v}`, ip, weights[ip])); err != nil {
+			t.Fatalf("failed to store %s: %s", ip, err)
+		}
+		i++
+	}
+	lookupItems := func(client *pdnsClient) []objectType[any] {
+		result, err := lookup(objectType[any]{"qname": "weighted.example.net.", "qtype": "A"}, client)
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		items, ok := result.([]objectType[any])
+		if !ok || len(items) != len(weights) {
+			t.Fatalf("unexpected result %v", result)
+		}
+		return items
+	}
+	const lookups = 10000
+	first := map[string]int{}
+	client := newTestClient()
+	for i := 0; i < lookups; i++ {
+		items := lookupItems(client)
+		if last := items[len(items)-1]["content"]; last != "192.0.2.3" {
+			t.Fatalf("expected the record with weight 0 last, got %v", last)
+		}
+		first[items[0]["content"].(string)]++
+	}
+	for ip, weight := range weights {
+		expected := weight / 10
+		if actual := float64(first[ip]) / lookups; math.Abs(actual-expected) > 0.02 {
+			t.Errorf("%s: expected to be first in %.2f of the lookups, got %.3f", ip, expected, actual)
+		}
+	}
+	// the same seed and client id give the same orders
+	client1, client2 := newTestClient(), newTestClient()
+	for i := 0; i < 10; i++ {
+		items1, items2 := lookupItems(client1), lookupItems(client2)
+		for j := range items1 {
+			if items1[j]["content"] != items2[j]["content"] {
+				t.Fatalf("lookup %d: expected the same order, got %v and %v", i, items1, items2)
+			}
+		}
+	}
+	// invalid weights
+	for i, weight := range []string{"-1", "65536", `"1"`} {
+		if _, err := storeTestEntry(dn, "A", fmt.Sprint("x", i), fmt.Sprintf(`{"ip": "192.0.2.9", "weight": %s}`, weight)); err == nil {
+			t.Errorf("weight %v: expected an error", weight)
+		}
+	}
+}
+
+func TestLookupTimings(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	if _, err := storeTestEntry(zone.getChildCreate(testName("www")), "A", "", `192.0.2.1`); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient()
+	client.log.main().SetOutput(io.Discard)
+	hook := logtest.NewLocal(client.log.main())
+	request := pdnsRequest{"lookup", objectType[any]{"qname": "www.example.net.", "qtype": "A"}}
+	for _, trace := range []bool{false, true} {
+		hook.Reset()
+		level := logrus.DebugLevel
+		if trace {
+			level = logrus.TraceLevel
+		}
+		client.log.main().SetLevel(level)
+		handleRequest(&request, client)
+		fields := logrus.Fields{}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "result" {
+				fields = entry.Data
+			}
+		}
+		for _, phase := range []string{"cache", "load", "walk", "assemble", "order", "lookup", "respond"} {
+			if _, ok := fields["t-"+phase].(time.Duration); ok != trace {
+				t.Errorf("trace=%v: expected field t-%s: %v, got %v", trace, phase, trace, fields)
+			}
+		}
+	}
+}
+
+func TestAliasLookup(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	web := zone.getChildCreate(testName("web"))
+	for _, entry := range []struct {
+		dn                 *dataNode
+		qtype, id, content string
+	}{
+		{zone, "ALIAS", "", `="web"`},
+		{zone, "MX", "", `{"priority": 10, "target": "mail"}`},
+		{web, "A", "1", `192.0.2.1`},
+		{web, "A", "2", `192.0.2.2`},
+		{web, "AAAA", "", `2001:db8::1`},
+	} {
+		if _, err := storeTestEntry(entry.dn, entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s %s#%s: %s", entry.dn.getQname(), entry.qtype, entry.id, err)
+		}
+	}
+	// local target: flattened
+	if contents := lookupContents(t, "example.net.", "A"); !equal(contents, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("expected the A records of the target, got %q", contents)
+	}
+	if contents := lookupContents(t, "example.net.", "AAAA"); !equal(contents, []string{"2001:db8::1"}) {
+		t.Errorf("expected the AAAA record of the target, got %q", contents)
+	}
+	result, err := lookup(objectType[any]{"qname": "example.net.", "qtype": "ANY"}, newTestClient())
+	if err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+	items, _ := result.([]objectType[any])
+	var qtypes []string
+	for _, item := range items {
+		if item["qname"] != "example.net." {
+			t.Errorf("unexpected qname of item %v", item)
+		}
+		qtypes = append(qtypes, item["qtype"].(string))
+	}
+	if !equal(qtypes, []string{"A", "A", "AAAA", "MX", "SOA"}) {
+		t.Errorf("unexpected QTYPEs of ANY result: %q", qtypes)
+	}
+	// non-local target: passed through
+	if _, err := storeTestEntry(zone, "ALIAS", "", `="web.example.org."`); err != nil {
+		t.Fatalf("failed to store ALIAS: %s", err)
+	}
+	result, err = lookup(objectType[any]{"qname": "example.net.", "qtype": "A"}, newTestClient())
+	if err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+	if items, ok := result.([]objectType[any]); !ok || len(items) != 1 || items[0]["qtype"] != "ALIAS" || items[0]["content"] != "web.example.org." {
+		t.Errorf("expected the ALIAS record itself, got %v", result)
+	}
+}
+
+func TestMinimalAny(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for _, entry := range []struct{ qtype, content string }{
+		{"A", `192.0.2.1`},
+		{"AAAA", `2001:db8::1`},
+		{"TXT", `hello`},
+	} {
+		if _, err := storeTestEntry(www, entry.qtype, "", entry.content); err != nil {
+			t.Fatalf("failed to store %s: %s", entry.qtype, err)
+		}
+	}
+	qtypes := func() []string {
+		result, err := lookup(objectType[any]{"qname": "www.example.net.", "qtype": "ANY"}, newTestClient())
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		var qtypes []string
+		if items, ok := result.([]objectType[any]); ok {
+			for _, item := range items {
+				qtypes = append(qtypes, item["qtype"].(string))
+			}
+		}
+		return qtypes
+	}
+	if actual := qtypes(); !equal(actual, []string{"A", "AAAA", "TXT"}) {
+		t.Errorf("expected the full ANY answer by default, got %q", actual)
+	}
+	zone.options["ANY"] = map[string]defoptType{"": {objectType[any]{minimalAnyOption: true}, nil}}
+	if actual := qtypes(); !equal(actual, []string{"HINFO"}) {
+		t.Errorf("expected the minimal ANY answer, got %q", actual)
+	}
+	if contents := lookupContents(t, "www.example.net.", "ANY"); !equal(contents, []string{`"RFC8482" ""`}) {
+		t.Errorf("unexpected minimal ANY content: %q", contents)
+	}
+	if contents := lookupContents(t, "www.example.net.", "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected other queries to be unaffected, got %q", contents)
+	}
+	zone.options["ANY"] = map[string]defoptType{"": {objectType[any]{minimalAnyOption: false}, nil}}
+	if actual := qtypes(); !equal(actual, []string{"A", "AAAA", "TXT"}) {
+		t.Errorf("expected the full ANY answer with the option off, got %q", actual)
+	}
+}
+
+func TestNoDataLookup(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	if _, err := storeTestEntry(zone.getChildCreate(testName("www")), "A", "", `192.0.2.1`); err != nil {
+		t.Fatalf("failed to store A: %s", err)
+	}
+	// a.example.net. is an empty non-terminal
+	if _, err := storeTestEntry(zone.getChildCreate(testName("b.a")), "TXT", "", `"text"`); err != nil {
+		t.Fatalf("failed to store TXT: %s", err)
+	}
+	// c.example.net. and d.c.example.net. exist in the tree, but without any records
+	zone.getChildCreate(testName("d.c")).options["A"] = map[string]defoptType{"": {objectType[any]{"ttl": "1m"}, nil}}
+	for _, spec := range []struct {
+		qname, qtype string
+		response     string
+	}{
+		{"www.example.net.", "AAAA", `{"result":[]}`},   // NODATA
+		{"a.example.net.", "A", `{"result":[]}`},        // NODATA (empty non-terminal)
+		{"nx.example.net.", "A", `{"result":false}`},    // NXDOMAIN
+		{"x.www.example.net.", "A", `{"result":false}`}, // NXDOMAIN
+		{"c.example.net.", "A", `{"result":false}`},     // NXDOMAIN (no records below)
+		{"d.c.example.net.", "A", `{"result":false}`},   // NXDOMAIN (only options)
+	} {
+		result, err := lookup(objectType[any]{"qname": spec.qname, "qtype": spec.qtype}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s %s: lookup failed: %s", spec.qname, spec.qtype, err)
+		}
+		response, err := json.Marshal(makeResponse(result))
+		if err != nil {
+			t.Fatalf("%s %s: failed to encode the response: %s", spec.qname, spec.qtype, err)
+		}
+		if string(response) != spec.response {
+			t.Errorf("%s %s: expected %s, got %s", spec.qname, spec.qtype, spec.response, response)
+		}
+	}
+}
+
+func TestNameCase(t *testing.T) {
+	newTestETCD("dns/", nil)
+	defer func() { args.NameCase = nil }()
+	for _, spec := range []struct {
+		policy  string
+		found   []string
+		missing []string
+	}{
+		{nameCaseFold, []string{"www.example.net.", "WWW.Example.NET.", "wWw.eXaMpLe.nEt."}, nil},
+		{nameCasePreserveStrict, []string{"WwW.Example.net."}, []string{"www.example.net.", "WWW.EXAMPLE.NET."}},
+	} {
+		args.NameCase = strPtr(spec.policy)
+		if name, _, _, _, _, _ := parseEntryKey("dns/net.Example/WwW/A"); spec.policy == nameCaseFold && name.normal() != "www.example.net." {
+			t.Errorf("%s: expected the folded name, got %q", spec.policy, name.normal())
+		}
+		items := make(chan etcdItem, 3)
+		items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+		items <- etcdItem{"dns/net.Example/SOA", []byte(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`), 1}
+		items <- etcdItem{"dns/net.Example/WwW/A", []byte(`192.0.2.1`), 1}
+		close(items)
+		dataRoot.Store(newDataNode(nil, "", ""))
+		dataRoot.Load().reload(items)
+		// the names are served and stored as given, only matched by the policy
+		result, _ := lookup(objectType[any]{"qname": spec.found[0], "qtype": "A"}, newTestClient())
+		if results, ok := result.([]objectType[any]); !ok || len(results) != 1 || results[0]["qname"] != "WwW.Example.net." {
+			t.Errorf("%s: expected the owner name as given, got %v", spec.policy, result)
+		}
+		if key := dataRoot.Load().getChild(parseName(spec.found[0]), false).prefixKey(); key != "net.Example/WwW/" {
+			t.Errorf("%s: expected the key prefix as given, got %q", spec.policy, key)
+		}
+		for _, qname := range spec.found {
+			if contents := lookupContents(t, qname, "A"); !equal(contents, []string{"192.0.2.1"}) {
+				t.Errorf("%s: expected the record for %s, got %v", spec.policy, qname, contents)
+			}
+		}
+		for _, qname := range spec.missing {
+			if contents := lookupContents(t, qname, "A"); len(contents) != 0 {
+				t.Errorf("%s: expected no record for %s, got %v", spec.policy, qname, contents)
+			}
+		}
+	}
+}
+
+func TestServedZones(t *testing.T) {
+	soa := `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`
+	kv, _ := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":            `{"ttl": 3600}`,
+		"dns/net.example/SOA":       soa,
+		"dns/net.example/www/A":     `192.0.2.1`,
+		"dns/net.example/sub/SOA":   soa,
+		"dns/net.example/sub/www/A": `192.0.2.2`,
+		"dns/org.example/SOA":       soa,
+		"dns/org.example/www/A":     `192.0.2.3`,
+		"dns/com.example/www/A":     `192.0.2.4`, // outside of any zone
+	})
+	if _, err := loadData("test"); err != nil {
+		t.Fatalf("loadData() failed: %s", err)
+	}
+	drainKeys(kv)
+	args.Zones = strPtr(" example.NET ,")
+	defer func() { args.Zones = nil }()
+	for qname, expected := range map[string][]string{
+		"www.example.net.":     {"192.0.2.1"},
+		"www.sub.example.net.": nil,
+		"www.example.org.":     nil,
+		"www.example.com.":     nil,
+	} {
+		if contents := lookupContents(t, qname, "A"); !equal(contents, expected) {
+			t.Errorf("%s: expected %v, got %v", qname, expected, contents)
+		}
+	}
+	result, err := getAllDomains(objectType[any]{}, newTestClient())
+	if domains, ok := result.([]objectType[any]); err != nil || !ok || len(domains) != 1 || domains[0]["zone"] != "example.net." {
+		t.Errorf("expected only the zone example.net., got %v (%v)", result, err)
+	}
+	for _, zonename := range []string{"sub.example.net.", "example.org."} {
+		if result, err := list(objectType[any]{"zonename": zonename}, newTestClient()); err != nil || result != false {
+			t.Errorf("%s: expected no zone transfer, got %v (%v)", zonename, result, err)
+		}
+	}
+	for name, expected := range map[string]bool{".": false, "net.": false, "www.example.net.": false, "example.org.": true, "com.": true} {
+		if outside := isOutsideServedZones(parseName(name)); outside != expected {
+			t.Errorf("%s: expected outside %v, got %v", name, expected, outside)
+		}
+	}
+	// the event would reload the zone example.org.
+	key := "dns/org.example/-defaults-"
+	kv.entries[key] = `{"ttl": 60}`
+	handleEvent("", &clientv3.Event{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(`{"ttl": 60}`), CreateRevision: 2, ModRevision: 2}})
+	if keys := drainKeys(kv); len(keys) != 0 {
+		t.Errorf("expected the event to be ignored, got the reload of %v", keys)
+	}
+}
+
+func TestPTRTemplate(t *testing.T) {
+	zone := newTestZone("2.0.192.in-addr.arpa.")
+	dataRoot.Store(zone)
+	for !dataRoot.Load().isRoot() {
+		dataRoot.Store(dataRoot.Load().parent)
+	}
+	zone.options["PTR"] = map[string]defoptType{"": {objectType[any]{ptrTemplateOption: "host-%d.dyn.example.net."}, nil}}
+	if _, err := storeTestEntry(zone.getChildCreate(testName("1")), "PTR", "", "gw.example.net."); err != nil {
+		t.Fatalf("failed to store PTR: %s", err)
+	}
+	if _, err := storeTestEntry(zone.getChildCreate(testName("2")), "TXT", "", `"text"`); err != nil {
+		t.Fatalf("failed to store TXT: %s", err)
+	}
+	for _, spec := range []struct {
+		qname, qtype string
+		expected     []string
+	}{
+		{"1.2.0.192.in-addr.arpa.", "PTR", []string{"gw.example.net."}},
+		{"2.2.0.192.in-addr.arpa.", "PTR", []string{"host-2.dyn.example.net."}},
+		{"2.2.0.192.in-addr.arpa.", "ANY", []string{`"text"`, "host-2.dyn.example.net."}},
+		{"42.2.0.192.in-addr.arpa.", "PTR", []string{"host-42.dyn.example.net."}},
+		{"42.2.0.192.in-addr.arpa.", "ANY", []string{"host-42.dyn.example.net."}},
+		{"42.2.0.192.in-addr.arpa.", "A", nil},
+		{"x.42.2.0.192.in-addr.arpa.", "PTR", nil},
+	} {
+		if contents := lookupContents(t, spec.qname, spec.qtype); !equal(contents, spec.expected) {
+			t.Errorf("%s %s: expected %v, got %v", spec.qname, spec.qtype, spec.expected, contents)
+		}
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for _, entry := range []struct{ qtype, content string }{
+		{"A", `192.0.2.1`},
+		{"AAAA", `2001:db8::1`},
+	} {
+		if _, err := storeTestEntry(www, entry.qtype, "", entry.content); err != nil {
+			t.Fatalf("failed to store %s: %s", entry.qtype, err)
+		}
+	}
+	sub := zone.getChildCreate(testName("sub"))
+	setTestSOA(sub)
+	other := zone.parent.parent.getChildCreate(testName("example.org."))
+	setTestSOA(other)
+	for _, dn := range []*dataNode{sub, other} {
+		if _, err := storeTestEntry(dn, "A", "", `192.0.2.2`); err != nil {
+			t.Fatalf("failed to store A of %s: %s", dn.getQname(), err)
+		}
+	}
+	client := newTestClient()
+	// the queries of the zones interleaved, the batch looks them up by zone
+	queries := []any{
+		map[string]any{"qname": "www.example.net.", "qtype": "A"},
+		map[string]any{"qname": "example.org.", "qtype": "A"},
+		map[string]any{"qname": "www.example.net.", "qtype": "ANY"},
+		map[string]any{"qname": "sub.example.net.", "qtype": "A"},
+		map[string]any{"qname": "missing.example.net.", "qtype": "A"},
+		map[string]any{"qname": "example.com.", "qtype": "A"},
+		map[string]any{"qname": "example.net.", "qtype": "SOA"},
+		map[string]any{"qname": "sub.example.net.", "qtype": "SOA"},
+	}
+	if _, err := lookupBatch(objectType[any]{"queries": queries}, client); err == nil {
+		t.Errorf("expected an error without parameter %s", lookupBatchParam)
+	}
+	enabled := true
+	args.LookupBatch = &enabled
+	defer func() { args.LookupBatch = nil }()
+	result, err := lookupBatch(objectType[any]{"queries": queries}, client)
+	if err != nil {
+		t.Fatalf("lookupBatch failed: %s", err)
+	}
+	results, ok := result.([]interface{})
+	if !ok || len(results) != len(queries) {
+		t.Fatalf("expected %d results, got %v", len(queries), result)
+	}
+	if root := zone.parent.parent; !root.mutex.TryLock() {
+		t.Fatal("expected the data to be unlocked after the batch")
+	} else {
+		root.mutex.Unlock()
+	}
+	for i, query := range queries {
+		expected, err := lookup(objectType[any](query.(map[string]any)), client)
+		if err != nil {
+			t.Fatalf("lookup #%d failed: %s", i+1, err)
+		}
+		if fmt.Sprint(results[i]) != fmt.Sprint(expected) {
+			t.Errorf("query #%d: expected %v, got %v", i+1, expected, results[i])
+		}
+	}
+	for _, invalid := range []any{"www.example.net.", []any{"www.example.net."}, []any{map[string]any{"qname": "www.example.net."}}} {
+		if _, err := lookupBatch(objectType[any]{"queries": invalid}, client); err == nil {
+			t.Errorf("expected an error for the queries %v", invalid)
+		}
+	}
+}
+
+func TestViews(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for _, entry := range []struct{ qtype, id, content string }{
+		{"A", "int", `{"ip": "10.0.0.5", "view": ["10.0.0.0/8", "192.168.0.0/16"]}`},
+		{"A", "pub", `192.0.2.5`},
+		{"TXT", "", `"text"`},
+	} {
+		if _, err := storeTestEntry(www, entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s#%s: %s", entry.qtype, entry.id, err)
+		}
+	}
+	if _, err := storeTestEntry(www, "AAAA", "", `{"ip": "2001:db8::1", "view": "no network"}`); err == nil {
+		t.Errorf("expected an invalid view to be rejected")
+	}
+	for _, spec := range []struct {
+		params   objectType[any]
+		expected []string
+	}{
+		{objectType[any]{"qtype": "A", "remote": "10.1.2.3"}, []string{"10.0.0.5"}},
+		{objectType[any]{"qtype": "A", "remote": "198.51.100.1", "real-remote": "192.168.1.0/24"}, []string{"10.0.0.5"}},
+		{objectType[any]{"qtype": "A", "remote": "198.51.100.1"}, []string{"192.0.2.5"}},
+		{objectType[any]{"qtype": "A"}, []string{"192.0.2.5"}},
+		{objectType[any]{"qtype": "ANY", "remote": "10.1.2.3"}, []string{"10.0.0.5", `"text"`}},
+		{objectType[any]{"qtype": "TXT", "remote": "10.1.2.3"}, []string{`"text"`}},
+	} {
+		spec.params["qname"] = "www.example.net."
+		result, err := lookup(spec.params, newTestClient())
+		if err != nil {
+			t.Fatalf("%v: lookup failed: %s", spec.params, err)
+		}
+		var contents []string
+		if items, ok := result.([]objectType[any]); ok {
+			for _, item := range items {
+				contents = append(contents, item["content"].(string))
+			}
+		}
+		if !equal(contents, spec.expected) {
+			t.Errorf("%v: expected %v, got %v", spec.params, spec.expected, contents)
+		}
+	}
+}
+
+func TestLookupRemoteLogged(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	if _, err := storeTestEntry(zone.getChildCreate(testName("www")), "A", "", `192.0.2.1`); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient()
+	client.log.pdns().SetOutput(io.Discard)
+	client.log.pdns().SetLevel(logrus.TraceLevel)
+	hook := logtest.NewLocal(client.log.pdns())
+	params := objectType[any]{"qname": "www.example.net.", "qtype": "A", "remote": "198.51.100.1", "real-remote": "198.51.100.0/24"}
+	if _, err := lookup(params, client); err != nil {
+		t.Fatalf("lookup failed: %s", err)
+	}
+	var fields logrus.Fields
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "lookup of ") {
+			fields = entry.Data
+		}
+	}
+	if fields["remote"] != "198.51.100.1" || fields["real-remote"] != "198.51.100.0/24" {
+		t.Errorf("expected the remote fields in the lookup log entry, got %v", fields)
+	}
+}
+
+func TestAnyTypes(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	www := zone.getChildCreate(testName("www"))
+	for _, entry := range []struct{ qtype, content string }{
+		{"A", `192.0.2.1`},
+		{"AAAA", `2001:db8::1`},
+		{"TXT", `"text"`},
+		{"HINFO", `"cpu" "os"`},
+	} {
+		if _, err := storeTestEntry(www, entry.qtype, "", entry.content); err != nil {
+			t.Fatalf("failed to store %s: %s", entry.qtype, err)
+		}
+	}
+	for _, spec := range []struct {
+		option   any // nil = not set
+		expected []string
+	}{
+		{nil, []string{"A", "AAAA", "HINFO", "TXT"}},
+		{[]any{"A", "aaaa", "MX", "TXT"}, []string{"A", "AAAA", "TXT"}},
+		{"A, TXT", []string{"A", "TXT"}},
+		{"MX", nil},
+	} {
+		delete(zone.options, "ANY")
+		if spec.option != nil {
+			zone.options["ANY"] = map[string]defoptType{"": {objectType[any]{anyTypesOption: spec.option}, nil}}
+		}
+		result, err := lookup(objectType[any]{"qname": "www.example.net.", "qtype": "ANY"}, newTestClient())
+		if err != nil {
+			t.Fatalf("%v: lookup failed: %s", spec.option, err)
+		}
+		var qtypes []string
+		if items, ok := result.([]objectType[any]); ok {
+			for _, item := range items {
+				qtypes = append(qtypes, item["qtype"].(string))
+			}
+		}
+		if !equal(qtypes, spec.expected) {
+			t.Errorf("%v: expected %v, got %v", spec.option, spec.expected, qtypes)
+		}
+	}
+}
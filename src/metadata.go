@@ -0,0 +1,93 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parses the metadata values of a kind, which are given as a string or an array of strings
+func parseMetadataValues(value any) ([]string, error) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case []any:
+		values := make([]string, 0, len(value))
+		for i, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("value #%d: invalid type (not a string): %T", i+1, v)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("invalid value type (neither a string nor an array): %T", value)
+	}
+}
+
+// the metadata of a zone is searched for from the zone domain upwards, the first level with a value for a kind wins
+func findMetadata(name string, client *pdnsClient, f func(dn *dataNode)) {
+	qname := parseName(name)
+	ensureLoaded(qname)
+	data := dataRoot.Load().getChild(qname, true)
+	defer data.rUnlockUpwards(nil)
+	if data.depth() < qname.len() || !data.hasSOA() {
+		client.log.data().Debugf("no such zone: %q", qname.normal())
+		return
+	}
+	for dn := data; dn != nil; dn = dn.parent {
+		f(dn)
+	}
+}
+
+func getDomainMetadata(params objectType[any], client *pdnsClient) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	kind, ok := params["kind"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'kind'")
+	}
+	kind = strings.ToUpper(kind)
+	result := []string{}
+	found := false
+	findMetadata(name, client, func(dn *dataNode) {
+		if values, ok := dn.metadata[kind]; ok && !found {
+			client.log.data().WithField("values", values).Tracef("found metadata %s for %q in %q", kind, name, dn.getQname())
+			result = values
+			found = true
+		}
+	})
+	return result, nil
+}
+
+func getAllDomainMetadata(params objectType[any], client *pdnsClient) (interface{}, error) {
+	name, ok := params["name"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'name'")
+	}
+	result := map[string][]string{}
+	findMetadata(name, client, func(dn *dataNode) {
+		for kind, values := range dn.metadata {
+			if _, ok := result[kind]; !ok {
+				result[kind] = values
+			}
+		}
+	})
+	return result, nil
+}
@@ -0,0 +1,111 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"testing"
+)
+
+var testMetadataEntries = map[string]string{
+	"-defaults-":                      `{"ttl": 3600}`,
+	"net.example/SOA":                 `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+	"net.example/-metadata-/SOA-EDIT": `=["INCEPTION-INCREMENT"]`,
+	"-metadata-/ALLOW-AXFR-FROM":      `=["192.0.2.0/24", "2001:db8::/32"]`,
+	"net/-metadata-/SOA-EDIT":         `="EPOCH"`,
+}
+
+func TestGetDomainMetadata(t *testing.T) {
+	loadTestData(testMetadataEntries)
+	for _, spec := range []struct {
+		name, kind string
+		expected   []string
+	}{
+		{"example.net.", "SOA-EDIT", []string{"INCEPTION-INCREMENT"}},
+		{"example.net.", "soa-edit", []string{"INCEPTION-INCREMENT"}},
+		{"example.net.", "ALLOW-AXFR-FROM", []string{"192.0.2.0/24", "2001:db8::/32"}},
+		{"example.net.", "PRESIGNED", []string{}},
+		{"www.example.net.", "SOA-EDIT", []string{}},
+		{"example.org.", "SOA-EDIT", []string{}},
+	} {
+		result, err := getDomainMetadata(objectType[any]{"name": spec.name, "kind": spec.kind}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s/%s: failed: %s", spec.name, spec.kind, err)
+		}
+		if values, ok := result.([]string); !ok || !equal(values, spec.expected) {
+			t.Errorf("%s/%s: expected %v, got %#v", spec.name, spec.kind, spec.expected, result)
+		}
+	}
+}
+
+func TestGetAllDomainMetadata(t *testing.T) {
+	loadTestData(testMetadataEntries)
+	result, err := getAllDomainMetadata(objectType[any]{"name": "example.net."}, newTestClient())
+	if err != nil {
+		t.Fatalf("failed: %s", err)
+	}
+	metadata, ok := result.(map[string][]string)
+	if !ok || len(metadata) != 2 || !equal(metadata["SOA-EDIT"], []string{"INCEPTION-INCREMENT"}) || !equal(metadata["ALLOW-AXFR-FROM"], []string{"192.0.2.0/24", "2001:db8::/32"}) {
+		t.Errorf("unexpected result: %#v", result)
+	}
+}
+
+func TestMetadataEntries(t *testing.T) {
+	for _, spec := range []struct {
+		key   string
+		qtype string
+		err   bool
+	}{
+		{"net.example/-metadata-", "", false},
+		{"net.example/-metadata-/SOA-EDIT", "SOA-EDIT", false},
+		{"net.example/-metadata-/PRESIGNED@0.1", "PRESIGNED", false},
+		{"net.example/-metadata-/SOA-EDIT#1", "", true},
+	} {
+		prefix := ""
+		args.Prefix = &prefix
+		name, entryType, qtype, _, _, err := parseEntryKey(spec.key)
+		if spec.err {
+			if err == nil {
+				t.Errorf("%s: expected error", spec.key)
+			}
+			continue
+		}
+		if err != nil || entryType != metadataEntry || qtype != spec.qtype || name.normal() != "example.net." {
+			t.Errorf("%s: unexpected result: name=%s entryType=%s qtype=%q err=%v", spec.key, name.normal(), entryType, qtype, err)
+		}
+	}
+	root := loadTestData(map[string]string{
+		"net.example/-metadata-":           `{"SOA-EDIT": ["INCEPTION-INCREMENT"], "PRESIGNED": "1", "ALSO-NOTIFY": ["192.0.2.1", "192.0.2.2"], "invalid": "x"}`,
+		"net.example/-metadata-/PRESIGNED": `=["0"]`,
+		"net.example/www/-metadata-/X-A":   `="b"`,
+		"net.example/-metadata-/X-B":       `{"invalid": "object"}`,
+	})
+	node := root.getChild(parseName("example.net."), false)
+	expected := map[string][]string{
+		"SOA-EDIT":    {"INCEPTION-INCREMENT"},
+		"PRESIGNED":   {"0"},
+		"ALSO-NOTIFY": {"192.0.2.1", "192.0.2.2"},
+	}
+	if len(node.metadata) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, node.metadata)
+	}
+	for kind, values := range expected {
+		if !equal(node.metadata[kind], values) {
+			t.Errorf("%s: expected %v, got %v", kind, values, node.metadata[kind])
+		}
+	}
+	if www := node.getChild(parseName("www."), false); !equal(www.metadata["X-A"], []string{"b"}) {
+		t.Errorf("www: unexpected metadata %v", www.metadata)
+	}
+}
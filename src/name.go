@@ -14,13 +14,131 @@ limitations under the License. */
 
 package src
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
 type namePart struct {
-	name      string
+	name      string // folded by parameter name-case (see foldCase()), for matching
 	keyPrefix string
+	label     string // as given, for the output and the keys
 }
 
 type nameType []namePart // in reversed form (storage form)
 
+// folds the case of a name (or label) according to the parameter name-case. the names are folded on parsing (entry keys
+// and queried names) for matching them, the labels are kept as given for the output (see namePart).
+func foldCase(name string) string {
+	if args.NameCase != nil && *args.NameCase == nameCasePreserveStrict {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// the characters escaped in the labels of entry keys (besides those of the presentation form, see escapeLabel())
+func keySpecialChars() string {
+	return "." + keySeparator + idSeparator + versionSeparator
+}
+
+// sets the separators of the entry keys by the parameters key-sep, id-sep and version-sep (unset ones are the defaults).
+// each must be a single character, distinct from the others, the dot, the backslash and the label prefix, and must not
+// be a character of the names, QTYPEs or special keys (letters, digits and the hyphen).
+func setSeparators() error {
+	separators := []struct {
+		param, value string
+		target       *string
+	}{
+		{keySepParam, defaultKeySeparator, &keySeparator},
+		{idSepParam, defaultIDSeparator, &idSeparator},
+		{versionSepParam, defaultVersionSeparator, &versionSeparator},
+	}
+	for i, value := range []*string{args.KeySep, args.IDSep, args.VersionSep} {
+		if value != nil && *value != "" {
+			separators[i].value = *value
+		}
+	}
+	used := map[string]string{".": "the dot", "\\": "the backslash", labelPrefix: "the label prefix"}
+	for _, sep := range separators {
+		if utf8.RuneCountInString(sep.value) != 1 {
+			return fmt.Errorf("invalid parameter %s: not a single character: %q", sep.param, sep.value)
+		}
+		if r, _ := utf8.DecodeRuneInString(sep.value); unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+			return fmt.Errorf("invalid parameter %s: must not be a letter, digit or hyphen: %q", sep.param, sep.value)
+		}
+		if other, ok := used[sep.value]; ok {
+			return fmt.Errorf("invalid parameter %s: %q collides with %s", sep.param, sep.value, other)
+		}
+		used[sep.value] = sep.param
+	}
+	for _, sep := range separators {
+		*sep.target = sep.value
+	}
+	return nil
+}
+
+// escapes the label (which is stored unescaped) for the presentation form (like PowerDNS does): a backslash before
+// itself and the special characters, \DDD (decimal) for the non-printable characters (including space)
+func escapeLabel(label, special string) string {
+	var sb strings.Builder
+	for i := 0; i < len(label); i++ {
+		switch c := label[i]; {
+		case c == '\\' || strings.IndexByte(special, c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c <= ' ' || c >= 0x7f:
+			sb.WriteString(fmt.Sprintf("\\%03d", c))
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// the inverse of escapeLabel(): \DDD is the character with the decimal code DDD, \X is the character X
+func unescapeLabel(label string) string {
+	if !strings.Contains(label, "\\") {
+		return label
+	}
+	var sb strings.Builder
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if c == '\\' && i+1 < len(label) {
+			if i+3 < len(label) && isDigits(label[i+1:i+4]) {
+				if code, err := strconv.Atoi(label[i+1 : i+4]); err == nil && code <= 255 {
+					sb.WriteByte(byte(code))
+					i += 3
+					continue
+				}
+			}
+			i++
+			c = label[i]
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parse a domain name in normal form (the key prefixes are empty, they are not needed for queries)
+func parseName(name string) nameType {
+	return nameType(Map(reversed(splitDomainName(name, ".")), func(name string, _ int) namePart {
+		label := unescapeLabel(name)
+		return namePart{foldCase(label), "", label}
+	}))
+}
+
 func (name *nameType) String() string {
 	return name.normal()
 }
@@ -36,6 +154,14 @@ func (name *nameType) lname(depth int) string {
 	return (*name)[depth-1].name
 }
 
+// the label as given (see namePart)
+func (name *nameType) label(depth int) string {
+	if depth == 0 {
+		return ""
+	}
+	return (*name)[depth-1].label
+}
+
 func (name *nameType) keyPrefix(depth int) string {
 	if depth == 0 {
 		return ""
@@ -54,6 +180,11 @@ func (name *nameType) fromDepth(depth int) nameType {
 	return nameType(parts)
 }
 
+// the ancestor name at the given depth
+func (name *nameType) toDepth(depth int) nameType {
+	return (*name)[:depth]
+}
+
 // get the domain in normal form (with trailing dot)
 func (name *nameType) normal() string {
 	if name.len() == 0 {
@@ -61,7 +192,7 @@ func (name *nameType) normal() string {
 	}
 	ret := ""
 	for depth := name.len(); depth > 0; depth-- {
-		ret += name.lname(depth) + "."
+		ret += escapeLabel(name.lname(depth), ".") + "."
 	}
 	return ret
 }
@@ -73,7 +204,13 @@ func (name *nameType) asKey(withTrailingKeySeparator bool) string {
 	}
 	key := ""
 	for depth := 1; depth <= name.len(); depth++ {
-		key += name.keyPrefix(depth) + name.lname(depth)
+		switch keyPrefix := name.keyPrefix(depth); {
+		case keyPrefix == labelPrefix: // a label of a compact key part, which is contained in the key prefix of its last label
+		case strings.Contains(keyPrefix, labelPrefix): // the last label of a compact key part
+			key += keyPrefix
+		default:
+			key += keyPrefix + escapeLabel(name.label(depth), keySpecialChars())
+		}
 	}
 	if withTrailingKeySeparator {
 		key += keySeparator
@@ -0,0 +1,148 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import "testing"
+
+func TestSplitDomainName(t *testing.T) {
+	for _, spec := range []struct {
+		name     string
+		expected []string
+	}{
+		{"", nil},
+		{".", nil},
+		{"a.b.", []string{"a", "b"}},
+		{"a.b", []string{"a", "b"}},
+		{`a\.b.c.`, []string{`a\.b`, "c"}},
+		{`a\\.b`, []string{`a\\`, "b"}},
+		{`a\.`, []string{`a\.`}},
+		{"a..", []string{"a", ""}},
+	} {
+		if parts := splitDomainName(spec.name, "."); !equal(parts, spec.expected) {
+			t.Errorf("%q: expected %q, got %q", spec.name, spec.expected, parts)
+		}
+	}
+}
+
+func TestEscapedLabels(t *testing.T) {
+	newTestETCD("dns/", nil)
+	for _, spec := range []struct {
+		key, label, qname, id string
+	}{
+		{`dns/net.example/foo\.bar/A`, "foo.bar", `foo\.bar.example.net.`, ""},
+		{`dns/net.example/with\032space/A`, "with space", `with\032space.example.net.`, ""},
+		{`dns/net.example/a\#b\@c\/d/A#1`, "a#b@c/d", `a#b@c/d.example.net.`, "1"},
+		{`dns/net.example.back\\slash/A`, `back\slash`, `back\\slash.example.net.`, ""},
+	} {
+		name, _, qtype, id, _, err := parseEntryKey(spec.key)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", spec.key, err)
+			continue
+		}
+		if name.len() != 3 || name.lname(3) != spec.label || qtype != "A" || id != spec.id {
+			t.Errorf("%s: unexpected name %v, qtype %q, id %q", spec.key, name, qtype, id)
+		}
+		if normal := name.normal(); normal != spec.qname {
+			t.Errorf("%s: expected the name %q, got %q", spec.key, spec.qname, normal)
+		}
+		if parsed := parseName(spec.qname); parsed.len() != 3 || parsed.lname(3) != spec.label {
+			t.Errorf("%s: parsing %q gave the labels %v", spec.key, spec.qname, parsed)
+		}
+		// the key of the name parses to the same labels
+		key := *args.Prefix + name.asKey(true) + "A"
+		if reparsed, _, _, _, _, err := parseEntryKey(key); err != nil || reparsed.normal() != spec.qname {
+			t.Errorf("%s: the key %q parsed to %q (%v)", spec.key, key, reparsed.normal(), err)
+		}
+	}
+	items := make(chan etcdItem, 4)
+	items <- etcdItem{"dns/-defaults-", []byte(`{"ttl": 3600}`), 1}
+	items <- etcdItem{"dns/net.example/SOA", []byte(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`), 1}
+	items <- etcdItem{`dns/net.example/foo\.bar/A`, []byte(`192.0.2.1`), 1}
+	items <- etcdItem{`dns/net.example/bar/A`, []byte(`192.0.2.2`), 1}
+	close(items)
+	dataRoot.Store(newDataNode(nil, "", ""))
+	dataRoot.Load().reload(items)
+	if contents := lookupContents(t, `foo\.bar.example.net.`, "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected the record of the escaped label, got %v", contents)
+	}
+	if contents := lookupContents(t, "foo.bar.example.net.", "A"); len(contents) != 0 {
+		t.Errorf("expected no record for the unescaped name, got %v", contents)
+	}
+	result, _ := lookup(objectType[any]{"qname": `foo\.bar.example.net.`, "qtype": "A"}, newTestClient())
+	if items, ok := result.([]objectType[any]); !ok || len(items) != 1 || items[0]["qname"] != `foo\.bar.example.net.` {
+		t.Errorf("expected the escaped qname in the result, got %v", result)
+	}
+}
+
+func TestSeparators(t *testing.T) {
+	newTestETCD("dns:", nil)
+	args.KeySep, args.IDSep, args.VersionSep = new(string), new(string), new(string)
+	defer func() {
+		args.KeySep, args.IDSep, args.VersionSep = nil, nil, nil
+		_ = setSeparators()
+	}()
+	if err := readParameters(objectType[string]{keySepParam: ":", idSepParam: "~", versionSepParam: "%"}, newTestClient()); err != nil {
+		t.Fatalf("failed to read parameters: %s", err)
+	}
+	for _, spec := range []struct {
+		key, qname, qtype, id string
+		entryType             entryType
+		major                 uint64
+	}{
+		{`dns:net.example:www:A~1%1`, "www.example.net.", "A", "1", normalEntry, 1},
+		{`dns:net:example:a/b#c@d:A`, "a/b#c@d.example.net.", "A", "", normalEntry, 0},
+		{`dns:net.example:a\:b\~c\%d:A`, `a:b~c%d.example.net.`, "A", "", normalEntry, 0},
+		{`dns:net.example:-defaults-:A`, "example.net.", "A", "", defaultsEntry, 0},
+	} {
+		name, entryType, qtype, id, version, err := parseEntryKey(spec.key)
+		if err != nil {
+			t.Errorf("%s: failed to parse: %s", spec.key, err)
+			continue
+		}
+		if name.normal() != spec.qname || entryType != spec.entryType || qtype != spec.qtype || id != spec.id {
+			t.Errorf("%s: unexpected name %q, entry type %v, qtype %q, id %q", spec.key, name.normal(), entryType, qtype, id)
+		}
+		if (version == nil) != (spec.major == 0) || (version != nil && version.Major != spec.major) {
+			t.Errorf("%s: unexpected version %v", spec.key, version)
+		}
+		// the key of the name parses to the same labels
+		key := *args.Prefix + name.asKey(true) + "A"
+		if reparsed, _, _, _, _, err := parseEntryKey(key); err != nil || reparsed.normal() != spec.qname {
+			t.Errorf("%s: the key %q parsed to %q (%v)", spec.key, key, reparsed.normal(), err)
+		}
+	}
+	params := rrParams{qtype: "A", id: "1", data: newDataNode(nil, "", "").getChildCreate(testName("example.net."))}
+	if target := params.Target(); target != "example.net.:A~1" {
+		t.Errorf("unexpected target %q", target)
+	}
+	for _, spec := range []struct{ param, value string }{
+		{keySepParam, "::"},
+		{idSepParam, "x"},
+		{idSepParam, "1"},
+		{versionSepParam, "-"},
+		{keySepParam, "."},
+		{keySepParam, `\`},
+		{idSepParam, labelPrefix},
+		{idSepParam, ":"}, // the key separator
+	} {
+		if err := readParameters(objectType[string]{spec.param: spec.value}, newTestClient()); err == nil {
+			t.Errorf("%s=%q: expected an error", spec.param, spec.value)
+		}
+		*args.KeySep, *args.IDSep, *args.VersionSep = ":", "~", "%"
+	}
+	if keySeparator != ":" || idSeparator != "~" || versionSeparator != "%" {
+		t.Errorf("the separators changed on errors: %q %q %q", keySeparator, idSeparator, versionSeparator)
+	}
+}
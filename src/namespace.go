@@ -0,0 +1,111 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+// The used ETCD client version has no clientv3/namespace package (added in v3.2), so this is a minimal variant of it.
+// Only the operations used by pdns-etcd3 are namespaced: Get, Put and Delete on the KV and Watch on the Watcher.
+// Range options of Get (WithRange, WithFromKey, WithPrefix) are namespaced by their end key, Watch supports only WithPrefix.
+
+type namespaceKV struct {
+	clientv3.KV
+	namespace string
+}
+
+func newNamespaceKV(kv clientv3.KV, namespace string) clientv3.KV {
+	return &namespaceKV{kv, namespace}
+}
+
+func (kv *namespaceKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if end := rangeEnd(key, opts...); end != "" {
+		if end == "\x00" { // WithFromKey or WithPrefix of the empty key: up to the end of the namespace
+			end = rangeEnd(kv.namespace, clientv3.WithPrefix())
+		} else {
+			end = kv.namespace + end
+		}
+		opts = append(opts[:len(opts):len(opts)], clientv3.WithRange(end))
+	}
+	response, err := kv.KV.Get(ctx, kv.namespace+key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range response.Kvs {
+		response.Kvs[i] = stripNamespace(item, kv.namespace)
+	}
+	return response, nil
+}
+
+func (kv *namespaceKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	return kv.KV.Put(ctx, kv.namespace+key, val, opts...)
+}
+
+func (kv *namespaceKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	return kv.KV.Delete(ctx, kv.namespace+key, opts...)
+}
+
+type namespaceWatcher struct {
+	clientv3.Watcher
+	namespace string
+}
+
+func newNamespaceWatcher(watcher clientv3.Watcher, namespace string) clientv3.Watcher {
+	return &namespaceWatcher{watcher, namespace}
+}
+
+func (w *namespaceWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	watchChan := w.Watcher.Watch(ctx, w.namespace+key, opts...)
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(ch)
+		for watchResponse := range watchChan {
+			events := make([]*clientv3.Event, len(watchResponse.Events))
+			for i, ev := range watchResponse.Events {
+				event := *ev
+				event.Kv = stripNamespace(ev.Kv, w.namespace)
+				events[i] = &event
+			}
+			watchResponse.Events = events
+			select {
+			case ch <- watchResponse:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// the range end set by the options, it is not exported by clientv3.Op
+func rangeEnd(key string, opts ...clientv3.OpOption) string {
+	return string(reflect.ValueOf(clientv3.OpGet(key, opts...)).FieldByName("end").Bytes())
+}
+
+// returns a copy of item with the namespace removed from the key
+func stripNamespace(item *mvccpb.KeyValue, namespace string) *mvccpb.KeyValue {
+	if item == nil {
+		return nil
+	}
+	stripped := *item
+	stripped.Key = bytes.TrimPrefix(item.Key, []byte(namespace))
+	return &stripped
+}
@@ -19,10 +19,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -31,17 +35,46 @@ import (
 )
 
 type programArgs struct {
-	ConfigFile  *string
-	Endpoints   *string
-	DialTimeout *time.Duration
-	Prefix      *string
+	ConfigFile    *string
+	Endpoints     *string
+	DialTimeout   *time.Duration
+	ReqTimeout    *time.Duration
+	StartTimeout  *time.Duration
+	KeySep        *string
+	IDSep         *string
+	VersionSep    *string
+	MaxAnswers    *int
+	LookupBatch   *bool
+	OrderSeed     *int
+	Prefix        *string
+	CertFile      *string
+	KeyFile       *string
+	CAFile        *string
+	Username      *string
+	Password      *string
+	Namespace     *string
+	LazyLoad      *bool
+	LookupCache   *int
+	SnapshotFile  *string
+	SerialFile    *string
+	NameCase      *string
+	StrictJSON    *bool
+	Explain       *bool
+	Status        *bool
+	ReloadWorkers *int
+	DefaultTTL    *time.Duration
+	DefaultTTLs   *string
+	Concurrency   *int
+	Rate          *float64
+	Burst         *int
+	Zones         *string
 }
 
 var (
 	log        = newLog("", "main", "etcd", "data") // TODO timings
 	args       programArgs
 	standalone bool
-	dataRoot   *dataNode
+	dataRoot   atomic.Pointer[dataNode] // the current data tree, replaced as a whole (see loadData())
 )
 
 func parseBoolean(s string) (bool, error) {
@@ -68,6 +101,32 @@ func setBooleanParameterFunc(param *bool) setParameterFunc {
 	}
 }
 
+func setSizeParameterFunc(param *int) setParameterFunc {
+	return func(value string) error {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("failed to parse value as integer: %s", err)
+		}
+		if size < 0 {
+			return fmt.Errorf("size must not be negative: %d", size)
+		}
+		*param = size
+		return nil
+	}
+}
+
+func setNameCaseParameter(param *string) setParameterFunc {
+	return func(value string) error {
+		switch value {
+		case nameCaseFold, nameCasePreserveStrict:
+			*param = value
+		default:
+			return fmt.Errorf("invalid name case policy: %s", value)
+		}
+		return nil
+	}
+}
+
 func setPdnsVersionParameter(param *uint) setParameterFunc {
 	return func(value string) error {
 		switch value {
@@ -84,6 +143,35 @@ func setPdnsVersionParameter(param *uint) setParameterFunc {
 	}
 }
 
+// parses a list of <QTYPE>=<duration> (separated by commas), a duration may also be a number of seconds
+func setDefaultTTLsParameter(param *map[string]time.Duration) setParameterFunc {
+	return func(value string) error {
+		ttls := map[string]time.Duration{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			qtype, durStr, ok := strings.Cut(item, "=")
+			qtype = strings.ToUpper(strings.TrimSpace(qtype))
+			if !ok || qtype == "" {
+				return fmt.Errorf("invalid item %q (expected <QTYPE>=<duration>)", item)
+			}
+			var durValue any = strings.TrimSpace(durStr)
+			if seconds, err := strconv.ParseFloat(durValue.(string), 64); err == nil {
+				durValue = seconds
+			}
+			dur, err := parseDuration(durValue)
+			if err != nil {
+				return fmt.Errorf("invalid duration for %s: %s", qtype, err)
+			}
+			ttls[qtype] = dur
+		}
+		*param = ttls
+		return nil
+	}
+}
+
 func setDurationParameterFunc(param *time.Duration, minValue *time.Duration) setParameterFunc {
 	return func(value string) error {
 		dur, err := time.ParseDuration(value)
@@ -110,10 +198,80 @@ func readParameters(params objectType[string], client *pdnsClient) error {
 		case !standalone && k == dialTimeoutParam:
 			mdt := minimumDialTimeout
 			err = setDurationParameterFunc(args.DialTimeout, &mdt)(v)
+		case !standalone && k == reqTimeoutParam:
+			mrt := minimumReqTimeout
+			err = setDurationParameterFunc(args.ReqTimeout, &mrt)(v)
+		case !standalone && k == startTimeoutParam:
+			mrt := minimumReqTimeout
+			err = setDurationParameterFunc(args.StartTimeout, &mrt)(v)
 		case !standalone && k == prefixParam:
 			*args.Prefix = v
+		case !standalone && k == certFileParam:
+			*args.CertFile = v
+		case !standalone && k == keyFileParam:
+			*args.KeyFile = v
+		case !standalone && k == caFileParam:
+			*args.CAFile = v
+		case !standalone && k == usernameParam:
+			*args.Username = v
+		case !standalone && k == passwordParam:
+			*args.Password = v
+		case !standalone && k == namespaceParam:
+			*args.Namespace = v
+		case !standalone && k == lazyLoadParam:
+			err = setBooleanParameterFunc(args.LazyLoad)(v)
+		case !standalone && k == lookupCacheParam:
+			err = setSizeParameterFunc(args.LookupCache)(v)
+		case !standalone && k == snapshotFileParam:
+			*args.SnapshotFile = v
+		case !standalone && k == serialFileParam:
+			*args.SerialFile = v
+		case !standalone && k == nameCaseParam:
+			err = setNameCaseParameter(args.NameCase)(v)
+		case !standalone && k == strictJSONParam:
+			err = setBooleanParameterFunc(args.StrictJSON)(v)
+		case !standalone && k == explainParam:
+			err = setBooleanParameterFunc(args.Explain)(v)
+		case !standalone && k == statusParam:
+			err = setBooleanParameterFunc(args.Status)(v)
+		case !standalone && k == reloadWorkersParam:
+			err = setSizeParameterFunc(args.ReloadWorkers)(v)
+		case !standalone && k == defaultTTLParam:
+			mdt := minimumDefaultTTL
+			err = setDurationParameterFunc(args.DefaultTTL, &mdt)(v)
+		case !standalone && k == zonesParam:
+			*args.Zones = v
+		case !standalone && k == keySepParam:
+			*args.KeySep = v
+		case !standalone && k == idSepParam:
+			*args.IDSep = v
+		case !standalone && k == versionSepParam:
+			*args.VersionSep = v
+		case !standalone && k == maxAnswersParam:
+			err = setSizeParameterFunc(args.MaxAnswers)(v)
+		case !standalone && k == lookupBatchParam:
+			err = setBooleanParameterFunc(args.LookupBatch)(v)
+		case !standalone && k == orderSeedParam:
+			err = setSizeParameterFunc(args.OrderSeed)(v)
+		case !standalone && k == defaultTTLsParam:
+			err = setDefaultTTLsParameter(&defaultTTLs)(v)
 		case k == pdnsVersionParam:
 			err = setPdnsVersionParameter(&client.PdnsVersion)(v)
+		case k == logParam:
+			if !standalone {
+				err = log.setLoggingLevels(v)
+			}
+			if err == nil {
+				err = client.log.setLoggingLevels(v)
+			}
+		case k == logSyslogParam:
+			var writer syslogWriter
+			if writer, err = dialSyslog(v); err == nil {
+				if !standalone {
+					log.useSyslog(writer)
+				}
+				client.log.useSyslog(writer)
+			}
 		case strings.HasPrefix(k, logParamPrefix):
 			for _, level := range logrus.AllLevels {
 				if k == logParamPrefix+level.String() {
@@ -134,23 +292,31 @@ func readParameters(params objectType[string], client *pdnsClient) error {
 			return fmt.Errorf("failed to set parameter %q: %s", k, err)
 		}
 	}
+	if !standalone {
+		return setSeparators()
+	}
 	return nil
 }
 
-func startReadRequests(client *pdnsClient) <-chan pdnsRequest {
+func startReadRequests(ctx context.Context, client *pdnsClient) <-chan pdnsRequest {
 	ch := make(chan pdnsRequest)
 	go func() {
 		defer close(ch)
 		for {
-			if request, err := client.Comm.read(); err != nil {
+			request, err := client.Comm.read()
+			if err != nil {
 				if err == io.EOF {
 					client.log.pdns().Debug("EOF on input stream, terminating")
-					return
+				} else if ctx.Err() == nil {
+					client.log.pdns().Error("Failed to decode request, terminating:", err)
 				}
-				client.log.pdns().Fatal("Failed to decode request:", err)
-			} else {
-				client.log.pdns().WithField("request", request).Debug("received new request")
-				ch <- *request
+				return
+			}
+			client.log.pdns().WithField("request", request).Debug("received new request")
+			select {
+			case ch <- *request:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -160,27 +326,65 @@ func startReadRequests(client *pdnsClient) <-chan pdnsRequest {
 func handleRequest(request *pdnsRequest, client *pdnsClient) {
 	client.log.main().Debug("handling request:", request)
 	since := time.Now()
+	client.timings = newTimings(client.log.main())
+	defer func() { client.timings = nil }()
+	defer countRequest()()
 	var result interface{}
 	var err error
 	switch strings.ToLower(request.Method) {
 	case "lookup":
 		result, err = lookup(request.Parameters, client)
+	case "list":
+		result, err = list(request.Parameters, client)
+	case "getdomaininfo":
+		result, err = getDomainInfo(request.Parameters, client)
+	case "searchrecords":
+		result, err = searchRecords(request.Parameters, client)
+	case "getbeforeandafternamesabsolute":
+		result, err = getBeforeAndAfterNamesAbsolute(request.Parameters, client)
+	case "getalldomains":
+		result, err = getAllDomains(request.Parameters, client)
+	case "getupdatedmasters":
+		result, err = getUpdatedMasters(request.Parameters, client)
+	case "setnotified":
+		result, err = setNotified(request.Parameters, client)
+	case "getzonediff":
+		result, err = getZoneDiff(request.Parameters, client)
+	case "getdomainkeys":
+		result, err = getDomainKeys(request.Parameters, client)
+	case "adddomainkey":
+		result, err = addDomainKey(request.Parameters, client)
+	case "activatedomainkey":
+		result, err = activateDomainKey(request.Parameters, client)
+	case "deactivatedomainkey":
+		result, err = deactivateDomainKey(request.Parameters, client)
+	case "getdomainmetadata":
+		result, err = getDomainMetadata(request.Parameters, client)
 	case "getalldomainmetadata":
-		result, err = map[string]any{}, nil
+		result, err = getAllDomainMetadata(request.Parameters, client)
+	case "lookupbatch":
+		result, err = lookupBatch(request.Parameters, client)
+	case "explain":
+		result, err = explain(request.Parameters, client)
+	case "status":
+		result, err = status(request.Parameters, client)
 	default:
 		result, err = false, fmt.Errorf("unknown/unimplemented request: %s", request)
 	}
+	client.timings.mark(strings.ToLower(request.Method))
 	if err == nil {
 		client.respond(makeResponse(result))
 	} else {
 		client.respond(makeResponse(result, err.Error()))
 	}
+	client.timings.mark("respond")
 	dur := time.Since(since)
-	client.log.main().WithFields(logrus.Fields{"dur": dur, "err": err, "val": result}).Tracef("result")
+	client.log.main().WithFields(client.timings.fields()).WithFields(logrus.Fields{"dur": dur, "err": err, "val": result}).Tracef("result")
 }
 
-func handleEvent(event *clientv3.Event) {
-	log.etcd().WithField("event", event).Debug("handling event")
+// handles the event of the cluster (the caller must hold updateMutex)
+func handleEvent(cluster string, event *clientv3.Event) {
+	log.etcd().WithFields(logrus.Fields{"cluster": cluster, "event": event}).Debug("handling event")
 	since := time.Now()
 	entryKey := string(event.Kv.Key)
 	name, entryType, qtype, id, version, err := parseEntryKey(entryKey)
@@ -193,17 +397,89 @@ func handleEvent(event *clientv3.Event) {
 		log.data().WithError(err).Errorf("failed to parse entry key %q, ignoring event", entryKey)
 		return
 	}
-	itemData := dataRoot.getChild(name, true)
+	if isOutsideServedZones(name) {
+		log.data().Tracef("ignoring event on entry %q, it does not affect the served zones", entryKey)
+		return
+	}
+	if len(clusters) > 1 {
+		if cluster == clusters[0] && changesRoutes(entryKey, event.Kv.Value) {
+			log.data().Debugf("routes changed by entry %q, reloading all data", entryKey)
+			if _, err := loadData("watch"); err != nil {
+				log.data().WithError(err).Error("failed to reload data")
+			}
+			return
+		}
+		if routed := clusterOf(name); routed != cluster {
+			log.data().WithField("cluster", cluster).Tracef("ignoring event on entry %q, it is routed to cluster %q", entryKey, routed)
+			return
+		}
+	}
+	if *args.LazyLoad {
+		handleEventLazily(event, name, entryType, qtype, id)
+		return
+	}
+	// runs after releasing the locks below (deferred first), an update of an address record in place synchronizes only its node
+	autoPtrSync := syncAutoPtrs
+	defer func() { autoPtrSync() }()
+	root := dataRoot.Load()
+	itemData := root.getChild(name, true)
 	zoneData := itemData.findZone()
 	if event.Type == clientv3.EventTypeDelete && qtype == "SOA" && id == "" && entryType == normalEntry && zoneData != nil && zoneData.parent != nil {
 		// deleting the SOA record deletes the zone, so the parent zone must be reloaded instead. this results in a full data reload for top-level zones.
 		zoneData = zoneData.parent.findZone()
 	}
 	if zoneData == nil {
-		zoneData = dataRoot
+		zoneData = root
+	}
+	prevSerial := zoneData.zoneSerial()
+	// a single (unversioned) normal entry can be updated in place (its node is created if missing), other changes (SOA,
+	// defaults, options, ...) affect more records
+	found := itemData.depth() == name.len()
+	curr, exists := itemData.values[qtype][id]
+	exists = exists && found
+	if exists && (curr.key != entryKey || len(curr.equivalents) > 0) && entryType == normalEntry && version == nil && curr.version == nil {
+		// an equivalent key of the stored entry, or the stored entry itself while it has equivalent keys (see reload()),
+		// which one is taken is decided by a reload. the keys can be spelled differently (f.e. "a.b/A" and "a/b/A"), so
+		// the reload of the zone (by its key prefix) could miss them.
+		itemData.rUnlockUpwards(nil)
+		log.data().WithField("equivalent", curr.key).Debugf("entry %q has an equivalent key, reloading all data", entryKey)
+		if _, err := loadData("watch"); err != nil {
+			log.data().WithError(err).Error("failed to reload data")
+		}
+		return
+	}
+	if entryType == normalEntry && qtype != "SOA" && version == nil && (found || event.Type != clientv3.EventTypeDelete) && (!exists || curr.version == nil) {
+		itemData.rUnlockUpwards(zoneData)
+		saveZoneSnapshot(zoneData)
+		zoneData.mutex.RUnlock()
+		if zoneData.parent != nil {
+			defer zoneData.parent.rUnlockUpwards(nil)
+		}
+		zoneData.mutex.Lock()
+		defer zoneData.mutex.Unlock()
+		if !found {
+			// the nodes beneath the zone are guarded by the zone lock too
+			itemData = itemData.getChildCreate(name.fromDepth(itemData.depth() + 1))
+		}
+		if qtype == "A" || qtype == "AAAA" {
+			// other records (f.e. an explicit PTR record) may affect the synthesized PTR records of other nodes
+			autoPtrsBefore := itemData.hostAutoPtrs()
+			autoPtrSync = func() { syncHostAutoPtrs(itemData, autoPtrsBefore) }
+		}
+		itemData.updateEntry(entryKey, qtype, id, event.Kv.Value, maxOf(event.Kv.ModRevision, event.Kv.CreateRevision), event.Type == clientv3.EventTypeDelete)
+		if zoneData.hasSOA() {
+			zoneData.processSOA()
+		}
+		zoneData.checkTargets(root)
+		markUpdatedZone(zoneData, prevSerial)
+		saveZoneSnapshot(zoneData)
+		lookupCache.invalidate(zoneData)
+		logFrom(log.data(), "event-duration", time.Since(since)).Debugf("updated entry %q in zone %q", entryKey, zoneData.getQname())
+		return
 	}
 	itemData.rUnlockUpwards(zoneData)
-	getResponse, err := get(*args.Prefix+zoneData.prefixKey(), true, &event.Kv.ModRevision)
+	saveZoneSnapshot(zoneData)
+	_, items, err := getRouted(*args.Prefix+zoneData.prefixKey(), cluster, &event.Kv.ModRevision, false, requestTimeout())
 	if err != nil {
 		zoneData.rUnlockUpwards(nil)
 		log.data().WithError(err).Warnf("failed to get data for zone %q, not updating", zoneData.getQname())
@@ -217,7 +493,11 @@ func handleEvent(event *clientv3.Event) {
 	}
 	zoneData.mutex.Lock()
 	defer zoneData.mutex.Unlock()
-	zoneData.reload(getResponse.DataChan)
+	zoneData.reload(items)
+	zoneData.checkTargets(root)
+	markUpdatedZone(zoneData, prevSerial)
+	saveZoneSnapshot(zoneData)
+	lookupCache.invalidate(zoneData)
 	dur := time.Since(since)
 	logFrom(log.data(), "#records", zoneData.recordsCount(), "#zones", zoneData.zonesCount(), "data-revision", maxOf(event.Kv.ModRevision, event.Kv.CreateRevision), "event-duration", dur).Debugf("reloaded zone %q", qname)
 }
@@ -229,105 +509,381 @@ func Main(programVersion VersionType, gitVersion string) {
 		releaseVersion += fmt.Sprintf("[%s]", gitVersion)
 	}
 	log.main().Printf("pdns-etcd3 %s, Copyright © 2016-2024 nix <https://keybase.io/nixn>", releaseVersion)
-	// handle arguments // TODO handle more arguments, f.e. 'show-defaults' standalone command
+	// handle arguments
 	unixSocketPath := flag.String("unix", "", `Create a unix socket at given path and run in Unix Connector mode ("standalone")`)
+	tcpAddress := flag.String("tcp", "", `Listen on the given address (tcp://<host>:<port>[?allow=<IP or CIDR>[,...]]) and run in TCP Connector mode ("standalone")`)
+	showDefaultsFlag := flag.Bool("show-defaults", false, "Load the data, print the effective defaults and options tree and exit")
+	exportZonename := flag.String("export", "", "Load the data, print the given zone as zonefile and exit")
+	importZonefilePath := flag.String("import", "", "Write the records of the given zonefile to ETCD and exit")
+	dryRunFlag := flag.Bool("dry-run", false, "Only print the entries to be written by -import")
+	setFlag := flag.Bool("set", false, "Write the record given by the arguments <name> <qtype> <json> to ETCD and exit")
+	validateFlag := flag.Bool("validate", false, "Load the data, print the found problems and exit (with status 1 on errors)")
+	versionsFlag := flag.Bool("versions", false, "Scan the keys, print the numbers of keys by version and of selected keys by domain and exit")
 	args = programArgs{
-		ConfigFile:  flag.String(configFileParam, "", "Use the given configuration file for the ETCD connection (overrides -endpoints)"),
-		Endpoints:   flag.String(endpointsParam, defaultEndpointIPv6+"|"+defaultEndpointIPv4, "Use the endpoints configuration for ETCD connection"),
-		DialTimeout: flag.Duration(dialTimeoutParam, defaultDialTimeout, "ETCD dial timeout"),
-		Prefix:      flag.String(prefixParam, "", "Global key prefix"),
+		ConfigFile:    flag.String(configFileParam, "", "Use the given configuration file for the ETCD connection (overrides -endpoints)"),
+		Endpoints:     flag.String(endpointsParam, defaultEndpointIPv6+"|"+defaultEndpointIPv4, "Use the endpoints configuration for ETCD connection"),
+		DialTimeout:   flag.Duration(dialTimeoutParam, defaultDialTimeout, "ETCD dial timeout"),
+		ReqTimeout:    flag.Duration(reqTimeoutParam, 0, "ETCD request timeout (defaults to the dial timeout)"),
+		StartTimeout:  flag.Duration(startTimeoutParam, 0, "ETCD request timeout for loading the whole data at startup (defaults to the dial timeout, at least 10s)"),
+		Prefix:        flag.String(prefixParam, "", "Global key prefix"),
+		CertFile:      flag.String(certFileParam, "", "Use the given client certificate file for the ETCD connection (TLS, requires -"+keyFileParam+")"),
+		KeyFile:       flag.String(keyFileParam, "", "Use the given client key file for the ETCD connection (TLS, requires -"+certFileParam+")"),
+		CAFile:        flag.String(caFileParam, "", "Use the given CA certificate file for verifying the ETCD server certificates (TLS)"),
+		Username:      flag.String(usernameParam, "", "Authenticate to ETCD with the given username (requires -"+passwordParam+")"),
+		Password:      flag.String(passwordParam, "", "Authenticate to ETCD with the given password (requires -"+usernameParam+")"),
+		Namespace:     flag.String(namespaceParam, "", "ETCD key namespace, transparently prepended to all keys (before the prefix)"),
+		LazyLoad:      flag.Bool(lazyLoadParam, false, "Load the zones on demand (only the zone apexes and the entries outside of zones are loaded at startup)"),
+		LookupCache:   flag.Int(lookupCacheParam, 0, "Cache up to the given number of lookup results (0 = disabled)"),
+		SnapshotFile:  flag.String(snapshotFileParam, "", "Write the loaded records to the given file and serve them while ETCD is unavailable at startup"),
+		SerialFile:    flag.String(serialFileParam, "", "Store the last generated SOA serials (option soa-serial) in the given file, to keep them increasing across restarts"),
+		NameCase:      flag.String(nameCaseParam, nameCaseFold, "Match the names case-insensitively ("+nameCaseFold+") or case-sensitively ("+nameCasePreserveStrict+")"),
+		StrictJSON:    flag.Bool(strictJSONParam, false, "Parse the JSON values strictly (no comments, unquoted keys or trailing commas)"),
+		Explain:       flag.Bool(explainParam, false, "Enable the non-standard request method explain (for debugging the resolution of values)"),
+		Status:        flag.Bool(statusParam, false, "Enable the non-standard request method status (for monitoring)"),
+		ReloadWorkers: flag.Int(reloadWorkersParam, 0, "Process the data with up to the given number of goroutines in parallel (0 = number of CPUs)"),
+		DefaultTTL:    flag.Duration(defaultTTLParam, 0, "Use the given TTL for records without any TTL value (0 = none, such records are ignored)"),
+		Zones:         flag.String(zonesParam, "", "Serve only the given zones (separated by commas, empty = all zones)"),
+		Concurrency:   flag.Int(concurrencyParam, 0, "Serve up to the given number of connections concurrently in standalone mode, close further ones (0 = unlimited)"),
+		Rate:          flag.Float64(rateParam, 0, "Limit the requests of each connection to the given number per second in standalone mode (0 = unlimited)"),
+		Burst:         flag.Int(burstParam, 0, "Allow bursts of up to the given number of requests of each connection (0 = the rate, at least 1)"),
+		KeySep:        flag.String(keySepParam, defaultKeySeparator, "Separate the parts of the entry keys by the given character"),
+		IDSep:         flag.String(idSepParam, defaultIDSeparator, "Separate the ids in the entry keys by the given character"),
+		VersionSep:    flag.String(versionSepParam, defaultVersionSeparator, "Separate the versions in the entry keys by the given character"),
+		MaxAnswers:    flag.Int(maxAnswersParam, 0, "Return up to the given number of records for a lookup, drop further ones (0 = unlimited)"),
+		LookupBatch:   flag.Bool(lookupBatchParam, false, "Enable the non-standard request method lookupBatch (several lookups in one request)"),
+		OrderSeed:     flag.Int(orderSeedParam, 0, "Seed the random orders of the records (option order) with the given number plus the client id (0 = by the time)"),
+		DefaultTTLs:   flag.String(defaultTTLsParam, "", "Use the given TTLs per QTYPE for records without any TTL value (<QTYPE>=<duration>, separated by commas)"),
 	}
 	logging := map[logrus.Level]*string{}
 	for _, level := range logrus.AllLevels {
 		logging[level] = flag.String(logParamPrefix+level.String(), "", fmt.Sprintf("Set logging level %s to the given components (separated by +)", level))
 	}
+	loggingLevels := flag.String(logParam, "", "Set the logging levels of the components, given as <component>=<level>, separated by commas")
+	logSyslog := flag.String(logSyslogParam, "", `Log to syslog ("local" or "[<network>://]<host>:<port>") instead of stderr`)
 	flag.Parse()
-	standalone = unixSocketPath != nil && *unixSocketPath != ""
-	if standalone {
+	if err := setSeparators(); err != nil {
+		log.main().Fatal(err)
+	}
+	setLogging := func() {
 		for level, components := range logging {
 			if len(*components) > 0 {
 				log.setLoggingLevel(*components, level)
 			}
 		}
-		socket, err := net.Listen("unix", *unixSocketPath)
-		if err != nil {
-			log.main().Fatalf("Failed to create a unix socket at %s: %s", *unixSocketPath, err)
+		if err := log.setLoggingLevels(*loggingLevels); err != nil {
+			log.main().Fatalf("Invalid parameter %s: %s", logParam, err)
 		}
-		defer socket.Close()
-		err = os.Chmod(*unixSocketPath, 0777)
+	}
+	if *logSyslog != "" {
+		writer, err := dialSyslog(*logSyslog)
 		if err != nil {
-			log.main().Warnf("Failed to chmod unix socket to 0777: %s", err)
+			log.main().Fatalf("Failed to connect to syslog %q: %s", *logSyslog, err)
+		}
+		defaultSyslog = writer
+		log.useSyslog(writer)
+	}
+	if *showDefaultsFlag || *exportZonename != "" || *importZonefilePath != "" || *validateFlag || *versionsFlag || *setFlag {
+		setLogging()
+		if *showDefaultsFlag {
+			if err := showDefaults(os.Stdout); err != nil {
+				log.main().Fatalf("Failed to show the defaults: %s", err)
+			}
+		} else if *setFlag {
+			if flag.NArg() != 3 {
+				log.main().Fatalf("-set requires the arguments <name> <qtype> <json>, got %d arguments", flag.NArg())
+			}
+			if err := setRecord(flag.Arg(0), flag.Arg(1), flag.Arg(2)); err != nil {
+				log.main().Fatalf("Failed to set the record: %s", err)
+			}
+		} else if *validateFlag {
+			errors, err := validate(os.Stdout)
+			if err != nil {
+				log.main().Fatalf("Failed to validate the data: %s", err)
+			}
+			if errors > 0 {
+				os.Exit(1)
+			}
+		} else if *versionsFlag {
+			if err := reportVersions(os.Stdout); err != nil {
+				log.main().Fatalf("Failed to report the versions: %s", err)
+			}
+		} else if *exportZonename != "" {
+			if err := exportZone(os.Stdout, *exportZonename); err != nil {
+				log.main().Fatalf("Failed to export zone %q: %s", *exportZonename, err)
+			}
+		} else if err := importZonefile(*importZonefilePath, *dryRunFlag, os.Stdout); err != nil {
+			log.main().Fatalf("Failed to import zonefile %q: %s", *importZonefilePath, err)
+		}
+		return
+	}
+	if *unixSocketPath != "" && *tcpAddress != "" {
+		log.main().Fatalf("Only one of -unix and -tcp can be given")
+	}
+	standalone = *unixSocketPath != "" || *tcpAddress != ""
+	var err error
+	if standalone {
+		if *args.ReqTimeout != 0 && *args.ReqTimeout < minimumReqTimeout {
+			log.main().Fatalf("Request timeout %s is less than minimum allowed (%s)", *args.ReqTimeout, minimumReqTimeout)
+		}
+		if *args.StartTimeout != 0 && *args.StartTimeout < minimumReqTimeout {
+			log.main().Fatalf("Startup timeout %s is less than minimum allowed (%s)", *args.StartTimeout, minimumReqTimeout)
+		}
+		if *args.DefaultTTL != 0 && *args.DefaultTTL < minimumDefaultTTL {
+			log.main().Fatalf("Default TTL %s is less than minimum allowed (%s)", *args.DefaultTTL, minimumDefaultTTL)
+		}
+		if err := setDefaultTTLsParameter(&defaultTTLs)(*args.DefaultTTLs); err != nil {
+			log.main().Fatalf("Invalid parameter %s: %s", defaultTTLsParam, err)
+		}
+		if *args.MaxAnswers < 0 {
+			log.main().Fatalf("Invalid parameter %s: must not be negative: %d", maxAnswersParam, *args.MaxAnswers)
+		}
+		if *args.OrderSeed < 0 {
+			log.main().Fatalf("Invalid parameter %s: must not be negative: %d", orderSeedParam, *args.OrderSeed)
+		}
+		if *args.Concurrency < 0 {
+			log.main().Fatalf("Invalid parameter %s: must not be negative: %d", concurrencyParam, *args.Concurrency)
+		}
+		if *args.Rate < 0 || math.IsInf(*args.Rate, 0) || math.IsNaN(*args.Rate) {
+			log.main().Fatalf("Invalid parameter %s: must be a non-negative number: %v", rateParam, *args.Rate)
+		}
+		if *args.Burst < 0 {
+			log.main().Fatalf("Invalid parameter %s: must not be negative: %d", burstParam, *args.Burst)
+		}
+		setLogging()
+		if *unixSocketPath != "" {
+			err = unix(shutdownOnSignal(), *unixSocketPath)
+		} else {
+			err = tcp(shutdownOnSignal(), *tcpAddress)
 		}
-		go unix(socket)
 	} else {
-		go pipe()
+		err = pipe(shutdownOnSignal())
 	}
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill, syscall.SIGTERM)
+	// the deferred functions of unix() or pipe() were executed already
+	if err != nil {
+		log.main().Fatalf("Fatal error: %s", err)
+	}
+	log.main().Debugf("{main} shut down")
+}
+
+// returns a context which is canceled on SIGINT or SIGTERM (the default signal handling is restored afterward)
+func shutdownOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	log.main().Debugf("{main} waiting for shutdown signal")
-	sig := <-c
-	log.main().Debugf("{main} caught signal %s, shutting down", sig)
-	// TODO implement graceful shutdown. when calling fatal (or log.Fatal), the deferred functions are not executed :-(
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.main().Debugf("{main} caught signal %s, shutting down", sig)
+		cancel()
+	}()
+	return ctx
 }
 
 func populateData(caller string) (context.CancelFunc, error) {
 	log.main().Debugf("{%s} populating data", caller)
 	doneCtx, cancel := context.WithCancel(context.Background())
-	getResponse, err := get(*args.Prefix, true, nil)
+	revisions, err := loadData(caller)
 	if err != nil {
-		return cancel, fmt.Errorf("get() failed: %s", err)
-	}
-	func() {
-		dataRoot = newDataNode(nil, "", "")
-		dataRoot.mutex.Lock()
-		defer dataRoot.mutex.Unlock()
-		dataRoot.reload(getResponse.DataChan)
-		log.main().Debugf("{%s} loaded data: #records=%d #zones=%d revision=%v", caller, dataRoot.recordsCount(), dataRoot.zonesCount(), getResponse.Revision)
-	}()
-	log.main().Debugf("{%s} starting data watcher", caller)
-	go watchData(doneCtx, getResponse.Revision+1)
+		return cancel, err
+	}
+	saveSnapshot(caller)
+	for _, cluster := range clusters {
+		log.main().Debugf("{%s} starting data watcher (cluster %q)", caller, cluster)
+		go watchData(doneCtx, cluster, revisions[cluster]+1)
+	}
 	return cancel, nil
 }
 
-func unix(socket net.Listener) {
-	connectMessages, err := setupClient()
+// loads all data into a new tree, which replaces dataRoot afterwards. returns the revisions of the loaded data (by cluster).
+func loadData(caller string) (map[string]int64, error) {
+	root := newDataNode(nil, "", "")
+	root.mutex.Lock()
+	defer root.mutex.Unlock()
+	releaseSerials := zoneSerials.hold()
+	defer releaseSerials()
+	var revisions map[string]int64
+	if *args.LazyLoad {
+		revision, err := root.reloadLazily(nil)
+		if err != nil {
+			return nil, fmt.Errorf("reloadLazily() failed: %s", err)
+		}
+		revisions = map[string]int64{clusters[0]: revision}
+	} else {
+		var items <-chan etcdItem
+		var err error
+		revisions, items, err = getRouted(*args.Prefix, "", nil, true, startupTimeout())
+		if err != nil {
+			return nil, fmt.Errorf("getRouted() failed: %s", err)
+		}
+		warnIncompatibleVersions(caller, root.reload(items))
+		root.syncAutoPtrs()
+		root.checkTargets(root)
+	}
+	releaseSerials() // before serving them
+	root.assignZoneIDs()
+	dataRoot.Store(root)
+	lookupCache.clear()
+	setDataRevisions(revisions)
+	log.main().Debugf("{%s} loaded data: #records=%d #zones=%d revisions=%v", caller, root.recordsCount(), root.zonesCount(), revisions)
+	return revisions, nil
+}
+
+// warns about the entries ignored due to version incompatibility (see reload()), so that misversioned data is noticed
+func warnIncompatibleVersions(caller string, incompatible map[string]int) {
+	for _, version := range sortedKeys(incompatible) {
+		log.main().WithField("version", version).WithField("#", incompatible[version]).Warnf("{%s} ignored %d entries of data version %s, which is incompatible to the data version %s", caller, incompatible[version], version, &dataVersion)
+	}
+}
+
+// connects to ETCD and loads the whole data (without watching it), for the commands which exit afterward.
+// the client must be closed by the caller on success.
+func loadDataOnce(caller string) error {
+	if _, err := setupClient(); err != nil {
+		return fmt.Errorf("setupClient() failed: %s", err)
+	}
+	lazyLoad := false // the whole tree is needed
+	args.LazyLoad = &lazyLoad
+	if _, err := loadData(caller); err != nil {
+		closeClient()
+		return fmt.Errorf("loadData() failed: %s", err)
+	}
+	return nil
+}
+
+// serves the clients connecting to the unix socket at the path, until ctx is done
+func unix(ctx context.Context, path string) error {
+	socket, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to create a unix socket at %s: %s", path, err)
+	}
+	defer socket.Close()
+	err = os.Chmod(path, 0777)
 	if err != nil {
-		log.main().Fatalf("{listen} setupClient() failed: %s", err)
+		log.main().Warnf("Failed to chmod unix socket to 0777: %s", err)
 	}
-	defer closeClient()
-	log.main().WithError(err).Debug("{listen} setupClient: ", strings.Join(connectMessages, "; "))
-	cancel, err := populateData("listen")
+	return listen(ctx, socket)
+}
+
+// serves the clients connecting to the TCP address (tcp://<host>:<port>[?allow=<IP or CIDR>[,...]]), until ctx is done
+func tcp(ctx context.Context, address string) error {
+	host, allowed, err := parseTCPAddress(address)
 	if err != nil {
-		log.main().Fatalf("{listen} populateData() failed: %s", err)
+		return fmt.Errorf("invalid TCP address %q: %s", address, err)
 	}
-	defer cancel()
+	socket, err := net.Listen("tcp", host)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", host, err)
+	}
+	if allowed != nil {
+		socket = &allowListener{socket, allowed}
+	}
+	defer socket.Close()
+	return listen(ctx, socket)
+}
+
+// connects to ETCD, loads the data and serves the connections of the socket, until ctx is done
+func listen(ctx context.Context, socket net.Listener) error {
+	stop, connectMessages, err := connectData("listen")
+	if err != nil {
+		return fmt.Errorf("{listen} %s", err)
+	}
+	defer stop()
+	log.main().Debug("{listen} connected: ", strings.Join(connectMessages, "; "))
+	accept(ctx, socket)
+	return nil
+}
+
+func maxConcurrency() int {
+	if args.Concurrency == nil {
+		return 0
+	}
+	return *args.Concurrency
+}
+
+// serves the connections of the socket until ctx is done. then it stops accepting new connections and waits (up to
+// shutdownTimeout) for the clients to finish their current request. new connections exceeding the parameter
+// max-concurrency are closed right away.
+func accept(ctx context.Context, socket net.Listener) {
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = socket.Close() // makes Accept() return
+		case <-stopped:
+		}
+	}()
 	log.main().Infof("{listen} Waiting for connections")
+	clients := sync.WaitGroup{}
+	var slots chan struct{}
+	if limit := maxConcurrency(); limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
 	var nextClientID uint = 1
 	for {
 		conn, err := socket.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			log.main().Errorf("Failed to accept new connection: %s", err)
 			continue
 		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.main().Warnf("{listen} Too many concurrent connections (%s=%d), closing new connection: %+v", concurrencyParam, cap(slots), conn)
+				_ = conn.Close()
+				continue
+			}
+		}
 		log.main().Debugf("{listen} New connection [%d]: %+v", nextClientID, conn)
-		go serve(newPdnsClient(nextClientID, conn, conn))
+		clients.Add(1)
+		go func(client *pdnsClient, conn net.Conn) {
+			defer clients.Done()
+			defer conn.Close()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			if err := serve(ctx, client); err != nil {
+				client.log.main().Errorf("Fatal error: %s", err)
+			}
+		}(newPdnsClient(nextClientID, conn, conn), conn)
 		nextClientID++
 	}
+	log.main().Debugf("{listen} stopped accepting connections, waiting for the clients to finish")
+	if !waitTimeout(&clients, shutdownTimeout) {
+		log.main().Warnf("{listen} clients did not finish within %s, shutting down anyway", shutdownTimeout)
+	}
 }
 
-func pipe() {
-	serve(newPdnsClient(0, os.Stdin, os.Stdout))
+func pipe(ctx context.Context) error {
+	return serve(ctx, newPdnsClient(0, os.Stdin, os.Stdout))
 }
 
-func serve(client *pdnsClient) {
+// serves the requests of the client until its input ends or ctx is done (a request in progress is finished before)
+func serve(ctx context.Context, client *pdnsClient) error {
 	var logMessages []string
-	reqChan := startReadRequests(client)
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	reqChan := startReadRequests(readCtx, client)
 	// first request must be 'initialize'
 	{
 		client.log.pdns().Infof("Waiting for initial request")
-		initRequest := <-reqChan
+		var initRequest pdnsRequest
+		select {
+		case <-ctx.Done():
+			return nil
+		case request, ok := <-reqChan:
+			if !ok {
+				return nil
+			}
+			initRequest = request
+		}
 		if initRequest.Method != "initialize" {
-			client.log.pdns().WithField("method", initRequest.Method).Fatalf("Wrong request method (waited for 'initialize')")
+			return respondError(client, fmt.Errorf("wrong request method %q (waited for 'initialize')", initRequest.Method))
 		}
 		client.log.main().WithField("parameters", initRequest.Parameters).Infof("initializing")
 		params := objectType[string]{}
@@ -336,31 +892,37 @@ func serve(client *pdnsClient) {
 		}
 		err := readParameters(params, client)
 		if err != nil {
-			fatal(client, err)
+			return respondError(client, err)
 		}
 		client.log.main().Debugf("successfully read parameters")
 	}
 	if !standalone {
-		clientMessages, err := setupClient()
+		stop, clientMessages, err := connectData("serve")
 		if err != nil {
-			fatal(client, fmt.Errorf("setupClient() failed: %s", err))
+			return respondError(client, err)
 		}
-		defer closeClient()
+		defer stop()
 		client.log.main().Debugf("connected")
 		logMessages = append(logMessages, clientMessages...)
-		cancel, err := populateData("serve")
-		if err != nil {
-			fatal(client, fmt.Errorf("populateData() failed: %s", err))
-		}
-		defer cancel()
 	}
 	client.respond(makeResponse(true, logMessages...))
+	limiter := newClientRateLimiter()
 	for {
-		request, ok := <-reqChan
-		if !ok {
-			break
+		select {
+		case <-ctx.Done():
+			client.log.main().Debugf("shutting down")
+			return nil
+		case request, ok := <-reqChan:
+			if !ok {
+				return nil
+			}
+			if limiter != nil && !limiter.allow(currentTime()) {
+				client.log.main().WithField("request", &request).Warnf("rate limit exceeded (%s=%v), rejecting request", rateParam, *args.Rate)
+				client.respond(makeResponse(false, "rate limit exceeded"))
+				continue
+			}
+			handleRequest(&request, client)
 		}
-		handleRequest(&request, client)
 	}
 }
 
@@ -372,8 +934,8 @@ func makeResponse(result any, msgs ...string) objectType[any] {
 	return response
 }
 
-func fatal(client *pdnsClient, msg any) {
-	s := fmt.Sprintf("%s", msg)
-	client.respond(makeResponse(false, s))
-	client.log.main().Fatalf("Fatal error: %s", s)
+// responds the error to the client (as failure) and returns it
+func respondError(client *pdnsClient, err error) error {
+	client.respond(makeResponse(false, err.Error()))
+	return err
 }
@@ -0,0 +1,160 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// connects to the socket and initializes the connection
+func newTestConnection(t *testing.T, network, address string) (net.Conn, *json.Encoder, *json.Decoder) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+	if err := encoder.Encode(objectType[any]{"method": "initialize", "parameters": objectType[any]{}}); err != nil {
+		t.Fatalf("failed to send initialize: %s", err)
+	}
+	response := objectType[any]{}
+	if err := decoder.Decode(&response); err != nil || response["result"] != true {
+		t.Fatalf("initialize failed: %v (error %v)", response, err)
+	}
+	return conn, encoder, decoder
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":        `{"ttl": 3600}`,
+		"dns/net.example/SOA":   `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A": `192.0.2.1`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	defer func(value bool) { standalone = value }(standalone)
+	standalone = true
+	path := filepath.Join(t.TempDir(), "socket")
+	socket, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := shutdownOnSignal()
+	done := make(chan struct{})
+	go func() {
+		accept(ctx, socket)
+		close(done)
+	}()
+	conn, encoder, decoder := newTestConnection(t, "unix", path)
+	defer conn.Close()
+	idleConn, _, idleDecoder := newTestConnection(t, "unix", path)
+	defer idleConn.Close()
+	// the lookup is blocked by the write lock, until after the signal
+	dataRoot.Load().mutex.Lock()
+	if err := encoder.Encode(objectType[any]{"method": "lookup", "parameters": objectType[any]{"qname": "www.example.net.", "qtype": "A"}}); err != nil {
+		dataRoot.Load().mutex.Unlock()
+		t.Fatalf("failed to send lookup: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond) // the request is handled meanwhile
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		dataRoot.Load().mutex.Unlock()
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		dataRoot.Load().mutex.Unlock()
+		t.Fatalf("the signal did not cancel the context")
+	}
+	select {
+	case <-done:
+		t.Errorf("stopped before the request in progress finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	dataRoot.Load().mutex.Unlock()
+	response := struct{ Result []objectType[any] }{}
+	if err := decoder.Decode(&response); err != nil {
+		t.Fatalf("failed to receive the lookup response: %s", err)
+	}
+	if len(response.Result) != 1 || response.Result[0]["content"] != "192.0.2.1" {
+		t.Errorf("unexpected lookup response: %+v", response)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("did not stop after the request in progress finished")
+	}
+	// the connections are closed and no new ones are accepted
+	if err := idleDecoder.Decode(&objectType[any]{}); err == nil {
+		t.Errorf("expected the idle connection to be closed")
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		t.Errorf("expected no new connections to be accepted")
+	}
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	defer func(value bool) { standalone = value }(standalone)
+	standalone = true
+	limit := 2
+	args.Concurrency = &limit
+	defer func() { args.Concurrency = nil }()
+	path := filepath.Join(t.TempDir(), "socket")
+	socket, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		accept(ctx, socket)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	var conns []net.Conn
+	for i := 0; i < limit; i++ {
+		conn, _, _ := newTestConnection(t, "unix", path)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	// the connection exceeding the limit is closed without being served
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	_ = json.NewEncoder(conn).Encode(objectType[any]{"method": "initialize", "parameters": objectType[any]{}})
+	if err := json.NewDecoder(conn).Decode(&objectType[any]{}); err == nil {
+		t.Errorf("expected the connection exceeding the limit to be closed")
+	}
+	conn.Close()
+	// a slot is free again after a served connection ended
+	conns[0].Close()
+	time.Sleep(100 * time.Millisecond)
+	conn, _, _ = newTestConnection(t, "unix", path)
+	conn.Close()
+}
@@ -17,6 +17,8 @@ package src
 import (
 	"fmt"
 	"io"
+	"math/rand"
+	"time"
 )
 
 type pdnsRequest struct {
@@ -33,6 +35,8 @@ type pdnsClient struct {
 	PdnsVersion uint
 	Comm        *commType[pdnsRequest]
 	log         logType
+	timings     *timings // of the current request (nil if not traced)
+	random      *rand.Rand
 }
 
 func newPdnsClient(id uint, in io.Reader, out io.Writer) *pdnsClient {
@@ -44,9 +48,23 @@ func newPdnsClient(id uint, in io.Reader, out io.Writer) *pdnsClient {
 	}
 }
 
+// the random source of the client for the option order (values random and weighted), seeded by the parameter order-seed
+// plus the client id if set. it is created on first use, after the parameters are read.
+func (client *pdnsClient) rand() *rand.Rand {
+	if client.random == nil {
+		seed := time.Now().UnixNano()
+		if args.OrderSeed != nil && *args.OrderSeed != 0 {
+			seed = int64(*args.OrderSeed) + int64(client.ID)
+		}
+		client.random = rand.New(rand.NewSource(seed))
+	}
+	return client.random
+}
+
 func (client *pdnsClient) respond(response any) {
 	client.log.pdns().WithField("response", response).Tracef("response")
 	if err := client.Comm.write(response); err != nil {
-		client.log.pdns().WithError(err).WithField("response", response).Fatalf("failed to encode response")
+		// the input fails too (f.e. a closed connection), which ends serving the client
+		client.log.pdns().WithError(err).WithField("response", response).Errorf("failed to encode response")
 	}
 }
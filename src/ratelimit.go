@@ -0,0 +1,62 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"math"
+	"time"
+)
+
+// a token bucket limiting the rate of requests (of a client), not safe for concurrent use
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64 // the capacity
+	tokens float64
+	last   time.Time
+}
+
+// a full token bucket
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refills the bucket up to now and takes a token, if available
+func (tb *tokenBucket) allow(now time.Time) bool {
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens = math.Min(tb.burst, tb.tokens+elapsed.Seconds()*tb.rate)
+		tb.last = now
+	}
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// a new rate limiter for a client as given by the parameters rate and burst (the burst defaults to the rate, at least
+// 1), nil if disabled (not in standalone mode or rate 0)
+func newClientRateLimiter() *tokenBucket {
+	if !standalone || args.Rate == nil || *args.Rate <= 0 {
+		return nil
+	}
+	burst := 0
+	if args.Burst != nil {
+		burst = *args.Burst
+	}
+	if burst <= 0 {
+		burst = maxOf(1, int(math.Ceil(*args.Rate)))
+	}
+	return newTokenBucket(*args.Rate, burst, currentTime())
+}
@@ -0,0 +1,78 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	tb := newTokenBucket(1, 3, now)
+	for i := 0; i < 3; i++ {
+		if !tb.allow(now) {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	if tb.allow(now) {
+		t.Errorf("request exceeding the burst allowed")
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	tb := newTokenBucket(2, 2, now)
+	tb.allow(now)
+	tb.allow(now)
+	if tb.allow(now.Add(400 * time.Millisecond)) {
+		t.Errorf("allowed before a token was refilled")
+	}
+	if !tb.allow(now.Add(500 * time.Millisecond)) {
+		t.Errorf("rejected after a token was refilled")
+	}
+	// the refill is capped at the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !tb.allow(now) {
+			t.Fatalf("request %d after the refill rejected", i+1)
+		}
+	}
+	if tb.allow(now) {
+		t.Errorf("allowed more than the burst after a long pause")
+	}
+}
+
+func TestClientRateLimiter(t *testing.T) {
+	defer func(value bool) { standalone = value }(standalone)
+	defer func() { args.Rate, args.Burst = nil, nil }()
+	standalone = true
+	if newClientRateLimiter() != nil {
+		t.Errorf("expected no limiter without %s", rateParam)
+	}
+	rate, burst := 2.5, 0
+	args.Rate, args.Burst = &rate, &burst
+	if tb := newClientRateLimiter(); tb == nil || tb.burst != 3 {
+		t.Errorf("expected a limiter with the burst defaulting to the rate (3), got %+v", tb)
+	}
+	burst = 10
+	if tb := newClientRateLimiter(); tb == nil || tb.burst != 10 {
+		t.Errorf("expected a limiter with burst 10, got %+v", tb)
+	}
+	standalone = false
+	if newClientRateLimiter() != nil {
+		t.Errorf("expected no limiter in pipe mode")
+	}
+}
@@ -0,0 +1,114 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+func isNumberPart(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// translates relaxed JSON (a subset of JSON5: comments, unquoted object keys and trailing commas) into strict JSON.
+// invalid input is passed through as far as possible, for the JSON parser to report it.
+func relaxJSON(value []byte) ([]byte, error) {
+	var out bytes.Buffer
+	n := len(value)
+	for i := 0; i < n; {
+		c := value[i]
+		switch {
+		case c == '"': // copy the string as is
+			start := i
+			for i++; i < n && value[i] != '"'; i++ {
+				if value[i] == '\\' {
+					i++
+				}
+			}
+			if i >= n {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i++
+			out.Write(value[start:i])
+		case c == '/' && i+1 < n && value[i+1] == '/':
+			for i += 2; i < n && value[i] != '\n'; i++ {
+			}
+		case c == '/' && i+1 < n && value[i+1] == '*':
+			end := bytes.Index(value[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += 2 + end + 2
+			out.WriteByte(' ') // a comment separates tokens
+		case c == '}' || c == ']':
+			// drop a trailing comma (comments are already dropped, only whitespace may follow it)
+			trimmed := bytes.TrimRightFunc(out.Bytes(), func(r rune) bool { return r < 0x80 && isSpace(byte(r)) })
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				out.Truncate(len(trimmed) - 1)
+			}
+			out.WriteByte(c)
+			i++
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			for i++; i < n && isNumberPart(value[i]); i++ {
+			}
+			out.Write(value[start:i])
+		case isIdentifierStart(c):
+			start := i
+			for i++; i < n && isIdentifierPart(value[i]); i++ {
+			}
+			j := i
+			for j < n && isSpace(value[j]) {
+				j++
+			}
+			if j < n && value[j] == ':' { // an object key
+				out.WriteByte('"')
+				out.Write(value[start:i])
+				out.WriteByte('"')
+			} else { // true, false, null (or invalid)
+				out.Write(value[start:i])
+			}
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// parses the JSON value into v, relaxed unless the parameter strict-json is set
+func unmarshalJSON(value []byte, v any) error {
+	if args.StrictJSON == nil || !*args.StrictJSON {
+		var err error
+		if value, err = relaxJSON(value); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(value, v)
+}
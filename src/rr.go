@@ -15,10 +15,16 @@ limitations under the License. */
 package src
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -32,6 +38,11 @@ type rrParams struct {
 	version        *VersionType
 	data           *dataNode
 	ttl            time.Duration
+	notAuth        bool
+	disabled       bool
+	view           *viewType
+	weight         *uint16
+	explanation    *explanation // only set by explain(), the record is not stored then
 	//logger         *logrus.Logger // TODO remove?
 }
 
@@ -40,11 +51,18 @@ func (p *rrParams) Target() string {
 }
 
 func (p *rrParams) SetContent(content string, priority *uint16) {
+	if p.explanation != nil {
+		p.explanation.record = &recordType{content, priority, p.ttl, p.version, p.notAuth, p.disabled, p.view, p.weight}
+		return
+	}
 	// p.data.records was set in dataNode.processValues(), no need to check it here
 	if _, ok := p.data.records[p.qtype]; !ok {
 		p.data.records[p.qtype] = map[string]recordType{}
 	}
-	p.data.records[p.qtype][p.id] = recordType{content, priority, p.ttl, p.version}
+	p.data.records[p.qtype][p.id] = recordType{content, priority, p.ttl, p.version, p.notAuth, p.disabled, p.view, p.weight}
+	if p.qtype == "SOA" {
+		p.data.zoneID = zoneIDs.of(p.data.zoneIDName())
+	}
 	str := fmt.Sprintf("stored record content: %q", content)
 	if priority != nil {
 		str += fmt.Sprintf(" !%d", *priority)
@@ -53,6 +71,12 @@ func (p *rrParams) SetContent(content string, priority *uint16) {
 		str += fmt.Sprintf(" @%s", p.version)
 	}
 	str += fmt.Sprintf(" (%s)", p.ttl)
+	if p.notAuth {
+		str += " not-authoritative"
+	}
+	if p.disabled {
+		str += " disabled"
+	}
 	p.log().Trace(str)
 }
 
@@ -68,38 +92,103 @@ func (p *rrParams) exlog(args ...any) *logrus.Entry {
 
 type rrFunc func(params *rrParams)
 
+// the rrFunc of the QTYPE, generic for the generic type names (TYPE<n>) of types not supported otherwise, nil if none
+func rrFuncOf(qtype string) rrFunc {
+	if rrFunc, ok := rr2func[qtype]; ok {
+		return rrFunc
+	}
+	if genericTypeRegex.MatchString(qtype) {
+		return generic
+	}
+	return nil
+}
+
 var rr2func = map[string]rrFunc{
-	"A":     a,
-	"AAAA":  aaaa,
-	"CNAME": domainName("target"),
-	"DNAME": domainName("name"),
-	"MX":    mx,
-	"NS":    domainName("hostname"),
-	"PTR":   domainName("hostname"),
-	"SOA":   soa,
-	"SRV":   srv,
-	"TXT":   txt,
+	"A":          a,
+	"AAAA":       aaaa,
+	"ALIAS":      domainName("target"),
+	"APL":        apl,
+	"CERT":       cert,
+	"CNAME":      domainName("target"),
+	"DNAME":      domainName("name"),
+	"DS":         ds,
+	"HINFO":      hinfo,
+	"MX":         mx,
+	"NS":         domainName("hostname"),
+	"NSEC3PARAM": nsec3param,
+	"PTR":        domainName("hostname"),
+	"SOA":        soa,
+	"SPF":        txt,
+	"SRV":        srv,
+	"TXT":        txt,
 }
 
+// completes a relative domain name (not ending with a dot), walking up from the node of the record.
+// on each level (domain) the following is done, until the name is complete:
+//  1. the option zone-append-domain is appended, if set on that level itself (resolved by QTYPE and id, so it can be
+//     set for a specific QTYPE only). an absolute value (ending with a dot) completes the name, so it fully replaces
+//     the appending of the zone (f.e. for PTR records in reverse zones pointing into an unrelated forward zone).
+//     a relative value is completed further (by the next step and upper levels).
+//  2. the zone domain is appended, if the level is a zone apex (or the record is a SOA record). for PTR records the
+//     option ptr-forward-zone (of the zone or above) is appended instead, if set.
+//
+// an absolute domain name is returned unchanged. with the option trailing-dot set to as-written, the domain name is
+// returned unchanged too (except for SOA, NS and MX records, whose names are always absolute).
 func fqdn(domain string, params *rrParams) (string, error) {
+	if !absoluteNameTypes[params.qtype] {
+		mode, oPath, err := findOptionValue[string](trailingDotOption, params.qtype, params.id, params.data, false)
+		if err != nil {
+			return domain, fmt.Errorf("failed to get option %q: %s", trailingDotOption, err)
+		}
+		if oPath != nil {
+			switch mode {
+			case trailingDotAlways:
+			case trailingDotAsWritten:
+				params.explain("option %s from %s: %q is used as written", trailingDotOption, oPath, domain)
+				return domain, nil
+			default:
+				return domain, fmt.Errorf("invalid value %q of option %q (in %s)", mode, trailingDotOption, oPath)
+			}
+		}
+	}
 	qSOA := params.qtype == "SOA"
 	for data := params.data; !strings.HasSuffix(domain, "."); data = data.parent {
 		zoneAppendDomain, valuePath, err := findOptionValue[string](zoneAppendDomainOption, params.qtype, params.id, data, true)
 		if err != nil {
-			return domain, fmt.Errorf("failed to get option %q (dn=%s, vp=%s): %s", zoneAppendDomain, data.getQname(), (valuePath), err)
+			return domain, fmt.Errorf("failed to get option %q (dn=%s, vp=%s): %s", zoneAppendDomainOption, data.getQname(), valuePath, err)
 		}
 		if valuePath != nil {
 			zoneAppendDomain = strings.TrimSpace(zoneAppendDomain)
+			if zoneAppendDomain == "" {
+				return domain, fmt.Errorf("option %q is empty (vp=%s)", zoneAppendDomainOption, valuePath)
+			}
 			if zoneAppendDomain[0] != '.' {
 				domain += "."
 			}
 			domain += zoneAppendDomain
+			params.explain("appended option %s %q from %s: %q", zoneAppendDomainOption, zoneAppendDomain, valuePath, domain)
 		}
 		if !strings.HasSuffix(domain, ".") && (qSOA || data.hasSOA()) {
+			if params.qtype == "PTR" {
+				forwardZone, valuePath, err := findOptionValue[string](ptrForwardZoneOption, params.qtype, params.id, data, false)
+				if err != nil {
+					return domain, fmt.Errorf("failed to get option %q (dn=%s, vp=%s): %s", ptrForwardZoneOption, data.getQname(), valuePath, err)
+				}
+				if valuePath != nil {
+					forwardZone = strings.Trim(strings.TrimSpace(forwardZone), ".")
+					if forwardZone == "" {
+						return domain, fmt.Errorf("option %q is empty (vp=%s)", ptrForwardZoneOption, valuePath)
+					}
+					domain += "." + forwardZone + "."
+					params.explain("appended option %s %q from %s: %q", ptrForwardZoneOption, forwardZone, valuePath, domain)
+					break
+				}
+			}
 			if !data.isRoot() {
 				domain += "."
 			}
 			domain += data.getQname()
+			params.explain("appended zone %q: %q", data.getQname(), domain)
 			break
 		}
 		if data.parent == nil {
@@ -109,8 +198,95 @@ func fqdn(domain string, params *rrParams) (string, error) {
 	return domain, nil
 }
 
+// the fallback TTL for records without any TTL value (parameter default-ttl), 0 if not set
+func defaultTTL() time.Duration {
+	if args.DefaultTTL == nil {
+		return 0
+	}
+	return *args.DefaultTTL
+}
+
+// the default TTLs per QTYPE (parameter default-ttls), preferred over the parameter default-ttl
+var defaultTTLs map[string]time.Duration
+
+func qtypeDefaultTTL(qtype string) (time.Duration, string) {
+	if ttl, ok := defaultTTLs[qtype]; ok {
+		return ttl, defaultTTLsParam
+	}
+	return defaultTTL(), defaultTTLParam
+}
+
+// clamps the TTL into the range of the options min-ttl and max-ttl (if set), max-ttl wins if min-ttl is greater
+func clampTTL(ttl time.Duration, params *rrParams) (time.Duration, error) {
+	for _, key := range []string{minTTLOption, maxTTLOption} {
+		value, oPath, err := findOptionValue[any](key, params.qtype, params.id, params.data, false)
+		if err != nil {
+			return ttl, fmt.Errorf("failed to get option %q: %s", key, err)
+		}
+		if oPath == nil {
+			continue
+		}
+		limit, err := parseDuration(value)
+		if err != nil {
+			return ttl, fmt.Errorf("failed to parse option %q (vp=%s): %s", key, oPath, err)
+		}
+		if (key == minTTLOption && ttl < limit) || (key == maxTTLOption && ttl > limit) {
+			params.log("option", key).Tracef("clamping TTL %s to %s", ttl, limit)
+			ttl = limit
+		}
+	}
+	return ttl, nil
+}
+
+// the option not-authoritative (or its alias not-aa), false if not set
+func notAuthoritative(params *rrParams) (bool, error) {
+	for _, key := range []string{notAuthoritativeOption, notAAOption} {
+		notAuth, oPath, err := findOptionValue[bool](key, params.qtype, params.id, params.data, false)
+		if err != nil {
+			return false, fmt.Errorf("failed to get option %q: %s", key, err)
+		}
+		if oPath != nil {
+			return notAuth, nil
+		}
+	}
+	return false, nil
+}
+
+// the field disabled of the record value (or a default), false if not set. SOA records cannot be disabled.
+func isDisabled(params *rrParams, values objectType[any]) (bool, error) {
+	if params.qtype == "SOA" {
+		return false, nil
+	}
+	disabled, _, err := findValueOrDefault[bool]("disabled", values, params.qtype, params.id, params.data, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s.disabled: %s", params.Target(), err)
+	}
+	return disabled, nil
+}
+
+// the field weight of the record value (or a default), nil if not set
+func recordWeight(params *rrParams, values objectType[any]) (*uint16, error) {
+	weightF, vPath, err := findValueOrDefault[float64]("weight", values, params.qtype, params.id, params.data, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s.weight: %s", params.Target(), err)
+	}
+	if vPath == nil {
+		return nil, nil
+	}
+	weightI, err := float2int(weightF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s.weight (%v) to int: %s", params.Target(), weightF, err)
+	}
+	if weightI < 0 || weightI > 65535 {
+		return nil, fmt.Errorf("%s.weight out of range (0-65535)", params.Target())
+	}
+	weight := uint16(weightI)
+	return &weight, nil
+}
+
 func getValue[T any](key string, params *rrParams) (T, *valuePath, error) {
-	value, vPath, err := findValueOrDefault[T](key, params.values, params.qtype, params.id, params.data)
+	_, direct := params.values[key]
+	value, vPath, err := findValueOrDefault[T](key, params.values, params.qtype, params.id, params.data, false)
 	if err != nil {
 		return value, vPath, fmt.Errorf("failed to get value %s.%s (or default): %s", params.Target(), key, err)
 	}
@@ -121,15 +297,104 @@ func getValue[T any](key string, params *rrParams) (T, *valuePath, error) {
 				params.values[key] = lastFieldValue
 				logFrom(log.data(), "value", lastFieldValue).Tracef("using last-field-value for %s:%s", params.Target(), key)
 				params.lastFieldValue = nil
+				params.explain("field %s = %v from the last-field-value", key, lastFieldValue)
 				return lastFieldValue, &qPath, nil
 			}
 			return value, &qPath, fmt.Errorf("invalid value type: %T", *params.lastFieldValue)
 		}
+		params.explain("field %s not found", key)
 		return value, nil, nil
 	}
+	if direct {
+		params.explain("field %s = %v from the entry", key, value)
+	} else {
+		params.explain("field %s = %v from the defaults of %s", key, value, vPath)
+	}
 	return value, &qPath, nil
 }
 
+// expands the tokens {name} (the domain name of the record), {zone} (the zone domain name) and {label} (the first label
+// of the record domain) in a plain string or string last-field-value, if enabled by option content-templates.
+// "{{" and "}}" are literal braces, other tokens are an error.
+func expandTemplate(content string, params *rrParams) (string, error) {
+	enabled, _, err := findOptionValue[bool](contentTemplatesOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		return content, fmt.Errorf("failed to get option %q: %s", contentTemplatesOption, err)
+	}
+	if !enabled {
+		return content, nil
+	}
+	var result strings.Builder
+	for i := 0; i < len(content); i++ {
+		switch {
+		case strings.HasPrefix(content[i:], "{{"), strings.HasPrefix(content[i:], "}}"):
+			result.WriteByte(content[i])
+			i++
+		case content[i] == '{':
+			end := strings.IndexByte(content[i:], '}')
+			if end < 0 {
+				return content, fmt.Errorf("unterminated token at position %d", i)
+			}
+			switch token := content[i+1 : i+end]; token {
+			case "name":
+				result.WriteString(params.data.getQname())
+			case "zone":
+				zone := params.data.findZone()
+				if zone == nil {
+					return content, fmt.Errorf("token {zone} outside of any zone")
+				}
+				result.WriteString(zone.getQname())
+			case "label":
+				result.WriteString(escapeLabel(params.data.label, "."))
+			default:
+				return content, fmt.Errorf("unknown token {%s}", token)
+			}
+			i += end
+		default:
+			result.WriteByte(content[i])
+		}
+	}
+	if expanded := result.String(); expanded != content {
+		params.explain("expanded the content template %q: %q", content, expanded)
+		return expanded, nil
+	}
+	return content, nil
+}
+
+// splits a string last-field-value positionally into the fields keys (separated by whitespace), if it has exactly one
+// part per field (f.e. `="10 mail"` for MX). a part "_" leaves the field to a default, the numeric fields are parsed as
+// numbers. other last-field-values are left as they are.
+func splitLastFieldValue(params *rrParams, numeric map[string]bool, keys ...string) error {
+	if params.lastFieldValue == nil {
+		return nil
+	}
+	value, ok := (*params.lastFieldValue).(string)
+	if !ok {
+		return nil
+	}
+	parts := strings.Fields(value)
+	if len(parts) != len(keys) {
+		return nil
+	}
+	for i, key := range keys {
+		if parts[i] == "_" {
+			continue
+		}
+		if !numeric[key] {
+			params.values[key] = parts[i]
+			continue
+		}
+		number, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q for %s.%s in the last-field-value", parts[i], params.Target(), key)
+		}
+		params.values[key] = number
+	}
+	params.lastFieldValue = nil
+	params.explain("fields %s from the last-field-value %q", strings.Join(keys, " "), value)
+	return nil
+}
+
 func getUint16(key string, params *rrParams) (uint16, *valuePath, error) {
 	valueF, vPath, err := getValue[float64](key, params)
 	if err != nil {
@@ -148,6 +413,24 @@ func getUint16(key string, params *rrParams) (uint16, *valuePath, error) {
 	return uint16(valueI), vPath, nil
 }
 
+func getUint8(key string, params *rrParams) (uint8, *valuePath, error) {
+	valueF, vPath, err := getValue[float64](key, params)
+	if err != nil {
+		return 0, vPath, fmt.Errorf("failed to get %s.%s as float64: %s", params.Target(), key, err)
+	}
+	if vPath == nil {
+		return 0, nil, nil
+	}
+	valueI, err := float2int(valueF)
+	if err != nil {
+		return 0, vPath, fmt.Errorf("failed to convert float (%v) to int: %s", valueF, err)
+	}
+	if valueI < 0 || valueI > 255 {
+		return 0, vPath, fmt.Errorf("out of range (0-255)")
+	}
+	return uint8(valueI), vPath, nil
+}
+
 func getDuration(key string, params *rrParams) (time.Duration, *valuePath, error) {
 	value, vPath, err := getValue[any](key, params)
 	if err != nil {
@@ -156,27 +439,68 @@ func getDuration(key string, params *rrParams) (time.Duration, *valuePath, error
 	if vPath == nil {
 		return 0, nil, nil
 	}
+	dur, err := parseDuration(value)
+	return dur, vPath, err
+}
+
+func parseDuration(value any) (time.Duration, error) {
 	var dur time.Duration
 	switch value := value.(type) {
 	case float64:
 		valueI, err := float2int(value)
 		if err != nil {
-			return 0, vPath, fmt.Errorf("failed to convert float (%v) to int: %s", value, err)
+			return 0, fmt.Errorf("failed to convert float (%v) to int: %s", value, err)
 		}
 		dur = time.Duration(valueI) * time.Second
 	case string:
 		if v, err := time.ParseDuration(value); err == nil {
 			dur = v
 		} else {
-			return 0, vPath, fmt.Errorf("parse error: %s", err)
+			return 0, fmt.Errorf("parse error: %s", err)
 		}
 	default:
-		return 0, vPath, fmt.Errorf("invalid value type (neither a number nor a string): %T", value)
+		return 0, fmt.Errorf("invalid value type (neither a number nor a string): %T", value)
 	}
 	if dur < time.Second {
-		return 0, vPath, fmt.Errorf("must be >= 1s")
+		return 0, fmt.Errorf("must be >= 1s")
+	}
+	return dur, nil
+}
+
+// gets the TTL of the record, in the order: the record value, a default of the record domain,
+// an option (of the record domain or a parent domain), a default of a parent domain.
+// with the option ttl-inherit-across-zones set to false, the parent domains above the zone apex are not searched.
+func getTTL(params *rrParams, values objectType[any]) (time.Duration, *valuePath, error) {
+	value, vPath, err := findValueOrDefault[any]("ttl", values, params.qtype, params.id, params.data, true)
+	stop := (*dataNode)(nil) // the last domain to search, nil = the root
+	if err == nil && vPath == nil {
+		var inherit bool
+		var oPath *valuePath
+		inherit, oPath, err = findOptionValue[bool](ttlInheritOption, params.qtype, params.id, params.data, false)
+		if err == nil && oPath != nil && !inherit {
+			if stop = params.data.findZone(); stop == nil {
+				stop = params.data
+			}
+		}
+	}
+	for data := params.data; err == nil && vPath == nil; data = data.parent {
+		value, vPath, err = findOptionValue[any]("ttl", params.qtype, params.id, data, true)
+		if data == stop || data.parent == nil {
+			break
+		}
+	}
+	for data := params.data; err == nil && vPath == nil && data != stop && data.parent != nil; {
+		data = data.parent
+		value, vPath, err = findValueOrDefault[any]("ttl", nil, params.qtype, params.id, data, true)
+	}
+	if err != nil {
+		return 0, vPath, fmt.Errorf("failed to get %s.ttl: %s", params.Target(), err)
+	}
+	if vPath == nil {
+		return 0, nil, nil
 	}
-	return dur, vPath, nil
+	dur, err := parseDuration(value)
+	return dur, vPath, err
 }
 
 func getHostname(key string, params *rrParams) (string, *valuePath, error) {
@@ -239,7 +563,7 @@ func soa(params *rrParams) {
 		params.exlog("vp", vPath, "error", err).Error("failed to append zone domain to 'mail'")
 	}
 	// serial
-	serial := params.data.zoneRev() // no need for findZone(), because SOA defines the zone
+	serial := soaSerial(params, params.explanation == nil) // explain() does not store the record
 	// refresh
 	refresh, vPath, err := getDuration("refresh", params)
 	if vPath == nil || err != nil {
@@ -264,12 +588,200 @@ func soa(params *rrParams) {
 		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'neg-ttl'")
 		return
 	}
-	// TODO handle option 'not-authoritative' (alias 'not-aa'?)
-	// (done)
+	negativeTTL = soaNegativeTTL(negativeTTL, params)
 	content := fmt.Sprintf("%s %s %d %d %d %d %d", primary, mail, serial, seconds(refresh), seconds(retry), seconds(expire), seconds(negativeTTL))
 	params.SetContent(content, nil)
 }
 
+// checks the negative TTL of the SOA record against its TTL (PowerDNS caches negative answers with the lower one of both,
+// but other resolvers may use the negative TTL alone) and clamps it to the TTL by option clamp-neg-ttl
+func soaNegativeTTL(negativeTTL time.Duration, params *rrParams) time.Duration {
+	if params.ttl == 0 || negativeTTL <= params.ttl {
+		return negativeTTL
+	}
+	clamp, _, err := findOptionValue[bool](clampNegTTLOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.log("error", err).Warnf("failed to get option %q, using false", clampNegTTLOption)
+	}
+	if clamp {
+		params.log().Debugf("clamping neg-ttl %s to the TTL", negativeTTL)
+		return params.ttl
+	}
+	params.log().Warnf("neg-ttl %s is larger than the TTL %s, negative answers may be cached surprisingly long (see option %s)", negativeTTL, params.ttl, clampNegTTLOption)
+	return negativeTTL
+}
+
+// the serial of the SOA record (params.data is the zone), by option soa-serial. a new serial (for the modes unixtime
+// and date) is generated only if generate is set, when processing the SOA record on loading and updating the data.
+func soaSerial(params *rrParams, generate bool) int64 {
+	rev := params.data.zoneRev() // no need for findZone(), because SOA defines the zone
+	mode, oPath, err := findOptionValue[string](soaSerialOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.log("error", err).Warnf("failed to get option %q, using %q", soaSerialOption, soaSerialRevision)
+		return rev
+	}
+	if oPath == nil {
+		return rev
+	}
+	switch mode {
+	case soaSerialRevision:
+		return rev
+	case soaSerialUnixtime, soaSerialDate:
+		if !generate {
+			return zoneSerials.current(params.data.getQname(), mode, rev)
+		}
+		return zoneSerials.next(params.data.getQname(), mode, rev, currentTime())
+	}
+	params.log().Warnf("invalid value %q of option %q (in %s), using %q", mode, soaSerialOption, oPath, soaSerialRevision)
+	return rev
+}
+
+// the serial of the zone as in its SOA record (see soaSerial()), for the domain infos and the update tracking. it only
+// reads the serial generated when processing the SOA record, so requests do not wait for the serial file.
+func (dn *dataNode) zoneSerial() int64 {
+	return soaSerial(&rrParams{qtype: "SOA", data: dn}, false)
+}
+
+// the current time, replaceable for tests
+var currentTime = time.Now
+
+// the last serials of the zones (by qname) for the option values unixtime and date. the serial changes only with the
+// zone revision and is increased at least by one, even if the clock or the mode would not do so. the serials are kept
+// across restarts in the serial file (see parameter serial-file), if configured.
+var zoneSerials = serialsType{states: map[string]serialState{}}
+
+type serialState struct {
+	Mode   string `json:"mode"`
+	Rev    int64  `json:"rev"`
+	Serial int64  `json:"serial"`
+}
+
+type serialsType struct {
+	sync.Mutex
+	states map[string]serialState
+	read   bool       // whether the serial file was read already (see readFile())
+	held   int        // the number of holds (see hold()), the changed states are stored after the last one is released
+	dirty  bool       // whether the states changed since they were stored
+	failed bool       // whether the last write failed (logged once until a write succeeds)
+	file   sync.Mutex // serializes the writes of the serial file, which are done without holding the states lock
+}
+
+// the serial generated last for the zone revision (see next()), or the revision if there is none (f.e. the SOA record
+// could not be processed)
+func (serials *serialsType) current(zone, mode string, rev int64) int64 {
+	serials.Lock()
+	defer serials.Unlock()
+	if state, ok := serials.states[zone]; ok && state.Mode == mode && state.Rev == rev {
+		return state.Serial
+	}
+	return rev
+}
+
+// generates the serial for the zone revision (if it changed) and stores it (see writeFile())
+func (serials *serialsType) next(zone, mode string, rev int64, now time.Time) int64 {
+	if err := serials.readFile(); err != nil {
+		log.data().Errorf("%s, the serials may decrease", err)
+	}
+	serials.Lock()
+	state, ok := serials.states[zone]
+	if ok && state.Mode == mode && state.Rev == rev {
+		serials.Unlock()
+		return state.Serial
+	}
+	var serial int64
+	switch mode {
+	case soaSerialUnixtime:
+		serial = now.Unix()
+	case soaSerialDate: // YYYYMMDDnn (RFC 1912)
+		year, month, day := now.UTC().Date()
+		serial = int64(year*10000+int(month)*100+day) * 100
+	}
+	if ok && serial <= state.Serial {
+		serial = state.Serial + 1
+	}
+	serials.states[zone] = serialState{mode, rev, serial}
+	serials.dirty = true
+	serials.Unlock()
+	serials.writeFile()
+	return serial
+}
+
+// defers storing the changed states until the returned function is called (further calls do nothing), f.e. while
+// loading a whole tree, to store the serials of all zones at once (before they are served)
+func (serials *serialsType) hold() func() {
+	serials.Lock()
+	defer serials.Unlock()
+	serials.held++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			serials.Lock()
+			serials.held--
+			serials.Unlock()
+			serials.writeFile()
+		})
+	}
+}
+
+// reads the states of the serial file (if configured) on the first call. a missing file is no error, any other
+// failure is returned (only once), because the serials could decrease then.
+func (serials *serialsType) readFile() error {
+	serials.Lock()
+	defer serials.Unlock()
+	if serials.read || args.SerialFile == nil || *args.SerialFile == "" {
+		return nil
+	}
+	serials.read = true
+	content, err := os.ReadFile(*args.SerialFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		var states map[string]serialState
+		if err = json.Unmarshal(content, &states); err == nil {
+			for zone, state := range states {
+				if _, ok := serials.states[zone]; !ok {
+					serials.states[zone] = state
+				}
+			}
+			log.data().Debugf("read %d serials from %q", len(states), *args.SerialFile)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to read the serial file %q: %s", *args.SerialFile, err)
+}
+
+// writes the changed states into the serial file (if configured and not held)
+func (serials *serialsType) writeFile() {
+	serials.file.Lock()
+	defer serials.file.Unlock()
+	serials.Lock()
+	if !serials.dirty || serials.held > 0 || args.SerialFile == nil || *args.SerialFile == "" {
+		serials.Unlock()
+		return
+	}
+	content, err := json.Marshal(serials.states)
+	serials.dirty = false
+	serials.Unlock()
+	if err == nil {
+		err = writeFileAtomically(*args.SerialFile, content)
+	}
+	serials.Lock()
+	defer serials.Unlock()
+	if err != nil {
+		serials.dirty = true
+		if !serials.failed {
+			log.data().Errorf("failed to write the serial file %q, the serials may decrease after a restart: %s", *args.SerialFile, err)
+		}
+		serials.failed = true
+		return
+	}
+	if serials.failed {
+		log.data().Infof("wrote the serial file %q again", *args.SerialFile)
+	}
+	serials.failed = false
+}
+
 func parseOctets(value any, ipVer int, asPrefix bool) ([]byte, error) {
 	values := []any{}
 	sepFirst := false
@@ -425,7 +937,162 @@ func parseOctets(value any, ipVer int, asPrefix bool) ([]byte, error) {
 	return octets, nil
 }
 
+// computes the IP of the host index in the network, the index must fit into the host bits
+func hostIP(network *net.IPNet, host *big.Int) (net.IP, error) {
+	ones, bits := network.Mask.Size()
+	if host.Sign() < 0 || host.BitLen() > bits-ones {
+		return nil, fmt.Errorf("host %s does not fit into the %d host bits of %s", host, bits-ones, network)
+	}
+	ip := new(big.Int).SetBytes(network.IP.Mask(network.Mask))
+	return ip.Or(ip, host).FillBytes(make(net.IP, bits/8)), nil
+}
+
+// parses the host index, a non-negative integer (a number or a string, which may be prefixed by 0x, 0o or 0b)
+func parseHost(value any) (*big.Int, error) {
+	switch value := value.(type) {
+	case float64:
+		valueI, err := float2int(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert float (%v) to int: %s", value, err)
+		}
+		return big.NewInt(valueI), nil
+	case string:
+		host, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %q", value)
+		}
+		return host, nil
+	}
+	return nil, fmt.Errorf("invalid value type (neither a number nor a string): %T", value)
+}
+
+// the IP from the value host and the option ip-network, if the option is set and the value is given
+func networkHostIP(params *rrParams, ipVer int) (net.IP, bool) {
+	networkStr, oPath, err := findOptionValue[string](ipNetworkOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.exlog("error", err).Errorf("failed to get option %q", ipNetworkOption)
+		return nil, true
+	}
+	if oPath == nil {
+		return nil, false
+	}
+	value, vPath, err := getValue[any]("host", params)
+	if err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'host'")
+		return nil, true
+	}
+	if vPath == nil {
+		return nil, false
+	}
+	_, network, err := net.ParseCIDR(networkStr)
+	if err != nil || (network.IP.To4() != nil) != (ipVer == 4) {
+		params.log("option", ipNetworkOption, "value", networkStr, "error", err).Errorf("invalid IPv%d network", ipVer)
+		return nil, true
+	}
+	host, err := parseHost(value)
+	if err != nil {
+		params.exlog("field", "host", "value", value).Errorf("failed to parse value: %s", err)
+		return nil, true
+	}
+	ip, err := hostIP(network, host)
+	if err != nil {
+		params.exlog("field", "host", "option", ipNetworkOption).Error(err)
+		return nil, true
+	}
+	return ip, true
+}
+
+// the modified EUI-64 interface identifier of a MAC address (RFC 4291, appendix A): ff:fe inserted in the middle and
+// the universal/local bit inverted
+func eui64(mac net.HardwareAddr) ([]byte, error) {
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("not a MAC address of 6 octets: %s", mac)
+	}
+	return []byte{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}, nil
+}
+
+// the IPv6 address of the value mac by option eui64: the EUI-64 interface identifier spliced onto the first 64 bits
+// of the option ip-network (or ip-prefix). the bool result is false if the option or the value is not set.
+func eui64IP(params *rrParams) (net.IP, bool) {
+	enabled, oPath, err := findOptionValue[bool](eui64Option, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.exlog("error", err).Errorf("failed to get option %q", eui64Option)
+		return nil, true
+	}
+	if oPath == nil || !enabled {
+		return nil, false
+	}
+	value, vPath, err := getValue[string]("mac", params)
+	if err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'mac'")
+		return nil, true
+	}
+	if vPath == nil {
+		return nil, false
+	}
+	mac, err := net.ParseMAC(strings.TrimSpace(value))
+	if err != nil {
+		params.exlog("field", "mac", "value", value).Errorf("failed to parse value: %s", err)
+		return nil, true
+	}
+	identifier, err := eui64(mac)
+	if err != nil {
+		params.exlog("field", "mac", "value", value).Error(err)
+		return nil, true
+	}
+	var prefix []byte
+	if networkStr, oPath, err := findOptionValue[string](ipNetworkOption, params.qtype, params.id, params.data, false); err != nil {
+		params.exlog("error", err).Errorf("failed to get option %q", ipNetworkOption)
+		return nil, true
+	} else if oPath != nil {
+		_, network, err := net.ParseCIDR(networkStr)
+		if err != nil || network.IP.To4() != nil {
+			params.log("option", ipNetworkOption, "value", networkStr, "error", err).Error("invalid IPv6 network")
+			return nil, true
+		}
+		if ones, _ := network.Mask.Size(); ones > 64 {
+			params.log("option", ipNetworkOption, "value", networkStr).Errorf("network is too small for option %q (more than 64 bits)", eui64Option)
+			return nil, true
+		}
+		prefix = network.IP
+	} else {
+		prefixAny, oPath, err := findOptionValue[any](ipPrefixOption, params.qtype, params.id, params.data, false)
+		if err != nil {
+			params.exlog("error", err).Errorf("failed to get option %q", ipPrefixOption)
+			return nil, true
+		}
+		if oPath == nil {
+			params.log("field", "mac").Errorf("neither option %q nor %q is set for option %q", ipNetworkOption, ipPrefixOption, eui64Option)
+			return nil, true
+		}
+		if prefix, err = parseOctets(prefixAny, 6, true); err != nil {
+			params.log("field", "mac", "option", ipPrefixOption).Errorf("failed to parse octets: %s", err)
+			return nil, true
+		}
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[:8], prefix)
+	copy(ip[8:], identifier)
+	return ip, true
+}
+
 func ipRR(params *rrParams, ipVer int) {
+	if ipVer == 6 {
+		if ip, ok := eui64IP(params); ok {
+			if ip != nil {
+				params.SetContent(ip.String(), nil)
+				handleAutoPtr(params, ip)
+			}
+			return
+		}
+	}
+	if ip, ok := networkHostIP(params, ipVer); ok {
+		if ip != nil {
+			params.SetContent(ip.String(), nil)
+			handleAutoPtr(params, ip)
+		}
+		return
+	}
 	value, vPath, err := getValue[any]("ip", params)
 	if vPath == nil || err != nil {
 		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'ip'")
@@ -469,7 +1136,7 @@ func ipRR(params *rrParams, ipVer int) {
 	}
 	content := ip.String()
 	params.SetContent(content, nil)
-	// TODO handle option 'auto-ptr': save the (hostname, ip) pair for later processing, b/c here the reverse zone could be not present yet (later it also could be not present, need to deal with it somehow)
+	handleAutoPtr(params, ip)
 }
 
 func a(params *rrParams) {
@@ -481,6 +1148,10 @@ func aaaa(params *rrParams) {
 }
 
 func srv(params *rrParams) {
+	if err := splitLastFieldValue(params, map[string]bool{"priority": true, "weight": true, "port": true}, "priority", "weight", "port", "target"); err != nil {
+		params.log("error", err).Error("failed to split the last-field-value")
+		return
+	}
 	priority, vPath, err := getUint16("priority", params)
 	if vPath == nil || err != nil {
 		params.log("vp", vPath, "error", err).Error("failed to get value for 'priority'")
@@ -506,6 +1177,10 @@ func srv(params *rrParams) {
 }
 
 func mx(params *rrParams) {
+	if err := splitLastFieldValue(params, map[string]bool{"priority": true}, "priority", "target"); err != nil {
+		params.log("error", err).Error("failed to split the last-field-value")
+		return
+	}
 	priority, vPath, err := getUint16("priority", params)
 	if vPath == nil || err != nil {
 		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'priority'")
@@ -526,5 +1201,279 @@ func txt(params *rrParams) {
 		params.log("vp", vPath, "error", err).Error("failed to get value for 'text' (as string)")
 		return
 	}
+	chunk, oPath, err := findOptionValue[bool](txtChunkOption, params.qtype, params.id, params.data, false)
+	if err != nil {
+		params.exlog("vp", oPath, "error", err).Errorf("failed to get option %q", txtChunkOption)
+		return
+	}
+	if oPath == nil {
+		chunk = true
+	}
+	if chunk && len(text) > txtChunkSize {
+		text = chunkText(text, txtChunkSize)
+	}
 	params.SetContent(text, nil)
 }
+
+// split text into quoted chunks of at most size bytes (unescaped), separated by a space
+func chunkText(text string, size int) string {
+	var chunks []string
+	for len(text) > 0 {
+		n := size
+		if n > len(text) {
+			n = len(text)
+		}
+		chunks = append(chunks, quote(text[:n]))
+		text = text[n:]
+	}
+	return strings.Join(chunks, " ")
+}
+
+func ds(params *rrParams) {
+	keyTag, vPath, err := getUint16("key-tag", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'key-tag'")
+		return
+	}
+	algorithm, vPath, err := getUint8("algorithm", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'algorithm'")
+		return
+	}
+	digestType, vPath, err := getUint8("digest-type", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'digest-type'")
+		return
+	}
+	digest, vPath, err := getValue[string]("digest", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'digest' (as string)")
+		return
+	}
+	digest = strings.TrimSpace(digest)
+	if _, err := hex.DecodeString(digest); err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to parse value for 'digest' as hex string")
+		return
+	}
+	if length, ok := dsDigestLengths[digestType]; ok && len(digest) != length {
+		params.exlog("vp", vPath, "digest-type", digestType).Errorf("invalid length of 'digest' (found %d, need %d)", len(digest), length)
+		return
+	}
+	content := fmt.Sprintf("%d %d %d %s", keyTag, algorithm, digestType, digest)
+	params.SetContent(content, nil)
+}
+
+// checks the generic record data (RFC 3597, section 5): "\\# <length> <hex data>", where the hex data may be split by
+// whitespace and must have exactly the given length (in bytes). the hex data is missing for length 0.
+func checkGenericRdata(content string) error {
+	fields := strings.Fields(content)
+	if len(fields) < 2 || fields[0] != `\#` {
+		return fmt.Errorf(`must begin with "\# <length>"`)
+	}
+	length, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid length %q: %s", fields[1], err)
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return fmt.Errorf("invalid hex data: %s", err)
+	}
+	if uint64(len(data)) != length {
+		return fmt.Errorf("length mismatch (given %d, found %d bytes)", length, len(data))
+	}
+	return nil
+}
+
+// the record of a generic type (TYPE<n>) given by the field data (hex string, may contain whitespace)
+func generic(params *rrParams) {
+	genericRdata("data")(params)
+}
+
+// the record in the generic form (RFC 3597) given by the field key (hex string, may contain whitespace).
+// the field rdata is rendered this way for any QTYPE, bypassing the type-specific handling (see processValuesEntry()).
+func genericRdata(key string) rrFunc {
+	return func(params *rrParams) {
+		data, vPath, err := getValue[string](key, params)
+		if vPath == nil || err != nil {
+			params.exlog("vp", vPath, "error", err).Errorf("failed to get value for '%s' (as string)", key)
+			return
+		}
+		data = strings.Join(strings.Fields(data), "")
+		if _, err := hex.DecodeString(data); err != nil {
+			params.exlog("vp", vPath, "error", err).Errorf("failed to parse value for '%s' as hex string", key)
+			return
+		}
+		content := fmt.Sprintf(`\# %d`, len(data)/2)
+		if len(data) > 0 {
+			content += " " + strings.ToLower(data)
+		}
+		params.SetContent(content, nil)
+	}
+}
+
+// enclose the string in double quotes, escaping contained quotes and backslashes
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func hinfo(params *rrParams) {
+	cpu, vPath, err := getValue[string]("cpu", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'cpu' (as string)")
+		return
+	}
+	os, vPath, err := getValue[string]("os", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'os' (as string)")
+		return
+	}
+	content := fmt.Sprintf("%s %s", quote(cpu), quote(os))
+	params.SetContent(content, nil)
+}
+
+func cert(params *rrParams) {
+	typeAny, vPath, err := getValue[any]("type", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'type'")
+		return
+	}
+	var certType uint16
+	switch value := typeAny.(type) {
+	case float64:
+		valueI, err := float2int(value)
+		if err != nil || valueI < 0 || valueI > 65535 {
+			params.exlog("vp", vPath, "value", value, "error", err).Error("invalid value for 'type' (not an uint16)")
+			return
+		}
+		certType = uint16(valueI)
+	case string:
+		var ok bool
+		if certType, ok = certTypes[strings.ToUpper(strings.TrimSpace(value))]; !ok {
+			params.exlog("vp", vPath, "value", value).Error("invalid value for 'type' (unknown mnemonic)")
+			return
+		}
+	default:
+		params.exlog("vp", vPath).Errorf("invalid value type for 'type' (neither a number nor a string): %T", value)
+		return
+	}
+	keyTag, vPath, err := getUint16("key-tag", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'key-tag'")
+		return
+	}
+	algorithm, vPath, err := getUint8("algorithm", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'algorithm'")
+		return
+	}
+	certificate, vPath, err := getValue[string]("certificate", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'certificate' (as string)")
+		return
+	}
+	certificate = strings.Join(strings.Fields(certificate), "")
+	if _, err := base64.StdEncoding.DecodeString(certificate); err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to parse value for 'certificate' as base64 string")
+		return
+	}
+	content := fmt.Sprintf("%d %d %d %s", certType, keyTag, algorithm, certificate)
+	params.SetContent(content, nil)
+}
+
+func nsec3param(params *rrParams) {
+	algorithm, vPath, err := getUint8("algorithm", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'algorithm'")
+		return
+	}
+	flags, vPath, err := getUint8("flags", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'flags'")
+		return
+	}
+	iterations, vPath, err := getUint16("iterations", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'iterations'")
+		return
+	}
+	salt, vPath, err := getValue[string]("salt", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'salt' (as string)")
+		return
+	}
+	salt = strings.TrimSpace(salt)
+	if salt == "" {
+		salt = "-"
+	}
+	if salt != "-" {
+		if _, err := hex.DecodeString(salt); err != nil {
+			params.exlog("vp", vPath, "error", err).Error("failed to parse value for 'salt' as hex string")
+			return
+		}
+	}
+	content := fmt.Sprintf("%d %d %d %s", algorithm, flags, iterations, salt)
+	params.SetContent(content, nil)
+}
+
+func apl(params *rrParams) {
+	prefixes, vPath, err := getValue[[]any]("prefixes", params)
+	if vPath == nil || err != nil {
+		params.exlog("vp", vPath, "error", err).Error("failed to get value for 'prefixes' (as array)")
+		return
+	}
+	items := make([]string, 0, len(prefixes))
+	for i, prefix := range prefixes {
+		item, err := aplItem(prefix)
+		if err != nil {
+			params.exlog("vp", vPath, "value", prefix).Errorf("failed to parse prefix #%d: %s", i+1, err)
+			return
+		}
+		items = append(items, item)
+	}
+	params.SetContent(strings.Join(items, " "), nil)
+}
+
+func aplItem(value any) (string, error) {
+	spec, ok := value.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("invalid value type (not an object): %T", value)
+	}
+	familyF, ok := spec["family"].(float64)
+	if !ok {
+		return "", fmt.Errorf("missing or invalid 'family' (must be a number)")
+	}
+	prefix, ok := spec["prefix"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing or invalid 'prefix' (must be a string)")
+	}
+	negate := false
+	if negateAny, ok := spec["negate"]; ok {
+		if negate, ok = negateAny.(bool); !ok {
+			return "", fmt.Errorf("invalid 'negate' (must be a boolean)")
+		}
+	}
+	ip, ipNet, err := net.ParseCIDR(strings.TrimSpace(prefix))
+	if err != nil {
+		return "", err
+	}
+	var family int
+	switch familyF {
+	case 1:
+		family = 4
+	case 2:
+		family = 6
+	default:
+		return "", fmt.Errorf("unsupported family %v (must be 1 or 2)", familyF)
+	}
+	if len(ipNet.IP) != ipMeta[family].totalOctets {
+		return "", fmt.Errorf("family %v does not match the prefix address %q", familyF, prefix)
+	}
+	ones, _ := ipNet.Mask.Size()
+	item := fmt.Sprintf("%v:%s/%d", familyF, ip, ones)
+	if negate {
+		item = "!" + item
+	}
+	return item, nil
+}
@@ -15,9 +15,17 @@ limitations under the License. */
 package src
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 type Comparable[T any] interface {
@@ -226,3 +234,833 @@ func TestParseOctets(t *testing.T) {
 		}
 	}
 }
+
+type str string
+
+func (s str) Equal(other str) bool {
+	return s == other
+}
+
+func testName(name string) nameType {
+	return nameType(Map(reversed(splitDomainName(name, ".")), func(name string, i int) namePart {
+		if i == 0 {
+			return namePart{name, "", name}
+		}
+		return namePart{name, keySeparator, name}
+	}))
+}
+
+func setTestSOA(dn *dataNode) {
+	params := rrParams{qtype: "SOA", data: dn}
+	params.SetContent("", nil)
+}
+
+func init() {
+	// the program arguments are initialized in Main(), but the data handling needs some of them
+	args.LazyLoad = new(bool)
+}
+
+func newTestZone(name string) *dataNode {
+	root := newDataNode(nil, "", "")
+	root.defaults[""] = map[string]defoptType{"": {objectType[any]{"ttl": float64(3600)}, nil}}
+	zone := root.getChildCreate(testName(name))
+	setTestSOA(zone)
+	return zone
+}
+
+func storeTestEntry(dn *dataNode, qtype, id, content string) (*recordType, error) {
+	value, isLastFieldValue, err := parseEntryContent([]byte(content), true)
+	if err != nil {
+		return nil, err
+	}
+	params := rrParams{
+		qtype: qtype,
+		id:    id,
+		data:  dn,
+	}
+	processValuesEntry(&params, &valuesType{params.Target(), value, isLastFieldValue, nil, nil})
+	if record, ok := dn.records[qtype][id]; ok {
+		return &record, nil
+	}
+	return nil, fmt.Errorf("no record stored")
+}
+
+func newTestClient() *pdnsClient {
+	return newPdnsClient(0, strings.NewReader(""), io.Discard)
+}
+
+func rrTestFunc(qtype string) testFunc[string, str] {
+	return func(content string) (str, error) {
+		record, err := storeTestEntry(newTestZone("example.net."), qtype, "", content)
+		if err != nil {
+			return "", err
+		}
+		return str(record.content), nil
+	}
+}
+
+func testRR(t *testing.T, qtype string, specs []test[string, str]) {
+	for i, spec := range specs {
+		check[string, str](t, fmt.Sprintf("%s#%d", qtype, i+1), rrTestFunc(qtype), spec.input, spec.expected)
+	}
+}
+
+func TestDS(t *testing.T) {
+	testRR(t, "DS", []test[string, str]{
+		{`{"key-tag":60485,"algorithm":5,"digest-type":1,"digest":"2BB183AF5F22588179A53B0A98631FAD1A292118"}`, ve[str]{v: "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"}},
+		{`{"key-tag":2371,"algorithm":13,"digest-type":2,"digest":"1F987CC6583E92DF0890718C42E2962DC24A5C6A9AEB5C1E6C8B5EDA9E0A2EF6"}`, ve[str]{v: "2371 13 2 1F987CC6583E92DF0890718C42E2962DC24A5C6A9AEB5C1E6C8B5EDA9E0A2EF6"}},
+		{`{"key-tag":2371,"algorithm":13,"digest-type":2,"digest":"2BB183AF5F22588179A53B0A98631FAD1A292118"}`, ve[str]{e: "no record"}},
+		{`{"key-tag":2371,"algorithm":13,"digest-type":2,"digest":"not a hex digest"}`, ve[str]{e: "no record"}},
+		{`{"key-tag":2371,"algorithm":256,"digest-type":2,"digest":"1F987CC6583E92DF0890718C42E2962DC24A5C6A9AEB5C1E6C8B5EDA9E0A2EF6"}`, ve[str]{e: "no record"}},
+	})
+}
+
+func TestSPF(t *testing.T) {
+	testRR(t, "SPF", []test[string, str]{
+		{`v=spf1 -all`, ve[str]{v: "v=spf1 -all"}},
+		{`{"text":"v=spf1 ip4:192.0.2.0/24 -all"}`, ve[str]{v: "v=spf1 ip4:192.0.2.0/24 -all"}},
+		{`="v=spf1 mx -all"`, ve[str]{v: "v=spf1 mx -all"}},
+	})
+	zone := newTestZone("example.net.")
+	zone.defaults["SPF"] = map[string]defoptType{"": {objectType[any]{"ttl": "2h"}, nil}}
+	record, err := storeTestEntry(zone, "SPF", "", `{"text":"v=spf1 -all"}`)
+	if err != nil {
+		t.Fatalf("SPF with defaults: %s", err)
+	}
+	if record.ttl != 2*time.Hour {
+		t.Errorf("SPF with defaults: expected TTL %s, got %s", 2*time.Hour, record.ttl)
+	}
+	item := makeResultItem("SPF", zone, record, newTestClient())
+	if item["qtype"] != "SPF" || item["content"] != "v=spf1 -all" {
+		t.Errorf("SPF result item: unexpected %v", item)
+	}
+}
+
+func TestTXTChunking(t *testing.T) {
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A", 9)[:282]
+	testRR(t, "TXT", []test[string, str]{
+		{`short text`, ve[str]{v: "short text"}},
+		{`{"text":"short text"}`, ve[str]{v: "short text"}},
+		{`{"text":"` + dkim + `"}`, ve[str]{v: str(`"` + dkim[:255] + `" "` + dkim[255:] + `"`)}},
+		{`{"text":"` + strings.Repeat(`\"`, 256) + `"}`, ve[str]{v: str(`"` + strings.Repeat(`\"`, 255) + `" "\""`)}},
+	})
+	for i, spec := range []test[string, str]{
+		{`{"text":"short text"}`, ve[str]{v: "short text"}},
+		{`{"text":"` + dkim + `"}`, ve[str]{v: str(dkim)}},
+	} {
+		check[string, str](t, fmt.Sprintf("TXT(no-chunk)#%d", i+1), func(content string) (str, error) {
+			zone := newTestZone("example.net.")
+			zone.options["TXT"] = map[string]defoptType{"": {objectType[any]{txtChunkOption: false}, nil}}
+			record, err := storeTestEntry(zone, "TXT", "", content)
+			if err != nil {
+				return "", err
+			}
+			return str(record.content), nil
+		}, spec.input, spec.expected)
+	}
+}
+
+func TestHINFO(t *testing.T) {
+	testRR(t, "HINFO", []test[string, str]{
+		{`"amd64" "Linux"`, ve[str]{v: `"amd64" "Linux"`}},
+		{`{"cpu":"amd64","os":"Linux"}`, ve[str]{v: `"amd64" "Linux"`}},
+		{`{"cpu":"PDP-11/70","os":"UNIX \"V7\""}`, ve[str]{v: `"PDP-11/70" "UNIX \"V7\""`}},
+		{`{"cpu":"amd64"}`, ve[str]{e: "no record"}},
+	})
+	zone := newTestZone("example.net.")
+	zone.defaults["HINFO"] = map[string]defoptType{"": {objectType[any]{"cpu": "arm64"}, nil}}
+	record, err := storeTestEntry(zone, "HINFO", "", `="FreeBSD"`)
+	if err != nil {
+		t.Fatalf("HINFO with last-field-value: %s", err)
+	}
+	if record.content != `"arm64" "FreeBSD"` {
+		t.Errorf("HINFO with last-field-value: unexpected content %q", record.content)
+	}
+}
+
+func TestCERT(t *testing.T) {
+	testRR(t, "CERT", []test[string, str]{
+		{`{"type":1,"key-tag":12345,"algorithm":8,"certificate":"MIIBCgKCAQEA"}`, ve[str]{v: "1 12345 8 MIIBCgKCAQEA"}},
+		{`{"type":"PKIX","key-tag":12345,"algorithm":8,"certificate":"MIIBCgKCAQEA"}`, ve[str]{v: "1 12345 8 MIIBCgKCAQEA"}},
+		{`{"type":"pkix","key-tag":0,"algorithm":0,"certificate":"MIIB CgKC\nAQEA"}`, ve[str]{v: "1 0 0 MIIBCgKCAQEA"}},
+		{`{"type":"X509","key-tag":12345,"algorithm":8,"certificate":"MIIBCgKCAQEA"}`, ve[str]{e: "no record"}},
+		{`{"type":1,"key-tag":12345,"algorithm":8,"certificate":"MIIBCgKCAQE"}`, ve[str]{e: "no record"}},
+		{`{"type":1,"key-tag":12345,"algorithm":8,"certificate":"not base64!"}`, ve[str]{e: "no record"}},
+	})
+}
+
+func TestNSEC3PARAM(t *testing.T) {
+	testRR(t, "NSEC3PARAM", []test[string, str]{
+		{`{"algorithm":1,"flags":0,"iterations":0,"salt":"-"}`, ve[str]{v: "1 0 0 -"}},
+		{`{"algorithm":1,"flags":0,"iterations":0,"salt":""}`, ve[str]{v: "1 0 0 -"}},
+		{`{"algorithm":1,"flags":1,"iterations":12,"salt":"aabbccdd"}`, ve[str]{v: "1 1 12 aabbccdd"}},
+		{`{"algorithm":1,"flags":0,"iterations":10,"salt":"xyz"}`, ve[str]{e: "no record"}},
+		{`{"algorithm":1,"flags":0,"iterations":70000,"salt":"-"}`, ve[str]{e: "no record"}},
+	})
+}
+
+func TestAPL(t *testing.T) {
+	testRR(t, "APL", []test[string, str]{
+		{`{"prefixes":[{"family":1,"prefix":"192.0.2.0/24","negate":false}]}`, ve[str]{v: "1:192.0.2.0/24"}},
+		{`{"prefixes":[{"family":1,"prefix":"192.0.2.0/24"},{"family":1,"prefix":"192.0.2.128/25","negate":true},{"family":2,"prefix":"2001:db8::/32"}]}`, ve[str]{v: "1:192.0.2.0/24 !1:192.0.2.128/25 2:2001:db8::/32"}},
+		{`=[{"family":2,"prefix":"2001:db8:a::/48","negate":true}]`, ve[str]{v: "!2:2001:db8:a::/48"}},
+		{`{"prefixes":[]}`, ve[str]{v: ""}},
+		{`{"prefixes":[{"family":2,"prefix":"192.0.2.0/24"}]}`, ve[str]{e: "no record"}},
+		{`{"prefixes":[{"family":1,"prefix":"2001:db8::/32"}]}`, ve[str]{e: "no record"}},
+		{`{"prefixes":[{"family":3,"prefix":"192.0.2.0/24"}]}`, ve[str]{e: "no record"}},
+		{`{"prefixes":[{"family":1,"prefix":"192.0.2.0"}]}`, ve[str]{e: "no record"}},
+		{`{"prefixes":["1:192.0.2.0/24"]}`, ve[str]{e: "no record"}},
+	})
+}
+
+// loads the entries (key → value, without prefix) as if they were read from ETCD and sets them as the global data
+func loadTestData(entries map[string]string) *dataNode {
+	prefix, lazyLoad := "", false
+	args.Prefix, args.LazyLoad = &prefix, &lazyLoad
+	ch := make(chan etcdItem)
+	go func() {
+		defer close(ch)
+		for key, value := range entries {
+			ch <- etcdItem{key, []byte(value), 1}
+		}
+	}()
+	dataRoot.Store(newDataNode(nil, "", ""))
+	dataRoot.Load().reload(ch)
+	return dataRoot.Load()
+}
+
+func TestTTLPrecedence(t *testing.T) {
+	ttlValue := func(ttl string) defoptType {
+		return defoptType{objectType[any]{"ttl": ttl}, nil}
+	}
+	for _, spec := range []struct {
+		name     string
+		content  string
+		setup    func(zone, www *dataNode)
+		expected time.Duration
+	}{
+		{"zone default", `192.0.2.1`, func(zone, www *dataNode) {}, time.Hour},
+		{"option over zone default", `192.0.2.1`, func(zone, www *dataNode) {
+			zone.options[""] = map[string]defoptType{"": ttlValue("5m")}
+		}, 5 * time.Minute},
+		{"option of the record domain", `192.0.2.1`, func(zone, www *dataNode) {
+			zone.options[""] = map[string]defoptType{"": ttlValue("5m")}
+			www.options["A"] = map[string]defoptType{"": ttlValue("3m")}
+		}, 3 * time.Minute},
+		{"record default over option", `192.0.2.1`, func(zone, www *dataNode) {
+			zone.options[""] = map[string]defoptType{"": ttlValue("5m")}
+			www.defaults["A"] = map[string]defoptType{"": ttlValue("2m")}
+		}, 2 * time.Minute},
+		{"zone default below option", `192.0.2.1`, func(zone, www *dataNode) {
+			zone.defaults["A"] = map[string]defoptType{"": ttlValue("10m")}
+			www.options[""] = map[string]defoptType{"": ttlValue("5m")}
+		}, 5 * time.Minute},
+		{"explicit value over all", `{"ip": "192.0.2.1", "ttl": "1m"}`, func(zone, www *dataNode) {
+			zone.options[""] = map[string]defoptType{"": ttlValue("5m")}
+			www.defaults["A"] = map[string]defoptType{"": ttlValue("2m")}
+		}, time.Minute},
+	} {
+		zone := newTestZone("example.net.")
+		www := zone.getChildCreate(testName("www"))
+		spec.setup(zone, www)
+		record, err := storeTestEntry(www, "A", "", spec.content)
+		if err != nil {
+			t.Errorf("%s: %s", spec.name, err)
+			continue
+		}
+		if record.ttl != spec.expected {
+			t.Errorf("%s: expected TTL %s, got %s", spec.name, spec.expected, record.ttl)
+		}
+	}
+}
+
+func TestTTLInheritAcrossZones(t *testing.T) {
+	ttlValue := func(ttl string) defoptType {
+		return defoptType{objectType[any]{"ttl": ttl}, nil}
+	}
+	for _, spec := range []struct {
+		name     string
+		setup    func(root, parent, child *dataNode)
+		expected time.Duration // 0 = no record
+	}{
+		{"inherited from the parent zone", func(root, parent, child *dataNode) {}, 10 * time.Minute},
+		{"stopped at the zone cut", func(root, parent, child *dataNode) {
+			root.options[""] = map[string]defoptType{"": {objectType[any]{ttlInheritOption: false}, nil}}
+		}, 0},
+		{"default of the zone", func(root, parent, child *dataNode) {
+			root.options[""] = map[string]defoptType{"": {objectType[any]{ttlInheritOption: false}, nil}}
+			child.defaults[""] = map[string]defoptType{"": ttlValue("2m")}
+		}, 2 * time.Minute},
+		{"option above the zone", func(root, parent, child *dataNode) {
+			parent.options[""] = map[string]defoptType{"": {objectType[any]{ttlInheritOption: false, "ttl": "5m"}, nil}}
+		}, 0},
+		{"option of the zone", func(root, parent, child *dataNode) {
+			child.options[""] = map[string]defoptType{"": {objectType[any]{ttlInheritOption: false, "ttl": "5m"}, nil}}
+		}, 5 * time.Minute},
+	} {
+		parent := newTestZone("example.net.")
+		root := parent.parent.parent
+		delete(root.defaults, "")
+		parent.defaults[""] = map[string]defoptType{"": ttlValue("10m")}
+		child := parent.getChildCreate(testName("sub"))
+		spec.setup(root, parent, child)
+		setTestSOA(child)
+		record, err := storeTestEntry(child.getChildCreate(testName("www")), "A", "", `192.0.2.1`)
+		switch {
+		case spec.expected == 0 && err == nil:
+			t.Errorf("%s: expected no record, got TTL %s", spec.name, record.ttl)
+		case spec.expected != 0 && err != nil:
+			t.Errorf("%s: %s", spec.name, err)
+		case spec.expected != 0 && record.ttl != spec.expected:
+			t.Errorf("%s: expected TTL %s, got %s", spec.name, spec.expected, record.ttl)
+		}
+	}
+}
+
+func TestTTLClamping(t *testing.T) {
+	zone := newTestZone("example.net.")
+	zone.options[""] = map[string]defoptType{"": {objectType[any]{minTTLOption: "5m", maxTTLOption: float64(86400)}, nil}}
+	for content, expected := range map[string]time.Duration{
+		`{"ip": "192.0.2.1", "ttl": 60}`:    5 * time.Minute,
+		`{"ip": "192.0.2.1", "ttl": "48h"}`: 24 * time.Hour,
+		`{"ip": "192.0.2.1", "ttl": "1h"}`:  time.Hour,
+	} {
+		record, err := storeTestEntry(zone, "A", "", content)
+		if err != nil {
+			t.Errorf("%s: %s", content, err)
+			continue
+		}
+		if record.ttl != expected {
+			t.Errorf("%s: expected TTL %s, got %s", content, expected, record.ttl)
+		}
+	}
+	// the SOA timers are not TTLs
+	record, err := storeTestEntry(zone, "SOA", "", `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 2, "expire": 3, "neg-ttl": 4, "ttl": 1}`)
+	if err != nil {
+		t.Fatalf("SOA: %s", err)
+	}
+	if !strings.HasSuffix(record.content, " 1 2 3 4") || record.ttl != 5*time.Minute {
+		t.Errorf("SOA: unexpected record %+v", record)
+	}
+}
+
+func TestSOANegativeTTL(t *testing.T) {
+	zone := newTestZone("example.net.")
+	hook := logtest.NewLocal(log.data())
+	for _, spec := range []struct {
+		clamp   bool
+		negTTL  string
+		content string
+		warned  bool
+	}{
+		{false, "1h", " 3600", false},
+		{false, "2h", " 7200", true},
+		{true, "2h", " 3600", false},
+		{true, "30m", " 1800", false},
+	} {
+		hook.Reset()
+		zone.options["SOA"] = map[string]defoptType{"": {objectType[any]{clampNegTTLOption: spec.clamp}, nil}}
+		record, err := storeTestEntry(zone, "SOA", "", fmt.Sprintf(`{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 2, "expire": 3, "neg-ttl": %q}`, spec.negTTL))
+		if err != nil {
+			t.Fatalf("clamp=%v, neg-ttl=%s: %s", spec.clamp, spec.negTTL, err)
+		}
+		if !strings.HasSuffix(record.content, spec.content) {
+			t.Errorf("clamp=%v, neg-ttl=%s: expected content ending with %q, got %q", spec.clamp, spec.negTTL, spec.content, record.content)
+		}
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || (entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "neg-ttl"))
+		}
+		if warned != spec.warned {
+			t.Errorf("clamp=%v, neg-ttl=%s: expected a warning %v, got %v", spec.clamp, spec.negTTL, spec.warned, warned)
+		}
+	}
+}
+
+func TestPositionalLastFieldValue(t *testing.T) {
+	zone := newTestZone("example.net.")
+	zone.defaults["MX"] = map[string]defoptType{"": {objectType[any]{"priority": float64(20)}, nil}}
+	zone.defaults["SRV"] = map[string]defoptType{"": {objectType[any]{"weight": float64(5)}, nil}}
+	for _, spec := range []struct {
+		qtype, content string
+		v3, v4         string // "" = no record stored
+		priority       uint16
+	}{
+		{"MX", `="10 mail"`, "mail.example.net.", "10 mail.example.net.", 10},
+		{"MX", `="_ mail"`, "mail.example.net.", "20 mail.example.net.", 20},
+		{"MX", `="mail"`, "mail.example.net.", "20 mail.example.net.", 20},
+		{"MX", `="ten mail"`, "", "", 0},
+		{"SRV", `="10 1 5060 sip"`, "1 5060 sip.example.net.", "10 1 5060 sip.example.net.", 10},
+		{"SRV", `="0 _ 5060 sip."`, "5 5060 sip.", "0 5 5060 sip.", 0},
+		{"SRV", `="0 5060 sip"`, "", "", 0},
+	} {
+		delete(zone.records, spec.qtype)
+		record, err := storeTestEntry(zone, spec.qtype, "", spec.content)
+		if spec.v3 == "" {
+			if err == nil {
+				t.Errorf("%s %s: expected no record, got %+v", spec.qtype, spec.content, record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", spec.qtype, spec.content, err)
+			continue
+		}
+		if record.priority == nil || *record.priority != spec.priority {
+			t.Errorf("%s %s: expected priority %d, got %v", spec.qtype, spec.content, spec.priority, record.priority)
+		}
+		if v3 := recordContent(record, 3); v3 != spec.v3 {
+			t.Errorf("%s %s: expected content %q for version 3, got %q", spec.qtype, spec.content, spec.v3, v3)
+		}
+		if v4 := recordContent(record, 4); v4 != spec.v4 {
+			t.Errorf("%s %s: expected content %q for version 4, got %q", spec.qtype, spec.content, spec.v4, v4)
+		}
+	}
+}
+
+func TestPTRForwardZone(t *testing.T) {
+	reverse := newTestZone("2.0.192.in-addr.arpa.")
+	reverse.options["PTR"] = map[string]defoptType{"": {objectType[any]{ptrForwardZoneOption: "example.net"}, nil}}
+	sub := reverse.getChildCreate(testName("sub"))
+	sub.options["PTR"] = map[string]defoptType{"": {objectType[any]{zoneAppendDomainOption: "hosts"}, nil}}
+	for _, spec := range []struct {
+		dn       *dataNode
+		qtype    string
+		content  string
+		expected string
+	}{
+		{reverse.getChildCreate(testName("1")), "PTR", `="ns1"`, "ns1.example.net."},
+		{reverse.getChildCreate(testName("1")), "PTR", `="ns1.example.org."`, "ns1.example.org."},
+		{reverse.getChildCreate(testName("1")), "CNAME", `="other"`, "other.2.0.192.in-addr.arpa."},
+		{sub.getChildCreate(testName("2")), "PTR", `="www"`, "www.hosts.example.net."},
+	} {
+		record, err := storeTestEntry(spec.dn, spec.qtype, "", spec.content)
+		if err != nil {
+			t.Errorf("%s %s: %s", spec.dn.getQname(), spec.qtype, err)
+			continue
+		}
+		if record.content != spec.expected {
+			t.Errorf("%s %s: expected %q, got %q", spec.dn.getQname(), spec.qtype, spec.expected, record.content)
+		}
+	}
+}
+
+func TestZoneAppendDomain(t *testing.T) {
+	reverse := newTestZone("2.0.192.in-addr.arpa.")
+	reverse.options["PTR"] = map[string]defoptType{"": {objectType[any]{zoneAppendDomainOption: "example.net."}, nil}}
+	sub := reverse.getChildCreate(testName("sub"))
+	sub.options["PTR"] = map[string]defoptType{"": {objectType[any]{zoneAppendDomainOption: "hosts"}, nil}}
+	empty := reverse.getChildCreate(testName("empty"))
+	empty.options["PTR"] = map[string]defoptType{"": {objectType[any]{zoneAppendDomainOption: " "}, nil}}
+	forward := newTestZone("example.net.")
+	for _, spec := range []struct {
+		dn       *dataNode
+		qtype    string
+		content  string
+		expected string
+	}{
+		// an absolute option value replaces the zone appending
+		{reverse.getChildCreate(testName("1")), "PTR", `="www"`, "www.example.net."},
+		// the option is set for PTR only
+		{reverse.getChildCreate(testName("1")), "CNAME", `="other"`, "other.2.0.192.in-addr.arpa."},
+		// a relative option value is completed by the upper levels
+		{sub.getChildCreate(testName("2")), "PTR", `="www"`, "www.hosts.example.net."},
+		// absolute names are not changed
+		{reverse.getChildCreate(testName("3")), "PTR", `="www.example.org."`, "www.example.org."},
+		// the ancestor-based default
+		{forward.getChildCreate(testName("www")), "CNAME", `="web"`, "web.example.net."},
+		{forward.getChildCreate(testName("a.b")), "CNAME", `="web"`, "web.example.net."},
+		{empty, "PTR", `="www"`, ""},
+	} {
+		record, err := storeTestEntry(spec.dn, spec.qtype, "", spec.content)
+		if spec.expected == "" {
+			if err == nil {
+				t.Errorf("%s %s: expected no record, got %v", spec.dn.getQname(), spec.qtype, record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", spec.dn.getQname(), spec.qtype, err)
+			continue
+		}
+		if record.content != spec.expected {
+			t.Errorf("%s %s: expected %q, got %q", spec.dn.getQname(), spec.qtype, spec.expected, record.content)
+		}
+	}
+}
+
+func TestTrailingDot(t *testing.T) {
+	for _, spec := range []struct {
+		mode                    string // option value, "" for not set
+		cname, cnameAbs, mx, ns string
+	}{
+		{"", "web.example.net.", "web.example.org.", "10 mail.example.net.", "ns1.example.net."},
+		{trailingDotAlways, "web.example.net.", "web.example.org.", "10 mail.example.net.", "ns1.example.net."},
+		{trailingDotAsWritten, "web", "web.example.org.", "10 mail.example.net.", "ns1.example.net."},
+		{"invalid", "", "", "10 mail.example.net.", "ns1.example.net."},
+	} {
+		zone := newTestZone("example.net.")
+		if spec.mode != "" {
+			zone.options[""] = map[string]defoptType{"": {objectType[any]{trailingDotOption: spec.mode}, nil}}
+		}
+		www := zone.getChildCreate(testName("www"))
+		for _, entry := range []struct {
+			dn                       *dataNode
+			qtype, content, expected string
+		}{
+			{www, "CNAME", `="web"`, spec.cname},
+			{zone.getChildCreate(testName("abs")), "CNAME", `="web.example.org."`, spec.cnameAbs},
+			{zone, "MX", `{"priority": 10, "target": "mail"}`, spec.mx},
+			{zone, "NS", `="ns1"`, spec.ns},
+		} {
+			record, err := storeTestEntry(entry.dn, entry.qtype, "", entry.content)
+			if entry.expected == "" {
+				if err == nil {
+					t.Errorf("%q: %s %s: expected no record, got %v", spec.mode, entry.qtype, entry.content, record)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%q: %s %s: %s", spec.mode, entry.qtype, entry.content, err)
+			} else if content := recordContent(record, 4); content != entry.expected {
+				t.Errorf("%q: %s %s: expected %q, got %q", spec.mode, entry.qtype, entry.content, entry.expected, content)
+			}
+		}
+	}
+}
+
+func TestGenericType(t *testing.T) {
+	zone := newTestZone("example.net.")
+	for _, spec := range []struct {
+		qtype, content, expected string // expected "" for no record
+	}{
+		{"TYPE123", `\# 0`, `\# 0`},
+		{"TYPE123", `\# 3 0a0B0c`, `\# 3 0a0B0c`},
+		{"TYPE123", `\# 4 0a0b 0c0d`, `\# 4 0a0b 0c0d`},
+		{"TYPE123", `\# 2 0a0b0c`, ""}, // length mismatch
+		{"TYPE123", `\# 3 0a0b`, ""},   // length mismatch
+		{"TYPE123", `\# 1 zz`, ""},     // invalid hex
+		{"TYPE123", `\# x`, ""},        // invalid length
+		{"TYPE123", `some text`, ""},   // not generic
+		{"TYPE65534", `{"data": "0A 0B"}`, `\# 2 0a0b`},
+		{"TYPE65534", `{"data": ""}`, `\# 0`},
+		{"TYPE65534", `="0c"`, `\# 1 0c`},
+		{"TYPE65534", `{"data": "0x"}`, ""},
+		{"TYPEX", `{"data": "00"}`, ""},   // not a generic type name
+		{"UNKNOWN", `\# 1 00`, `\# 1 00`}, // plain strings of other types are not checked
+	} {
+		delete(zone.records, spec.qtype)
+		record, err := storeTestEntry(zone, spec.qtype, "", spec.content)
+		if spec.expected == "" {
+			if err == nil {
+				t.Errorf("%s %s: expected no record, got %v", spec.qtype, spec.content, record)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", spec.qtype, spec.content, err)
+		} else if record.content != spec.expected {
+			t.Errorf("%s %s: expected %q, got %q", spec.qtype, spec.content, spec.expected, record.content)
+		}
+	}
+}
+
+func TestDefaultTTL(t *testing.T) {
+	root := newDataNode(nil, "", "")
+	zone := root.getChildCreate(testName("example.net."))
+	zone.options["A"] = map[string]defoptType{"clamped": {objectType[any]{maxTTLOption: "1m"}, nil}}
+	if _, err := storeTestEntry(zone, "A", "", `192.0.2.1`); err == nil {
+		t.Errorf("expected the record without TTL to be ignored")
+	}
+	ttl := 5 * time.Minute
+	args.DefaultTTL = &ttl
+	defer func() { args.DefaultTTL = nil }()
+	for id, expected := range map[string]time.Duration{"": 5 * time.Minute, "clamped": time.Minute} {
+		record, err := storeTestEntry(zone, "A", id, `192.0.2.1`)
+		if err != nil {
+			t.Errorf("#%s: %s", id, err)
+		} else if record.ttl != expected {
+			t.Errorf("#%s: expected TTL %s, got %s", id, expected, record.ttl)
+		}
+	}
+	record, err := storeTestEntry(zone, "A", "", `{"ip": "192.0.2.1", "ttl": "1h"}`)
+	if err != nil || record.ttl != time.Hour {
+		t.Errorf("expected the TTL value to override the parameter, got %v (%v)", record, err)
+	}
+}
+
+func TestDefaultTTLs(t *testing.T) {
+	ttls := map[string]time.Duration{}
+	for value, ok := range map[string]bool{"A=300, aaaa=5m,,NS=86400": true, "": true, "A": false, "=300": false, "A=0": false, "A=x": false} {
+		if err := setDefaultTTLsParameter(&ttls)(value); (err == nil) != ok {
+			t.Errorf("%q: expected ok=%v, got error %v", value, ok, err)
+		}
+	}
+	if err := setDefaultTTLsParameter(&ttls)("A=300,AAAA=5m"); err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	defaultTTLs = ttls
+	defer func() { defaultTTLs = nil }()
+	zone := newDataNode(nil, "", "").getChildCreate(testName("example.net."))
+	if record, err := storeTestEntry(zone, "A", "", `192.0.2.1`); err != nil || record.ttl != 5*time.Minute {
+		t.Errorf("A: expected TTL 5m, got %v (%v)", record, err)
+	}
+	if record, err := storeTestEntry(zone, "AAAA", "", `{"ip": "2001:db8::1", "ttl": 60}`); err != nil || record.ttl != time.Minute {
+		t.Errorf("AAAA: expected the TTL value to override the parameter, got %v (%v)", record, err)
+	}
+	if _, err := storeTestEntry(zone, "TXT", "", `text`); err == nil {
+		t.Errorf("TXT: expected the record without TTL to be ignored")
+	}
+	ttl := time.Hour
+	args.DefaultTTL = &ttl
+	defer func() { args.DefaultTTL = nil }()
+	for qtype, expected := range map[string]time.Duration{"A": 5 * time.Minute, "TXT": time.Hour} {
+		delete(zone.records, qtype)
+		if record, err := storeTestEntry(zone, qtype, "", `192.0.2.1`); err != nil || record.ttl != expected {
+			t.Errorf("%s: expected TTL %s, got %v (%v)", qtype, expected, record, err)
+		}
+	}
+}
+
+func TestSOASerial(t *testing.T) {
+	defer func(f func() time.Time) { currentTime = f }(currentTime)
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	zone := newTestZone("serial.example.")
+	delete(zoneSerials.states, zone.getQname())
+	serial := func() string {
+		record, err := storeTestEntry(zone, "SOA", "", `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`)
+		if err != nil {
+			t.Fatalf("failed to store SOA: %s", err)
+		}
+		return strings.Fields(record.content)[2]
+	}
+	for i, step := range []struct {
+		mode   string // "" = no option
+		rev    int64
+		now    time.Time
+		serial string
+	}{
+		{"", 5, now, "5"},
+		{soaSerialRevision, 6, now, "6"},
+		{soaSerialUnixtime, 6, now, "1704189600"},
+		{soaSerialUnixtime, 6, now.Add(time.Hour), "1704189600"}, // unchanged zone
+		{soaSerialUnixtime, 7, now, "1704189601"},                // the clock did not advance
+		{soaSerialUnixtime, 8, now.Add(time.Minute), "1704189660"},
+		{soaSerialDate, 8, now, "2024010200"},
+		{soaSerialDate, 8, now, "2024010200"},
+		{soaSerialDate, 9, now.Add(time.Hour), "2024010201"},
+		{soaSerialDate, 10, now.Add(2 * time.Hour), "2024010202"},
+		{soaSerialDate, 11, now.Add(24 * time.Hour), "2024010300"},
+		{"invalid", 12, now, "12"},
+	} {
+		if step.mode == "" {
+			delete(zone.options, "SOA")
+		} else {
+			zone.options["SOA"] = map[string]defoptType{"": {objectType[any]{soaSerialOption: step.mode}, nil}}
+		}
+		zone.maxRev = step.rev
+		now = step.now
+		if actual := serial(); actual != step.serial {
+			t.Errorf("step %d (%s, rev %d): expected serial %s, got %s", i, step.mode, step.rev, step.serial, actual)
+		}
+	}
+	// the counter rolls over into the next day, keeping the serial monotonic
+	zone.options["SOA"] = map[string]defoptType{"": {objectType[any]{soaSerialOption: soaSerialDate}, nil}}
+	zoneSerials.states[zone.getQname()] = serialState{soaSerialDate, 12, 2024010399}
+	zone.maxRev = 13
+	now = time.Date(2024, 1, 3, 23, 0, 0, 0, time.UTC)
+	if actual := serial(); actual != "2024010400" {
+		t.Errorf("expected the serial 2024010400 after 2024010399, got %s", actual)
+	}
+}
+
+func TestSOASerialRestart(t *testing.T) {
+	defer func(f func() time.Time) { currentTime = f }(currentTime)
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	args.SerialFile = strPtr(filepath.Join(t.TempDir(), "serials.json"))
+	defer func() { args.SerialFile = nil }()
+	restart := func() {
+		zoneSerials.states, zoneSerials.read, zoneSerials.failed = map[string]serialState{}, false, false
+	}
+	defer restart()
+	restart()
+	zone := newTestZone("serial.example.")
+	zone.options["SOA"] = map[string]defoptType{"": {objectType[any]{soaSerialOption: soaSerialDate}, nil}}
+	serial := func(rev int64) int64 {
+		zone.maxRev = rev
+		return soaSerial(&rrParams{qtype: "SOA", data: zone}, true)
+	}
+	stored := func() int64 {
+		content, err := os.ReadFile(*args.SerialFile)
+		if err != nil {
+			t.Fatalf("failed to read the serial file: %s", err)
+		}
+		var states map[string]serialState
+		if err := json.Unmarshal(content, &states); err != nil {
+			t.Fatalf("failed to parse the serial file: %s", err)
+		}
+		return states[zone.getQname()].Serial
+	}
+	if actual := serial(5); actual != 2024010200 {
+		t.Errorf("expected serial 2024010200, got %d", actual)
+	}
+	if actual := serial(6); actual != 2024010201 {
+		t.Errorf("expected serial 2024010201, got %d", actual)
+	}
+	restart()
+	if actual := serial(6); actual != 2024010201 {
+		t.Errorf("unchanged zone after restart: expected serial 2024010201, got %d", actual)
+	}
+	if actual := serial(7); actual != 2024010202 {
+		t.Errorf("changed zone after restart: expected serial 2024010202, got %d", actual)
+	}
+	// a held change is stored on release
+	release := zoneSerials.hold()
+	if actual := serial(8); actual != 2024010203 {
+		t.Errorf("expected serial 2024010203, got %d", actual)
+	}
+	if actual := stored(); actual != 2024010202 {
+		t.Errorf("expected the stored serial 2024010202 while held, got %d", actual)
+	}
+	release()
+	release()
+	if actual := stored(); actual != 2024010203 {
+		t.Errorf("expected the stored serial 2024010203 after the release, got %d", actual)
+	}
+	// requests only read the generated serial
+	if actual := zone.zoneSerial(); actual != 2024010203 {
+		t.Errorf("expected the generated serial 2024010203, got %d", actual)
+	}
+	zone.maxRev = 9
+	if actual := zone.zoneSerial(); actual != 9 || stored() != 2024010203 {
+		t.Errorf("expected no new serial for the unprocessed revision 9, got %d (stored %d)", actual, stored())
+	}
+	// an unreadable serial file is an error
+	restart()
+	if err := os.WriteFile(*args.SerialFile, []byte(`{"serial.example.":`), 0o600); err != nil {
+		t.Fatalf("failed to write the serial file: %s", err)
+	}
+	if err := zoneSerials.readFile(); err == nil {
+		t.Errorf("expected an error for the invalid serial file")
+	}
+}
+
+func TestIPNetwork(t *testing.T) {
+	for i, step := range []struct {
+		qtype, network, content string
+		expected                string // "" = no record
+	}{
+		{"A", "192.0.2.0/24", `{"host": 5}`, "192.0.2.5"},
+		{"A", "192.0.2.0/24", `=5`, "192.0.2.5"},
+		{"A", "192.0.2.7/24", `{"host": "0xff"}`, "192.0.2.255"},
+		{"A", "192.0.2.0/24", `{"ip": "192.0.2.9"}`, "192.0.2.9"},
+		{"A", "192.0.2.0/24", `{"host": 256}`, ""},
+		{"A", "192.0.2.0/24", `{"host": -1}`, ""},
+		{"A", "2001:db8::/64", `{"host": 5}`, ""},
+		{"AAAA", "2001:db8::/64", `{"host": "18446744073709551615"}`, "2001:db8::ffff:ffff:ffff:ffff"},
+		{"AAAA", "2001:db8:0:1::/64", `{"host": "0x10000"}`, "2001:db8:0:1::1:0"},
+		{"AAAA", "2001:db8::/64", `{"host": "18446744073709551616"}`, ""},
+		{"AAAA", "2001:db8::/64", `{"host": "x"}`, ""},
+	} {
+		zone := newTestZone("example.net.")
+		zone.options[step.qtype] = map[string]defoptType{"": {objectType[any]{ipNetworkOption: step.network}, nil}}
+		record, err := storeTestEntry(zone, step.qtype, "", step.content)
+		switch {
+		case step.expected == "" && err == nil:
+			t.Errorf("step %d (%s, %s): expected no record, got %q", i, step.network, step.content, record.content)
+		case step.expected != "" && err != nil:
+			t.Errorf("step %d (%s, %s): expected %s, got error: %s", i, step.network, step.content, step.expected, err)
+		case step.expected != "" && record.content != step.expected:
+			t.Errorf("step %d (%s, %s): expected %s, got %s", i, step.network, step.content, step.expected, record.content)
+		}
+	}
+}
+
+func TestEUI64(t *testing.T) {
+	for i, step := range []struct {
+		option, value, content string
+		expected               string // "" = no record
+	}{
+		{ipPrefixOption, "2001:db8:0:1:", `{"mac": "00:11:22:33:44:55"}`, "2001:db8:0:1:211:22ff:fe33:4455"},
+		{ipPrefixOption, "2001:db8:0:1:", `="02-11-22-33-44-55"`, "2001:db8:0:1:11:22ff:fe33:4455"},
+		{ipNetworkOption, "2001:db8:0:2::/64", `{"mac": "00:11:22:33:44:55"}`, "2001:db8:0:2:211:22ff:fe33:4455"},
+		{ipNetworkOption, "2001:db8:0:2::/64", `{"host": 5}`, "2001:db8:0:2::5"},
+		{ipPrefixOption, "2001:db8:0:1:", `{"ip": ":1"}`, "2001:db8:0:1::1"},
+		{ipNetworkOption, "2001:db8:0:2::/80", `{"mac": "00:11:22:33:44:55"}`, ""},
+		{ipPrefixOption, "2001:db8:0:1:", `{"mac": "00:11:22:33:44"}`, ""},
+		{ipPrefixOption, "2001:db8:0:1:", `{"mac": "00:11:22:33:44:55:66:77"}`, ""},
+		{ipPrefixOption, "2001:db8:0:1:", `{"mac": "x"}`, ""},
+	} {
+		zone := newTestZone("example.net.")
+		zone.options["AAAA"] = map[string]defoptType{"": {objectType[any]{eui64Option: true, step.option: step.value}, nil}}
+		record, err := storeTestEntry(zone, "AAAA", "", step.content)
+		switch {
+		case step.expected == "" && err == nil:
+			t.Errorf("step %d (%s): expected no record, got %q", i, step.content, record.content)
+		case step.expected != "" && err != nil:
+			t.Errorf("step %d (%s): expected %s, got error: %s", i, step.content, step.expected, err)
+		case step.expected != "" && record.content != step.expected:
+			t.Errorf("step %d (%s): expected %s, got %s", i, step.content, step.expected, record.content)
+		}
+	}
+}
+
+func TestContentTemplates(t *testing.T) {
+	zone := newTestZone("example.net.")
+	www := zone.getChildCreate(testName("www"))
+	for i, step := range []struct {
+		enabled        bool
+		qtype, content string
+		expected       string // "" = no record
+	}{
+		{true, "TXT", `"host {name} in {zone}"`, `"host www.example.net. in example.net."`},
+		{true, "TXT", `"{label}"`, `"www"`},
+		{true, "TXT", `"{{zone}} {{}}"`, `"{zone} {}"`},
+		{true, "CNAME", `="{label}-1"`, "www-1.example.net."},
+		{true, "TXT", "!{name}", "{name}"},
+		{true, "TXT", `"{unknown}"`, ""},
+		{true, "TXT", `"{name"`, ""},
+		{false, "TXT", `"{name}"`, `"{name}"`},
+	} {
+		zone.options[""] = map[string]defoptType{"": {objectType[any]{contentTemplatesOption: step.enabled}, nil}}
+		delete(www.records, step.qtype)
+		record, err := storeTestEntry(www, step.qtype, "", step.content)
+		switch {
+		case step.expected == "" && err == nil:
+			t.Errorf("step %d (%s): expected no record, got %q", i, step.content, record.content)
+		case step.expected != "" && err != nil:
+			t.Errorf("step %d (%s): expected %s, got error: %s", i, step.content, step.expected, err)
+		case step.expected != "" && record.content != step.expected:
+			t.Errorf("step %d (%s): expected %s, got %s", i, step.content, step.expected, record.content)
+		}
+	}
+}
+
+func TestRawRdata(t *testing.T) {
+	zone := newTestZone("example.net.")
+	for i, step := range []struct {
+		qtype, content string
+		expected       string // "" = no record
+	}{
+		{"A", `{"rdata": "c0000201"}`, `\# 4 c0000201`},
+		{"NAPTR", `{"rdata": "00 0A 00 64"}`, `\# 4 000a0064`},
+		{"TYPE65534", `{"rdata": ""}`, `\# 0`},
+		{"A", `{"rdata": "c00002"}`, `\# 3 c00002`},
+		{"A", `{"rdata": "xyz"}`, ""},
+		{"A", `{"rdata": "c00"}`, ""},
+		{"A", `{"rdata": 5}`, ""},
+	} {
+		delete(zone.records, step.qtype)
+		record, err := storeTestEntry(zone, step.qtype, "", step.content)
+		switch {
+		case step.expected == "" && err == nil:
+			t.Errorf("step %d (%s): expected no record, got %q", i, step.content, record.content)
+		case step.expected != "" && err != nil:
+			t.Errorf("step %d (%s): expected %s, got error: %s", i, step.content, step.expected, err)
+		case step.expected != "" && record.content != step.expected:
+			t.Errorf("step %d (%s): expected %s, got %s", i, step.content, step.expected, record.content)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// translates a search pattern (with wildcards '*' and '?') into a case-insensitive regular expression
+func searchPatternRegex(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	return regexp.Compile("(?i)^" + expr + "$")
+}
+
+func searchRecords(params objectType[any], client *pdnsClient) (interface{}, error) {
+	pattern, ok := params["pattern"].(string)
+	if !ok {
+		return false, fmt.Errorf("missing or invalid parameter 'pattern'")
+	}
+	maxResults := -1
+	if value, ok := params["maxResults"].(float64); ok {
+		maxResults = int(value)
+	}
+	re, err := searchPatternRegex(pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	matches := func(s string) bool {
+		return re.MatchString(s) || re.MatchString(strings.TrimSuffix(s, "."))
+	}
+	result := []objectType[any]{}
+	ensureAllLoaded()
+	dataRoot.Load().walk(func(dn *dataNode) bool {
+		if len(dn.records) > 0 && !isServedZone(dn.findZone()) {
+			return true
+		}
+		qname := dn.getQname()
+		nameMatches := matches(qname)
+		for qtype, records := range dn.records {
+			for _, record := range records {
+				if maxResults >= 0 && len(result) >= maxResults {
+					return false
+				}
+				if !nameMatches && !matches(record.content) {
+					continue
+				}
+				item := makeResultItem(qtype, dn, &record, client)
+				item["disabled"] = record.disabled
+				client.log.pdns().WithField("item", item).Trace("adding result item")
+				result = append(result, item)
+			}
+		}
+		return maxResults < 0 || len(result) < maxResults
+	})
+	client.log.pdns().WithField("#", len(result)).Debug("request result items count")
+	return result, nil
+}
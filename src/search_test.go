@@ -0,0 +1,66 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSearchRecords(t *testing.T) {
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	zone.records["SOA"][""] = recordType{content: "ns1.example.net. hostmaster.example.net. 1 1 1 1 1"}
+	zone.getChildCreate(testName("www")).records["A"] = map[string]recordType{"": {content: "192.0.2.1"}}
+	zone.getChildCreate(testName("mail")).records["A"] = map[string]recordType{"": {content: "192.0.2.2"}}
+	zone.getChildCreate(testName("www")).records["AAAA"] = map[string]recordType{"": {content: "2001:db8::1"}}
+	dataRoot.Load().getChildCreate(testName("example.org.")).records["A"] = map[string]recordType{"": {content: "192.0.2.3"}}
+	for _, spec := range []struct {
+		pattern    string
+		maxResults float64
+		expected   []string
+	}{
+		{"*.example.net", 100, []string{"mail.example.net./A", "www.example.net./A", "www.example.net./AAAA"}},
+		{"WWW.example.net.", 100, []string{"www.example.net./A", "www.example.net./AAAA"}},
+		{"192.0.2.?", 100, []string{"example.org./A", "mail.example.net./A", "www.example.net./A"}},
+		{"*example*", 100, []string{"example.net./SOA", "example.org./A", "mail.example.net./A", "www.example.net./A", "www.example.net./AAAA"}},
+		{"nothing", 100, []string{}},
+	} {
+		result, err := searchRecords(objectType[any]{"pattern": spec.pattern, "maxResults": spec.maxResults}, newTestClient())
+		if err != nil {
+			t.Fatalf("%s: searchRecords failed: %s", spec.pattern, err)
+		}
+		items := result.([]objectType[any])
+		got := Map(items, func(item objectType[any], _ int) string { return item["qname"].(string) + "/" + item["qtype"].(string) })
+		sort.Strings(got)
+		if !equal(got, spec.expected) {
+			t.Errorf("%s: expected %v, got %v", spec.pattern, spec.expected, got)
+		}
+		for _, item := range items {
+			if item["disabled"] != false {
+				t.Errorf("%s: expected disabled=false, got %v", spec.pattern, item)
+			}
+		}
+	}
+	for _, maxResults := range []float64{0, 1, 2} {
+		result, err := searchRecords(objectType[any]{"pattern": "*", "maxResults": maxResults}, newTestClient())
+		if err != nil {
+			t.Fatalf("searchRecords failed: %s", err)
+		}
+		if n := len(result.([]objectType[any])); n != int(maxResults) {
+			t.Errorf("maxResults %v: got %d items", maxResults, n)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the key (with prefix) of the record entry of the domain name (in normal form) and QTYPE, the labels are separated by keySeparator
+func recordKey(name, qtype string) string {
+	parsed := parseName(name)
+	for i := 1; i < parsed.len(); i++ {
+		parsed[i].keyPrefix = keySeparator
+	}
+	return *args.Prefix + parsed.asKey(true) + qtype
+}
+
+// dry-runs the entry value through the processing of the record type (with the defaults and options of the data below
+// root), returns the record content. the record is not stored, the node of the domain name is created if missing.
+func checkRecord(root *dataNode, name, qtype string, value []byte) (string, error) {
+	content, isLastFieldValue, err := parseEntryContent(value, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse value: %s", err)
+	}
+	dn := root.getChildCreate(parseName(name))
+	previous, hadPrevious := dn.records[qtype][""]
+	defer func() {
+		if hadPrevious {
+			dn.records[qtype][""] = previous
+		} else if records, ok := dn.records[qtype]; ok {
+			delete(records, "")
+			if len(records) == 0 {
+				delete(dn.records, qtype)
+			}
+		}
+	}()
+	if records, ok := dn.records[qtype]; ok {
+		delete(records, "")
+	}
+	diags := &diagnostics{}
+	collectDiagnostics(diags, func() {
+		processValuesEntry(&rrParams{qtype: qtype, data: dn}, &valuesType{recordKey(name, qtype), content, isLastFieldValue, nil, nil})
+	})
+	record, ok := dn.records[qtype][""]
+	if !ok {
+		messages := Map(diags.list, func(diag diagnostic, _ int) string { return diag.message })
+		return "", fmt.Errorf("invalid record: %s", strings.Join(messages, "; "))
+	}
+	return recordContent(&record, defaultPdnsVersion), nil
+}
+
+// validates the record against the data in ETCD and writes it (replacing an existing entry)
+func setRecord(name, qtype, value string) error {
+	qtype = strings.ToUpper(qtype)
+	if !qtypeRegex.MatchString(qtype) {
+		return fmt.Errorf("invalid QTYPE %q", qtype)
+	}
+	if err := loadDataOnce("set"); err != nil {
+		return err
+	}
+	defer closeClient()
+	content, err := checkRecord(dataRoot.Load(), name, qtype, []byte(value))
+	if err != nil {
+		return err
+	}
+	key := recordKey(name, qtype)
+	if err := put(key, value); err != nil {
+		return fmt.Errorf("failed to put %q: %s", key, err)
+	}
+	log.main().Infof("set %q (content %q)", key, content)
+	return nil
+}
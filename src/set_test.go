@@ -0,0 +1,63 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetRecord(t *testing.T) {
+	newTestETCD("dns/", nil)
+	for _, spec := range []struct{ name, qtype, key string }{
+		{"www.example.net.", "A", "dns/net/example/www/A"},
+		{"WWW.Example.NET", "TXT", "dns/net/example/www/TXT"},
+		{"example.net", "SOA", "dns/net/example/SOA"},
+		{".", "NS", "dns/NS"},
+	} {
+		if key := recordKey(spec.name, spec.qtype); key != spec.key {
+			t.Errorf("%s %s: expected key %q, got %q", spec.name, spec.qtype, spec.key, key)
+		}
+	}
+	zone := newTestZone("example.net.")
+	if _, err := storeTestEntry(zone.getChildCreate(testName("www")), "A", "", `192.0.2.1`); err != nil {
+		t.Fatal(err)
+	}
+	root := zone.findUpwards(func(dn *dataNode) bool { return dn.isRoot() })
+	for _, spec := range []struct{ name, qtype, value, content, error string }{
+		{"www.example.net.", "A", `{"ip": "192.0.2.2"}`, "192.0.2.2", ""},
+		{"mail.example.net.", "MX", `{"priority": 10, "target": "mx"}`, "10 mx.example.net.", ""},
+		{"www.example.net.", "A", `{"ip": "192.0.2"}`, "", "too few octets"},
+		{"www.example.net.", "A", `{"ip": "300.0.2.1"}`, "", "failed to parse value to octets"},
+		{"www.example.net.", "A", `{"address": "192.0.2.1"}`, "", "failed to get value for 'ip'"},
+		{"www.example.net.", "FOO", `{"foo": 1}`, "", "not object-supported"},
+		{"www.example.net.", "A", `{"ip": `, "", "failed to parse value"},
+	} {
+		content, err := checkRecord(root, spec.name, spec.qtype, []byte(spec.value))
+		if spec.error == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", spec.value, err)
+			} else if content != spec.content {
+				t.Errorf("%s: expected content %q, got %q", spec.value, spec.content, content)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), spec.error) {
+			t.Errorf("%s: expected error containing %q, got %v", spec.value, spec.error, err)
+		}
+	}
+	// the existing record is kept
+	if records := zone.getChild(parseName("www"), false).records["A"]; len(records) != 1 || records[""].content != "192.0.2.1" {
+		t.Errorf("the existing record was modified: %v", records)
+	}
+}
@@ -0,0 +1,232 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the content of the snapshot file (see parameter snapshot-file), only the processed records of the tree
+type snapshotType struct {
+	DataVersion string        `json:"data-version"`
+	Root        *snapshotNode `json:"root"`
+}
+
+type snapshotNode struct {
+	KeyPrefix string                               `json:"key-prefix,omitempty"`
+	Label     string                               `json:"label,omitempty"`   // only if spelled differently than the key in Children
+	Records   map[string]map[string]snapshotRecord `json:"records,omitempty"` // <QTYPE> → (<id> → record)
+	Children  map[string]*snapshotNode             `json:"children,omitempty"`
+}
+
+type snapshotRecord struct {
+	Content  string        `json:"content"`
+	Priority *uint16       `json:"priority,omitempty"`
+	TTL      time.Duration `json:"ttl"`
+	NotAuth  bool          `json:"not-auth,omitempty"`
+	Disabled bool          `json:"disabled,omitempty"`
+	View     []string      `json:"view,omitempty"`
+	Weight   *uint16       `json:"weight,omitempty"`
+}
+
+func (dn *dataNode) snapshot() *snapshotNode {
+	dn.mutex.RLock()
+	defer dn.mutex.RUnlock()
+	node := &snapshotNode{KeyPrefix: dn.keyPrefix}
+	if dn.label != dn.lname {
+		node.Label = dn.label
+	}
+	if len(dn.records) > 0 {
+		node.Records = map[string]map[string]snapshotRecord{}
+		for qtype, records := range dn.records {
+			node.Records[qtype] = map[string]snapshotRecord{}
+			for id, record := range records {
+				node.Records[qtype][id] = snapshotRecord{record.content, record.priority, record.ttl, record.notAuth, record.disabled, record.view.strings(), record.weight}
+			}
+		}
+	}
+	for lname, child := range dn.children {
+		if childNode := child.snapshot(); len(childNode.Records) > 0 || len(childNode.Children) > 0 {
+			if node.Children == nil {
+				node.Children = map[string]*snapshotNode{}
+			}
+			node.Children[lname] = childNode
+		}
+	}
+	return node
+}
+
+func (node *snapshotNode) restore(dn *dataNode) {
+	for qtype, records := range node.Records {
+		dn.records[qtype] = map[string]recordType{}
+		for id, record := range records {
+			var view *viewType
+			if len(record.View) > 0 {
+				view, _ = parseView(record.View)
+			}
+			dn.records[qtype][id] = recordType{content: record.Content, priority: record.Priority, ttl: record.TTL, notAuth: record.NotAuth, disabled: record.Disabled, view: view, weight: record.Weight}
+		}
+	}
+	if dn.hasSOA() {
+		dn.zoneID = zoneIDs.of(dn.zoneIDName())
+	}
+	for lname, childNode := range node.Children {
+		child := newDataNode(dn, lname, childNode.KeyPrefix)
+		if childNode.Label != "" {
+			child.label = childNode.Label
+		}
+		dn.children[lname] = child
+		childNode.restore(child)
+	}
+}
+
+// writes the records of the tree (must be the root node) into the file, replacing it atomically
+func writeSnapshot(path string, root *dataNode) error {
+	content, err := json.Marshal(snapshotType{dataVersion.String(), root.snapshot()})
+	if err != nil {
+		return fmt.Errorf("failed to serialize the data: %s", err)
+	}
+	return writeFileAtomically(path, content)
+}
+
+// writes the content into the file, replacing it atomically
+func writeFileAtomically(path string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name()) // fails after the rename, which is fine
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write %q: %s", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %q: %s", file.Name(), err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %q: %s", path, err)
+	}
+	return nil
+}
+
+// reads the file written by writeSnapshot() into a new tree
+func readSnapshot(path string) (*dataNode, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot snapshotType
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %s", path, err)
+	}
+	if snapshot.DataVersion != dataVersion.String() {
+		return nil, fmt.Errorf("snapshot %q has data version %s, expected %s", path, snapshot.DataVersion, dataVersion.String())
+	}
+	root := newDataNode(nil, "", "")
+	if snapshot.Root != nil {
+		snapshot.Root.restore(root)
+	}
+	root.assignZoneIDs()
+	return root, nil
+}
+
+// stores the current data into the snapshot file (if configured)
+func saveSnapshot(caller string) {
+	if !snapshotEnabled() {
+		return
+	}
+	if err := writeSnapshot(*args.SnapshotFile, dataRoot.Load()); err != nil {
+		log.main().Warnf("{%s} failed to write the snapshot: %s", caller, err)
+		return
+	}
+	log.main().Debugf("{%s} wrote the snapshot to %q", caller, *args.SnapshotFile)
+}
+
+func snapshotEnabled() bool {
+	// the tree is complete only in eager mode
+	return args.SnapshotFile != nil && *args.SnapshotFile != "" && !*args.LazyLoad
+}
+
+// connects to ETCD and populates the data (see populateData()). if that fails and a snapshot file is configured, the
+// data of the snapshot is served instead (stale), while connecting is retried in the background until it succeeds.
+// the returned function stops the data watchers (or the retries) and closes the client.
+func connectData(caller string) (func(), []string, error) {
+	// serving the serials generated without the stored ones could decrease them, which secondaries do not follow
+	if err := zoneSerials.readFile(); err != nil {
+		return nil, nil, err
+	}
+	setupLookupCache()
+	connect := func() (func(), []string, error) {
+		logMessages, err := setupClient()
+		if err != nil {
+			return nil, nil, fmt.Errorf("setupClient() failed: %s", err)
+		}
+		cancel, err := populateData(caller)
+		if err != nil {
+			cancel()
+			closeClient()
+			return nil, nil, fmt.Errorf("populateData() failed: %s", err)
+		}
+		return func() { cancel(); closeClient() }, logMessages, nil
+	}
+	stop, logMessages, err := connect()
+	if err == nil || !snapshotEnabled() {
+		return stop, logMessages, err
+	}
+	root, snapshotErr := readSnapshot(*args.SnapshotFile)
+	if snapshotErr != nil {
+		return nil, nil, fmt.Errorf("%s (and failed to read the snapshot: %s)", err, snapshotErr)
+	}
+	dataRoot.Store(root)
+	log.main().Warnf("{%s} ETCD is unavailable (%s), serving stale data from the snapshot %q until it recovers", caller, err, *args.SnapshotFile)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan func(), 1)
+	go func() {
+		stopped <- recoverData(ctx, caller, func() (func(), error) {
+			stop, _, err := connect()
+			return stop, err
+		})
+	}()
+	stop = func() {
+		cancel()
+		if stop := <-stopped; stop != nil {
+			stop()
+		}
+	}
+	return stop, []string{fmt.Sprintf("ETCD is unavailable, serving stale data from the snapshot %q", *args.SnapshotFile)}, nil
+}
+
+// retries connect (with backoff) until it succeeds or ctx is done. returns the stop function of the successful attempt.
+func recoverData(ctx context.Context, caller string, connect func() (func(), error)) func() {
+	b := backoff{min: minimumWatchBackoff, max: maximumWatchBackoff}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(b.next()):
+		}
+		stop, err := connect()
+		if err == nil {
+			log.main().Infof("{%s} ETCD is available again, serving current data", caller)
+			return stop
+		}
+		log.main().Debugf("{%s} ETCD is still unavailable: %s", caller, err)
+	}
+}
@@ -0,0 +1,126 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	zone := newTestZone("example.net.")
+	www := zone.getChildCreate(testName("www"))
+	for _, entry := range []struct {
+		dn                 *dataNode
+		qtype, id, content string
+	}{
+		{www, "A", "1", `192.0.2.1`},
+		{www, "A", "2", `192.0.2.2`},
+		{www, "TXT", "", `"some text"`},
+		{zone, "MX", "", `{"priority": 10, "target": "mail"}`},
+	} {
+		if _, err := storeTestEntry(entry.dn, entry.qtype, entry.id, entry.content); err != nil {
+			t.Fatalf("failed to store %s %s: %s", entry.qtype, entry.content, err)
+		}
+	}
+	origRoot := zone
+	for origRoot.parent != nil {
+		origRoot = origRoot.parent
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := writeSnapshot(path, origRoot); err != nil {
+		t.Fatalf("writeSnapshot() failed: %s", err)
+	}
+	root, err := readSnapshot(path)
+	if err != nil {
+		t.Fatalf("readSnapshot() failed: %s", err)
+	}
+	if restored := root.getChild(testName("example.net."), false); restored == nil || restored.zoneID != zone.zoneID {
+		t.Fatalf("expected the restored zone with ID %d, got %v", zone.zoneID, restored)
+	}
+	for _, query := range []struct{ qname, qtype string }{
+		{"www.example.net.", "A"},
+		{"www.example.net.", "TXT"},
+		{"example.net.", "MX"},
+		{"example.net.", "SOA"},
+	} {
+		dataRoot.Store(origRoot)
+		expected := lookupContents(t, query.qname, query.qtype)
+		dataRoot.Store(root)
+		if contents := lookupContents(t, query.qname, query.qtype); len(expected) == 0 || !equal(contents, expected) {
+			t.Errorf("%s %s: expected %v, got %v", query.qname, query.qtype, expected, contents)
+		}
+	}
+	if err := os.WriteFile(path, []byte(`{"data-version": "0.1.0", "root": {}}`), 0600); err != nil {
+		t.Fatalf("failed to write %s: %s", path, err)
+	}
+	if _, err := readSnapshot(path); err == nil {
+		t.Errorf("expected an error for a snapshot of another data version")
+	}
+}
+
+func TestSnapshotStaleServe(t *testing.T) {
+	kv, watcher := newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":        `{"ttl": 3600}`,
+		"dns/net.example/SOA":   `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A": `192.0.2.1`,
+	})
+	args.SnapshotFile = strPtr(filepath.Join(t.TempDir(), "snapshot.json"))
+	defer func() { args.SnapshotFile = nil }()
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	<-watcher.keys
+	cancel()
+	// ETCD is unavailable at the next start, the snapshot is served
+	kv.entries["dns/net.example/www/A"] = `192.0.2.2`
+	dataRoot.Store(newDataNode(nil, "", ""))
+	root, err := readSnapshot(*args.SnapshotFile)
+	if err != nil {
+		t.Fatalf("readSnapshot() failed: %s", err)
+	}
+	dataRoot.Store(root)
+	if contents := lookupContents(t, "www.example.net.", "A"); !equal(contents, []string{"192.0.2.1"}) {
+		t.Errorf("expected the stale record, got %v", contents)
+	}
+	attempts := 0
+	stop := recoverData(context.Background(), "test", func() (func(), error) {
+		if attempts++; attempts < 3 {
+			return nil, fmt.Errorf("unavailable")
+		}
+		return populateData("test")
+	})
+	if stop == nil {
+		t.Fatalf("expected recoverData() to succeed")
+	}
+	<-watcher.keys
+	stop()
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	if contents := lookupContents(t, "www.example.net.", "A"); !equal(contents, []string{"192.0.2.2"}) {
+		t.Errorf("expected the current record after recovery, got %v", contents)
+	}
+	ctx, cancelRecovery := context.WithCancel(context.Background())
+	cancelRecovery()
+	if stop := recoverData(ctx, "test", func() (func(), error) { return nil, fmt.Errorf("unavailable") }); stop != nil {
+		t.Errorf("expected no stop function after cancellation")
+	}
+}
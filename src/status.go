@@ -0,0 +1,107 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var startTime = time.Now()
+
+// the revisions of the data (by cluster): of the last load, advanced by the handled events
+var dataRevisions = struct {
+	sync.Mutex
+	revisions map[string]int64
+}{revisions: map[string]int64{}}
+
+func setDataRevisions(revisions map[string]int64) {
+	dataRevisions.Lock()
+	defer dataRevisions.Unlock()
+	dataRevisions.revisions = map[string]int64{}
+	for cluster, revision := range revisions {
+		dataRevisions.revisions[cluster] = revision
+	}
+}
+
+func advanceDataRevision(cluster string, revision int64) {
+	dataRevisions.Lock()
+	defer dataRevisions.Unlock()
+	dataRevisions.revisions[cluster] = maxOf(dataRevisions.revisions[cluster], revision)
+}
+
+// the number of requests (handled and in progress), for status
+var requestsCount = struct {
+	sync.Mutex
+	total, current, max int64
+}{}
+
+// counts a started request, the returned function must be called when it is finished
+func countRequest() func() {
+	requestsCount.Lock()
+	defer requestsCount.Unlock()
+	requestsCount.total++
+	requestsCount.current++
+	requestsCount.max = maxOf(requestsCount.max, requestsCount.current)
+	return func() {
+		requestsCount.Lock()
+		defer requestsCount.Unlock()
+		requestsCount.current--
+	}
+}
+
+// the number of the records (by name and QTYPE, like recordsCount()) and zones of the tree, with each node read-locked
+func (dn *dataNode) counts() (records int, zones int) {
+	dn.walk(func(dn *dataNode) bool {
+		records += len(dn.records)
+		if dn.hasSOA() {
+			zones++
+		}
+		return true
+	})
+	return
+}
+
+// the non-standard request method "status" (enabled by parameter status): the loaded state of the data and the
+// request statistics, for monitoring
+func status(_ objectType[any], _ *pdnsClient) (interface{}, error) {
+	if args.Status == nil || !*args.Status {
+		return false, fmt.Errorf("request method status is not enabled (parameter %s)", statusParam)
+	}
+	records, zones := dataRoot.Load().counts()
+	result := objectType[any]{
+		"records": records,
+		"zones":   zones,
+		"uptime":  seconds(time.Since(startTime)),
+	}
+	dataRevisions.Lock()
+	revisions := map[string]int64{}
+	for cluster, revision := range dataRevisions.revisions {
+		revisions[cluster] = revision
+	}
+	dataRevisions.Unlock()
+	if len(clusters) > 0 {
+		result["revision"] = revisions[clusters[0]]
+	}
+	if len(clusters) > 1 {
+		result["revisions"] = revisions
+	}
+	requestsCount.Lock()
+	result["requests"] = requestsCount.total
+	result["max-concurrent-requests"] = requestsCount.max
+	requestsCount.Unlock()
+	return result, nil
+}
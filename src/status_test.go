@@ -0,0 +1,75 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	newTestETCD("dns/", nil)
+	args.Status = new(bool)
+	defer func() { args.Status = nil }()
+	zone := newTestZone("example.net.")
+	dataRoot.Store(zone.parent.parent)
+	for _, name := range []string{"www", "mail"} {
+		if _, err := storeTestEntry(zone.getChildCreate(testName(name)), "A", "", `192.0.2.1`); err != nil {
+			t.Fatalf("failed to store A: %s", err)
+		}
+	}
+	setTestSOA(dataRoot.Load().getChildCreate(testName("example.org.")))
+	setDataRevisions(map[string]int64{"": 5})
+	advanceDataRevision("", 7)
+	advanceDataRevision("", 6)
+	if _, err := status(nil, newTestClient()); err == nil {
+		t.Errorf("expected an error when status is not enabled")
+	}
+	*args.Status = true
+	done := countRequest()
+	result, err := status(nil, newTestClient())
+	done()
+	if err != nil {
+		t.Fatalf("status failed: %s", err)
+	}
+	object, ok := result.(objectType[any])
+	if !ok {
+		t.Fatalf("expected an object, got %T", result)
+	}
+	if object["records"] != 4 || object["zones"] != 2 || object["revision"] != int64(7) {
+		t.Errorf("unexpected data status: %v", object)
+	}
+	if _, ok := object["revisions"]; ok {
+		t.Errorf("expected no revisions by cluster for a single cluster, got %v", object["revisions"])
+	}
+	if object["requests"].(int64) < 1 || object["max-concurrent-requests"].(int64) < 1 {
+		t.Errorf("unexpected request counts: %v", object)
+	}
+	encoded, err := json.Marshal(makeResponse(result))
+	if err != nil {
+		t.Fatalf("failed to encode the response: %s", err)
+	}
+	var decoded struct {
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode the response: %s", err)
+	}
+	for _, key := range []string{"records", "zones", "revision", "uptime", "requests", "max-concurrent-requests"} {
+		if _, ok := decoded.Result[key].(float64); !ok {
+			t.Errorf("expected the number %q in the response, got %v", key, decoded.Result[key])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// connects to the syslog given by the address: "local" (the local syslog daemon) or "[<network>://]<host>:<port>"
+// (network defaults to udp)
+func dialSyslog(address string) (syslogWriter, error) {
+	const priority = syslog.LOG_INFO | syslog.LOG_DAEMON
+	if address == "local" {
+		return syslog.New(priority, "pdns-etcd3")
+	}
+	network := "udp"
+	if n, addr, ok := strings.Cut(address, "://"); ok {
+		network, address = n, addr
+	}
+	return syslog.Dial(network, address, priority, "pdns-etcd3")
+}
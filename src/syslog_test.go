@@ -0,0 +1,63 @@
+//go:build !windows && !plan9
+
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := newTestClient()
+	if err := readParameters(objectType[string]{logSyslogParam: "udp://" + conn.LocalAddr().String()}, client); err != nil {
+		t.Fatalf("readParameters() failed: %s", err)
+	}
+	for component, logger := range client.log {
+		installed := false
+		for _, hook := range logger.Hooks[logrus.InfoLevel] {
+			if _, ok := hook.(syslogHook); ok {
+				installed = true
+			}
+		}
+		if !installed {
+			t.Errorf("%s: syslog hook not installed", component)
+		}
+	}
+	client.log.main().Warn("syslog test message")
+	buffer := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("failed to receive the message: %s", err)
+	}
+	// priority = daemon (3) * 8 + warning (4)
+	if message := string(buffer[:n]); !strings.HasPrefix(message, "<28>") || !strings.Contains(message, "WRN: [0] syslog test message") {
+		t.Errorf("unexpected message: %q", message)
+	}
+	if err := readParameters(objectType[string]{logSyslogParam: "foo://localhost:1"}, newTestClient()); err == nil {
+		t.Errorf("expected an error for an invalid network")
+	}
+}
@@ -0,0 +1,101 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parses the address of the TCP Connector mode: tcp://<host>:<port>[?allow=<IP or CIDR>[,...]].
+// returns the host (with port) and the allowed source networks (nil = all).
+func parseTCPAddress(address string) (string, []*net.IPNet, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", nil, err
+	}
+	if u.Scheme != "tcp" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", nil, fmt.Errorf("expected tcp://<host>:<port>")
+	}
+	var allowed []*net.IPNet
+	for key, values := range u.Query() {
+		if key != "allow" {
+			return "", nil, fmt.Errorf("unknown parameter %q", key)
+		}
+		for _, value := range values {
+			for _, value := range strings.Split(value, ",") {
+				network, err := parseIPNet(strings.TrimSpace(value))
+				if err != nil {
+					return "", nil, err
+				}
+				allowed = append(allowed, network)
+			}
+		}
+	}
+	return u.Host, allowed, nil
+}
+
+// parses an IP (as single address network) or a CIDR
+func parseIPNet(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %s", value, err)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", value)
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	} else {
+		ip = ip.To4()
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// accepts only the connections from the allowed source networks, others are closed right away
+type allowListener struct {
+	net.Listener
+	allowed []*net.IPNet
+}
+
+func (l *allowListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && l.allows(addr.IP) {
+			return conn, nil
+		}
+		log.main().Warnf("{listen} Rejecting connection from %s (not allowed)", conn.RemoteAddr())
+		_ = conn.Close()
+	}
+}
+
+func (l *allowListener) allows(ip net.IP) bool {
+	for _, network := range l.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseTCPAddress(t *testing.T) {
+	for _, spec := range []struct {
+		address, host string
+		allowed       []string
+	}{
+		{"tcp://127.0.0.1:5300", "127.0.0.1:5300", nil},
+		{"tcp://[::1]:5300/", "[::1]:5300", nil},
+		{"tcp://:5300?allow=192.0.2.1,2001:db8::/32&allow=10.0.0.0/8", ":5300", []string{"192.0.2.1/32", "2001:db8::/32", "10.0.0.0/8"}},
+	} {
+		host, allowed, err := parseTCPAddress(spec.address)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", spec.address, err)
+			continue
+		}
+		if host != spec.host {
+			t.Errorf("%s: expected host %q, got %q", spec.address, spec.host, host)
+		}
+		if actual := Map(allowed, func(network *net.IPNet, _ int) string { return network.String() }); !equal(actual, spec.allowed) {
+			t.Errorf("%s: expected allowed %v, got %v", spec.address, spec.allowed, actual)
+		}
+	}
+	for _, address := range []string{"127.0.0.1:5300", "unix:///tmp/socket", "tcp://:5300?allow=foo", "tcp://:5300?deny=192.0.2.1", "tcp://:5300/path"} {
+		if _, _, err := parseTCPAddress(address); err == nil {
+			t.Errorf("%s: expected an error", address)
+		}
+	}
+}
+
+func TestTCPConnector(t *testing.T) {
+	newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":        `{"ttl": 3600}`,
+		"dns/net.example/SOA":   `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A": `192.0.2.1`,
+	})
+	cancel, err := populateData("test")
+	if err != nil {
+		t.Fatalf("populateData() failed: %s", err)
+	}
+	defer cancel()
+	defer func(value bool) { standalone = value }(standalone)
+	standalone = true
+	for _, spec := range []struct {
+		allow   string
+		allowed bool
+	}{
+		{"", true},
+		{"?allow=192.0.2.0/24,127.0.0.1", true},
+		{"?allow=192.0.2.1", false},
+	} {
+		host, allowed, err := parseTCPAddress("tcp://127.0.0.1:0" + spec.allow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		socket, err := net.Listen("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != nil {
+			socket = &allowListener{socket, allowed}
+		}
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			accept(ctx, socket)
+			close(done)
+		}()
+		address := socket.Addr().String()
+		if spec.allowed {
+			conn, encoder, decoder := newTestConnection(t, "tcp", address)
+			if err := encoder.Encode(objectType[any]{"method": "lookup", "parameters": objectType[any]{"qname": "www.example.net.", "qtype": "A"}}); err != nil {
+				t.Fatalf("failed to send lookup: %s", err)
+			}
+			response := struct{ Result []objectType[any] }{}
+			if err := decoder.Decode(&response); err != nil {
+				t.Errorf("%q: failed to receive the lookup response: %s", spec.allow, err)
+			} else if len(response.Result) != 1 || response.Result[0]["content"] != "192.0.2.1" {
+				t.Errorf("%q: unexpected lookup response: %+v", spec.allow, response)
+			}
+			conn.Close()
+		} else {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			if err := json.NewDecoder(conn).Decode(&objectType[any]{}); err == nil || isTimeout(err) {
+				t.Errorf("%q: expected the connection to be closed, got %v", spec.allow, err)
+			}
+			conn.Close()
+		}
+		stop()
+		<-done
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -17,8 +17,10 @@ package src
 import (
 	"cmp"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,18 +39,43 @@ func seconds(dur time.Duration) int64 {
 	return int64(dur.Seconds())
 }
 
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func clearMap[K comparable, V any](m map[K]V) {
 	for k := range m {
 		delete(m, k)
 	}
 }
 
+// splits the name at the separator (ignoring a trailing one), except where it is escaped by a backslash (see escapeLabel())
 func splitDomainName(name string, separator string) []string {
-	name = strings.TrimSuffix(name, separator)
-	if name == "" {
+	var parts []string
+	start := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' {
+			i++ // skip the escaped character (the digits of \DDD are no separators anyway)
+			continue
+		}
+		if strings.HasPrefix(name[i:], separator) {
+			parts = append(parts, name[start:i])
+			i += len(separator) - 1
+			start = i + 1
+		}
+	}
+	if start < len(name) || len(parts) == 0 {
+		parts = append(parts, name[start:])
+	}
+	if len(parts) == 1 && parts[0] == "" {
 		return []string(nil)
 	}
-	return strings.Split(name, separator)
+	return parts
 }
 
 // Map takes a slice of type T, maps every element of it to type R through the mapper function and returns the mapped elements in a new slice of type R
@@ -61,6 +88,17 @@ func Map[T any, R any](slice []T, mapper func(T, int) R) []R {
 	return r
 }
 
+// Filter returns the elements of the slice for which the predicate returns true, in a new slice
+func Filter[T any](slice []T, predicate func(T) bool) []T {
+	var r []T
+	for _, element := range slice {
+		if predicate(element) {
+			r = append(r, element)
+		}
+	}
+	return r
+}
+
 func ptr2str[T any](ptr *T) string {
 	if ptr == nil {
 		return "<nil>"
@@ -88,3 +126,53 @@ func maxOf[T cmp.Ordered](first T, more ...T) T {
 	}
 	return result
 }
+
+func minOf[T cmp.Ordered](first T, more ...T) T {
+	result := first
+	for _, item := range more {
+		if item < result {
+			result = item
+		}
+	}
+	return result
+}
+
+// workerPool limits the number of additional goroutines started by run()
+type workerPool chan struct{}
+
+func newWorkerPool(size int) workerPool {
+	return make(workerPool, maxOf(size, 0))
+}
+
+// run calls f in a new goroutine (registered in wg), if the pool has a free slot, otherwise in the current goroutine.
+// the latter prevents deadlocks on nested calls, because no goroutine waits for a free slot.
+func (wp workerPool) run(wg *sync.WaitGroup, f func()) {
+	select {
+	case wp <- struct{}{}:
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-wp
+				wg.Done()
+			}()
+			f()
+		}()
+	default:
+		f()
+	}
+}
+
+// waits for the WaitGroup, returns false if the timeout elapsed before
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
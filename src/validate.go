@@ -0,0 +1,179 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// a problem of the data, found by validateData()
+type diagnostic struct {
+	level   logrus.Level // logrus.ErrorLevel or logrus.WarnLevel
+	subject string       // the domain name or the entry key, if known
+	message string
+}
+
+type diagnostics struct {
+	mutex sync.Mutex // the data is processed in parallel
+	list  []diagnostic
+}
+
+func (d *diagnostics) add(level logrus.Level, subject string, format string, args ...any) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.list = append(d.list, diagnostic{level, subject, fmt.Sprintf(format, args...)})
+}
+
+// collects the warnings and errors of the data logger as diagnostics
+type diagnosticsHook struct {
+	diagnostics *diagnostics
+}
+
+func (h diagnosticsHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (h diagnosticsHook) Fire(entry *logrus.Entry) error {
+	subject := ""
+	for _, field := range []string{"entry", "dn"} {
+		if value, ok := entry.Data[field].(string); ok {
+			subject = value
+			break
+		}
+	}
+	h.diagnostics.add(entry.Level, subject, "%s", entry.Message)
+	return nil
+}
+
+// calls f, collecting the warnings and errors of the data logger into diags instead of logging them
+func collectDiagnostics(diags *diagnostics, f func()) {
+	logger := log.data()
+	hooks, out := logger.Hooks, logger.Out
+	logger.ReplaceHooks(logrus.LevelHooks{})
+	logger.AddHook(diagnosticsHook{diags})
+	logger.SetOutput(io.Discard)
+	defer func() {
+		logger.ReplaceHooks(hooks)
+		logger.SetOutput(out)
+	}()
+	f()
+}
+
+// processes the entries into a new tree (like loadData(), but not replacing dataRoot) and checks the tree.
+// the problems which are logged while processing the entries are collected instead of being logged.
+func validateData(items []etcdItem) []diagnostic {
+	diags := &diagnostics{}
+	dataCh := make(chan etcdItem)
+	go func() {
+		for _, item := range items {
+			// version incompatible entries are silently ignored by reload()
+			if _, _, _, _, version, _ := parseEntryKey(item.Key); version != nil && !dataVersion.isCompatibleTo(version) {
+				diags.add(logrus.WarnLevel, item.Key, "ignored entry due to version incompatibility (data version %s)", &dataVersion)
+			}
+			dataCh <- item
+		}
+		close(dataCh)
+	}()
+	root := newDataNode(nil, "", "")
+	collectDiagnostics(diags, func() {
+		root.mutex.Lock()
+		defer root.mutex.Unlock()
+		root.reload(dataCh)
+		root.checkTargets(root)
+	})
+	root.walk(func(dn *dataNode) bool {
+		dn.validate(root, diags)
+		return true
+	})
+	sort.SliceStable(diags.list, func(i, j int) bool {
+		a, b := diags.list[i], diags.list[j]
+		return a.subject < b.subject || (a.subject == b.subject && a.level < b.level)
+	})
+	return diags.list
+}
+
+// checks the records of the node (not its descendants)
+func (dn *dataNode) validate(root *dataNode, diags *diagnostics) {
+	if len(dn.records) > 0 && dn.findZone() == nil {
+		diags.add(logrus.ErrorLevel, dn.getQname(), "records outside of any zone (no SOA record at or above the domain)")
+	}
+	// the targets are checked only if they are in a zone of the data
+	target := func(content string) (*dataNode, bool) {
+		targetNode := root.getChild(parseName(content), false)
+		if targetNode.findZone() == nil {
+			return nil, false
+		}
+		if targetNode.getQname() != content {
+			return nil, true
+		}
+		return targetNode, true
+	}
+	for _, qtype := range []string{"CNAME", "NS"} {
+		for _, record := range dn.records[qtype] {
+			if record.disabled {
+				continue
+			}
+			targetNode, inData := target(record.content)
+			if !inData {
+				continue
+			}
+			switch {
+			case targetNode == nil || len(targetNode.records) == 0:
+				diags.add(logrus.WarnLevel, dn.getQname(), "dangling %s target %q (no records)", qtype, record.content)
+			case qtype == "NS" && len(targetNode.records["A"])+len(targetNode.records["AAAA"]) == 0:
+				diags.add(logrus.WarnLevel, dn.getQname(), "%s target %q has no address records", qtype, record.content)
+			}
+		}
+	}
+}
+
+// writes the diagnostics to w, returns the number of errors
+func writeDiagnostics(w io.Writer, diags []diagnostic) (int, error) {
+	errors := 0
+	for _, diag := range diags {
+		if diag.level == logrus.ErrorLevel {
+			errors++
+		}
+		if _, err := fmt.Fprintf(w, "%s %s: %s\n", logLevelChars[diag.level], diag.subject, diag.message); err != nil {
+			return errors, err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%d problems (%d errors)\n", len(diags), errors); err != nil {
+		return errors, err
+	}
+	return errors, nil
+}
+
+// connects to ETCD, validates the whole data and writes the problems to w. returns the number of errors.
+func validate(w io.Writer) (int, error) {
+	if _, err := setupClient(); err != nil {
+		return 0, fmt.Errorf("setupClient() failed: %s", err)
+	}
+	defer closeClient()
+	_, dataChan, err := getRouted(*args.Prefix, "", nil, true, startupTimeout())
+	if err != nil {
+		return 0, fmt.Errorf("getRouted() failed: %s", err)
+	}
+	var items []etcdItem
+	for item := range dataChan {
+		items = append(items, item)
+	}
+	return writeDiagnostics(w, validateData(items))
+}
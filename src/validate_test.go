@@ -0,0 +1,72 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateData(t *testing.T) {
+	newTestETCD("dns/", nil)
+	entries := map[string]string{
+		"dns/net.example/SOA":         `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1, "ttl": 3600}`,
+		"dns/net.example/NS#1":        `{"hostname": "ns1", "ttl": 3600}`,
+		"dns/net.example/NS#2":        `{"hostname": "ns2", "ttl": 3600}`,
+		"dns/net.example/NS#3":        `{"hostname": "ns.example.org.", "ttl": 3600}`,
+		"dns/net.example/ns1/A":       `{"ip": "192.0.2.1", "ttl": 3600}`,
+		"dns/net.example/ns2/TXT":     `{"text": "no address", "ttl": 3600}`,
+		"dns/net.example/www/CNAME":   `{"target": "web", "ttl": 3600}`,
+		"dns/net.example/ftp/CNAME":   `{"target": "www", "ttl": 3600}`,
+		"dns/net.example/mail/A":      `192.0.2.2`,
+		"dns/net.example/foo/BAR":     `{"bar": 1, "ttl": 3600}`,
+		"dns/net.example/new/A@2.0":   `{"ip": "192.0.2.3", "ttl": 3600}`,
+		"dns/org.example/www/A":       `{"ip": "192.0.2.4", "ttl": 3600}`,
+		"dns/net.example/other/CNAME": `{"target": "www.example.com.", "ttl": 3600}`,
+	}
+	var items []etcdItem
+	for _, key := range sortedKeys(entries) {
+		items = append(items, etcdItem{key, []byte(entries[key]), 1})
+	}
+	buffer := strings.Builder{}
+	errors, err := writeDiagnostics(&buffer, validateData(items))
+	if err != nil {
+		t.Fatalf("writeDiagnostics() failed: %s", err)
+	}
+	output := buffer.String()
+	for _, expected := range []string{
+		`ERR dns/net.example/foo/BAR: record type "BAR" is not object-supported`,
+		`ERR : failed to get TTL for entry "dns/net.example/mail/A", ignoring`,
+		`WRN dns/net.example/new/A@2.0: ignored entry due to version incompatibility`,
+		`WRN example.net.: NS target "ns2.example.net." has no address records`,
+		`WRN www.example.net.: dangling CNAME target "web.example.net." (no records)`,
+		`ERR www.example.org.: records outside of any zone`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the output:\n%s", expected, output)
+		}
+	}
+	for _, unexpected := range []string{"ns1.example.net.", "ns.example.org.", "ftp.example.net.", "www.example.com."} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("unexpected %q in the output:\n%s", unexpected, output)
+		}
+	}
+	if errors != 3 {
+		t.Errorf("expected 3 errors, got %d:\n%s", errors, output)
+	}
+	if !strings.HasSuffix(output, "\n6 problems (3 errors)\n") {
+		t.Errorf("unexpected summary:\n%s", output)
+	}
+}
@@ -15,6 +15,7 @@ limitations under the License. */
 package src
 
 import (
+	"cmp"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -23,6 +24,7 @@ import (
 
 const (
 	developmentPrefix = "0."
+	rangeSeparator    = "-"
 )
 
 var (
@@ -33,6 +35,7 @@ var (
 type VersionType struct {
 	IsDevelopment       bool
 	Major, Minor, Patch uint64
+	Until               *VersionType // the upper bound (inclusive) of a version range, nil for a single version
 }
 
 func (v *VersionType) String() string {
@@ -47,17 +50,58 @@ func (v *VersionType) String() string {
 	if v.Patch > 0 {
 		vs += fmt.Sprintf(".%d", v.Patch)
 	}
+	if v.Until != nil {
+		vs += rangeSeparator + v.Until.String()
+	}
 	return vs
 }
 
+// compares the versions (without ranges), development versions are lower than all stable versions
+func (v *VersionType) compare(otherVersion *VersionType) int {
+	if v.IsDevelopment != otherVersion.IsDevelopment {
+		if v.IsDevelopment {
+			return -1
+		}
+		return 1
+	}
+	if c := cmp.Compare(v.Major, otherVersion.Major); c != 0 {
+		return c
+	}
+	return cmp.Compare(v.Minor, otherVersion.Minor)
+}
+
+// whether v can read entries of otherVersion: a single version must have the same major version and a lower or equal
+// minor version, a version range must include v.
 func (v *VersionType) isCompatibleTo(otherVersion *VersionType) bool {
+	if otherVersion.Until != nil {
+		return otherVersion.compare(v) <= 0 && v.compare(otherVersion.Until) <= 0
+	}
 	if v.IsDevelopment == otherVersion.IsDevelopment && v.Major == otherVersion.Major && v.Minor >= otherVersion.Minor {
 		return true
 	}
 	return false
 }
 
+// parses a version (<version>) or a version range (<version>-<version>, both inclusive)
 func parseEntryVersion(string string) (*VersionType, error) {
+	if from, until, ok := strings.Cut(string, rangeSeparator); ok {
+		version, err := parseSingleVersion(from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the lower bound: %s", err)
+		}
+		version.Until, err = parseSingleVersion(until)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the upper bound: %s", err)
+		}
+		if version.compare(version.Until) > 0 {
+			return nil, fmt.Errorf("the lower bound %s is greater than the upper bound %s", from, until)
+		}
+		return version, nil
+	}
+	return parseSingleVersion(string)
+}
+
+func parseSingleVersion(string string) (*VersionType, error) {
 	version := VersionType{}
 	if strings.HasPrefix(string, developmentPrefix) {
 		version.IsDevelopment = true
@@ -0,0 +1,89 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import "testing"
+
+func TestParseEntryVersion(t *testing.T) {
+	for _, spec := range []struct {
+		version    string
+		str        string // "" = error
+		compatible bool   // to the data version 0.1.1
+	}{
+		{"0.1", "0.1.0", true},
+		{"0.1.1", "0.1.1", true},
+		{"0.1.2", "0.1.2", false},
+		{"0.2", "0.2.0", false},
+		{"1", "1.0", false},
+		{"0.1.0-0.1.3", "0.1.0-0.1.3", true},
+		{"0.1.1-0.1.1", "0.1.1-0.1.1", true},
+		{"0.1.2-0.1.3", "0.1.2-0.1.3", false},
+		{"0.0.5-0.1.0", "0.0.5-0.1.0", false},
+		{"0.0.5-1.3", "0.0.5-1.3", true},
+		{"1.0-1.3", "1.0-1.3", false},
+		{"0.1.3-0.1.0", "", false},
+		{"1.0-", "", false},
+		{"-1.0", "", false},
+		{"1.0-1.1-1.2", "", false},
+	} {
+		version, err := parseEntryVersion(spec.version)
+		if spec.str == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", spec.version, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", spec.version, err)
+			continue
+		}
+		if str := version.String(); str != spec.str {
+			t.Errorf("%q: expected %q, got %q", spec.version, spec.str, str)
+		}
+		if compatible := dataVersion.isCompatibleTo(version); compatible != spec.compatible {
+			t.Errorf("%q: expected compatible %v, got %v", spec.version, spec.compatible, compatible)
+		}
+	}
+}
+
+func TestVersionRanges(t *testing.T) {
+	newTestETCD("dns/", map[string]string{
+		"dns/-defaults-":                        `{"ttl": 3600}`,
+		"dns/net.example/SOA":                   `{"primary": "ns1", "mail": "hostmaster", "refresh": 1, "retry": 1, "expire": 1, "neg-ttl": 1}`,
+		"dns/net.example/www/A":                 `192.0.2.1`,
+		"dns/net.example/within/A@0.1-0.1.3":    `192.0.2.2`,
+		"dns/net.example/outside/A@0.1.2-0.1.3": `192.0.2.3`,
+	})
+	defer func(version VersionType) { dataVersion = version }(dataVersion)
+	for _, spec := range []struct {
+		version VersionType
+		names   map[string]bool // name → has an A record
+	}{
+		{VersionType{IsDevelopment: true, Major: 1, Minor: 1}, map[string]bool{"www": true, "within": true, "outside": false}},
+		{VersionType{IsDevelopment: true, Major: 1, Minor: 3}, map[string]bool{"www": true, "within": true, "outside": true}},
+		{VersionType{IsDevelopment: true, Major: 1, Minor: 4}, map[string]bool{"www": true, "within": false, "outside": false}},
+	} {
+		dataVersion = spec.version
+		if _, err := loadData("test"); err != nil {
+			t.Fatalf("%s: loadData() failed: %s", &spec.version, err)
+		}
+		for name, expected := range spec.names {
+			data := dataRoot.Load().getChild(parseName(name+".example.net."), false)
+			if actual := data.getQname() == name+".example.net." && len(data.records["A"]) == 1; actual != expected {
+				t.Errorf("%s: expected an A record at %s: %v, got %v", &spec.version, name, expected, actual)
+			}
+		}
+	}
+}
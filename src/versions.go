@@ -0,0 +1,109 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	unversioned    = "unversioned"
+	invalidVersion = "invalid"
+)
+
+// the numbers of keys of a data node: all keys and the ones selected by reload() under the current data version
+type nodeKeys struct {
+	keys     int
+	selected map[string]bool // <entry type>/<QTYPE>#<id> → has a selected key
+}
+
+// counts the keys by version and by data node (see versionsReport()), also returns the incompatible versions
+func countVersions(items []etcdItem) (map[string]int, map[string]bool, map[string]*nodeKeys) {
+	versions := map[string]int{}
+	incompatible := map[string]bool{}
+	nodes := map[string]*nodeKeys{}
+	for _, item := range items {
+		name, entryType, qtype, id, version, err := parseEntryKey(item.Key)
+		if _, versionStr := cutKey(strings.TrimPrefix(item.Key, *args.Prefix), versionSeparator); version == nil && versionStr != "" {
+			versions[invalidVersion]++
+		} else if version == nil {
+			versions[unversioned]++
+		} else {
+			versions[version.String()]++
+			incompatible[version.String()] = !dataVersion.isCompatibleTo(version)
+		}
+		if err != nil {
+			continue // not selected, like in reload()
+		}
+		qname := name.normal()
+		node, ok := nodes[qname]
+		if !ok {
+			node = &nodeKeys{selected: map[string]bool{}}
+			nodes[qname] = node
+		}
+		node.keys++
+		// one key of an entry is selected (the highest compatible version or the unversioned one), if any
+		if version == nil || dataVersion.isCompatibleTo(version) {
+			node.selected[fmt.Sprintf("%s%s%s%s%s", entryType2key[entryType], keySeparator, qtype, idSeparator, id)] = true
+		}
+	}
+	return versions, incompatible, nodes
+}
+
+// writes a histogram of the versions of the keys and the numbers of keys each data node selects under the current data
+// version to w
+func versionsReport(w io.Writer, items []etcdItem) error {
+	versions, incompatible, nodes := countVersions(items)
+	if _, err := fmt.Fprintf(w, "versions (data version %s):\n", &dataVersion); err != nil {
+		return err
+	}
+	for _, version := range sortedKeys(versions) {
+		line := fmt.Sprintf("  %s: %d", version, versions[version])
+		if incompatible[version] {
+			line += " (incompatible)"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w, "nodes (selected/keys):"); err != nil {
+		return err
+	}
+	for _, qname := range sortedKeys(nodes) {
+		if _, err := fmt.Fprintf(w, "  %s: %d/%d\n", qname, len(nodes[qname].selected), nodes[qname].keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connects to ETCD, scans all keys under the prefix and writes the versions report to w
+func reportVersions(w io.Writer) error {
+	if _, err := setupClient(); err != nil {
+		return fmt.Errorf("setupClient() failed: %s", err)
+	}
+	defer closeClient()
+	_, dataChan, err := getRouted(*args.Prefix, "", nil, true, startupTimeout())
+	if err != nil {
+		return fmt.Errorf("getRouted() failed: %s", err)
+	}
+	var items []etcdItem
+	for item := range dataChan {
+		items = append(items, item)
+	}
+	return versionsReport(w, items)
+}
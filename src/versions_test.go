@@ -0,0 +1,61 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionsReport(t *testing.T) {
+	newTestETCD("dns/", nil)
+	var items []etcdItem
+	for _, key := range []string{
+		"dns/-defaults-",
+		"dns/net.example/SOA",
+		"dns/net.example/SOA@0.1",
+		"dns/net.example/-options-@0.1.1",
+		"dns/net.example/www/A",
+		"dns/net.example/www/A@0.1",
+		"dns/net.example/www/A#2@0.1.1",
+		"dns/net.example/www/TXT@0.2",
+		"dns/net.example/new/A@1.0",
+		"dns/net.example/new/AAAA@0.1-0.1.3",
+		"dns/net.example/invalid/A@x",
+	} {
+		items = append(items, etcdItem{key, []byte(`{}`), 1})
+	}
+	buffer := strings.Builder{}
+	if err := versionsReport(&buffer, items); err != nil {
+		t.Fatalf("versionsReport() failed: %s", err)
+	}
+	expected := `versions (data version 0.1.1):
+  0.1.0: 2
+  0.1.0-0.1.3: 1
+  0.1.1: 2
+  0.2.0: 1 (incompatible)
+  1.0: 1 (incompatible)
+  invalid: 1
+  unversioned: 3
+nodes (selected/keys):
+  .: 1/1
+  example.net.: 2/3
+  new.example.net.: 1/2
+  www.example.net.: 2/4
+`
+	if output := buffer.String(); output != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, output)
+	}
+}
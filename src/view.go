@@ -0,0 +1,179 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+// Split-horizon views: a record with a view (the field or option view, a network in CIDR notation or an array of them)
+// is only served to the clients within one of the networks. The records of a QTYPE without a view form the default
+// view, which is served to the clients not matching any view of these records (see viewRecords()).
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the networks of a view. a record refers to it by pointer, so the records stay comparable.
+type viewType struct {
+	networks []*net.IPNet
+}
+
+// the view of the record (by the field view, a default or the option view), nil if none
+func recordView(params *rrParams, values objectType[any]) (*viewType, error) {
+	if params.qtype == "SOA" {
+		return nil, nil
+	}
+	value, vPath, err := findValueOrDefault[any](viewOption, values, params.qtype, params.id, params.data, false)
+	if err == nil && vPath == nil {
+		value, vPath, err = findOptionValue[any](viewOption, params.qtype, params.id, params.data, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s.%s: %s", params.Target(), viewOption, err)
+	}
+	if vPath == nil {
+		return nil, nil
+	}
+	view, err := parseView(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s.%s (vp=%s): %s", params.Target(), viewOption, vPath, err)
+	}
+	return view, nil
+}
+
+// parses a network in CIDR notation or an array of them
+func parseView(value any) (*viewType, error) {
+	var values []any
+	switch value := value.(type) {
+	case string:
+		values = []any{value}
+	case []any:
+		values = value
+	case []string: // from a snapshot
+		for _, value := range value {
+			values = append(values, value)
+		}
+	default:
+		return nil, fmt.Errorf("neither a string nor an array: %T", value)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no networks")
+	}
+	view := &viewType{}
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("not a string: %v", value)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(str))
+		if err != nil {
+			return nil, err
+		}
+		view.networks = append(view.networks, network)
+	}
+	return view, nil
+}
+
+func (view *viewType) strings() []string {
+	if view == nil {
+		return nil
+	}
+	var strs []string
+	for _, network := range view.networks {
+		strs = append(strs, network.String())
+	}
+	return strs
+}
+
+func (view *viewType) contains(ip net.IP) bool {
+	if view == nil {
+		return false
+	}
+	for _, network := range view.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the address of the client of a lookup, from the parameter real-remote (the EDNS client subnet, if given) or remote.
+// nil if unknown.
+func clientAddress(params objectType[any]) net.IP {
+	for _, key := range []string{"real-remote", "remote"} {
+		value, ok := params[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if ip, _, err := net.ParseCIDR(value); err == nil {
+			return ip
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// whether any record of the node has a view, data must be read-locked
+func (dn *dataNode) hasViews() bool {
+	for _, records := range dn.records {
+		for _, record := range records {
+			if record.view != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the log fields of the client address parameters of a lookup (remote and real-remote), as they are given
+func remoteFields(params objectType[any]) logrus.Fields {
+	fields := logrus.Fields{}
+	for _, key := range []string{"remote", "real-remote"} {
+		if value, ok := params[key].(string); ok && value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// the records (of one QTYPE) to serve to the client at remote: the (enabled) records with a view containing remote,
+// if any, otherwise the records without a view
+func viewRecords(records map[string]recordType, remote net.IP) map[string]recordType {
+	viewed := false
+	for _, record := range records {
+		viewed = viewed || record.view != nil
+	}
+	if !viewed {
+		return records
+	}
+	matching := map[string]recordType{}
+	if remote != nil {
+		for id, record := range records {
+			if !record.disabled && record.view.contains(remote) {
+				matching[id] = record
+			}
+		}
+	}
+	if len(matching) == 0 {
+		for id, record := range records {
+			if record.view == nil {
+				matching[id] = record
+			}
+		}
+	}
+	return matching
+}
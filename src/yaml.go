@@ -0,0 +1,96 @@
+/* Copyright 2016-2024 nix <https://keybase.io/nixn>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package src
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+const yamlDocumentMarker = "---"
+
+// returns the YAML document of value without the document marker line, if value begins with it
+func cutYAMLDocument(value []byte) ([]byte, bool) {
+	value = bytes.ReplaceAll(value, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(value, []byte(yamlDocumentMarker)) {
+		return nil, false
+	}
+	document := value[len(yamlDocumentMarker):]
+	if len(document) > 0 && document[0] != '\n' {
+		return nil, false
+	}
+	return document, true
+}
+
+// parses the YAML document into an object, with the values converted to their JSON equivalents (see yamlToJSON()).
+// an empty document is an empty object.
+func parseYAMLObject(document []byte) (objectType[any], error) {
+	var content interface{}
+	if err := yaml.Unmarshal(document, &content); err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return objectType[any]{}, nil
+	}
+	value, err := yamlToJSON(content)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := value.(objectType[any])
+	if !ok {
+		return nil, fmt.Errorf("not an object, but %T", value)
+	}
+	return object, nil
+}
+
+// converts a decoded YAML value into the types decoded from JSON: the mappings into objects (with string keys) and the
+// numbers into float64, so that the values are handled the same regardless of their syntax
+func yamlToJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		object := objectType[any]{}
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string key %v (%T)", key, key)
+			}
+			var err error
+			if object[k], err = yamlToJSON(value); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, value := range v {
+			var err error
+			if array[i], err = yamlToJSON(value); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	}
+	return value, nil
+}